@@ -104,24 +104,46 @@ const EventItem = ({ event }: { event: FollowerEvent }) => {
             />
           </div>
           <div className="items-center justify-center truncate text-gray-900 sm:flex">
-            {
-              {
-                FOLLOWED: (
-                  <a target="_blank" rel="noreferrer" href={'https://twitter.com/' + event.follower.handle}>
-                    {event.follower.name}
-                    <span className="hidden sm:inline"> (@{event.follower.handle})</span> followed you
-                  </a>
-                ),
-                UNFOLLOWED: (
-                  <a target="_blank" rel="noreferrer" href={'https://twitter.com/' + event.follower.handle}>
-                    {event.follower.name}
-                    <span className="hidden sm:inline"> (@{event.follower.handle})</span> unfollowed you
-                  </a>
-                ),
-                DELETED: `Follower #${event.follower.id} was deleted`,
-                SUSPENDED: `Follower #${event.follower.id} was suspended`,
-              }[event.followerStateReason]
-            }
+            {event.follower.handle
+              ? {
+                  FOLLOWED: (
+                    <a target="_blank" rel="noreferrer" href={'https://twitter.com/' + event.follower.handle}>
+                      {event.follower.name}
+                      <span className="hidden sm:inline"> (@{event.follower.handle})</span> followed you
+                    </a>
+                  ),
+                  UNFOLLOWED: (
+                    <a target="_blank" rel="noreferrer" href={'https://twitter.com/' + event.follower.handle}>
+                      {event.follower.name}
+                      <span className="hidden sm:inline"> (@{event.follower.handle})</span> unfollowed you
+                    </a>
+                  ),
+                  REFOLLOWED: (
+                    <a target="_blank" rel="noreferrer" href={'https://twitter.com/' + event.follower.handle}>
+                      {event.follower.name}
+                      <span className="hidden sm:inline"> (@{event.follower.handle})</span> followed you again
+                    </a>
+                  ),
+                  PROTECTED: (
+                    <a target="_blank" rel="noreferrer" href={'https://twitter.com/' + event.follower.handle}>
+                      {event.follower.name}
+                      <span className="hidden sm:inline"> (@{event.follower.handle})</span> went protected
+                    </a>
+                  ),
+                  BLOCKED: (
+                    <a target="_blank" rel="noreferrer" href={'https://twitter.com/' + event.follower.handle}>
+                      {event.follower.name}
+                      <span className="hidden sm:inline"> (@{event.follower.handle})</span> blocked you
+                    </a>
+                  ),
+                  REMOVED: (
+                    <a target="_blank" rel="noreferrer" href={'https://twitter.com/' + event.follower.handle}>
+                      You removed {event.follower.name}
+                      <span className="hidden sm:inline"> (@{event.follower.handle})</span> from your followers
+                    </a>
+                  ),
+                }[event.followerStateReason]
+              : describeFollowerWithoutProfile(event)}
             {event.follower.protected && (
               <span className="ml-1 hidden sm:flex">
                 <LockClosedIcon className="h-4 w-4 text-gray-500" data-tip="Protected account" />
@@ -143,6 +165,23 @@ const EventItem = ({ event }: { event: FollowerEvent }) => {
   )
 }
 
+// Followers come with their ID only if they're gone, blocked the user, or
+// their profile couldn't be looked up
+const describeFollowerWithoutProfile = (event: FollowerEvent) => {
+  const follower = `Follower #${event.follower.id}`
+  return {
+    FOLLOWED: `${follower} followed you`,
+    UNFOLLOWED: `${follower} unfollowed you`,
+    REFOLLOWED: `${follower} followed you again`,
+    DELETED: `${follower} was deleted`,
+    SUSPENDED: `${follower} was suspended`,
+    DEACTIVATED: `${follower} deactivated their account`,
+    PROTECTED: `${follower} went protected`,
+    BLOCKED: `${follower} blocked you`,
+    REMOVED: `You removed ${follower.toLowerCase()} from your followers`,
+  }[event.followerStateReason]
+}
+
 const numberWithCommas = (n: number) => {
   return n.toString().replace(/\B(?=(\d{3})+(?!\d))/g, ',')
 }
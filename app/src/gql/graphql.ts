@@ -23,6 +23,83 @@ export type Scalars = {
   AWSURL: string
 }
 
+export type AlertConfig = {
+  __typename?: 'AlertConfig'
+  anomalies: Scalars['Boolean']
+  customMilestones?: Maybe<Array<Scalars['Int']>>
+  lossPercent: Scalars['Int']
+  milestones: Scalars['Boolean']
+  spikeFactor: Scalars['Int']
+}
+
+export type AlertInput = {
+  anomalies: Scalars['Boolean']
+  customMilestones?: InputMaybe<Array<Scalars['Int']>>
+  lossPercent?: InputMaybe<Scalars['Int']>
+  milestones: Scalars['Boolean']
+  spikeFactor?: InputMaybe<Scalars['Int']>
+}
+
+export type BurstConfig = {
+  __typename?: 'BurstConfig'
+  enabled: Scalars['Boolean']
+  window: Scalars['Int']
+}
+
+export type BurstInput = {
+  enabled: Scalars['Boolean']
+  window?: InputMaybe<Scalars['Int']>
+}
+
+export enum DeliveryStatus {
+  Failed = 'FAILED',
+  Pending = 'PENDING',
+  Sent = 'SENT',
+}
+
+export type DigestConfig = {
+  __typename?: 'DigestConfig'
+  hour: Scalars['Int']
+  mode: DigestMode
+}
+
+export type DigestInput = {
+  hour?: InputMaybe<Scalars['Int']>
+  mode: DigestMode
+}
+
+export enum DigestMode {
+  Daily = 'DAILY',
+  Off = 'OFF',
+  Weekly = 'WEEKLY',
+}
+
+export type DiscordConfig = {
+  __typename?: 'DiscordConfig'
+  enabled: Scalars['Boolean']
+  filter?: Maybe<NotificationFilter>
+  webhookUrl?: Maybe<Scalars['AWSURL']>
+}
+
+export type DiscordInput = {
+  enabled: Scalars['Boolean']
+  filter?: InputMaybe<NotificationFilterInput>
+  webhookUrl?: InputMaybe<Scalars['AWSURL']>
+}
+
+export type EmailConfig = {
+  __typename?: 'EmailConfig'
+  address?: Maybe<Scalars['AWSEmail']>
+  enabled: Scalars['Boolean']
+  filter?: Maybe<NotificationFilter>
+}
+
+export type EmailInput = {
+  address?: InputMaybe<Scalars['AWSEmail']>
+  enabled: Scalars['Boolean']
+  filter?: InputMaybe<NotificationFilterInput>
+}
+
 export type Follower = {
   __typename?: 'Follower'
   bio?: Maybe<Scalars['String']>
@@ -33,6 +110,7 @@ export type Follower = {
   profileImageUrl?: Maybe<Scalars['AWSURL']>
   protected: Scalars['Boolean']
   totalFollowers: Scalars['Int']
+  verified: Scalars['Boolean']
 }
 
 export type FollowerEvent = {
@@ -42,6 +120,7 @@ export type FollowerEvent = {
   followerState: FollowerState
   followerStateReason: FollowerStateReason
   id: Scalars['ID']
+  refollows?: Maybe<Scalars['Int']>
   totalFollowers: Scalars['Int']
 }
 
@@ -51,39 +130,169 @@ export enum FollowerState {
 }
 
 export enum FollowerStateReason {
+  Blocked = 'BLOCKED',
+  Deactivated = 'DEACTIVATED',
   Deleted = 'DELETED',
   Followed = 'FOLLOWED',
+  Protected = 'PROTECTED',
+  Refollowed = 'REFOLLOWED',
+  Removed = 'REMOVED',
   Suspended = 'SUSPENDED',
   Unfollowed = 'UNFOLLOWED',
 }
 
 export type Mutation = {
   __typename?: 'Mutation'
+  createSlackInstallState?: Maybe<SlackInstallState>
+  createTelegramLinkCode?: Maybe<TelegramLinkCode>
   deleteUser?: Maybe<Scalars['ID']>
+  previewTemplates?: Maybe<TemplatePreview>
   registerUser?: Maybe<User>
+  replayDeadLetter?: Maybe<NotificationDelivery>
+  rotateFeedToken?: Maybe<User>
+  sendTestNotification?: Maybe<TestNotificationResult>
+  subscribePush?: Maybe<User>
+  unsubscribePush?: Maybe<User>
   updateUser?: Maybe<User>
 }
 
+export type MutationCreateSlackInstallStateArgs = {
+  id: Scalars['ID']
+}
+
+export type MutationCreateTelegramLinkCodeArgs = {
+  id: Scalars['ID']
+}
+
 export type MutationDeleteUserArgs = {
   id: Scalars['ID']
 }
 
+export type MutationPreviewTemplatesArgs = {
+  followerStateReason?: InputMaybe<FollowerStateReason>
+  id: Scalars['ID']
+  input: NotificationTemplatesInput
+}
+
 export type MutationRegisterUserArgs = {
   id: Scalars['ID']
 }
 
+export type MutationReplayDeadLetterArgs = {
+  channel: Scalars['String']
+  eventId: Scalars['ID']
+  id: Scalars['ID']
+}
+
+export type MutationRotateFeedTokenArgs = {
+  id: Scalars['ID']
+}
+
+export type MutationSendTestNotificationArgs = {
+  channel: Scalars['String']
+  id: Scalars['ID']
+}
+
+export type MutationSubscribePushArgs = {
+  id: Scalars['ID']
+  input: PushSubscriptionInput
+}
+
+export type MutationUnsubscribePushArgs = {
+  id: Scalars['ID']
+  subscriptionId: Scalars['ID']
+}
+
 export type MutationUpdateUserArgs = {
   id: Scalars['ID']
   input: UpdateUserInput
 }
 
+export type NotificationDelivery = {
+  __typename?: 'NotificationDelivery'
+  attempts: Scalars['Int']
+  channel: Scalars['String']
+  createdAt: Scalars['AWSDateTime']
+  event: FollowerEvent
+  eventId: Scalars['ID']
+  lastError?: Maybe<Scalars['String']>
+  response?: Maybe<Scalars['String']>
+  status: DeliveryStatus
+  updatedAt: Scalars['AWSDateTime']
+}
+
+export type NotificationFilter = {
+  __typename?: 'NotificationFilter'
+  minFollowers: Scalars['Int']
+  protectedOnly: Scalars['Boolean']
+  reasons?: Maybe<Array<FollowerStateReason>>
+  states?: Maybe<Array<FollowerState>>
+  verifiedOnly: Scalars['Boolean']
+}
+
+export type NotificationFilterInput = {
+  minFollowers?: InputMaybe<Scalars['Int']>
+  protectedOnly?: InputMaybe<Scalars['Boolean']>
+  reasons?: InputMaybe<Array<FollowerStateReason>>
+  states?: InputMaybe<Array<FollowerState>>
+  verifiedOnly?: InputMaybe<Scalars['Boolean']>
+}
+
+export type NotificationTemplates = {
+  __typename?: 'NotificationTemplates'
+  footer?: Maybe<Scalars['String']>
+  header?: Maybe<Scalars['String']>
+  text?: Maybe<Scalars['String']>
+}
+
+export type NotificationTemplatesInput = {
+  footer?: InputMaybe<Scalars['String']>
+  header?: InputMaybe<Scalars['String']>
+  text?: InputMaybe<Scalars['String']>
+}
+
+export type PushConfig = {
+  __typename?: 'PushConfig'
+  enabled: Scalars['Boolean']
+  filter?: Maybe<NotificationFilter>
+  subscriptions?: Maybe<Array<PushSubscription>>
+}
+
+export type PushInput = {
+  enabled: Scalars['Boolean']
+  filter?: InputMaybe<NotificationFilterInput>
+}
+
+export type PushSubscription = {
+  __typename?: 'PushSubscription'
+  createdAt: Scalars['AWSDateTime']
+  device?: Maybe<Scalars['String']>
+  endpoint: Scalars['AWSURL']
+  id: Scalars['ID']
+}
+
+export type PushSubscriptionInput = {
+  auth: Scalars['String']
+  device?: InputMaybe<Scalars['String']>
+  endpoint: Scalars['AWSURL']
+  p256dh: Scalars['String']
+}
+
 export type Query = {
   __typename?: 'Query'
+  getDeadLetters?: Maybe<Array<NotificationDelivery>>
   getLatestFollowerEvents?: Maybe<Array<FollowerEvent>>
+  getRateBudgets?: Maybe<Array<RateBudget>>
   getUser?: Maybe<User>
+  getVapidPublicKey?: Maybe<Scalars['String']>
+  getWebhookDeliveries?: Maybe<Array<WebhookDelivery>>
   ping: Scalars['String']
 }
 
+export type QueryGetDeadLettersArgs = {
+  userId: Scalars['ID']
+}
+
 export type QueryGetLatestFollowerEventsArgs = {
   userId: Scalars['ID']
 }
@@ -92,37 +301,189 @@ export type QueryGetUserArgs = {
   id: Scalars['ID']
 }
 
+export type QueryGetWebhookDeliveriesArgs = {
+  userId: Scalars['ID']
+  webhookId: Scalars['ID']
+}
+
+export type QuietHours = {
+  __typename?: 'QuietHours'
+  coalesce: Scalars['Boolean']
+  enabled: Scalars['Boolean']
+  end: Scalars['Int']
+  start: Scalars['Int']
+}
+
+export type QuietHoursInput = {
+  coalesce?: InputMaybe<Scalars['Boolean']>
+  enabled: Scalars['Boolean']
+  end: Scalars['Int']
+  start: Scalars['Int']
+}
+
+export type RateBudget = {
+  __typename?: 'RateBudget'
+  capacity: Scalars['Int']
+  name: Scalars['String']
+  tokens: Scalars['Float']
+  updatedAt: Scalars['AWSDateTime']
+  used: Scalars['Int']
+  windowStart: Scalars['AWSDateTime']
+}
+
+export type RefollowConfig = {
+  __typename?: 'RefollowConfig'
+  enabled: Scalars['Boolean']
+  suppress: Scalars['Boolean']
+  window: Scalars['Int']
+}
+
+export type RefollowInput = {
+  enabled: Scalars['Boolean']
+  suppress?: InputMaybe<Scalars['Boolean']>
+  window?: InputMaybe<Scalars['Int']>
+}
+
 export type SlackConfig = {
   __typename?: 'SlackConfig'
   channel?: Maybe<Scalars['String']>
   enabled: Scalars['Boolean']
+  filter?: Maybe<NotificationFilter>
+  installed: Scalars['Boolean']
+  teamName?: Maybe<Scalars['String']>
   webhookUrl?: Maybe<Scalars['AWSURL']>
 }
 
 export type SlackInput = {
   channel?: InputMaybe<Scalars['String']>
   enabled: Scalars['Boolean']
+  filter?: InputMaybe<NotificationFilterInput>
   webhookUrl?: InputMaybe<Scalars['AWSURL']>
 }
 
+export type SlackInstallState = {
+  __typename?: 'SlackInstallState'
+  expiresAt: Scalars['AWSDateTime']
+  state: Scalars['String']
+}
+
+export type TelegramConfig = {
+  __typename?: 'TelegramConfig'
+  enabled: Scalars['Boolean']
+  filter?: Maybe<NotificationFilter>
+  linked: Scalars['Boolean']
+}
+
+export type TelegramInput = {
+  enabled: Scalars['Boolean']
+  filter?: InputMaybe<NotificationFilterInput>
+}
+
+export type TelegramLinkCode = {
+  __typename?: 'TelegramLinkCode'
+  code: Scalars['String']
+  expiresAt: Scalars['AWSDateTime']
+}
+
+export type TemplatePreview = {
+  __typename?: 'TemplatePreview'
+  footer: Scalars['String']
+  header: Scalars['String']
+  text: Scalars['String']
+}
+
+export type TestNotificationResult = {
+  __typename?: 'TestNotificationResult'
+  channel: Scalars['String']
+  error?: Maybe<Scalars['String']>
+  response?: Maybe<Scalars['String']>
+  statusCode?: Maybe<Scalars['Int']>
+  success: Scalars['Boolean']
+}
+
+export enum TrackingStatus {
+  Active = 'ACTIVE',
+  Disabled = 'DISABLED',
+  NeedsReauth = 'NEEDS_REAUTH',
+  Paused = 'PAUSED',
+}
+
 export type UpdateUserInput = {
+  alerts?: InputMaybe<AlertInput>
+  burst?: InputMaybe<BurstInput>
+  digest?: InputMaybe<DigestInput>
+  discord?: InputMaybe<DiscordInput>
+  email?: InputMaybe<EmailInput>
   ignoreFollowers?: InputMaybe<Array<Scalars['String']>>
+  locale?: InputMaybe<Scalars['String']>
+  push?: InputMaybe<PushInput>
+  quietHours?: InputMaybe<QuietHoursInput>
+  refollow?: InputMaybe<RefollowInput>
   slack?: InputMaybe<SlackInput>
+  telegram?: InputMaybe<TelegramInput>
+  templates?: InputMaybe<NotificationTemplatesInput>
+  timezone?: InputMaybe<Scalars['String']>
+  trackingStatus?: InputMaybe<TrackingStatus>
+  webhooks?: InputMaybe<Array<WebhookInput>>
 }
 
 export type User = {
   __typename?: 'User'
+  alerts: AlertConfig
   bio?: Maybe<Scalars['String']>
+  burst: BurstConfig
   createdAt: Scalars['AWSDateTime']
+  digest: DigestConfig
+  discord: DiscordConfig
+  email: EmailConfig
+  feedToken?: Maybe<Scalars['String']>
   handle: Scalars['String']
   id: Scalars['ID']
   ignoreFollowers?: Maybe<Array<Scalars['String']>>
   lastLogin: Scalars['AWSDateTime']
+  locale?: Maybe<Scalars['String']>
   location?: Maybe<Scalars['String']>
   name: Scalars['String']
+  nextCheckAt?: Maybe<Scalars['AWSDateTime']>
   profileImageUrl: Scalars['AWSURL']
+  push: PushConfig
+  quietHours: QuietHours
+  refollow: RefollowConfig
   slack: SlackConfig
+  telegram: TelegramConfig
+  templates: NotificationTemplates
+  timezone?: Maybe<Scalars['String']>
+  trackingStatus: TrackingStatus
   updatedAt: Scalars['AWSDateTime']
+  webhooks?: Maybe<Array<Webhook>>
+}
+
+export type Webhook = {
+  __typename?: 'Webhook'
+  createdAt: Scalars['AWSDateTime']
+  enabled: Scalars['Boolean']
+  failures: Scalars['Int']
+  id: Scalars['ID']
+  secret: Scalars['String']
+  url: Scalars['AWSURL']
+}
+
+export type WebhookDelivery = {
+  __typename?: 'WebhookDelivery'
+  attempts: Scalars['Int']
+  createdAt: Scalars['AWSDateTime']
+  error?: Maybe<Scalars['String']>
+  eventId: Scalars['ID']
+  id: Scalars['ID']
+  statusCode?: Maybe<Scalars['Int']>
+  succeeded: Scalars['Boolean']
+  webhookId: Scalars['ID']
+}
+
+export type WebhookInput = {
+  enabled: Scalars['Boolean']
+  id?: InputMaybe<Scalars['ID']>
+  url: Scalars['AWSURL']
 }
 
 export type RegisterUserMutationVariables = Exact<{
@@ -140,6 +501,7 @@ export type GetLatestFollowerEventsQueryVariables = Exact<{
 
 export type GetLatestFollowerEventsQuery = {
   __typename?: 'Query'
+  getDeadLetters?: Maybe<Array<NotificationDelivery>>
   getLatestFollowerEvents?: Array<{
     __typename?: 'FollowerEvent'
     id: string
@@ -0,0 +1,132 @@
+// Command listkeeper-local runs the follower pipeline on a single machine,
+// using files instead of DynamoDB and S3 and a fake Twitter API loaded from a
+// JSON fixture. Edit the fixture between runs to simulate follower changes.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/local"
+	"github.com/mlafeldt/listkeeper/functions/internal/pipeline"
+)
+
+func main() {
+	var (
+		stateDir    = flag.String("state", ".listkeeper", "directory to keep table and bucket in")
+		twitterFile = flag.String("twitter", "twitter.json", "JSON fixture of the fake Twitter API")
+		userID      = flag.String("user", "", "check this user now instead of all due users")
+		every       = flag.Duration("every", 0, "keep running cycles at this interval")
+	)
+	flag.Parse()
+
+	if err := run(*stateDir, *twitterFile, *userID, *every); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(stateDir, twitterFile, userID string, every time.Duration) error {
+	ctx := context.Background()
+
+	tw, err := local.LoadTwitter(twitterFile)
+	if err != nil {
+		return err
+	}
+
+	storePath := filepath.Join(stateDir, "table.gob")
+	store, err := loadStore(storePath)
+	if err != nil {
+		return err
+	}
+	table := data.NewLocalTable(store)
+
+	p := pipeline.New(pipeline.Config{
+		Table:   table,
+		Bucket:  local.NewBucket(filepath.Join(stateDir, "s3")),
+		Twitter: tw,
+	})
+
+	for _, a := range tw.Accounts() {
+		a := a
+		if _, err := table.GetUser(ctx, a.User.ID); !errors.Is(err, data.ErrUserNotFound) {
+			continue
+		}
+		res, err := p.SignUp(ctx, pipeline.NewUser(&a))
+		if err != nil {
+			return err
+		}
+		report("signup @"+a.User.Handle, res)
+	}
+
+	for {
+		var res *pipeline.Result
+		if userID != "" {
+			res, err = p.CheckUser(ctx, userID)
+		} else {
+			res, err = p.RunCycle(ctx)
+		}
+		if err != nil {
+			return err
+		}
+		report("cycle", res)
+
+		if err := saveStore(storePath, store); err != nil {
+			return err
+		}
+
+		if every == 0 {
+			return nil
+		}
+		time.Sleep(every)
+	}
+}
+
+func report(name string, res *pipeline.Result) {
+	fmt.Printf("%s: %d users checked, %d deferred, %d events\n",
+		name, res.Enqueued.TotalUsers, res.Enqueued.DeferredUsers, len(res.Events))
+
+	for _, n := range res.Notifications {
+		fmt.Printf("  [%s] %s\n    %s\n", n.Output.Header, n.Output.Text, n.Output.Footer)
+	}
+	for _, err := range res.Errors {
+		fmt.Printf("  error: %s\n", err)
+	}
+}
+
+func loadStore(path string) (*data.MemoryStore, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return data.NewMemoryStore(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return data.LoadMemoryStore(f)
+}
+
+func saveStore(path string, store *data.MemoryStore) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil { //nolint:gomnd
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := store.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
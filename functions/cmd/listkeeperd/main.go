@@ -0,0 +1,47 @@
+// Command listkeeperd runs Listkeeper as a self-hosted daemon, see
+// listkeeperd.example.yml for its configuration.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os/signal"
+	"syscall"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/daemon"
+	"github.com/mlafeldt/listkeeper/functions/internal/notify"
+)
+
+func main() {
+	configFile := flag.String("config", "listkeeperd.yml", "path to config file")
+	generateVAPIDKey := flag.Bool("generate-vapid-key", false, "print a new key pair for push notifications and exit")
+	flag.Parse()
+
+	if *generateVAPIDKey {
+		key, err := notify.GenerateVAPIDKey()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("private key: %s\npublic key:  %s\n", key.PrivateKey(), key.PublicKey())
+		return
+	}
+
+	cfg, err := daemon.LoadConfig(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	d, err := daemon.New(ctx, cfg, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := d.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,253 @@
+package budget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+// Limit is the number of API calls all users may make to an endpoint per
+// window, as all of them share the same consumer key. Twitter limits some
+// endpoints per access token instead, which makes them PerUser.
+type Limit struct {
+	Capacity int
+	Window   time.Duration
+	PerUser  bool
+}
+
+// DefaultLimits leave some headroom below Twitter's app limits.
+var DefaultLimits = map[string]Limit{
+	twitter.EndpointFollowerIDs:       {Capacity: 300, Window: 15 * time.Minute},
+	twitter.EndpointUsersShow:         {Capacity: 800, Window: 15 * time.Minute},
+	twitter.EndpointFriendshipsShow:   {Capacity: 150, Window: 15 * time.Minute},
+	twitter.EndpointVerifyCredentials: {Capacity: 60, Window: 15 * time.Minute, PerUser: true},
+}
+
+// DefaultUserShare is the fraction of a budget a single user may draw per
+// window, so that one big account cannot starve everyone else.
+const DefaultUserShare = 0.25
+
+const maxConflictRetries = 5
+
+var _ twitter.BatchLimiter = (*Planner)(nil)
+
+// Planner manages the rate budgets persisted in the table. Every API call
+// draws from the global budget of its endpoint as well as from the user's
+// share of it.
+type Planner struct {
+	table     data.TableAPI
+	limits    map[string]Limit
+	userShare float64
+	now       func() time.Time
+}
+
+func NewPlanner(table data.TableAPI) *Planner {
+	return &Planner{
+		table:     table,
+		limits:    DefaultLimits,
+		userShare: DefaultUserShare,
+		now:       time.Now,
+	}
+}
+
+type userKey struct{}
+
+// WithUser returns a context that makes the planner charge API calls to the
+// given user in addition to the global budget.
+func WithUser(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userKey{}, userID)
+}
+
+func userFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(userKey{}).(string)
+	return userID
+}
+
+// Take draws a single token for the endpoint. It fails with ErrExhausted if
+// either the global budget or the user's share of it is used up, in which
+// case neither of them is drawn from.
+func (p *Planner) Take(ctx context.Context, endpoint string) error {
+	return p.TakeN(ctx, endpoint, 1)
+}
+
+// TakeN is like Take, but draws n tokens at once, e.g. for all pages of a
+// paginated request. Tokens that end up unused should be given back via
+// Refund.
+func (p *Planner) TakeN(ctx context.Context, endpoint string, n int) error {
+	return p.update(ctx, p.budgets(ctx, endpoint), func(b *data.RateBudget) error {
+		if !b.Take(n, p.now()) {
+			return fmt.Errorf("%w: %s", ErrExhausted, b.Name)
+		}
+		return nil
+	})
+}
+
+// Refund gives back n tokens drawn for calls to the endpoint that weren't
+// made after all.
+func (p *Planner) Refund(ctx context.Context, endpoint string, n int) error {
+	return p.update(ctx, p.budgets(ctx, endpoint), func(b *data.RateBudget) error {
+		b.Refund(n, p.now())
+		return nil
+	})
+}
+
+type namedLimit struct {
+	name  string
+	limit Limit
+}
+
+// budgets returns the budgets a call to the endpoint draws from. Calls to
+// endpoints limited per user can't be budgeted without knowing the user.
+func (p *Planner) budgets(ctx context.Context, endpoint string) []namedLimit {
+	limit, ok := p.limits[endpoint]
+	if !ok {
+		return nil
+	}
+
+	userID := userFromContext(ctx)
+	if limit.PerUser {
+		if userID == "" {
+			return nil
+		}
+		return []namedLimit{{endpoint + "#" + userID, limit}}
+	}
+
+	budgets := []namedLimit{{endpoint, limit}}
+	if userID != "" {
+		userLimit := Limit{
+			Capacity: int(math.Ceil(float64(limit.Capacity) * p.userShare)),
+			Window:   limit.Window,
+		}
+		budgets = append(budgets, namedLimit{endpoint + "#" + userID, userLimit})
+	}
+	return budgets
+}
+
+// TakeUser draws a single token from a budget of the user that isn't tied to
+// an API endpoint, e.g. to limit how often users can trigger an action.
+func (p *Planner) TakeUser(ctx context.Context, name, userID string, limit Limit) error {
+	return p.update(ctx, []namedLimit{{name + "#" + userID, limit}}, func(b *data.RateBudget) error {
+		if !b.Take(1, p.now()) {
+			return fmt.Errorf("%w: %s", ErrExhausted, b.Name)
+		}
+		return nil
+	})
+}
+
+// update applies f to all budgets and saves them at once, so that either all
+// of them or none are drawn from. It starts over if another call saved any of
+// them in the meantime.
+func (p *Planner) update(ctx context.Context, budgets []namedLimit, f func(*data.RateBudget) error) error {
+	if len(budgets) == 0 {
+		return nil
+	}
+
+	for i := 0; i < maxConflictRetries; i++ {
+		loaded := make([]*data.RateBudget, len(budgets))
+		for j, nl := range budgets {
+			b, err := p.load(ctx, nl.name, nl.limit)
+			if err != nil {
+				return err
+			}
+			if err := f(b); err != nil {
+				return err
+			}
+			loaded[j] = b
+		}
+
+		err := p.table.SaveRateBudgets(ctx, loaded...)
+		if errors.Is(err, data.ErrRateBudgetConflict) {
+			continue
+		}
+		return err
+	}
+
+	return fmt.Errorf("%w: %s", data.ErrRateBudgetConflict, budgets[0].name)
+}
+
+// Available returns the number of calls that can be made to the endpoint
+// right now without exceeding the global budget.
+func (p *Planner) Available(ctx context.Context, endpoint string) (int, error) {
+	limit, ok := p.limits[endpoint]
+	if !ok || limit.PerUser {
+		return math.MaxInt, nil
+	}
+
+	b, err := p.load(ctx, endpoint, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return b.Available(p.now()), nil
+}
+
+// Window returns the refill window of the endpoint's budget.
+func (p *Planner) Window(endpoint string) time.Duration {
+	return p.limits[endpoint].Window
+}
+
+// Usage returns the current state of all global budgets, which leaves out
+// those limited per user.
+func (p *Planner) Usage(ctx context.Context) ([]*data.RateBudget, error) {
+	stored, err := p.table.GetRateBudgets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*data.RateBudget, len(stored))
+	for _, b := range stored {
+		byName[b.Name] = b
+	}
+
+	var (
+		now     = p.now()
+		budgets = make([]*data.RateBudget, 0, len(p.limits))
+	)
+
+	endpoints := make([]string, 0, len(p.limits))
+	for endpoint, limit := range p.limits {
+		if !limit.PerUser {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	sort.Strings(endpoints)
+
+	for _, endpoint := range endpoints {
+		limit := p.limits[endpoint]
+		b, ok := byName[endpoint]
+		if !ok {
+			b = data.NewRateBudget(endpoint, limit.Capacity, limit.Window, now)
+		}
+		b.Refill(now)
+		budgets = append(budgets, b)
+	}
+
+	return budgets, nil
+}
+
+func (p *Planner) load(ctx context.Context, name string, limit Limit) (*data.RateBudget, error) {
+	b, err := p.table.GetRateBudget(ctx, name)
+	if errors.Is(err, data.ErrRateBudgetNotFound) {
+		return data.NewRateBudget(name, limit.Capacity, limit.Window, p.now()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Pick up changed limits
+	b.Capacity = limit.Capacity
+	b.Window = limit.Window
+	if b.Tokens > float64(b.Capacity) {
+		b.Tokens = float64(b.Capacity)
+	}
+
+	return b, nil
+}
+
+var ErrExhausted = errors.New("rate budget exhausted")
@@ -0,0 +1,282 @@
+package budget
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+var now, _ = time.Parse(time.RFC822, "07 Nov 20 21:04 UTC")
+
+type tableStub struct {
+	data.TableAPI
+
+	budgets   map[string]data.RateBudget
+	conflicts int
+	saves     int
+}
+
+func (t *tableStub) GetRateBudget(ctx context.Context, name string) (*data.RateBudget, error) {
+	b, ok := t.budgets[name]
+	if !ok {
+		return nil, data.ErrRateBudgetNotFound
+	}
+	return &b, nil
+}
+
+func (t *tableStub) GetRateBudgets(ctx context.Context) ([]*data.RateBudget, error) {
+	var budgets []*data.RateBudget
+	for name := range t.budgets {
+		b := t.budgets[name]
+		budgets = append(budgets, &b)
+	}
+	return budgets, nil
+}
+
+func (t *tableStub) SaveRateBudgets(ctx context.Context, budgets ...*data.RateBudget) error {
+	t.saves++
+	if t.conflicts > 0 {
+		t.conflicts--
+		return data.ErrRateBudgetConflict
+	}
+	for _, b := range budgets {
+		b.Version++
+		t.budgets[b.Name] = *b
+	}
+	return nil
+}
+
+func newPlanner(table data.TableAPI) *Planner {
+	p := NewPlanner(table)
+	p.limits = map[string]Limit{
+		twitter.EndpointFollowerIDs: {Capacity: 8, Window: 15 * time.Minute},
+	}
+	p.now = func() time.Time { return now }
+	return p
+}
+
+func TestTakeUserShare(t *testing.T) {
+	var (
+		table   = &tableStub{budgets: map[string]data.RateBudget{}}
+		p       = newPlanner(table)
+		bigUser = WithUser(context.Background(), "111")
+	)
+
+	// A single user may only draw a quarter of the budget
+	for i := 0; i < 2; i++ {
+		if err := p.Take(bigUser, twitter.EndpointFollowerIDs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Take(bigUser, twitter.EndpointFollowerIDs); !errors.Is(err, ErrExhausted) {
+		t.Fatalf("expected exhausted budget, got %v", err)
+	}
+
+	// Others can still make calls
+	if err := p.Take(WithUser(context.Background(), "222"), twitter.EndpointFollowerIDs); err != nil {
+		t.Fatal(err)
+	}
+
+	available, err := p.Available(context.Background(), twitter.EndpointFollowerIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(5, available); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestDefaultLimits(t *testing.T) {
+	// Every endpoint read in bulk must be budgeted
+	for _, endpoint := range []string{
+		twitter.EndpointFollowerIDs,
+		twitter.EndpointUsersShow,
+		twitter.EndpointFriendshipsShow,
+		twitter.EndpointVerifyCredentials,
+	} {
+		if _, ok := DefaultLimits[endpoint]; !ok {
+			t.Errorf("no default limit for %s", endpoint)
+		}
+	}
+}
+
+func TestTakeGlobalExhausted(t *testing.T) {
+	var (
+		table = &tableStub{budgets: map[string]data.RateBudget{}}
+		p     = newPlanner(table)
+	)
+
+	// Others used up the global budget
+	for i := 0; i < 8; i++ {
+		if err := p.Take(context.Background(), twitter.EndpointFollowerIDs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := p.Take(WithUser(context.Background(), "111"), twitter.EndpointFollowerIDs); !errors.Is(err, ErrExhausted) {
+		t.Fatalf("expected exhausted budget, got %v", err)
+	}
+
+	// The user didn't pay for the call that wasn't made
+	if b, ok := table.budgets[twitter.EndpointFollowerIDs+"#111"]; ok && b.Used != 0 {
+		t.Errorf("user was charged for %d calls", b.Used)
+	}
+}
+
+func TestTakeSavesOnce(t *testing.T) {
+	var (
+		table = &tableStub{budgets: map[string]data.RateBudget{}}
+		p     = newPlanner(table)
+	)
+
+	// The global budget and the user's share are drawn from together
+	if err := p.Take(WithUser(context.Background(), "111"), twitter.EndpointFollowerIDs); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(1, table.saves); diff != "" {
+		t.Error(diff)
+	}
+	for _, name := range []string{twitter.EndpointFollowerIDs, twitter.EndpointFollowerIDs + "#111"} {
+		if diff := cmp.Diff(1, table.budgets[name].Used); diff != "" {
+			t.Errorf("%s: %s", name, diff)
+		}
+	}
+}
+
+func TestTakeNAndRefund(t *testing.T) {
+	var (
+		table = &tableStub{budgets: map[string]data.RateBudget{}}
+		p     = newPlanner(table)
+		ctx   = WithUser(context.Background(), "111")
+	)
+
+	// More than the user's share
+	if err := p.TakeN(ctx, twitter.EndpointFollowerIDs, 3); !errors.Is(err, ErrExhausted) {
+		t.Fatalf("expected exhausted budget, got %v", err)
+	}
+	if table.saves != 0 {
+		t.Errorf("expected nothing to be saved, got %d saves", table.saves)
+	}
+
+	if err := p.TakeN(ctx, twitter.EndpointFollowerIDs, 2); err != nil {
+		t.Fatal(err)
+	}
+	// Only one of the pages was needed
+	if err := p.Refund(ctx, twitter.EndpointFollowerIDs, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{twitter.EndpointFollowerIDs, twitter.EndpointFollowerIDs + "#111"} {
+		if diff := cmp.Diff(1, table.budgets[name].Used); diff != "" {
+			t.Errorf("%s: %s", name, diff)
+		}
+	}
+}
+
+func TestTakePerUser(t *testing.T) {
+	var (
+		table = &tableStub{budgets: map[string]data.RateBudget{}}
+		p     = newPlanner(table)
+	)
+	p.limits[twitter.EndpointVerifyCredentials] = Limit{Capacity: 2, Window: 15 * time.Minute, PerUser: true}
+
+	// Every user gets the whole capacity
+	for _, userID := range []string{"111", "222"} {
+		ctx := WithUser(context.Background(), userID)
+		for i := 0; i < 2; i++ {
+			if err := p.Take(ctx, twitter.EndpointVerifyCredentials); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := p.Take(ctx, twitter.EndpointVerifyCredentials); !errors.Is(err, ErrExhausted) {
+			t.Fatalf("expected exhausted budget, got %v", err)
+		}
+	}
+
+	if _, ok := table.budgets[twitter.EndpointVerifyCredentials]; ok {
+		t.Error("per-user limit must not have a global budget")
+	}
+}
+
+func TestTakeRetriesConflicts(t *testing.T) {
+	var (
+		table = &tableStub{budgets: map[string]data.RateBudget{}, conflicts: 2}
+		p     = newPlanner(table)
+	)
+
+	if err := p.Take(context.Background(), twitter.EndpointFollowerIDs); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(1, table.budgets[twitter.EndpointFollowerIDs].Used); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestTakeUnknownEndpoint(t *testing.T) {
+	p := newPlanner(&tableStub{})
+
+	if err := p.Take(context.Background(), "some/endpoint"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTakeUser(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		table = &tableStub{budgets: map[string]data.RateBudget{}}
+		p     = newPlanner(table)
+		limit = Limit{Capacity: 2, Window: time.Hour}
+	)
+
+	for i := 0; i < 2; i++ {
+		if err := p.TakeUser(ctx, "some-action", "alice", limit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.TakeUser(ctx, "some-action", "alice", limit); !errors.Is(err, ErrExhausted) {
+		t.Errorf("expected budget to be exhausted, got %v", err)
+	}
+	if err := p.TakeUser(ctx, "some-action", "bob", limit); err != nil {
+		t.Errorf("users must not share budget: %v", err)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	var (
+		table = &tableStub{budgets: map[string]data.RateBudget{}}
+		p     = newPlanner(table)
+	)
+
+	if err := p.Take(WithUser(context.Background(), "111"), twitter.EndpointFollowerIDs); err != nil {
+		t.Fatal(err)
+	}
+
+	budgets, err := p.Usage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*data.RateBudget{
+		{
+			Name:        twitter.EndpointFollowerIDs,
+			Capacity:    8,
+			Window:      15 * time.Minute,
+			Tokens:      7,
+			Used:        1,
+			WindowStart: now,
+			UpdatedAt:   now,
+			Version:     1,
+		},
+	}
+
+	if diff := cmp.Diff(want, budgets); diff != "" {
+		t.Error(diff)
+	}
+}
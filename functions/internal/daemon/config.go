@@ -0,0 +1,182 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	valid "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"gopkg.in/yaml.v3"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/notify"
+	"github.com/mlafeldt/listkeeper/functions/internal/secret"
+)
+
+// Config is read from a YAML file, see listkeeperd.example.yml.
+type Config struct {
+	Listen   string        `yaml:"listen"`
+	APIKey   string        `yaml:"apiKey"`
+	Schema   string        `yaml:"schema"`
+	Database string        `yaml:"database"`
+	DataDir  string        `yaml:"dataDir"`
+	Interval time.Duration `yaml:"interval"`
+	Workers  int           `yaml:"workers"`
+	AppURL   string        `yaml:"appUrl"`
+	TableTTL time.Duration `yaml:"tableTtl"`
+	EventTTL time.Duration `yaml:"eventTtl"`
+
+	// The Slack app can be installed if a client ID is set. Its redirect URL
+	// must point to /slack/callback, its event subscriptions to /slack/events,
+	// and its interactivity request URL to /slack/actions.
+	Slack struct {
+		Username      string `yaml:"username"`
+		IconURL       string `yaml:"iconUrl"`
+		ClientID      string `yaml:"clientId"`
+		ClientSecret  string `yaml:"clientSecret"`
+		SigningSecret string `yaml:"signingSecret"`
+		TokenKey      string `yaml:"tokenKey"` // encrypts bot tokens, see secret.NewBox
+	} `yaml:"slack"`
+
+	// Email notifications are available if an SMTP server is set
+	Email struct {
+		SMTPAddr string `yaml:"smtpAddr"`
+		Username string `yaml:"username"`
+		Password string `yaml:"password"`
+		From     string `yaml:"from"`
+	} `yaml:"email"`
+
+	// Telegram notifications are available if a bot token is set. The bot's
+	// webhook must point to /telegram with the same secret token.
+	Telegram struct {
+		BotToken    string `yaml:"botToken"`
+		SecretToken string `yaml:"secretToken"`
+	} `yaml:"telegram"`
+
+	// Browser push notifications are available if a VAPID key is set, see
+	// listkeeperd -generate-vapid-key.
+	Push struct {
+		VAPIDPrivateKey string `yaml:"vapidPrivateKey"`
+	} `yaml:"push"`
+
+	Twitter struct {
+		ConsumerKey    string `yaml:"consumerKey"`
+		ConsumerSecret string `yaml:"consumerSecret"`
+	} `yaml:"twitter"`
+
+	// Users log in via Auth0 like on AWS, if configured. Otherwise, the
+	// Twitter accounts below are tracked.
+	Auth0 struct {
+		Domain       string `yaml:"domain"`
+		ClientID     string `yaml:"clientId"`
+		ClientSecret string `yaml:"clientSecret"`
+	} `yaml:"auth0"`
+
+	Accounts []Account `yaml:"accounts"`
+}
+
+// Account is a Twitter account authorized for Listkeeper.
+type Account struct {
+	AccessToken  string `yaml:"accessToken"`
+	AccessSecret string `yaml:"accessSecret"`
+}
+
+const (
+	defaultListen   = "127.0.0.1:8080"
+	defaultSchema   = "schema.graphql"
+	defaultDatabase = "listkeeper.db"
+	defaultDataDir  = "data"
+	defaultInterval = 15 * time.Minute
+	defaultWorkers  = 4
+	defaultFrom     = "Listkeeper <notifications@listkeeper.io>"
+)
+
+// LoadConfig reads the config file and fills in defaults.
+func LoadConfig(path string) (*Config, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(buf, &cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.Listen == "" {
+		cfg.Listen = defaultListen
+	}
+	if cfg.Schema == "" {
+		cfg.Schema = defaultSchema
+	}
+	if cfg.Database == "" {
+		cfg.Database = defaultDatabase
+	}
+	if cfg.DataDir == "" {
+		cfg.DataDir = defaultDataDir
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.Workers == 0 {
+		cfg.Workers = defaultWorkers
+	}
+	if cfg.Email.From == "" {
+		cfg.Email.From = defaultFrom
+	}
+
+	return &cfg, cfg.Validate()
+}
+
+func (cfg *Config) Validate() error {
+	err := valid.ValidateStruct(cfg,
+		valid.Field(&cfg.Listen, valid.Required),
+		valid.Field(&cfg.APIKey, valid.Required),
+		valid.Field(&cfg.Interval, valid.Min(time.Minute)),
+		valid.Field(&cfg.Workers, valid.Min(1)),
+		valid.Field(&cfg.AppURL, is.URL),
+		valid.Field(&cfg.Twitter, valid.By(func(interface{}) error {
+			return valid.ValidateStruct(&cfg.Twitter,
+				valid.Field(&cfg.Twitter.ConsumerKey, valid.Required),
+				valid.Field(&cfg.Twitter.ConsumerSecret, valid.Required),
+			)
+		})),
+		valid.Field(&cfg.Slack, valid.By(func(interface{}) error {
+			app := cfg.Slack.ClientID != ""
+			return valid.ValidateStruct(&cfg.Slack,
+				valid.Field(&cfg.Slack.ClientSecret, valid.When(app, valid.Required)),
+				valid.Field(&cfg.Slack.SigningSecret, valid.When(app, valid.Required)),
+				valid.Field(&cfg.Slack.TokenKey, valid.When(app, valid.Required), valid.By(func(interface{}) error {
+					if cfg.Slack.TokenKey == "" {
+						return nil
+					}
+					_, err := secret.NewBox(cfg.Slack.TokenKey)
+					return err
+				})),
+			)
+		})),
+		valid.Field(&cfg.Telegram, valid.By(func(interface{}) error {
+			return valid.ValidateStruct(&cfg.Telegram,
+				valid.Field(&cfg.Telegram.SecretToken, valid.When(cfg.Telegram.BotToken != "", valid.Required)),
+			)
+		})),
+		valid.Field(&cfg.Push, valid.By(func(interface{}) error {
+			if cfg.Push.VAPIDPrivateKey == "" {
+				return nil
+			}
+			_, err := notify.ParseVAPIDKey(cfg.Push.VAPIDPrivateKey)
+			return err
+		})),
+		valid.Field(&cfg.Accounts, valid.Each(valid.By(func(v interface{}) error {
+			a, _ := v.(Account)
+			return valid.ValidateStruct(&a,
+				valid.Field(&a.AccessToken, valid.Required),
+				valid.Field(&a.AccessSecret, valid.Required),
+			)
+		}))),
+	)
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("config -> %s", err) //nolint:errorlint
+}
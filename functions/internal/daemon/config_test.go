@@ -0,0 +1,56 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "listkeeperd.yml")
+	err := os.WriteFile(path, []byte(`
+apiKey: secret
+interval: 5m
+twitter:
+  consumerKey: key
+  consumerSecret: secret
+accounts:
+  - accessToken: token
+    accessSecret: secret
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(5*time.Minute, cfg.Interval); diff != "" {
+		t.Error(diff)
+	}
+	if diff := cmp.Diff(defaultWorkers, cfg.Workers); diff != "" {
+		t.Error(diff)
+	}
+	if diff := cmp.Diff([]Account{{AccessToken: "token", AccessSecret: "secret"}}, cfg.Accounts); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestLoadConfigExample(t *testing.T) {
+	// The example lacks credentials on purpose
+	_, err := LoadConfig("../../cmd/listkeeperd/listkeeperd.example.yml")
+
+	want := "config -> Accounts: (0: (AccessSecret: cannot be blank; AccessToken: cannot be blank.).); " +
+		"Twitter: (ConsumerKey: cannot be blank; ConsumerSecret: cannot be blank.)."
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	if diff := cmp.Diff(want, err.Error()); diff != "" {
+		t.Error(diff)
+	}
+}
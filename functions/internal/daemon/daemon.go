@@ -0,0 +1,343 @@
+// Package daemon runs Listkeeper as a single self-hosted process: a scheduler
+// in place of the EventBridge rule, workers for the follower pipeline, and an
+// HTTP server for the GraphQL API, with all data kept in SQLite.
+package daemon
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/budget"
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/graphql"
+	"github.com/mlafeldt/listkeeper/functions/internal/local"
+	"github.com/mlafeldt/listkeeper/functions/internal/notify"
+	"github.com/mlafeldt/listkeeper/functions/internal/pipeline"
+	"github.com/mlafeldt/listkeeper/functions/internal/resolvegraphql"
+	"github.com/mlafeldt/listkeeper/functions/internal/secret"
+	"github.com/mlafeldt/listkeeper/functions/internal/servefeed"
+	"github.com/mlafeldt/listkeeper/functions/internal/slackapp"
+	"github.com/mlafeldt/listkeeper/functions/internal/sqlite"
+	"github.com/mlafeldt/listkeeper/functions/internal/telegrambot"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+const (
+	shutdownTimeout = 30 * time.Second
+
+	// flushInterval is how often buffered bursts are delivered between cycles,
+	// like the rule that triggers notify-user on AWS.
+	flushInterval = 5 * time.Minute
+)
+
+type Daemon struct {
+	cfg      *Config
+	store    *sqlite.Store
+	table    *data.LocalTable
+	pipeline *pipeline.Pipeline
+	resolver *resolvegraphql.Handler
+	accounts *accountDirectory
+	server   *http.Server
+}
+
+// New sets up all components. The Twitter API can be replaced for testing;
+// if nil, the real API is used with the configured consumer key.
+func New(ctx context.Context, cfg *Config, tw twitter.API) (*Daemon, error) {
+	store, err := sqlite.Open(cfg.Database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	var (
+		table   = data.NewLocalTable(store)
+		planner = budget.NewPlanner(table)
+	)
+
+	if tw == nil {
+		tw = twitter.NewClient(cfg.Twitter.ConsumerKey, cfg.Twitter.ConsumerSecret, planner)
+	}
+
+	notifiers, err := newNotifiers(cfg, table)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	p := pipeline.New(pipeline.Config{
+		Table:     table,
+		Bucket:    local.NewBucket(filepath.Join(cfg.DataDir, "followers")),
+		Twitter:   tw,
+		Budget:    planner,
+		Notifiers: notifiers,
+		AppURL:    cfg.AppURL,
+		TableTTL:  cfg.TableTTL,
+		EventTTL:  cfg.EventTTL,
+	})
+
+	d := &Daemon{
+		cfg:      cfg,
+		store:    store,
+		table:    table,
+		pipeline: p,
+	}
+
+	var directory resolvegraphql.Directory
+	if cfg.Auth0.Domain != "" {
+		directory, err = resolvegraphql.NewAuth0Directory(cfg.Auth0.Domain, cfg.Auth0.ClientID, cfg.Auth0.ClientSecret)
+	} else {
+		d.accounts, err = newAccountDirectory(ctx, tw, cfg.Accounts)
+		directory = d.accounts
+	}
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	d.resolver = &resolvegraphql.Handler{
+		Table:     table,
+		Budget:    planner,
+		EVB:       p.Bus,
+		Directory: directory,
+		Notifiers: notifiers,
+	}
+	if n, ok := notifiers.Get(notify.ChannelPush); ok {
+		d.resolver.VAPIDKey = n.(*notify.Push).Key.PublicKey()
+	}
+
+	sdl, err := os.ReadFile(cfg.Schema)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	schema, err := graphql.LoadSchema(filepath.Base(cfg.Schema), string(sdl))
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/graphql", d.requireAPIKey(graphql.NewServer(schema, d.resolve)))
+	mux.Handle("/feed/", &servefeed.Handler{Table: table, AppURL: cfg.AppURL})
+	if cfg.Telegram.BotToken != "" {
+		mux.Handle("/telegram", &telegrambot.Handler{
+			Table:       table,
+			Bot:         &notify.Telegram{Token: cfg.Telegram.BotToken},
+			SecretToken: cfg.Telegram.SecretToken,
+		})
+	}
+	if cfg.Slack.ClientID != "" {
+		box, err := secret.NewBox(cfg.Slack.TokenKey)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		mux.Handle("/slack/", &slackapp.Handler{
+			Table:         table,
+			Tokens:        box,
+			ClientID:      cfg.Slack.ClientID,
+			ClientSecret:  cfg.Slack.ClientSecret,
+			SigningSecret: cfg.Slack.SigningSecret,
+			AppURL:        cfg.AppURL,
+			Twitter:       tw,
+		})
+	}
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+
+	d.server = &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second, //nolint:gomnd
+	}
+
+	return d, nil
+}
+
+func newNotifiers(cfg *Config, table data.TableAPI) (*notify.Registry, error) {
+	nc := notify.Config{
+		SlackUsername:    cfg.Slack.Username,
+		SlackIconURL:     cfg.Slack.IconURL,
+		SlackTokenKey:    cfg.Slack.TokenKey,
+		AppURL:           cfg.AppURL,
+		EmailFrom:        cfg.Email.From,
+		TelegramBotToken: cfg.Telegram.BotToken,
+		VAPIDPrivateKey:  cfg.Push.VAPIDPrivateKey,
+	}
+	nc.SMTP.Addr = cfg.Email.SMTPAddr
+	nc.SMTP.Username = cfg.Email.Username
+	nc.SMTP.Password = cfg.Email.Password
+
+	notifiers, err := notify.NewRegistryFromConfig(&nc, table)
+	if err != nil {
+		return nil, err
+	}
+	notifiers.TrackDeliveries(&notify.Deliveries{Table: table})
+
+	return notifiers, nil
+}
+
+// Handler returns the HTTP handler of the API.
+func (d *Daemon) Handler() http.Handler {
+	return d.server.Handler
+}
+
+// Run starts workers, scheduler, and HTTP server, and blocks until ctx is
+// done. Pending work is finished before it returns.
+func (d *Daemon) Run(ctx context.Context) error {
+	defer d.store.Close()
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.pipeline.Lambda.Serve(ctx, d.cfg.Workers)
+	}()
+
+	if err := d.registerAccounts(ctx); err != nil {
+		return err
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.schedule(ctx)
+	}()
+
+	errc := make(chan error, 1)
+	go func() {
+		log.Printf("listening on %s", d.cfg.Listen)
+		if err := d.server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			errc <- err
+		}
+		close(errc)
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errc:
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if serr := d.server.Shutdown(shutdownCtx); serr != nil && err == nil {
+		err = serr
+	}
+
+	wg.Wait()
+	return err
+}
+
+// schedule replaces the EventBridge rules that trigger enqueue-users and
+// notify-user.
+func (d *Daemon) schedule(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+	flushTicker := time.NewTicker(flushInterval)
+	defer flushTicker.Stop()
+
+	d.RunCycle(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.RunCycle(ctx)
+		case <-flushTicker.C:
+			if _, err := d.pipeline.FlushDeferredEvents(ctx); err != nil {
+				log.Printf("failed to flush deferred events: %s", err)
+			}
+		}
+	}
+}
+
+// RunCycle checks all due users, sends notifications held back during quiet
+// hours or buffered as bursts as well as due digests, and expires old data.
+func (d *Daemon) RunCycle(ctx context.Context) {
+	res, err := d.pipeline.RunCycle(ctx)
+	if err != nil {
+		log.Printf("cycle failed: %s", err)
+		return
+	}
+
+	log.Printf("cycle: %d users checked, %d deferred, %d events, %d notifications",
+		res.Enqueued.TotalUsers, res.Enqueued.DeferredUsers, len(res.Events), len(res.Notifications))
+	for _, err := range res.Errors {
+		log.Printf("cycle error: %s", err)
+	}
+
+	if _, err := d.pipeline.FlushDeferredEvents(ctx); err != nil {
+		log.Printf("failed to flush deferred events: %s", err)
+	}
+
+	if out, err := d.pipeline.SendDigests(ctx); err != nil {
+		log.Printf("failed to send digests: %s", err)
+	} else if len(out.UserIDs) > 0 {
+		log.Printf("sent %d digests", len(out.UserIDs))
+	}
+
+	n, err := d.table.Expire(ctx, time.Now())
+	if err != nil {
+		log.Printf("failed to expire items: %s", err)
+	} else if n > 0 {
+		log.Printf("expired %d items", n)
+	}
+}
+
+// registerAccounts signs up configured accounts that aren't known yet.
+func (d *Daemon) registerAccounts(ctx context.Context) error {
+	if d.accounts == nil {
+		return nil
+	}
+
+	for _, user := range d.accounts.Users() {
+		if _, err := d.table.GetUser(ctx, user.ID); !errors.Is(err, data.ErrUserNotFound) {
+			continue
+		}
+
+		res, err := d.pipeline.SignUp(ctx, user)
+		if err != nil {
+			return fmt.Errorf("failed to sign up @%s: %w", user.Handle, err)
+		}
+		log.Printf("signed up @%s with %d events", user.Handle, len(res.Events))
+	}
+
+	return nil
+}
+
+// resolve hands GraphQL fields to the same handler AppSync invokes. Requests
+// are authorized with the API key, so they come without OIDC identity.
+func (d *Daemon) resolve(ctx context.Context, field *graphql.Field) (interface{}, error) {
+	return d.resolver.Handle(ctx, resolvegraphql.Event{
+		Info: resolvegraphql.Info{
+			FieldName:        field.Name,
+			ParentTypeName:   field.ParentTypeName,
+			Variables:        field.Variables,
+			SelectionSetList: field.SelectionSetList,
+		},
+		Arguments: field.Arguments,
+	})
+}
+
+// requireAPIKey checks the x-api-key header, just like AppSync does.
+func (d *Daemon) requireAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("x-api-key")
+		if subtle.ConstantTimeCompare([]byte(key), []byte(d.cfg.APIKey)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
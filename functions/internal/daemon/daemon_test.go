@@ -0,0 +1,162 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/local"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+const apiKey = "secret-key"
+
+func newDaemon(t *testing.T) *Daemon {
+	t.Helper()
+
+	dir := t.TempDir()
+	cfg := &Config{
+		Listen:   "127.0.0.1:0",
+		APIKey:   apiKey,
+		Schema:   "../../../schema.graphql",
+		Database: filepath.Join(dir, "listkeeper.db"),
+		DataDir:  dir,
+		Interval: time.Minute,
+		Workers:  2,
+		Accounts: []Account{{AccessToken: "token", AccessSecret: "secret"}},
+	}
+
+	tw := local.NewTwitter(&local.Fixture{
+		Accounts: []local.Account{{
+			User: twitter.User{
+				ID:              "111",
+				Handle:          "alice",
+				Name:            "Alice",
+				ProfileImageURL: "https://example.com/alice.jpg",
+			},
+			AccessToken:  "token",
+			AccessSecret: "secret",
+			Followers:    []int64{1, 2},
+		}},
+	})
+
+	d, err := New(context.Background(), cfg, tw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.store.Close() })
+
+	if err := d.registerAccounts(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+func query(t *testing.T, d *Daemon, key, q string) (int, map[string]interface{}) {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]string{"query": q})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body))
+	req.Header.Set("x-api-key", key)
+	rec := httptest.NewRecorder()
+	d.Handler().ServeHTTP(rec, req)
+
+	var res map[string]interface{}
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, res
+}
+
+func TestAPIKey(t *testing.T) {
+	d := newDaemon(t)
+
+	code, _ := query(t, d, "wrong", `{ ping }`)
+	if diff := cmp.Diff(http.StatusUnauthorized, code); diff != "" {
+		t.Error(diff)
+	}
+
+	code, res := query(t, d, apiKey, `{ ping }`)
+	if diff := cmp.Diff(http.StatusOK, code); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff(map[string]interface{}{"data": map[string]interface{}{"ping": "pong"}}, res); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestGetAndUpdateUser(t *testing.T) {
+	d := newDaemon(t)
+
+	_, res := query(t, d, apiKey, `mutation {
+		updateUser(id: "111", input: {trackingStatus: PAUSED, ignoreFollowers: ["@bob"]}) { id }
+	}`)
+	if res["errors"] != nil {
+		t.Fatal(res["errors"])
+	}
+
+	_, res = query(t, d, apiKey, `{ getUser(id: "111") { handle trackingStatus ignoreFollowers slack { enabled } } }`)
+	want := map[string]interface{}{
+		"data": map[string]interface{}{
+			"getUser": map[string]interface{}{
+				"handle":          "alice",
+				"trackingStatus":  data.TrackingStatusPaused,
+				"ignoreFollowers": []interface{}{"@bob"},
+				"slack":           map[string]interface{}{"enabled": false},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, res); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestSignedUpAccount(t *testing.T) {
+	d := newDaemon(t)
+
+	lists, err := d.table.GetLatestFollowerLists(context.Background(), "111", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(1, len(lists)); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff(2, lists[0].TotalFollowers); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestRun(t *testing.T) {
+	d := newDaemon(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not shut down")
+	}
+}
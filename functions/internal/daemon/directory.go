@@ -0,0 +1,74 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/resolvegraphql"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+var _ resolvegraphql.Directory = (*accountDirectory)(nil)
+
+// accountDirectory serves the accounts from the config file in place of
+// Auth0. Profiles are looked up on Twitter once at startup.
+type accountDirectory struct {
+	users map[string]*data.User
+}
+
+func newAccountDirectory(ctx context.Context, tw twitter.API, accounts []Account) (*accountDirectory, error) {
+	var (
+		d   = &accountDirectory{users: map[string]*data.User{}}
+		now = time.Now()
+	)
+
+	for _, a := range accounts {
+		profile, err := tw.CurrentUser(ctx, a.AccessToken, a.AccessSecret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up account: %w", err)
+		}
+
+		user := data.NewUser(profile.ID)
+		user.Handle = profile.Handle
+		user.Name = profile.Name
+		user.Location = profile.Location
+		user.Bio = profile.Bio
+		user.ProfileImageURL = profile.ProfileImageURL
+		user.AccessToken = a.AccessToken
+		user.AccessSecret = a.AccessSecret
+		user.LastLogin = now
+		user.LastIP = "127.0.0.1"
+		user.LoginsCount = 1
+
+		d.users[user.ID] = user
+	}
+
+	return d, nil
+}
+
+func (d *accountDirectory) User(ctx context.Context, userID string) (*data.User, error) {
+	u, ok := d.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("no account configured for user with ID %s", userID)
+	}
+	c := *u
+	return &c, nil
+}
+
+// DeleteUser is a no-op; remove the account from the config file to stop
+// tracking it for good.
+func (d *accountDirectory) DeleteUser(ctx context.Context, userID string) error {
+	return nil
+}
+
+// Users returns all configured users.
+func (d *accountDirectory) Users() []*data.User {
+	users := make([]*data.User, 0, len(d.users))
+	for id := range d.users {
+		u := *d.users[id]
+		users = append(users, &u)
+	}
+	return users
+}
@@ -1,22 +1,40 @@
 package data
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
 	valid "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"github.com/segmentio/ksuid"
+	"golang.org/x/text/language"
 
 	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
 )
 
 const (
-	userIndex = "UserIndex"
+	userIndex     = "UserIndex"
+	scheduleIndex = "ScheduleIndex"
+	scheduleKey   = "SCHEDULE"
+	deferredKey   = "DEFERRED" // partition of deferred events in ScheduleIndex
+	retryKey      = "RETRY"    // partition of webhook deliveries to retry in ScheduleIndex
 
 	typeUser          = "User"
 	typeFollowerList  = "FollowerList"
 	typeFollowerEvent = "FollowerEvent"
+	typeRateBudget    = "RateBudget"
+	typeDelivery      = "WebhookDelivery"
+	typeDeferredEvent = "DeferredEvent"
+	typeNotification  = "NotificationDelivery"
+	typeFollowerChurn = "FollowerChurn"
 
 	FollowerStateNew              = "NEW"
 	FollowerStateLost             = "LOST"
@@ -24,53 +42,865 @@ const (
 	FollowerStateReasonUnfollowed = "UNFOLLOWED"
 	FollowerStateReasonDeleted    = "DELETED"
 	FollowerStateReasonSuspended  = "SUSPENDED"
+	FollowerStateReasonRefollowed = "REFOLLOWED"
+
+	// Lost followers are deactivated until re-checking confirms that they
+	// are deleted, went protected if they still follow but are no longer
+	// listed, blocked the user, or were removed or blocked by the user.
+	FollowerStateReasonDeactivated = "DEACTIVATED"
+	FollowerStateReasonProtected   = "PROTECTED"
+	FollowerStateReasonBlocked     = "BLOCKED"
+	FollowerStateReasonRemoved     = "REMOVED"
+
+	TrackingStatusActive      = "ACTIVE"
+	TrackingStatusPaused      = "PAUSED"
+	TrackingStatusNeedsReauth = "NEEDS_REAUTH"
+	TrackingStatusDisabled    = "DISABLED"
+
+	DeliveryStatusPending = "PENDING"
+	DeliveryStatusSent    = "SENT"
+	DeliveryStatusFailed  = "FAILED"
+
+	LocaleEnglish = "en"
+	LocaleGerman  = "de"
+
+	DefaultCheckInterval = 1 * time.Hour
+	MinCheckInterval     = 15 * time.Minute
+	MaxCheckInterval     = 24 * time.Hour
 )
 
 type User struct {
-	ID              string      `json:"id" dynamo:"UserID"`
-	Handle          string      `json:"handle"`
-	Name            string      `json:"name"`
-	Location        string      `json:"location,omitempty"`
-	Bio             string      `json:"bio,omitempty"`
-	ProfileImageURL string      `json:"profileImageUrl"`
-	AccessToken     string      `json:"-"`
-	AccessSecret    string      `json:"-"`
-	Slack           SlackConfig `json:"slack"`
-	IgnoreFollowers []string    `json:"ignoreFollowers,omitempty" dynamo:",set,omitempty"`
-	CreatedAt       time.Time   `json:"createdAt"`
-	UpdatedAt       time.Time   `json:"updatedAt"`
-	LastLogin       time.Time   `json:"lastLogin"`
-	LastIP          string      `json:"-"`
-	LoginsCount     int64       `json:"-"`
-	IDP             string      `json:"-" dynamo:"IdP"`
+	ID              string                `json:"id" dynamo:"UserID"`
+	Handle          string                `json:"handle"`
+	Name            string                `json:"name"`
+	Location        string                `json:"location,omitempty"`
+	Bio             string                `json:"bio,omitempty"`
+	ProfileImageURL string                `json:"profileImageUrl"`
+	AccessToken     string                `json:"-"`
+	AccessSecret    string                `json:"-"`
+	Slack           SlackConfig           `json:"slack"`
+	Email           EmailConfig           `json:"email"`
+	Discord         DiscordConfig         `json:"discord"`
+	Telegram        TelegramConfig        `json:"telegram"`
+	Push            PushConfig            `json:"push" dynamo:",omitempty"`
+	Webhooks        []*Webhook            `json:"webhooks,omitempty" dynamo:",omitempty"`
+	FeedToken       string                `json:"feedToken,omitempty" dynamo:",omitempty"`
+	Digest          DigestConfig          `json:"digest" dynamo:",omitempty"`
+	QuietHours      QuietHours            `json:"quietHours" dynamo:",omitempty"`
+	Burst           BurstConfig           `json:"burst" dynamo:",omitempty"`
+	Refollow        RefollowConfig        `json:"refollow" dynamo:",omitempty"`
+	Alerts          AlertConfig           `json:"alerts" dynamo:",omitempty"`
+	Stats           FollowerStats         `json:"-" dynamo:",omitempty"`
+	Templates       NotificationTemplates `json:"templates" dynamo:",omitempty"`
+	Timezone        string                `json:"timezone,omitempty" dynamo:",omitempty"`
+	Locale          string                `json:"locale,omitempty" dynamo:",omitempty"`
+	IgnoreFollowers []string              `json:"ignoreFollowers,omitempty" dynamo:",set,omitempty"`
+	TrackingStatus  string                `json:"trackingStatus"`
+	CreatedAt       time.Time             `json:"createdAt"`
+	UpdatedAt       time.Time             `json:"updatedAt"`
+	LastLogin       time.Time             `json:"lastLogin"`
+	LastIP          string                `json:"-"`
+	LoginsCount     int64                 `json:"-"`
+	IDP             string                `json:"-" dynamo:"IdP"`
+	CheckInterval   time.Duration         `json:"-"`
+	NextCheckAt     time.Time             `json:"nextCheckAt"`
+}
+
+// NotificationFilter decides which follower events are sent via a channel.
+// Empty lists match all states and reasons. Deleted, deactivated, and
+// suspended followers come without a profile, so only states and reasons
+// apply to them.
+type NotificationFilter struct {
+	States        []string `json:"states,omitempty" dynamo:",omitempty"`
+	Reasons       []string `json:"reasons,omitempty" dynamo:",omitempty"`
+	MinFollowers  int      `json:"minFollowers" dynamo:",omitempty"`
+	ProtectedOnly bool     `json:"protectedOnly" dynamo:",omitempty"`
+	VerifiedOnly  bool     `json:"verifiedOnly" dynamo:",omitempty"`
+}
+
+func (f NotificationFilter) Validate() error {
+	return valid.ValidateStruct(&f,
+		valid.Field(&f.States, valid.Each(valid.In(FollowerStateNew, FollowerStateLost))),
+		valid.Field(&f.Reasons, valid.Each(valid.In(
+			FollowerStateReasonFollowed,
+			FollowerStateReasonUnfollowed,
+			FollowerStateReasonDeleted,
+			FollowerStateReasonSuspended,
+			FollowerStateReasonRefollowed,
+			FollowerStateReasonDeactivated,
+			FollowerStateReasonProtected,
+			FollowerStateReasonBlocked,
+			FollowerStateReasonRemoved,
+		))),
+		valid.Field(&f.MinFollowers, valid.Min(0)),
+	)
+}
+
+// Match reports whether the event passes the filter. A nil filter matches
+// all events.
+func (f *NotificationFilter) Match(e *FollowerEvent) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.States) > 0 && !contains(f.States, e.FollowerState) {
+		return false
+	}
+	if len(f.Reasons) > 0 && !contains(f.Reasons, e.FollowerStateReason) {
+		return false
+	}
+
+	switch e.FollowerStateReason {
+	case FollowerStateReasonDeleted, FollowerStateReasonDeactivated, FollowerStateReasonSuspended:
+		return true
+	}
+
+	follower := e.Follower
+	if follower.TotalFollowers < f.MinFollowers {
+		return false
+	}
+	if f.ProtectedOnly && !follower.Protected {
+		return false
+	}
+	if f.VerifiedOnly && !follower.Verified {
+		return false
+	}
+	return true
 }
 
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// SlackConfig is either set up with an incoming webhook or by installing the
+// Slack app, which posts with a bot token instead. The app also sets the
+// webhook of the channel chosen during the install.
 type SlackConfig struct {
-	Enabled    bool   `json:"enabled"`
-	WebhookURL string `json:"webhookUrl,omitempty" dynamo:",omitempty"`
-	Channel    string `json:"channel,omitempty" dynamo:",omitempty"`
+	Enabled    bool                `json:"enabled"`
+	WebhookURL string              `json:"webhookUrl,omitempty" dynamo:",omitempty"`
+	Channel    string              `json:"channel,omitempty" dynamo:",omitempty"`
+	Filter     *NotificationFilter `json:"filter,omitempty" dynamo:",omitempty"`
+
+	TeamID                string       `json:"-" dynamo:",omitempty"`
+	TeamName              string       `json:"-" dynamo:",omitempty"`
+	ChannelID             string       `json:"-" dynamo:",omitempty"`
+	BotToken              string       `json:"-" dynamo:",omitempty"` // encrypted
+	Thread                *SlackThread `json:"-" dynamo:",omitempty"`
+	InstallState          string       `json:"-" dynamo:",omitempty"`
+	InstallStateExpiresAt time.Time    `json:"-" dynamo:",omitempty"`
+}
+
+// SlackThread is the message that events of the same run are posted to as
+// replies.
+type SlackThread struct {
+	RunID string
+	TS    string
+}
+
+// Installed reports whether the Slack app was installed.
+func (c SlackConfig) Installed() bool {
+	return c.BotToken != ""
+}
+
+// MarshalJSON adds the workspace of the installed app, if any.
+func (c SlackConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Enabled    bool                `json:"enabled"`
+		WebhookURL string              `json:"webhookUrl,omitempty"`
+		Channel    string              `json:"channel,omitempty"`
+		Filter     *NotificationFilter `json:"filter,omitempty"`
+		Installed  bool                `json:"installed"`
+		TeamName   string              `json:"teamName,omitempty"`
+	}{c.Enabled, c.WebhookURL, c.Channel, c.Filter, c.Installed(), c.TeamName})
 }
 
 func (c SlackConfig) Validate() error {
 	return valid.ValidateStruct(&c,
 		valid.Field(&c.Enabled),
-		valid.Field(&c.WebhookURL, valid.When(c.Enabled, valid.Required, is.URL)),
+		valid.Field(&c.WebhookURL, valid.When(c.Enabled && !c.Installed(), valid.Required), is.URL),
 		valid.Field(&c.Channel), // FIXME: too permissive
+		valid.Field(&c.Filter),
+		valid.Field(&c.ChannelID, valid.When(c.Installed(), valid.Required)),
+	)
+}
+
+// SlackInstallStateTTL is how long an install of the Slack app may take.
+const SlackInstallStateTTL = 15 * time.Minute
+
+// NewSlackInstallState returns the OAuth state that identifies the user
+// when the Slack app install redirects back to us, replacing any previous
+// one.
+func (u *User) NewSlackInstallState(now time.Time) (string, error) {
+	state, err := newOneTimeCode(u.ID)
+	if err != nil {
+		return "", err
+	}
+	u.Slack.InstallState = state
+	u.Slack.InstallStateExpiresAt = now.Add(SlackInstallStateTTL)
+	return state, nil
+}
+
+// SlackInstallStateUserID returns the ID of the user an install state
+// belongs to.
+func SlackInstallStateUserID(state string) (string, bool) {
+	return oneTimeCodeUserID(state)
+}
+
+// SlackInstall is the result of installing the Slack app.
+type SlackInstall struct {
+	TeamID     string
+	TeamName   string
+	ChannelID  string
+	Channel    string
+	WebhookURL string
+	BotToken   string // encrypted
+}
+
+// ValidSlackInstallState reports whether the state may be used to install
+// the Slack app.
+func (u *User) ValidSlackInstallState(state string, now time.Time) bool {
+	c := &u.Slack
+	return c.InstallState != "" && subtle.ConstantTimeCompare([]byte(state), []byte(c.InstallState)) == 1 && !now.After(c.InstallStateExpiresAt)
+}
+
+// InstallSlack enables notifications via the installed app if the state is
+// valid. Each state can only be used once.
+func (u *User) InstallSlack(state string, install *SlackInstall, now time.Time) error {
+	if !u.ValidSlackInstallState(state, now) {
+		return ErrSlackInstallStateInvalid
+	}
+	c := &u.Slack
+	*c = SlackConfig{
+		Enabled:    true,
+		WebhookURL: install.WebhookURL,
+		Channel:    install.Channel,
+		Filter:     c.Filter,
+		TeamID:     install.TeamID,
+		TeamName:   install.TeamName,
+		ChannelID:  install.ChannelID,
+		BotToken:   install.BotToken,
+	}
+	return nil
+}
+
+// UninstallSlack disables Slack notifications after the app was removed
+// from the workspace, which also revokes its webhook.
+func (u *User) UninstallSlack() {
+	u.Slack = SlackConfig{Filter: u.Slack.Filter}
+}
+
+type EmailConfig struct {
+	Enabled bool                `json:"enabled"`
+	Address string              `json:"address,omitempty" dynamo:",omitempty"`
+	Filter  *NotificationFilter `json:"filter,omitempty" dynamo:",omitempty"`
+}
+
+func (c EmailConfig) Validate() error {
+	return valid.ValidateStruct(&c,
+		valid.Field(&c.Enabled),
+		valid.Field(&c.Address, valid.When(c.Enabled, valid.Required), is.EmailFormat),
+		valid.Field(&c.Filter),
+	)
+}
+
+type DiscordConfig struct {
+	Enabled    bool                `json:"enabled"`
+	WebhookURL string              `json:"webhookUrl,omitempty" dynamo:",omitempty"`
+	Filter     *NotificationFilter `json:"filter,omitempty" dynamo:",omitempty"`
+}
+
+var discordWebhookURL = regexp.MustCompile(`^https://(?:(?:ptb|canary)\.)?discord(?:app)?\.com/api/webhooks/\d+/[\w-]+$`)
+
+func (c DiscordConfig) Validate() error {
+	return valid.ValidateStruct(&c,
+		valid.Field(&c.Enabled),
+		valid.Field(&c.WebhookURL, valid.When(c.Enabled, valid.Required),
+			valid.Match(discordWebhookURL).Error("must be a Discord webhook URL")),
+		valid.Field(&c.Filter),
+	)
+}
+
+const (
+	DigestModeOff    = "OFF"
+	DigestModeDaily  = "DAILY"
+	DigestModeWeekly = "WEEKLY"
+
+	// DefaultDigestHour is when digests are sent unless set otherwise
+	DefaultDigestHour = 9
+)
+
+// DigestConfig lets users get one summary per day or week instead of a
+// notification for every follower event.
+type DigestConfig struct {
+	Mode       string    `json:"mode" dynamo:",omitempty"`
+	Hour       *int      `json:"hour" dynamo:",omitempty"` // local time, see User.Timezone
+	LastSentAt time.Time `json:"-" dynamo:",omitempty"`
+}
+
+// MarshalJSON fills in the defaults of unset fields.
+func (c DigestConfig) MarshalJSON() ([]byte, error) {
+	mode, hour := c.Mode, DefaultDigestHour
+	if mode == "" {
+		mode = DigestModeOff
+	}
+	if c.Hour != nil {
+		hour = *c.Hour
+	}
+	return json.Marshal(struct {
+		Mode string `json:"mode"`
+		Hour int    `json:"hour"`
+	}{mode, hour})
+}
+
+func (c DigestConfig) Validate() error {
+	return valid.ValidateStruct(&c,
+		valid.Field(&c.Mode, valid.In(DigestModeOff, DigestModeDaily, DigestModeWeekly)),
+		valid.Field(&c.Hour, valid.Min(0), valid.Max(23)),
+	)
+}
+
+// Enabled reports whether events are collected for a digest rather than
+// notified right away.
+func (c DigestConfig) Enabled() bool {
+	return c.Mode == DigestModeDaily || c.Mode == DigestModeWeekly
+}
+
+// MaxTemplateLength limits the size of each notification template.
+const MaxTemplateLength = 2000
+
+// NotificationTemplates customize the notifications about follower changes, see
+// notify.TemplateData for what they can refer to. Empty templates fall back
+// to the defaults.
+type NotificationTemplates struct {
+	Header string `json:"header,omitempty" dynamo:",omitempty"`
+	Text   string `json:"text,omitempty" dynamo:",omitempty"`
+	Footer string `json:"footer,omitempty" dynamo:",omitempty"`
+}
+
+func (t NotificationTemplates) Validate() error {
+	return valid.ValidateStruct(&t,
+		valid.Field(&t.Header, valid.RuneLength(0, MaxTemplateLength)),
+		valid.Field(&t.Text, valid.RuneLength(0, MaxTemplateLength)),
+		valid.Field(&t.Footer, valid.RuneLength(0, MaxTemplateLength)),
+	)
+}
+
+// QuietHours is a daily window in the user's timezone during which follower
+// events are held back and delivered once the window ends. The window may
+// span midnight, e.g. from 22 to 7.
+type QuietHours struct {
+	Enabled  bool `json:"enabled" dynamo:",omitempty"`
+	Start    int  `json:"start" dynamo:",omitempty"`
+	End      int  `json:"end" dynamo:",omitempty"`
+	Coalesce bool `json:"coalesce" dynamo:",omitempty"` // deliver held events as one message
+}
+
+func (q QuietHours) Validate() error {
+	return valid.ValidateStruct(&q,
+		valid.Field(&q.Start, valid.Min(0), valid.Max(23)),
+		valid.Field(&q.End, valid.Min(0), valid.Max(23),
+			valid.When(q.Enabled, valid.NotIn(q.Start).Error("must differ from start"))),
+	)
+}
+
+// InQuietHours reports whether notifications are held back at the given time.
+func (u *User) InQuietHours(now time.Time) bool {
+	q := u.QuietHours
+	if !q.Enabled || q.Start == q.End {
+		return false
+	}
+
+	hour := now.In(u.TimeZone()).Hour()
+	if q.Start < q.End {
+		return hour >= q.Start && hour < q.End
+	}
+	return hour >= q.Start || hour < q.End
+}
+
+const (
+	MinBurstWindow     = 5 // minutes, how often buffered events are flushed
+	MaxBurstWindow     = 60
+	DefaultBurstWindow = 10
+)
+
+// BurstConfig buffers follower events for a few minutes after the first one,
+// so that a burst of changes, e.g. from the same diff run, is delivered as a
+// single message.
+type BurstConfig struct {
+	Enabled bool `json:"enabled" dynamo:",omitempty"`
+	Window  int  `json:"window" dynamo:",omitempty"` // minutes
+}
+
+func (b BurstConfig) Validate() error {
+	return valid.ValidateStruct(&b,
+		valid.Field(&b.Window, valid.When(b.Enabled, valid.Min(MinBurstWindow), valid.Max(MaxBurstWindow))),
+	)
+}
+
+// BurstWindow returns how long events are buffered, or zero if they aren't.
+func (u *User) BurstWindow() time.Duration {
+	if !u.Burst.Enabled {
+		return 0
+	}
+	window := u.Burst.Window
+	if window == 0 {
+		window = DefaultBurstWindow
+	}
+	return time.Duration(window) * time.Minute
+}
+
+const (
+	MinRefollowWindow     = 1 // hours, since the follower was lost
+	MaxRefollowWindow     = 7 * 24
+	DefaultRefollowWindow = 24
+
+	// SerialRefollows is how often a follower must have come back to be
+	// flagged as flapping in notifications.
+	SerialRefollows = 3
+)
+
+// RefollowConfig merges a follower coming back within the window after
+// being lost into a single REFOLLOWED event instead of a new follower, or
+// drops the event altogether if Suppress is set.
+type RefollowConfig struct {
+	Enabled  bool `json:"enabled" dynamo:",omitempty"`
+	Window   int  `json:"window" dynamo:",omitempty"` // hours
+	Suppress bool `json:"suppress" dynamo:",omitempty"`
+}
+
+func (c RefollowConfig) Validate() error {
+	return valid.ValidateStruct(&c,
+		valid.Field(&c.Window, valid.When(c.Enabled, valid.Min(MinRefollowWindow), valid.Max(MaxRefollowWindow))),
+	)
+}
+
+// RefollowWindow returns how long after being lost a follower counts as
+// coming back. Refollows are counted even if they aren't merged, so unlike
+// BurstWindow, it is never zero.
+func (u *User) RefollowWindow() time.Duration {
+	window := u.Refollow.Window
+	if window == 0 {
+		window = DefaultRefollowWindow
+	}
+	return time.Duration(window) * time.Hour
+}
+
+// DefaultMilestones are the follower counts users are alerted about in
+// addition to their own.
+var DefaultMilestones = []int{1000, 5000, 10000}
+
+const (
+	DefaultLossPercent  = 5
+	DefaultSpikeFactor  = 5
+	MaxCustomMilestones = 10
+)
+
+// AlertConfig enables alerts about the follower count as a whole. Unlike
+// follower events, alerts are sent right away, regardless of quiet hours,
+// bursts, and digests.
+type AlertConfig struct {
+	Milestones       bool  `json:"milestones" dynamo:",omitempty"`
+	CustomMilestones []int `json:"customMilestones,omitempty" dynamo:",omitempty"`
+	Anomalies        bool  `json:"anomalies" dynamo:",omitempty"`
+	LossPercent      int   `json:"lossPercent" dynamo:",omitempty"` // share of followers lost in one run
+	SpikeFactor      int   `json:"spikeFactor" dynamo:",omitempty"` // changes in one run compared with the trailing average
+}
+
+func (c AlertConfig) Validate() error {
+	return valid.ValidateStruct(&c,
+		valid.Field(&c.CustomMilestones, valid.Length(0, MaxCustomMilestones), valid.Each(valid.Min(1))),
+		valid.Field(&c.LossPercent, valid.Min(1), valid.Max(100)),
+		valid.Field(&c.SpikeFactor, valid.Min(2), valid.Max(100)),
+	)
+}
+
+// AllMilestones returns the follower counts to alert about in ascending order.
+func (c AlertConfig) AllMilestones() []int {
+	milestones := append(append([]int(nil), DefaultMilestones...), c.CustomMilestones...)
+	sort.Ints(milestones)
+	return milestones
+}
+
+// FollowerStats are kept by diff-followers to detect milestones and anomalies.
+type FollowerStats struct {
+	Milestone  int     `dynamo:",omitempty"` // the highest one alerted so far
+	AvgChanges float64 `dynamo:",omitempty"` // trailing average of changes per run
+	Runs       int     `dynamo:",omitempty"` // that changed followers, up to TrailingRuns
+}
+
+// TrailingRuns is how many runs the average of changes roughly spans.
+const TrailingRuns = 20
+
+// AddRun updates the trailing average with the changes of a run, weighting
+// the oldest runs less once there are more than TrailingRuns.
+func (s *FollowerStats) AddRun(changes int) {
+	if s.Runs < TrailingRuns {
+		s.Runs++
+	}
+	s.AvgChanges += (float64(changes) - s.AvgChanges) / float64(s.Runs)
+}
+
+// TelegramConfig is set up by sending a one-time link code to the bot, which
+// tells us the chat to send notifications to.
+type TelegramConfig struct {
+	Enabled           bool                `json:"enabled"`
+	ChatID            int64               `json:"-" dynamo:",omitempty"`
+	LinkCode          string              `json:"-" dynamo:",omitempty"`
+	LinkCodeExpiresAt time.Time           `json:"-" dynamo:",omitempty"`
+	Filter            *NotificationFilter `json:"filter,omitempty" dynamo:",omitempty"`
+}
+
+// MarshalJSON only tells whether a chat is linked, not which one.
+func (c TelegramConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Enabled bool                `json:"enabled"`
+		Linked  bool                `json:"linked"`
+		Filter  *NotificationFilter `json:"filter,omitempty"`
+	}{c.Enabled, c.ChatID != 0, c.Filter})
+}
+
+func (c TelegramConfig) Validate() error {
+	return valid.ValidateStruct(&c,
+		valid.Field(&c.Enabled),
+		valid.Field(&c.ChatID, valid.When(c.Enabled, valid.Required.Error("must be linked to a chat"))),
+		valid.Field(&c.Filter),
+	)
+}
+
+// TelegramLinkCodeTTL is how long a link code can be used.
+const TelegramLinkCodeTTL = 15 * time.Minute
+
+// NewTelegramLinkCode returns a new one-time code to link a Telegram chat,
+// replacing any previous one. The code starts with the user ID so that the
+// bot knows whom it belongs to.
+func (u *User) NewTelegramLinkCode(now time.Time) (string, error) {
+	code, err := newOneTimeCode(u.ID)
+	if err != nil {
+		return "", err
+	}
+	u.Telegram.LinkCode = code
+	u.Telegram.LinkCodeExpiresAt = now.Add(TelegramLinkCodeTTL)
+	return u.Telegram.LinkCode, nil
+}
+
+// TelegramLinkCodeUserID returns the ID of the user a link code belongs to.
+func TelegramLinkCodeUserID(code string) (string, bool) {
+	return oneTimeCodeUserID(code)
+}
+
+func newOneTimeCode(userID string) (string, error) {
+	secret := make([]byte, 8)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return userID + "-" + hex.EncodeToString(secret), nil
+}
+
+func oneTimeCodeUserID(code string) (string, bool) {
+	i := strings.LastIndexByte(code, '-')
+	if i <= 0 {
+		return "", false
+	}
+	return code[:i], true
+}
+
+// LinkTelegram sends notifications to the chat if the link code is valid.
+// Each code can only be used once.
+func (u *User) LinkTelegram(code string, chatID int64, now time.Time) error {
+	c := &u.Telegram
+	if c.LinkCode == "" || subtle.ConstantTimeCompare([]byte(code), []byte(c.LinkCode)) != 1 || now.After(c.LinkCodeExpiresAt) {
+		return ErrTelegramLinkCodeInvalid
+	}
+	c.Enabled = true
+	c.ChatID = chatID
+	c.LinkCode = ""
+	c.LinkCodeExpiresAt = time.Time{}
+	return nil
+}
+
+// MaxPushSubscriptions limits the number of browsers receiving push
+// notifications per user.
+const MaxPushSubscriptions = 10
+
+// PushConfig holds the Web Push subscriptions of the browsers on which the
+// user enabled notifications, one per device.
+type PushConfig struct {
+	Enabled       bool                `json:"enabled" dynamo:",omitempty"`
+	Subscriptions []*PushSubscription `json:"subscriptions,omitempty" dynamo:",omitempty"`
+	Filter        *NotificationFilter `json:"filter,omitempty" dynamo:",omitempty"`
+}
+
+func (c PushConfig) Validate() error {
+	return valid.ValidateStruct(&c,
+		valid.Field(&c.Enabled),
+		valid.Field(&c.Subscriptions, valid.Length(0, MaxPushSubscriptions)),
+		valid.Field(&c.Filter),
 	)
 }
 
+// PushSubscription is what the browser's PushManager returns: the endpoint
+// of the push service and the keys to encrypt messages with, see
+// https://www.w3.org/TR/push-api/#pushsubscription-interface
+type PushSubscription struct {
+	ID        string    `json:"id"`
+	Endpoint  string    `json:"endpoint"`
+	P256dh    string    `json:"-"` // base64url-encoded public key of the browser
+	Auth      string    `json:"-"` // base64url-encoded authentication secret
+	Device    string    `json:"device,omitempty" dynamo:",omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (s PushSubscription) Validate() error {
+	return valid.ValidateStruct(&s,
+		valid.Field(&s.ID, valid.Required),
+		valid.Field(&s.Endpoint, valid.Required, is.URL, valid.Match(httpsURL).Error("must be an HTTPS URL")),
+		valid.Field(&s.P256dh, valid.Required, valid.Length(87, 88)), // 65 bytes
+		valid.Field(&s.Auth, valid.Required, valid.Length(22, 24)),   // 16 bytes
+		valid.Field(&s.Device, valid.RuneLength(0, 100)),
+		valid.Field(&s.CreatedAt, valid.Required),
+	)
+}
+
+// AddPushSubscription registers a browser for push notifications. A browser
+// subscribing again replaces its previous subscription, and the oldest one
+// is dropped once there are too many.
+func (u *User) AddPushSubscription(endpoint, p256dh, auth, device string, now time.Time) (*PushSubscription, error) {
+	s := &PushSubscription{
+		ID:        ksuid.New().String(),
+		Endpoint:  endpoint,
+		P256dh:    p256dh,
+		Auth:      auth,
+		Device:    device,
+		CreatedAt: now,
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	subs := []*PushSubscription{}
+	for _, old := range u.Push.Subscriptions {
+		if old.Endpoint != endpoint {
+			subs = append(subs, old)
+		}
+	}
+	subs = append(subs, s)
+	if n := len(subs) - MaxPushSubscriptions; n > 0 {
+		subs = subs[n:]
+	}
+
+	u.Push.Subscriptions = subs
+	u.Push.Enabled = true
+	return s, nil
+}
+
+// RemovePushSubscription unregisters a browser from push notifications.
+func (u *User) RemovePushSubscription(id string) error {
+	i := pushSubscriptionIndex(u, id)
+	if i < 0 {
+		return ErrPushSubscriptionNotFound
+	}
+	u.Push.Subscriptions = append(u.Push.Subscriptions[:i:i], u.Push.Subscriptions[i+1:]...)
+	return nil
+}
+
+func pushSubscriptionIndex(u *User, id string) int {
+	for i, s := range u.Push.Subscriptions {
+		if s.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// NewFeedToken returns a new secret token for the user's feed of follower
+// events, replacing any previous one. Like link codes, the token starts with
+// the user ID so that the feed can be looked up.
+func (u *User) NewFeedToken() (string, error) {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	u.FeedToken = u.ID + "-" + hex.EncodeToString(secret)
+	return u.FeedToken, nil
+}
+
+// FeedTokenUserID returns the ID of the user a feed token belongs to.
+func FeedTokenUserID(token string) (string, bool) {
+	return oneTimeCodeUserID(token)
+}
+
+// ValidFeedToken reports whether the token grants access to the feed.
+func (u *User) ValidFeedToken(token string) bool {
+	return u.FeedToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(u.FeedToken)) == 1
+}
+
+// MaxWebhooks limits the number of webhook endpoints per user.
+const MaxWebhooks = 5
+
+// Webhook is an endpoint receiving all follower events of a user. Requests
+// are signed with Secret so that the receiver can verify them.
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret"`
+	Enabled   bool      `json:"enabled"`
+	Failures  int       `json:"failures"` // consecutive failed deliveries
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhookInput adds a new webhook if ID is empty and changes an existing one
+// otherwise.
+type WebhookInput struct {
+	ID      string
+	URL     string
+	Enabled bool
+}
+
+var httpsURL = regexp.MustCompile(`^https://`)
+
+func (w Webhook) Validate() error {
+	return valid.ValidateStruct(&w,
+		valid.Field(&w.ID, valid.Required),
+		valid.Field(&w.URL, valid.Required, is.URL, valid.Match(httpsURL).Error("must be an HTTPS URL")),
+		valid.Field(&w.Secret, valid.Required),
+		valid.Field(&w.Failures, valid.Min(0)),
+		valid.Field(&w.CreatedAt, valid.Required),
+	)
+}
+
+func NewWebhook(url string, now time.Time) (*Webhook, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return &Webhook{
+		ID:        ksuid.New().String(),
+		URL:       url,
+		Secret:    "whsec_" + hex.EncodeToString(secret),
+		Enabled:   true,
+		CreatedAt: now,
+	}, nil
+}
+
+// TimeZone returns the location of the user's timezone, which defaults to UTC.
+func (u *User) TimeZone() *time.Location {
+	if loc, err := time.LoadLocation(u.Timezone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// Language returns the language of the user's locale, which defaults to
+// English.
+func (u *User) Language() language.Tag {
+	if u.Locale == LocaleGerman {
+		return language.German
+	}
+	return language.English
+}
+
+// DigestPeriod returns the period of the latest digest that was due at the
+// given time. Daily digests are sent at the configured hour, weekly ones on
+// Mondays, both in the user's timezone. The digest is due if it hasn't been
+// sent yet.
+func (u *User) DigestPeriod(now time.Time) (from, to time.Time, due bool) {
+	if !u.Digest.Enabled() {
+		return time.Time{}, time.Time{}, false
+	}
+
+	hour := DefaultDigestHour
+	if u.Digest.Hour != nil {
+		hour = *u.Digest.Hour
+	}
+
+	local := now.In(u.TimeZone())
+	to = time.Date(local.Year(), local.Month(), local.Day(), hour, 0, 0, 0, local.Location())
+	if to.After(local) {
+		to = to.AddDate(0, 0, -1)
+	}
+
+	days := 1
+	if u.Digest.Mode == DigestModeWeekly {
+		days = 7
+		to = to.AddDate(0, 0, -((int(to.Weekday()) + 6) % 7)) // back to Monday
+	}
+	from = to.AddDate(0, 0, -days)
+
+	return from, to, u.Digest.LastSentAt.Before(to)
+}
+
+// Webhook returns the webhook with the given ID, or nil.
+func (u *User) Webhook(id string) *Webhook {
+	for _, w := range u.Webhooks {
+		if w.ID == id {
+			return w
+		}
+	}
+	return nil
+}
+
+// SetWebhooks replaces the user's webhooks. Existing webhooks keep their
+// secret, and re-enabling a webhook gives it a fresh start.
+func (u *User) SetWebhooks(inputs []WebhookInput, now time.Time) error {
+	webhooks := make([]*Webhook, 0, len(inputs))
+	for _, in := range inputs {
+		if in.ID == "" {
+			w, err := NewWebhook(in.URL, now)
+			if err != nil {
+				return err
+			}
+			w.Enabled = in.Enabled
+			webhooks = append(webhooks, w)
+			continue
+		}
+
+		stored := u.Webhook(in.ID)
+		if stored == nil {
+			return ErrWebhookNotFound
+		}
+		w := *stored
+		w.URL = in.URL
+		if in.Enabled && !w.Enabled {
+			w.Failures = 0
+		}
+		w.Enabled = in.Enabled
+		webhooks = append(webhooks, &w)
+	}
+
+	u.Webhooks = webhooks
+	return nil
+}
+
 type userItem struct {
-	PK        string
-	SK        string
-	UserIndex string
-	Type      string
+	PK            string
+	SK            string
+	UserIndex     string
+	ScheduleIndex string `dynamo:",omitempty"` // only set for tracked users
+	Type          string
 
 	*User
 }
 
 func NewUser(id string) *User {
 	now := time.Now()
-	return &User{ID: id, CreatedAt: now, UpdatedAt: now}
+	return &User{
+		ID:             id,
+		TrackingStatus: TrackingStatusActive,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		CheckInterval:  DefaultCheckInterval,
+		NextCheckAt:    now.UTC().Truncate(time.Second),
+	}
+}
+
+// IsTracked reports whether the user's followers should be checked. Users
+// registered before tracking status was introduced have an empty status.
+func (u *User) IsTracked() bool {
+	return u.TrackingStatus == "" || u.TrackingStatus == TrackingStatusActive
 }
 
 func (u *User) IgnoresFollower(id, handle string) bool {
@@ -85,6 +915,60 @@ func (u *User) IgnoresFollower(id, handle string) bool {
 	return false
 }
 
+// ChangeTrackingStatus lets users pause and resume tracking. Other statuses
+// are managed by Listkeeper itself and cannot be changed this way.
+func (u *User) ChangeTrackingStatus(status string) error {
+	switch {
+	case !u.IsTracked() && u.TrackingStatus != TrackingStatusPaused:
+		return ErrTrackingStatusLocked
+	case status != TrackingStatusActive && status != TrackingStatusPaused:
+		return ErrTrackingStatusLocked
+	}
+	u.TrackingStatus = status
+	return nil
+}
+
+// ScheduleCheckAt sets the time of the user's next check.
+func (u *User) ScheduleCheckAt(t time.Time) {
+	// Whole seconds in UTC keep NextCheckAt sortable as a string in ScheduleIndex
+	u.NextCheckAt = t.UTC().Truncate(time.Second)
+}
+
+// DeferNextCheck schedules the user's next check one check interval from now.
+func (u *User) DeferNextCheck(now time.Time) {
+	if u.CheckInterval == 0 {
+		u.CheckInterval = DefaultCheckInterval
+	}
+	u.ScheduleCheckAt(now.Add(u.CheckInterval))
+}
+
+// ScheduleNextCheck adapts the user's check interval to how active their
+// follower list is before deferring the next check: the interval is halved
+// whenever the list changed and grows by half otherwise, bounded by
+// MinCheckInterval and MaxCheckInterval.
+func (u *User) ScheduleNextCheck(changed bool, now time.Time) {
+	interval := u.CheckInterval
+	if interval == 0 {
+		interval = DefaultCheckInterval
+	}
+
+	if changed {
+		interval /= 2
+	} else {
+		interval += interval / 2
+	}
+
+	switch {
+	case interval < MinCheckInterval:
+		interval = MinCheckInterval
+	case interval > MaxCheckInterval:
+		interval = MaxCheckInterval
+	}
+
+	u.CheckInterval = interval
+	u.DeferNextCheck(now)
+}
+
 func (u *User) Validate() error {
 	err := valid.ValidateStruct(u,
 		valid.Field(&u.ID, valid.Required),
@@ -96,13 +980,34 @@ func (u *User) Validate() error {
 		valid.Field(&u.AccessToken, valid.Required),
 		valid.Field(&u.AccessSecret, valid.Required),
 		valid.Field(&u.Slack),
+		valid.Field(&u.Email),
+		valid.Field(&u.Discord),
+		valid.Field(&u.Telegram),
+		valid.Field(&u.Push),
+		valid.Field(&u.Digest),
+		valid.Field(&u.QuietHours),
+		valid.Field(&u.Burst),
+		valid.Field(&u.Refollow),
+		valid.Field(&u.Alerts),
+		valid.Field(&u.Templates),
+		valid.Field(&u.Timezone, valid.By(validateTimezone)),
+		valid.Field(&u.Locale, valid.In(LocaleEnglish, LocaleGerman)),
+		valid.Field(&u.Webhooks, valid.Length(0, MaxWebhooks)),
 		valid.Field(&u.IgnoreFollowers, valid.Each(valid.Required)), // FIXME: too permissive
+		valid.Field(&u.TrackingStatus, valid.In(
+			TrackingStatusActive,
+			TrackingStatusPaused,
+			TrackingStatusNeedsReauth,
+			TrackingStatusDisabled,
+		)),
 		valid.Field(&u.CreatedAt, valid.Required),
 		valid.Field(&u.UpdatedAt, valid.Required, valid.Min(u.CreatedAt)),
 		valid.Field(&u.LastLogin, valid.Required),
 		valid.Field(&u.LastIP, valid.Required, is.IP),
 		valid.Field(&u.LoginsCount, valid.Required),
 		valid.Field(&u.IDP),
+		valid.Field(&u.CheckInterval, valid.Min(MinCheckInterval), valid.Max(MaxCheckInterval)),
+		valid.Field(&u.NextCheckAt),
 	)
 	if err == nil {
 		return nil
@@ -110,17 +1015,33 @@ func (u *User) Validate() error {
 	return fmt.Errorf("%s -> %s", typeUser, err) //nolint:errorlint
 }
 
+func validateTimezone(v interface{}) error {
+	tz, _ := v.(string)
+	if tz == "" {
+		return nil
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		return errors.New("must be a valid IANA timezone")
+	}
+	return nil
+}
+
 func (u *User) pk() string { return "USER#" + u.ID }
 func (u *User) sk() string { return "USER#" + u.ID }
 
 func (u *User) toItem() *userItem {
-	return &userItem{
+	item := &userItem{
 		PK:        u.pk(),
 		SK:        u.sk(),
 		UserIndex: u.pk(),
 		Type:      typeUser,
 		User:      u,
 	}
+	// Users who aren't tracked drop out of the schedule until tracking resumes
+	if u.IsTracked() {
+		item.ScheduleIndex = scheduleKey
+	}
+	return item
 }
 
 type FollowerList struct {
@@ -130,6 +1051,41 @@ type FollowerList struct {
 	TotalFollowers int
 	CreatedAt      time.Time
 	ExpiresAt      time.Time
+
+	// How complete the fetch was, compared with the followers_count of the
+	// profile, which is zero if unknown. Truncated lists only have the most
+	// recent followers.
+	Pages            int  `dynamo:",omitempty"`
+	Truncated        bool `dynamo:",omitempty"`
+	ProfileFollowers int  `dynamo:",omitempty"`
+
+	// Quarantined lists are not compared with, as diff-followers found them
+	// to be implausible.
+	Quarantined bool `dynamo:",omitempty"`
+}
+
+// Incomplete reports whether the list lacks followers the profile counts.
+// Profile counts may lag behind a little, and truncated lists are complete
+// for the most recent followers.
+func (l *FollowerList) Incomplete() bool {
+	if l.ProfileFollowers == 0 || l.Truncated {
+		return false
+	}
+	return l.TotalFollowers < l.ProfileFollowers-FollowerCountTolerance(l.ProfileFollowers)
+}
+
+// Trusted reports whether the list can serve as a baseline for diffs.
+func (l *FollowerList) Trusted() bool {
+	return !l.Quarantined && !l.Incomplete()
+}
+
+// FollowerCountTolerance is how much follower counts may differ without
+// making anyone suspicious, 1% but at least 10.
+func FollowerCountTolerance(count int) int {
+	if tolerance := count / 100; tolerance > 10 {
+		return tolerance
+	}
+	return 10
 }
 
 type followerListItem struct {
@@ -171,14 +1127,22 @@ func (l *FollowerList) toItem() *followerListItem {
 type FollowerEvent struct {
 	ID                  string        `json:"id" dynamo:"EventID"`
 	UserID              string        `json:"userId" tstype:"-"` // FIXME: required by notify-user
+	RunID               string        `json:"runId,omitempty" dynamo:",omitempty" tstype:"-"`
 	TotalFollowers      int           `json:"totalFollowers"`
 	Follower            *twitter.User `json:"follower" tstype:",required"`
 	FollowerState       string        `json:"followerState" tstype:"'NEW' | 'LOST'"`
-	FollowerStateReason string        `json:"followerStateReason" tstype:"'FOLLOWED' | 'UNFOLLOWED' | 'DELETED' | 'SUSPENDED'"`
+	FollowerStateReason string        `json:"followerStateReason" tstype:"'FOLLOWED' | 'UNFOLLOWED' | 'DELETED' | 'SUSPENDED' | 'REFOLLOWED' | 'DEACTIVATED' | 'PROTECTED' | 'BLOCKED' | 'REMOVED'"`
+	Refollows           int           `json:"refollows,omitempty" dynamo:",omitempty"` // of REFOLLOWED events, including this one
 	CreatedAt           time.Time     `json:"createdAt"`
 	ExpiresAt           time.Time     `json:"-"`
 }
 
+// SerialRefollower reports whether the follower came back often enough to
+// be flagged.
+func (e *FollowerEvent) SerialRefollower() bool {
+	return e.FollowerStateReason == FollowerStateReasonRefollowed && e.Refollows >= SerialRefollows
+}
+
 type followerEventItem struct {
 	PK   string
 	SK   string
@@ -208,6 +1172,25 @@ func (e *FollowerEvent) Validate() error {
 func (e *FollowerEvent) pk() string { return "USER#" + e.UserID }
 func (e *FollowerEvent) sk() string { return "EVENT#" + e.ID }
 
+// eventSKAt returns the sort key before all events created at t or later.
+// Event IDs are KSUIDs, which start with the time they were created.
+func eventSKAt(t time.Time) string {
+	id, _ := ksuid.FromParts(t, make([]byte, 16)) //nolint:gomnd // payload size
+	return "EVENT#" + id.String()
+}
+
+// between keeps the events created within [from, to), since KSUIDs only
+// have a precision of seconds.
+func between(events []*FollowerEvent, from, to time.Time) []*FollowerEvent {
+	kept := events[:0]
+	for _, e := range events {
+		if !e.CreatedAt.Before(from) && e.CreatedAt.Before(to) {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
 func (e *FollowerEvent) toItem() *followerEventItem {
 	return &followerEventItem{
 		PK:            e.pk(),
@@ -218,6 +1201,542 @@ func (e *FollowerEvent) toItem() *followerEventItem {
 	}
 }
 
+// RateBudget is a token bucket shared by all functions calling a rate-limited
+// API endpoint. Tokens are refilled continuously up to Capacity per Window.
+type RateBudget struct {
+	Name        string        `json:"name"`
+	Capacity    int           `json:"capacity"`
+	Window      time.Duration `json:"-"`
+	Tokens      float64       `json:"tokens"`
+	Used        int           `json:"used"`
+	WindowStart time.Time     `json:"windowStart"`
+	UpdatedAt   time.Time     `json:"updatedAt"`
+	Version     int64         `json:"-"`
+}
+
+type rateBudgetItem struct {
+	PK   string
+	SK   string
+	TTL  time.Time `dynamo:",unixtime"`
+	Type string
+
+	*RateBudget
+}
+
+func NewRateBudget(name string, capacity int, window time.Duration, now time.Time) *RateBudget {
+	return &RateBudget{
+		Name:        name,
+		Capacity:    capacity,
+		Window:      window,
+		Tokens:      float64(capacity),
+		WindowStart: now,
+		UpdatedAt:   now,
+	}
+}
+
+// Refill adds the tokens accrued since the last update and starts a new
+// usage window if the current one has passed.
+func (b *RateBudget) Refill(now time.Time) {
+	if elapsed := now.Sub(b.UpdatedAt); elapsed > 0 {
+		b.Tokens += float64(b.Capacity) * float64(elapsed) / float64(b.Window)
+		if b.Tokens > float64(b.Capacity) {
+			b.Tokens = float64(b.Capacity)
+		}
+		b.UpdatedAt = now
+	}
+	if now.Sub(b.WindowStart) >= b.Window {
+		b.Used = 0
+		b.WindowStart = now
+	}
+}
+
+// Take draws n tokens from the budget if enough are available.
+func (b *RateBudget) Take(n int, now time.Time) bool {
+	b.Refill(now)
+	if b.Tokens < float64(n) {
+		return false
+	}
+	b.Tokens -= float64(n)
+	b.Used += n
+	return true
+}
+
+// Refund puts back n tokens drawn for calls that weren't made.
+func (b *RateBudget) Refund(n int, now time.Time) {
+	b.Refill(now)
+	b.Tokens += float64(n)
+	if b.Tokens > float64(b.Capacity) {
+		b.Tokens = float64(b.Capacity)
+	}
+	b.Used -= n
+	if b.Used < 0 {
+		b.Used = 0
+	}
+}
+
+// Available returns the number of whole tokens that can be drawn right now.
+func (b *RateBudget) Available(now time.Time) int {
+	b.Refill(now)
+	return int(b.Tokens)
+}
+
+func (b *RateBudget) Validate() error {
+	err := valid.ValidateStruct(b,
+		valid.Field(&b.Name, valid.Required),
+		valid.Field(&b.Capacity, valid.Required, valid.Min(1)),
+		valid.Field(&b.Window, valid.Required),
+		valid.Field(&b.Tokens, valid.Min(0.0), valid.Max(float64(b.Capacity))),
+		valid.Field(&b.Used, valid.Min(0)),
+		valid.Field(&b.WindowStart, valid.Required),
+		valid.Field(&b.UpdatedAt, valid.Required),
+	)
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s -> %s", typeRateBudget, err) //nolint:errorlint
+}
+
+func (b *RateBudget) pk() string { return "BUDGET" }
+func (b *RateBudget) sk() string { return "BUDGET#" + b.Name }
+
+func (b *RateBudget) toItem() *rateBudgetItem {
+	return &rateBudgetItem{
+		PK: b.pk(),
+		SK: b.sk(),
+		// Budgets of individual users vanish once they stop drawing from them
+		TTL:        b.UpdatedAt.Add(2 * b.Window),
+		Type:       typeRateBudget,
+		RateBudget: b,
+	}
+}
+
+// WebhookDelivery records the outcome of sending a follower event to a
+// webhook, including all retries. Its ID is the same for every attempt, see
+// NewWebhookDeliveryID, so that receivers can tell retries from new events.
+type WebhookDelivery struct {
+	ID            string         `json:"id" dynamo:"DeliveryID"`
+	UserID        string         `json:"-"`
+	WebhookID     string         `json:"webhookId"`
+	EventID       string         `json:"eventId"`
+	Attempts      int            `json:"attempts"`
+	StatusCode    int            `json:"statusCode,omitempty" dynamo:",omitempty"`
+	Error         string         `json:"error,omitempty" dynamo:",omitempty"`
+	Succeeded     bool           `json:"succeeded"`
+	NextAttemptAt time.Time      `json:"-" dynamo:",omitempty"` // zero unless a retry is pending
+	Event         *FollowerEvent `json:"-" dynamo:",omitempty"` // kept for the pending retry
+	CreatedAt     time.Time      `json:"createdAt"`
+	ExpiresAt     time.Time      `json:"-"`
+}
+
+// NewWebhookDeliveryID derives the ID of a delivery from the event and the
+// webhook. Event IDs are KSUIDs, which keeps deliveries sorted by time.
+func NewWebhookDeliveryID(eventID, webhookID string) string {
+	return eventID + "-" + webhookID
+}
+
+type webhookDeliveryItem struct {
+	PK            string
+	SK            string
+	ScheduleIndex string    `dynamo:",omitempty"` // only set while a retry is pending
+	NextCheckAt   time.Time `dynamo:",omitempty"` // sorts retries in ScheduleIndex
+	TTL           time.Time `dynamo:",unixtime"`
+	Type          string
+
+	*WebhookDelivery
+}
+
+func (d *WebhookDelivery) Validate() error {
+	err := valid.ValidateStruct(d,
+		valid.Field(&d.ID, valid.Required),
+		valid.Field(&d.UserID, valid.Required),
+		valid.Field(&d.WebhookID, valid.Required),
+		valid.Field(&d.EventID, valid.Required),
+		valid.Field(&d.Attempts, valid.Required, valid.Min(1)),
+		valid.Field(&d.Event, valid.When(!d.NextAttemptAt.IsZero(), valid.Required, valid.Skip), valid.Skip),
+		valid.Field(&d.CreatedAt, valid.Required),
+		valid.Field(&d.ExpiresAt, valid.Required, valid.Min(d.CreatedAt.Add(1*time.Hour))),
+	)
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s -> %s", typeDelivery, err) //nolint:errorlint
+}
+
+// Deliveries sort before the user item so that the latest follower lists can
+// still be queried together with the user, see GetUserAndLatestFollowerLists.
+func (d *WebhookDelivery) pk() string { return "USER#" + d.UserID }
+func (d *WebhookDelivery) sk() string { return "DELIVERY#" + d.WebhookID + "#" + d.ID }
+
+func (d *WebhookDelivery) toItem() *webhookDeliveryItem {
+	item := &webhookDeliveryItem{
+		PK:              d.pk(),
+		SK:              d.sk(),
+		TTL:             d.ExpiresAt,
+		Type:            typeDelivery,
+		WebhookDelivery: d,
+	}
+	if !d.NextAttemptAt.IsZero() {
+		item.ScheduleIndex = retryKey
+		item.NextCheckAt = d.NextAttemptAt.UTC()
+	}
+	return item
+}
+
+// NotificationDeliveryTTL is how long deliveries, including dead letters, are
+// kept.
+const NotificationDeliveryTTL = 30 * 24 * time.Hour
+
+// MaxNotificationAttempts is how often sending an event via a channel is
+// tried before the delivery is given up as failed. It matches the attempts
+// of an asynchronous Lambda invocation, the first one plus two retries.
+const MaxNotificationAttempts = 3
+
+// NotificationDelivery tracks sending a follower event via a notification
+// channel. It is keyed by event and channel so that events delivered more
+// than once by the event bus are only sent once. Failed deliveries form the
+// user's dead-letter list, from where they can be replayed.
+type NotificationDelivery struct {
+	UserID    string         `json:"-"`
+	EventID   string         `json:"eventId"`
+	Channel   string         `json:"channel"`
+	Status    string         `json:"status" tstype:"'PENDING' | 'SENT' | 'FAILED'"`
+	Attempts  int            `json:"attempts"`
+	LastError string         `json:"lastError,omitempty" dynamo:",omitempty"`
+	Response  string         `json:"response,omitempty" dynamo:",omitempty"` // e.g. the ID of the sent message
+	Event     *FollowerEvent `json:"event"`
+	Alert     bool           `json:"-" dynamo:",omitempty"` // about no follower event, see NewAlertDelivery
+	CreatedAt time.Time      `json:"createdAt"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+	ExpiresAt time.Time      `json:"-"`
+	Version   int64          `json:"-"`
+}
+
+type notificationDeliveryItem struct {
+	PK   string
+	SK   string
+	TTL  time.Time `dynamo:",unixtime"`
+	Type string
+
+	*NotificationDelivery
+}
+
+func NewNotificationDelivery(e *FollowerEvent, channel string, now time.Time) *NotificationDelivery {
+	return &NotificationDelivery{
+		UserID:    e.UserID,
+		EventID:   e.ID,
+		Channel:   channel,
+		Status:    DeliveryStatusPending,
+		Event:     e,
+		CreatedAt: now,
+		UpdatedAt: now,
+		ExpiresAt: now.Add(NotificationDeliveryTTL),
+	}
+}
+
+// NewAlertDelivery tracks sending an alert, such as a milestone, under the ID
+// of the event that triggered it. Alerts are only relevant at the time, so
+// they don't end up on the dead-letter list.
+func NewAlertDelivery(userID, alertID, channel string, now time.Time) *NotificationDelivery {
+	return &NotificationDelivery{
+		UserID:    userID,
+		EventID:   alertID,
+		Channel:   channel,
+		Status:    DeliveryStatusPending,
+		Alert:     true,
+		CreatedAt: now,
+		UpdatedAt: now,
+		ExpiresAt: now.Add(NotificationDeliveryTTL),
+	}
+}
+
+// Requeue lets a failed delivery be tried again.
+func (d *NotificationDelivery) Requeue(now time.Time) error {
+	if d.Alert {
+		// Not on the dead-letter list
+		return ErrNotificationNotFound
+	}
+	if d.Status != DeliveryStatusFailed {
+		return ErrNotificationNotFailed
+	}
+	d.Status = DeliveryStatusPending
+	d.Attempts = 0
+	d.UpdatedAt = now
+	d.ExpiresAt = now.Add(NotificationDeliveryTTL)
+	return nil
+}
+
+func (d *NotificationDelivery) Validate() error {
+	err := valid.ValidateStruct(d,
+		valid.Field(&d.UserID, valid.Required),
+		valid.Field(&d.EventID, valid.Required),
+		valid.Field(&d.Channel, valid.Required),
+		valid.Field(&d.Status, valid.Required, valid.In(DeliveryStatusPending, DeliveryStatusSent, DeliveryStatusFailed)),
+		valid.Field(&d.Attempts, valid.Min(0)),
+		valid.Field(&d.Event, valid.When(!d.Alert, valid.Required, valid.Skip), valid.Skip), // comes without ExpiresAt via the event bus
+		valid.Field(&d.CreatedAt, valid.Required),
+		valid.Field(&d.UpdatedAt, valid.Required),
+		valid.Field(&d.ExpiresAt, valid.Required, valid.Min(d.CreatedAt.Add(1*time.Hour))),
+	)
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s -> %s", typeNotification, err) //nolint:errorlint
+}
+
+// notificationPrefix sorts before the user item, like the prefix of webhook
+// deliveries.
+const notificationPrefix = "DISPATCH#"
+
+func (d *NotificationDelivery) pk() string { return "USER#" + d.UserID }
+func (d *NotificationDelivery) sk() string { return notificationPrefix + d.EventID + "#" + d.Channel }
+
+func (d *NotificationDelivery) toItem() *notificationDeliveryItem {
+	return &notificationDeliveryItem{
+		PK:                   d.pk(),
+		SK:                   d.sk(),
+		TTL:                  d.ExpiresAt,
+		Type:                 typeNotification,
+		NotificationDelivery: d,
+	}
+}
+
+const (
+	// FollowerChurnTTL is how long refollows are counted after the follower
+	// was last lost or came back.
+	FollowerChurnTTL = 30 * 24 * time.Hour
+
+	// DeactivationPeriod is how long Twitter keeps deactivated accounts
+	// before deleting them.
+	DeactivationPeriod = 30 * 24 * time.Hour
+
+	// RemovalWindow is how long after being removed or blocked via
+	// Listkeeper a lost follower is attributed to that.
+	RemovalWindow = 7 * 24 * time.Hour
+)
+
+// FollowerChurn remembers when a follower was last lost and how often they
+// came back since, so that diff-followers can tell refollows from new
+// followers. It also tracks removals via Listkeeper and deactivated
+// followers to re-check.
+type FollowerChurn struct {
+	UserID     string
+	FollowerID string
+	LostAt     time.Time `dynamo:",omitempty"` // zero once they came back
+	Refollows  int       `dynamo:",omitempty"`
+	RemovedAt  time.Time `dynamo:",omitempty"` // removed or blocked via Listkeeper
+	RecheckAt  time.Time `dynamo:",omitempty"` // whether a deactivated follower was deleted
+	EventID    string    `dynamo:",omitempty"` // that reported a deactivated follower
+	UpdatedAt  time.Time
+	ExpiresAt  time.Time
+}
+
+type followerChurnItem struct {
+	PK   string
+	SK   string
+	TTL  time.Time `dynamo:",unixtime"`
+	Type string
+
+	*FollowerChurn
+}
+
+// Lost records that the follower was lost.
+func (c *FollowerChurn) Lost(now time.Time) {
+	c.LostAt = now
+	c.UpdatedAt = now
+	c.ExpiresAt = now.Add(FollowerChurnTTL)
+}
+
+// Removed records that the user removed or blocked the follower.
+func (c *FollowerChurn) Removed(now time.Time) {
+	c.RemovedAt = now
+	c.UpdatedAt = now
+	if exp := now.Add(FollowerChurnTTL); exp.After(c.ExpiresAt) {
+		c.ExpiresAt = exp
+	}
+}
+
+// RemovedRecently reports whether the user removed or blocked the follower
+// within RemovalWindow.
+func (c *FollowerChurn) RemovedRecently(now time.Time) bool {
+	return !c.RemovedAt.IsZero() && now.Sub(c.RemovedAt) <= RemovalWindow
+}
+
+// Deactivated schedules a re-check once Twitter would have deleted the
+// follower, keeping the churn until then. The event that reported them is
+// corrected if they were.
+func (c *FollowerChurn) Deactivated(eventID string, now time.Time) {
+	c.RecheckAt = now.Add(DeactivationPeriod)
+	c.EventID = eventID
+	c.UpdatedAt = now
+	if exp := c.RecheckAt.Add(FollowerChurnTTL); exp.After(c.ExpiresAt) {
+		c.ExpiresAt = exp
+	}
+}
+
+// RecheckDue reports whether a deactivated follower is due to be re-checked.
+func (c *FollowerChurn) RecheckDue(now time.Time) bool {
+	return !c.RecheckAt.IsZero() && !now.Before(c.RecheckAt)
+}
+
+// Rechecked clears the re-check of a deactivated follower.
+func (c *FollowerChurn) Rechecked(now time.Time) {
+	c.RecheckAt = time.Time{}
+	c.EventID = ""
+	c.UpdatedAt = now
+}
+
+// Refollowed counts the follower coming back if they were lost within the
+// window and reports whether they did.
+func (c *FollowerChurn) Refollowed(window time.Duration, now time.Time) bool {
+	if c.LostAt.IsZero() || now.Sub(c.LostAt) > window {
+		return false
+	}
+	c.LostAt = time.Time{}
+	c.Refollows++
+	c.UpdatedAt = now
+	c.ExpiresAt = now.Add(FollowerChurnTTL)
+	return true
+}
+
+func (c *FollowerChurn) Validate() error {
+	err := valid.ValidateStruct(c,
+		valid.Field(&c.UserID, valid.Required),
+		valid.Field(&c.FollowerID, valid.Required),
+		valid.Field(&c.Refollows, valid.Min(0)),
+		valid.Field(&c.UpdatedAt, valid.Required),
+		valid.Field(&c.ExpiresAt, valid.Required, valid.Min(c.UpdatedAt)),
+	)
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s -> %s", typeFollowerChurn, err) //nolint:errorlint
+}
+
+// Churn sorts before the user item and follower lists as well.
+func (c *FollowerChurn) pk() string { return "USER#" + c.UserID }
+func (c *FollowerChurn) sk() string { return "CHURN#" + c.FollowerID }
+
+func (c *FollowerChurn) toItem() *followerChurnItem {
+	return &followerChurnItem{
+		PK:            c.pk(),
+		SK:            c.sk(),
+		TTL:           c.ExpiresAt,
+		Type:          typeFollowerChurn,
+		FollowerChurn: c,
+	}
+}
+
+// DeferredEventTTL is how long held events wait to be delivered at most.
+const DeferredEventTTL = 7 * 24 * time.Hour
+
+// DeferredEvent is a follower event held back during quiet hours or buffered
+// as part of a burst. Deferred events form a queue per user that is flushed
+// once quiet hours end and all bursts are due.
+type DeferredEvent struct {
+	ID        string         `json:"id" dynamo:"DeferredID"`
+	UserID    string         `json:"-"`
+	Event     *FollowerEvent `json:"event"`
+	DueAt     time.Time      `json:"dueAt,omitempty" dynamo:",omitempty"` // zero during quiet hours
+	CreatedAt time.Time      `json:"createdAt"`
+	ExpiresAt time.Time      `json:"-"`
+}
+
+type deferredEventItem struct {
+	PK            string
+	SK            string
+	ScheduleIndex string
+	NextCheckAt   time.Time // sorts deferred events in ScheduleIndex
+	TTL           time.Time `dynamo:",unixtime"`
+	Type          string
+
+	*DeferredEvent
+}
+
+// NewDeferredEvent queues the event. It is keyed on the event ID, which keeps
+// the queue in order and the event from being queued twice if its
+// notification is retried.
+func NewDeferredEvent(e *FollowerEvent, now time.Time) *DeferredEvent {
+	return &DeferredEvent{
+		ID:        e.ID,
+		UserID:    e.UserID,
+		Event:     e,
+		CreatedAt: now,
+		ExpiresAt: now.Add(DeferredEventTTL),
+	}
+}
+
+func (d *DeferredEvent) Validate() error {
+	err := valid.ValidateStruct(d,
+		valid.Field(&d.ID, valid.Required),
+		valid.Field(&d.UserID, valid.Required),
+		valid.Field(&d.Event, valid.Required, valid.Skip), // comes without ExpiresAt via the event bus
+		valid.Field(&d.CreatedAt, valid.Required),
+		valid.Field(&d.ExpiresAt, valid.Required, valid.Min(d.CreatedAt.Add(1*time.Hour))),
+	)
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s -> %s", typeDeferredEvent, err) //nolint:errorlint
+}
+
+// Like deliveries, deferred events sort before the user item and follower
+// lists, see GetUserAndLatestFollowerLists.
+func (d *DeferredEvent) pk() string { return "USER#" + d.UserID }
+func (d *DeferredEvent) sk() string { return "DEFERRED#" + d.ID }
+
+func (d *DeferredEvent) toItem() *deferredEventItem {
+	return &deferredEventItem{
+		PK:            d.pk(),
+		SK:            d.sk(),
+		ScheduleIndex: deferredKey,
+		NextCheckAt:   d.CreatedAt.UTC(),
+		TTL:           d.ExpiresAt,
+		Type:          typeDeferredEvent,
+		DeferredEvent: d,
+	}
+}
+
 type UserSignupEvent struct {
 	UserID string `tstype:"-"`
 }
+
+type TrackingStatusEvent struct {
+	UserID         string `tstype:"-"`
+	TrackingStatus string `tstype:"-"`
+}
+
+// MilestoneEvent is sent by diff-followers when the user's follower count
+// reached a milestone for the first time.
+type MilestoneEvent struct {
+	UserID         string    `tstype:"-"`
+	RunID          string    `tstype:"-"`
+	Milestone      int       `tstype:"-"`
+	TotalFollowers int       `tstype:"-"`
+	CreatedAt      time.Time `tstype:"-"`
+}
+
+const (
+	AnomalyKindLoss  = "LOSS"  // lost more than the user's share of followers
+	AnomalyKindSpike = "SPIKE" // many more changes than usual
+)
+
+// AnomalyEvent is sent by diff-followers when a run changed the user's
+// followers unusually.
+type AnomalyEvent struct {
+	UserID            string    `tstype:"-"`
+	RunID             string    `tstype:"-"`
+	Kind              string    `tstype:"-"`
+	PreviousFollowers int       `tstype:"-"`
+	TotalFollowers    int       `tstype:"-"`
+	Gained            int       `tstype:"-"`
+	Lost              int       `tstype:"-"`
+	AvgChanges        float64   `tstype:"-"` // before this run
+	CreatedAt         time.Time `tstype:"-"`
+}
+
+// NotificationReplayEvent asks notify-user to send a requeued delivery again.
+type NotificationReplayEvent struct {
+	UserID  string `tstype:"-"`
+	EventID string `tstype:"-"`
+	Channel string `tstype:"-"`
+}
@@ -1,7 +1,10 @@
 package data
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -58,6 +61,229 @@ func TestUser_Validate(t *testing.T) {
 	}
 }
 
+func TestEmailConfig_Validate(t *testing.T) {
+	tests := []struct {
+		config EmailConfig
+		err    string
+	}{
+		{config: EmailConfig{}},
+		{config: EmailConfig{Enabled: true, Address: "alice@example.com"}},
+		{config: EmailConfig{Enabled: true}, err: "address: cannot be blank."},
+		{config: EmailConfig{Enabled: true, Address: "alice"}, err: "address: must be a valid email address."},
+	}
+
+	for _, test := range tests {
+		var msg string
+		if err := test.config.Validate(); err != nil {
+			msg = err.Error()
+		}
+
+		if diff := cmp.Diff(test.err, msg); diff != "" {
+			t.Error(diff)
+		}
+	}
+}
+
+func TestDiscordConfig_Validate(t *testing.T) {
+	tests := []struct {
+		config DiscordConfig
+		err    string
+	}{
+		{config: DiscordConfig{}},
+		{config: DiscordConfig{Enabled: true, WebhookURL: "https://discord.com/api/webhooks/123/abc-DEF_456"}},
+		{config: DiscordConfig{Enabled: true, WebhookURL: "https://discordapp.com/api/webhooks/123/abc"}},
+		{config: DiscordConfig{Enabled: true}, err: "webhookUrl: cannot be blank."},
+		{config: DiscordConfig{Enabled: true, WebhookURL: "https://hooks.slack.com/services/T/B/X"}, err: "webhookUrl: must be a Discord webhook URL."},
+	}
+
+	for _, test := range tests {
+		var msg string
+		if err := test.config.Validate(); err != nil {
+			msg = err.Error()
+		}
+
+		if diff := cmp.Diff(test.err, msg); diff != "" {
+			t.Error(diff)
+		}
+	}
+}
+
+func TestNotificationFilter_Validate(t *testing.T) {
+	tests := []struct {
+		filter NotificationFilter
+		err    string
+	}{
+		{filter: NotificationFilter{}},
+		{filter: NotificationFilter{States: []string{"LOST"}, Reasons: []string{"UNFOLLOWED"}, MinFollowers: 100}},
+		{filter: NotificationFilter{States: []string{"GONE"}}, err: "states: (0: must be a valid value.)."},
+		{filter: NotificationFilter{Reasons: []string{"BLOCKED", "REMOVED"}}},
+		{filter: NotificationFilter{Reasons: []string{"MUTED"}}, err: "reasons: (0: must be a valid value.)."},
+		{filter: NotificationFilter{MinFollowers: -1}, err: "minFollowers: must be no less than 0."},
+	}
+
+	for _, test := range tests {
+		var msg string
+		if err := test.filter.Validate(); err != nil {
+			msg = err.Error()
+		}
+
+		if diff := cmp.Diff(test.err, msg); diff != "" {
+			t.Error(diff)
+		}
+	}
+}
+
+func TestNotificationFilter_Match(t *testing.T) {
+	var (
+		followed   = &FollowerEvent{FollowerState: "NEW", FollowerStateReason: "FOLLOWED", Follower: &twitter.User{TotalFollowers: 50}}
+		unfollowed = &FollowerEvent{FollowerState: "LOST", FollowerStateReason: "UNFOLLOWED", Follower: &twitter.User{TotalFollowers: 500, Verified: true}}
+		protected  = &FollowerEvent{FollowerState: "NEW", FollowerStateReason: "FOLLOWED", Follower: &twitter.User{TotalFollowers: 500, Protected: true}}
+		suspended  = &FollowerEvent{FollowerState: "LOST", FollowerStateReason: "SUSPENDED", Follower: &twitter.User{}}
+	)
+
+	tests := []struct {
+		filter *NotificationFilter
+		event  *FollowerEvent
+		match  bool
+	}{
+		{filter: nil, event: followed, match: true},
+		{filter: &NotificationFilter{}, event: suspended, match: true},
+		{filter: &NotificationFilter{States: []string{"LOST"}}, event: followed, match: false},
+		{filter: &NotificationFilter{States: []string{"LOST"}}, event: unfollowed, match: true},
+		{filter: &NotificationFilter{Reasons: []string{"FOLLOWED", "UNFOLLOWED", "DELETED"}}, event: suspended, match: false},
+		{filter: &NotificationFilter{MinFollowers: 100}, event: followed, match: false},
+		{filter: &NotificationFilter{MinFollowers: 100}, event: unfollowed, match: true},
+		{filter: &NotificationFilter{MinFollowers: 100}, event: suspended, match: true},
+		{filter: &NotificationFilter{ProtectedOnly: true}, event: unfollowed, match: false},
+		{filter: &NotificationFilter{ProtectedOnly: true}, event: protected, match: true},
+		{filter: &NotificationFilter{VerifiedOnly: true}, event: protected, match: false},
+		{filter: &NotificationFilter{VerifiedOnly: true}, event: unfollowed, match: true},
+	}
+
+	for i, test := range tests {
+		if match := test.filter.Match(test.event); match != test.match {
+			t.Errorf("test %d: match = %t, want %t", i, match, test.match)
+		}
+	}
+}
+
+func TestDigestConfig_Validate(t *testing.T) {
+	var (
+		hour    = 23
+		badHour = 24
+	)
+
+	tests := []struct {
+		config DigestConfig
+		err    string
+	}{
+		{config: DigestConfig{}},
+		{config: DigestConfig{Mode: DigestModeWeekly, Hour: &hour}},
+		{config: DigestConfig{Mode: "HOURLY"}, err: "mode: must be a valid value."},
+		{config: DigestConfig{Mode: DigestModeDaily, Hour: &badHour}, err: "hour: must be no greater than 23."},
+	}
+
+	for _, test := range tests {
+		var msg string
+		if err := test.config.Validate(); err != nil {
+			msg = err.Error()
+		}
+
+		if diff := cmp.Diff(test.err, msg); diff != "" {
+			t.Error(diff)
+		}
+	}
+}
+
+func TestQuietHours_Validate(t *testing.T) {
+	tests := []struct {
+		quiet QuietHours
+		err   string
+	}{
+		{quiet: QuietHours{}},
+		{quiet: QuietHours{Enabled: true, Start: 22, End: 7}},
+		{quiet: QuietHours{Enabled: true, Start: 7, End: 7}, err: "end: must differ from start."},
+		{quiet: QuietHours{Enabled: true, Start: 24, End: 7}, err: "start: must be no greater than 23."},
+	}
+
+	for _, test := range tests {
+		var msg string
+		if err := test.quiet.Validate(); err != nil {
+			msg = err.Error()
+		}
+
+		if diff := cmp.Diff(test.err, msg); diff != "" {
+			t.Error(diff)
+		}
+	}
+}
+
+func TestBurstConfig_Validate(t *testing.T) {
+	tests := []struct {
+		burst BurstConfig
+		err   string
+	}{
+		{burst: BurstConfig{}},
+		{burst: BurstConfig{Enabled: true}}, // default window
+		{burst: BurstConfig{Enabled: true, Window: 15}},
+		{burst: BurstConfig{Enabled: true, Window: 1}, err: "window: must be no less than 5."},
+		{burst: BurstConfig{Enabled: true, Window: 90}, err: "window: must be no greater than 60."},
+	}
+
+	for _, test := range tests {
+		var msg string
+		if err := test.burst.Validate(); err != nil {
+			msg = err.Error()
+		}
+
+		if diff := cmp.Diff(test.err, msg); diff != "" {
+			t.Error(diff)
+		}
+	}
+}
+
+func TestRefollowConfig_Validate(t *testing.T) {
+	tests := []struct {
+		refollow RefollowConfig
+		err      string
+	}{
+		{refollow: RefollowConfig{}},
+		{refollow: RefollowConfig{Enabled: true}}, // default window
+		{refollow: RefollowConfig{Enabled: true, Window: 48, Suppress: true}},
+		{refollow: RefollowConfig{Enabled: true, Window: -1}, err: "window: must be no less than 1."},
+		{refollow: RefollowConfig{Enabled: true, Window: 200}, err: "window: must be no greater than 168."},
+	}
+
+	for _, test := range tests {
+		var msg string
+		if err := test.refollow.Validate(); err != nil {
+			msg = err.Error()
+		}
+
+		if diff := cmp.Diff(test.err, msg); diff != "" {
+			t.Error(diff)
+		}
+	}
+}
+
+func TestFollowerStats_AddRun(t *testing.T) {
+	var s FollowerStats
+	for _, changes := range []int{2, 4, 6} {
+		s.AddRun(changes)
+	}
+	if s.Runs != 3 || s.AvgChanges != 4 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+
+	// Older runs fade out once the average spans TrailingRuns
+	for i := 0; i < 100; i++ {
+		s.AddRun(10)
+	}
+	if s.Runs != TrailingRuns || s.AvgChanges < 9.9 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
 func TestUser_ToItem(t *testing.T) {
 	u := User{
 		ID:              "1234",
@@ -72,12 +298,15 @@ func TestUser_ToItem(t *testing.T) {
 		LastLogin:       created.Add(1 * time.Hour),
 		LastIP:          "1.2.3.4",
 		LoginsCount:     3,
+		CheckInterval:   1 * time.Hour,
+		NextCheckAt:     created.Add(2 * time.Hour),
 	}
 
 	want := map[string]*dynamodb.AttributeValue{
 		"PK":              {S: aws.String("USER#1234")},
 		"SK":              {S: aws.String("USER#1234")},
 		"UserIndex":       {S: aws.String("USER#1234")},
+		"ScheduleIndex":   {S: aws.String("SCHEDULE")},
 		"UserID":          {S: aws.String("1234")},
 		"Type":            {S: aws.String("User")},
 		"Handle":          {S: aws.String("alice")},
@@ -89,11 +318,22 @@ func TestUser_ToItem(t *testing.T) {
 		"Slack": {M: map[string]*dynamodb.AttributeValue{
 			"Enabled": {BOOL: aws.Bool(false)},
 		}},
-		"CreatedAt":   {S: aws.String("2020-11-07T21:04:00Z")},
-		"UpdatedAt":   {S: aws.String("2020-11-07T22:04:00Z")},
-		"LastLogin":   {S: aws.String("2020-11-07T22:04:00Z")},
-		"LastIP":      {S: aws.String("1.2.3.4")},
-		"LoginsCount": {N: aws.String("3")},
+		"Email": {M: map[string]*dynamodb.AttributeValue{
+			"Enabled": {BOOL: aws.Bool(false)},
+		}},
+		"Discord": {M: map[string]*dynamodb.AttributeValue{
+			"Enabled": {BOOL: aws.Bool(false)},
+		}},
+		"Telegram": {M: map[string]*dynamodb.AttributeValue{
+			"Enabled": {BOOL: aws.Bool(false)},
+		}},
+		"CreatedAt":     {S: aws.String("2020-11-07T21:04:00Z")},
+		"UpdatedAt":     {S: aws.String("2020-11-07T22:04:00Z")},
+		"LastLogin":     {S: aws.String("2020-11-07T22:04:00Z")},
+		"LastIP":        {S: aws.String("1.2.3.4")},
+		"LoginsCount":   {N: aws.String("3")},
+		"CheckInterval": {N: aws.String("3600000000000")},
+		"NextCheckAt":   {S: aws.String("2020-11-07T23:04:00Z")},
 	}
 
 	if err := u.Validate(); err != nil {
@@ -108,6 +348,405 @@ func TestUser_ToItem(t *testing.T) {
 	if diff := cmp.Diff(want, got); diff != "" {
 		t.Error(diff)
 	}
+
+	// Paused users aren't scheduled
+	u.TrackingStatus = TrackingStatusPaused
+	got, err = dynamo.MarshalItem(u.toItem())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got["ScheduleIndex"]; ok {
+		t.Error("expected paused user to be left out of ScheduleIndex")
+	}
+}
+
+func TestUser_ChangeTrackingStatus(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		err  error
+	}{
+		{from: "", to: TrackingStatusPaused},
+		{from: TrackingStatusActive, to: TrackingStatusPaused},
+		{from: TrackingStatusPaused, to: TrackingStatusActive},
+		{from: TrackingStatusActive, to: TrackingStatusDisabled, err: ErrTrackingStatusLocked},
+		{from: TrackingStatusNeedsReauth, to: TrackingStatusActive, err: ErrTrackingStatusLocked},
+		{from: TrackingStatusDisabled, to: TrackingStatusActive, err: ErrTrackingStatusLocked},
+	}
+
+	for _, test := range tests {
+		u := User{TrackingStatus: test.from}
+		err := u.ChangeTrackingStatus(test.to)
+
+		if diff := cmp.Diff(test.err, err, compareErrors); diff != "" {
+			t.Error(diff)
+		}
+		if err == nil && u.TrackingStatus != test.to {
+			t.Errorf("tracking status = %q, want %q", u.TrackingStatus, test.to)
+		}
+	}
+}
+
+func TestUser_ScheduleNextCheck(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		changed  bool
+		want     time.Duration
+	}{
+		{interval: 0, changed: false, want: 90 * time.Minute},
+		{interval: 0, changed: true, want: 30 * time.Minute},
+		{interval: 2 * time.Hour, changed: false, want: 3 * time.Hour},
+		{interval: 2 * time.Hour, changed: true, want: 1 * time.Hour},
+		{interval: MinCheckInterval, changed: true, want: MinCheckInterval},
+		{interval: 20 * time.Hour, changed: false, want: MaxCheckInterval},
+	}
+
+	for _, test := range tests {
+		u := User{CheckInterval: test.interval}
+		u.ScheduleNextCheck(test.changed, created)
+
+		if diff := cmp.Diff(test.want, u.CheckInterval); diff != "" {
+			t.Error(diff)
+		}
+		if diff := cmp.Diff(created.Add(test.want), u.NextCheckAt); diff != "" {
+			t.Error(diff)
+		}
+	}
+}
+
+func TestUser_DigestPeriod(t *testing.T) {
+	var (
+		at = func(s string) time.Time {
+			ts, _ := time.Parse(time.RFC3339, s)
+			return ts
+		}
+		hour = func(h int) *int { return &h }
+	)
+
+	tests := []struct {
+		timezone string
+		digest   DigestConfig
+		from, to time.Time
+		due      bool
+	}{
+		{digest: DigestConfig{}},
+		{digest: DigestConfig{Mode: DigestModeOff}},
+		{
+			digest: DigestConfig{Mode: DigestModeDaily},
+			from:   at("2020-11-06T09:00:00Z"),
+			to:     at("2020-11-07T09:00:00Z"),
+			due:    true,
+		},
+		{
+			digest: DigestConfig{Mode: DigestModeDaily, LastSentAt: at("2020-11-07T09:00:00Z")},
+			from:   at("2020-11-06T09:00:00Z"),
+			to:     at("2020-11-07T09:00:00Z"),
+			due:    false,
+		},
+		{
+			digest: DigestConfig{Mode: DigestModeDaily, Hour: hour(22)},
+			from:   at("2020-11-05T22:00:00Z"),
+			to:     at("2020-11-06T22:00:00Z"),
+			due:    true,
+		},
+		{
+			timezone: "Europe/Berlin",
+			digest:   DigestConfig{Mode: DigestModeDaily, Hour: hour(9)},
+			from:     at("2020-11-06T09:00:00+01:00"),
+			to:       at("2020-11-07T09:00:00+01:00"),
+			due:      true,
+		},
+		{
+			digest: DigestConfig{Mode: DigestModeWeekly},
+			from:   at("2020-10-26T09:00:00Z"),
+			to:     at("2020-11-02T09:00:00Z"),
+			due:    true,
+		},
+	}
+
+	for _, test := range tests {
+		u := User{Digest: test.digest, Timezone: test.timezone}
+		from, to, due := u.DigestPeriod(created)
+
+		if !from.Equal(test.from) || !to.Equal(test.to) || due != test.due {
+			t.Errorf("%s %+v: got %s - %s (due %t), want %s - %s (due %t)",
+				test.timezone, test.digest, from, to, due, test.from, test.to, test.due)
+		}
+	}
+}
+
+func TestUser_InQuietHours(t *testing.T) {
+	tests := []struct {
+		timezone string
+		quiet    QuietHours
+		want     bool
+	}{
+		{quiet: QuietHours{Start: 20, End: 23}, want: false}, // disabled
+		{quiet: QuietHours{Enabled: true, Start: 20, End: 23}, want: true},
+		{quiet: QuietHours{Enabled: true, Start: 8, End: 21}, want: false},
+		{quiet: QuietHours{Enabled: true, Start: 22, End: 7}, want: false},
+		{quiet: QuietHours{Enabled: true, Start: 21, End: 7}, want: true},
+		{timezone: "Europe/Berlin", quiet: QuietHours{Enabled: true, Start: 22, End: 7}, want: true},
+		{timezone: "America/New_York", quiet: QuietHours{Enabled: true, Start: 22, End: 7}, want: false},
+	}
+
+	for _, test := range tests {
+		u := User{QuietHours: test.quiet, Timezone: test.timezone}
+
+		if got := u.InQuietHours(created); got != test.want {
+			t.Errorf("%s %+v: got %t, want %t", test.timezone, test.quiet, got, test.want)
+		}
+	}
+}
+
+func TestUser_LinkTelegram(t *testing.T) {
+	u := User{ID: "1234"}
+
+	code, err := u.NewTelegramLinkCode(created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID, ok := TelegramLinkCodeUserID(code); !ok || userID != "1234" {
+		t.Errorf("code %q does not belong to user", code)
+	}
+
+	tests := []struct {
+		code string
+		now  time.Time
+		err  error
+	}{
+		{code: "1234-invalid", now: created, err: ErrTelegramLinkCodeInvalid},
+		{code: code, now: created.Add(TelegramLinkCodeTTL + time.Second), err: ErrTelegramLinkCodeInvalid},
+		{code: code, now: created.Add(time.Minute)},
+		{code: code, now: created.Add(time.Minute), err: ErrTelegramLinkCodeInvalid}, // used already
+	}
+
+	for _, test := range tests {
+		err := u.LinkTelegram(test.code, 42, test.now)
+
+		if diff := cmp.Diff(test.err, err, compareErrors); diff != "" {
+			t.Error(diff)
+		}
+	}
+
+	if diff := cmp.Diff(TelegramConfig{Enabled: true, ChatID: 42}, u.Telegram); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestUser_FeedToken(t *testing.T) {
+	u := User{ID: "1234"}
+
+	if u.ValidFeedToken("") {
+		t.Error("empty token must not be valid")
+	}
+
+	old, err := u.NewFeedToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID, ok := FeedTokenUserID(old); !ok || userID != "1234" {
+		t.Errorf("token %q does not belong to user", old)
+	}
+
+	token, err := u.NewFeedToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		token string
+		valid bool
+	}{
+		{token: token, valid: true},
+		{token: old, valid: false}, // rotated
+		{token: "1234-invalid", valid: false},
+		{token: "", valid: false},
+	}
+
+	for _, test := range tests {
+		if got := u.ValidFeedToken(test.token); got != test.valid {
+			t.Errorf("ValidFeedToken(%q) = %t, want %t", test.token, got, test.valid)
+		}
+	}
+}
+
+func TestUser_InstallSlack(t *testing.T) {
+	filter := &NotificationFilter{States: []string{FollowerStateNew}}
+	u := User{ID: "1234", Slack: SlackConfig{WebhookURL: "https://hooks.slack.com/old", Filter: filter}}
+
+	state, err := u.NewSlackInstallState(created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID, ok := SlackInstallStateUserID(state); !ok || userID != "1234" {
+		t.Errorf("state %q does not belong to user", state)
+	}
+
+	install := &SlackInstall{TeamID: "T1", TeamName: "Acme", ChannelID: "C1", Channel: "#followers", WebhookURL: "https://hooks.slack.com/new", BotToken: "sealed"}
+
+	tests := []struct {
+		state string
+		now   time.Time
+		err   error
+	}{
+		{state: "1234-invalid", now: created, err: ErrSlackInstallStateInvalid},
+		{state: state, now: created.Add(SlackInstallStateTTL + time.Second), err: ErrSlackInstallStateInvalid},
+		{state: state, now: created.Add(time.Minute)},
+		{state: state, now: created.Add(time.Minute), err: ErrSlackInstallStateInvalid}, // used already
+	}
+
+	for _, test := range tests {
+		err := u.InstallSlack(test.state, install, test.now)
+
+		if diff := cmp.Diff(test.err, err, compareErrors); diff != "" {
+			t.Error(diff)
+		}
+	}
+
+	want := SlackConfig{
+		Enabled:    true,
+		WebhookURL: "https://hooks.slack.com/new",
+		Channel:    "#followers",
+		Filter:     filter,
+		TeamID:     "T1",
+		TeamName:   "Acme",
+		ChannelID:  "C1",
+		BotToken:   "sealed",
+	}
+	if diff := cmp.Diff(want, u.Slack); diff != "" {
+		t.Error(diff)
+	}
+	if err := u.Slack.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	buf, err := json.Marshal(u.Slack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(`{"enabled":true,"webhookUrl":"https://hooks.slack.com/new","channel":"#followers","filter":{"states":["NEW"],"minFollowers":0,"protectedOnly":false,"verifiedOnly":false},"installed":true,"teamName":"Acme"}`, string(buf)); diff != "" {
+		t.Error(diff)
+	}
+
+	u.UninstallSlack()
+	if diff := cmp.Diff(SlackConfig{Filter: filter}, u.Slack); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestUser_SetWebhooks(t *testing.T) {
+	u := User{}
+	if err := u.SetWebhooks([]WebhookInput{{URL: "https://example.com/hook", Enabled: true}}, created); err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Webhooks) != 1 {
+		t.Fatalf("expected 1 webhook, got %d", len(u.Webhooks))
+	}
+	w := *u.Webhooks[0]
+	if w.ID == "" || len(w.Secret) != len("whsec_")+64 {
+		t.Errorf("webhook not initialized: %+v", w)
+	}
+	if err := w.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	// Re-enabling a disabled webhook resets its failures but keeps the secret
+	u.Webhooks[0].Enabled = false
+	u.Webhooks[0].Failures = 5
+	if err := u.SetWebhooks([]WebhookInput{{ID: w.ID, URL: "https://example.com/new", Enabled: true}}, created); err != nil {
+		t.Fatal(err)
+	}
+	want := w
+	want.URL = "https://example.com/new"
+	if diff := cmp.Diff([]*Webhook{&want}, u.Webhooks); diff != "" {
+		t.Error(diff)
+	}
+
+	err := u.SetWebhooks([]WebhookInput{{ID: "unknown", URL: "https://example.com/hook"}}, created)
+	if diff := cmp.Diff(ErrWebhookNotFound, err, compareErrors); diff != "" {
+		t.Error(diff)
+	}
+
+	if err := u.SetWebhooks(nil, created); err != nil || len(u.Webhooks) != 0 {
+		t.Errorf("expected webhooks to be removed, got %v (err = %v)", u.Webhooks, err)
+	}
+}
+
+func TestUser_PushSubscriptions(t *testing.T) {
+	var (
+		u      = User{}
+		p256dh = strings.Repeat("A", 87)
+		auth   = strings.Repeat("B", 22)
+	)
+
+	_, err := u.AddPushSubscription("http://push.example.com/1", p256dh, auth, "", created)
+	if err == nil {
+		t.Error("expected error for non-HTTPS endpoint")
+	}
+	_, err = u.AddPushSubscription("https://push.example.com/1", "short", auth, "", created)
+	if err == nil {
+		t.Error("expected error for invalid key")
+	}
+
+	var ids []string
+	for i := 0; i < MaxPushSubscriptions+1; i++ {
+		s, err := u.AddPushSubscription(fmt.Sprintf("https://push.example.com/%d", i), p256dh, auth, "Firefox", created)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, s.ID)
+	}
+	if !u.Push.Enabled {
+		t.Error("expected push to be enabled")
+	}
+	if len(u.Push.Subscriptions) != MaxPushSubscriptions || u.Push.Subscriptions[0].ID != ids[1] {
+		t.Errorf("expected oldest subscription to be dropped, got %d", len(u.Push.Subscriptions))
+	}
+
+	// Subscribing again replaces the previous subscription
+	s, err := u.AddPushSubscription("https://push.example.com/5", p256dh, auth, "Chrome", created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Push.Subscriptions) != MaxPushSubscriptions || u.Push.Subscriptions[MaxPushSubscriptions-1] != s {
+		t.Errorf("expected subscription to be replaced, got %+v", u.Push.Subscriptions)
+	}
+
+	if err := u.RemovePushSubscription(s.ID); err != nil {
+		t.Fatal(err)
+	}
+	err = u.RemovePushSubscription(s.ID)
+	if diff := cmp.Diff(ErrPushSubscriptionNotFound, err, compareErrors); diff != "" {
+		t.Error(diff)
+	}
+	if len(u.Push.Subscriptions) != MaxPushSubscriptions-1 {
+		t.Errorf("expected %d subscriptions, got %d", MaxPushSubscriptions-1, len(u.Push.Subscriptions))
+	}
+}
+
+func TestWebhook_Validate(t *testing.T) {
+	tests := []struct {
+		url string
+		err string
+	}{
+		{url: "https://example.com/hook"},
+		{url: "http://example.com/hook", err: "url: must be an HTTPS URL."},
+		{url: "example", err: "url: must be a valid URL."},
+	}
+
+	for _, test := range tests {
+		w := Webhook{ID: "1", URL: test.url, Secret: "secret", CreatedAt: created}
+
+		var msg string
+		if err := w.Validate(); err != nil {
+			msg = err.Error()
+		}
+
+		if diff := cmp.Diff(test.err, msg); diff != "" {
+			t.Error(diff)
+		}
+	}
 }
 
 func TestFollowerList_Validate(t *testing.T) {
@@ -256,6 +895,7 @@ func TestFollowerEvent_ToItem(t *testing.T) {
 			"Location":       {S: aws.String("Wonderland")},
 			"Bio":            {S: aws.String("I ❤️  adventures")},
 			"Protected":      {BOOL: aws.Bool(false)},
+			"Verified":       {BOOL: aws.Bool(false)},
 			"TotalFollowers": {N: aws.String("100")},
 		}},
 		"FollowerState":       {S: aws.String("NEW")},
@@ -277,3 +917,353 @@ func TestFollowerEvent_ToItem(t *testing.T) {
 		t.Error(diff)
 	}
 }
+
+func TestWebhookDelivery_ToItem(t *testing.T) {
+	d := &WebhookDelivery{
+		ID:         "some-delivery-id",
+		UserID:     "some-user-id",
+		WebhookID:  "some-webhook-id",
+		EventID:    "some-event-id",
+		Attempts:   2,
+		StatusCode: 200,
+		Succeeded:  true,
+		CreatedAt:  created,
+		ExpiresAt:  created.Add(24 * time.Hour),
+	}
+
+	want := map[string]*dynamodb.AttributeValue{
+		"PK":         {S: aws.String("USER#some-user-id")},
+		"SK":         {S: aws.String("DELIVERY#some-webhook-id#some-delivery-id")},
+		"TTL":        {N: aws.String("1604869440")},
+		"Type":       {S: aws.String("WebhookDelivery")},
+		"DeliveryID": {S: aws.String("some-delivery-id")},
+		"UserID":     {S: aws.String("some-user-id")},
+		"WebhookID":  {S: aws.String("some-webhook-id")},
+		"EventID":    {S: aws.String("some-event-id")},
+		"Attempts":   {N: aws.String("2")},
+		"StatusCode": {N: aws.String("200")},
+		"Succeeded":  {BOOL: aws.Bool(true)},
+		"CreatedAt":  {S: aws.String("2020-11-07T21:04:00Z")},
+		"ExpiresAt":  {S: aws.String("2020-11-08T21:04:00Z")},
+	}
+
+	if err := d.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := dynamo.MarshalItem(d.toItem())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestWebhookDelivery_ToItemPendingRetry(t *testing.T) {
+	d := &WebhookDelivery{
+		ID:            "some-delivery-id",
+		UserID:        "some-user-id",
+		WebhookID:     "some-webhook-id",
+		EventID:       "some-event-id",
+		Attempts:      1,
+		Error:         "unexpected status 503 Service Unavailable",
+		NextAttemptAt: created.Add(5 * time.Minute),
+		Event:         &FollowerEvent{ID: "some-event-id"},
+		CreatedAt:     created,
+		ExpiresAt:     created.Add(24 * time.Hour),
+	}
+
+	if err := d.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	item := d.toItem()
+	if item.ScheduleIndex != retryKey || !item.NextCheckAt.Equal(d.NextAttemptAt) {
+		t.Errorf("unexpected schedule: %s %s", item.ScheduleIndex, item.NextCheckAt)
+	}
+
+	// The event is needed for the retry
+	d.Event = nil
+	if err := d.Validate(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestNotificationDelivery_ToItem(t *testing.T) {
+	e := &FollowerEvent{ID: "some-event-id", UserID: "some-user-id", Follower: &twitter.User{ID: "123"}}
+	d := NewNotificationDelivery(e, "slack", created)
+	d.Attempts = 1
+	d.Status = DeliveryStatusSent
+	d.Response = "ts=123.456"
+	d.Version = 2
+
+	if err := d.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := dynamo.MarshalItem(d.toItem())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for attr, want := range map[string]*dynamodb.AttributeValue{
+		"PK":       {S: aws.String("USER#some-user-id")},
+		"SK":       {S: aws.String("DISPATCH#some-event-id#slack")},
+		"TTL":      {N: aws.String("1607375040")},
+		"Type":     {S: aws.String("NotificationDelivery")},
+		"EventID":  {S: aws.String("some-event-id")},
+		"Channel":  {S: aws.String("slack")},
+		"Status":   {S: aws.String("SENT")},
+		"Attempts": {N: aws.String("1")},
+		"Response": {S: aws.String("ts=123.456")},
+		"Version":  {N: aws.String("2")},
+	} {
+		if diff := cmp.Diff(want, got[attr]); diff != "" {
+			t.Errorf("%s: %s", attr, diff)
+		}
+	}
+	if _, ok := got["LastError"]; ok {
+		t.Error("expected empty error to be omitted")
+	}
+}
+
+func TestNotificationDelivery_Requeue(t *testing.T) {
+	e := &FollowerEvent{ID: "some-event-id", UserID: "some-user-id", Follower: &twitter.User{ID: "123"}}
+	d := NewNotificationDelivery(e, "slack", created)
+
+	if err := d.Requeue(created); !errors.Is(err, ErrNotificationNotFailed) {
+		t.Errorf("requeued pending delivery: %v", err)
+	}
+
+	d.Status = DeliveryStatusFailed
+	d.Attempts = MaxNotificationAttempts
+	d.LastError = "boom"
+	later := created.Add(40 * 24 * time.Hour)
+	if err := d.Requeue(later); err != nil {
+		t.Fatal(err)
+	}
+	if d.Status != DeliveryStatusPending || d.Attempts != 0 || d.LastError != "boom" || !d.ExpiresAt.After(later) {
+		t.Errorf("unexpected delivery after requeue: %+v", d)
+	}
+}
+
+func TestDeferredEvent_ToItem(t *testing.T) {
+	d := &DeferredEvent{
+		ID:     "some-deferred-id",
+		UserID: "some-user-id",
+		Event: &FollowerEvent{
+			ID:                  "some-event-id",
+			UserID:              "some-user-id",
+			TotalFollowers:      200,
+			Follower:            &twitter.User{ID: "123"},
+			FollowerState:       "LOST",
+			FollowerStateReason: "DELETED",
+			CreatedAt:           created,
+			ExpiresAt:           created.Add(24 * time.Hour),
+		},
+		CreatedAt: created,
+		ExpiresAt: created.Add(24 * time.Hour),
+	}
+
+	want := map[string]*dynamodb.AttributeValue{
+		"PK":            {S: aws.String("USER#some-user-id")},
+		"SK":            {S: aws.String("DEFERRED#some-deferred-id")},
+		"ScheduleIndex": {S: aws.String("DEFERRED")},
+		"NextCheckAt":   {S: aws.String("2020-11-07T21:04:00Z")},
+		"TTL":           {N: aws.String("1604869440")},
+		"Type":          {S: aws.String("DeferredEvent")},
+		"DeferredID":    {S: aws.String("some-deferred-id")},
+		"UserID":        {S: aws.String("some-user-id")},
+		"Event": {M: map[string]*dynamodb.AttributeValue{
+			"EventID":        {S: aws.String("some-event-id")},
+			"UserID":         {S: aws.String("some-user-id")},
+			"TotalFollowers": {N: aws.String("200")},
+			"Follower": {M: map[string]*dynamodb.AttributeValue{
+				"ID":             {S: aws.String("123")},
+				"Protected":      {BOOL: aws.Bool(false)},
+				"Verified":       {BOOL: aws.Bool(false)},
+				"TotalFollowers": {N: aws.String("0")},
+			}},
+			"FollowerState":       {S: aws.String("LOST")},
+			"FollowerStateReason": {S: aws.String("DELETED")},
+			"CreatedAt":           {S: aws.String("2020-11-07T21:04:00Z")},
+			"ExpiresAt":           {S: aws.String("2020-11-08T21:04:00Z")},
+		}},
+		"CreatedAt": {S: aws.String("2020-11-07T21:04:00Z")},
+		"ExpiresAt": {S: aws.String("2020-11-08T21:04:00Z")},
+	}
+
+	if err := d.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := dynamo.MarshalItem(d.toItem())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestFollowerChurn_Refollowed(t *testing.T) {
+	c := FollowerChurn{UserID: "some-user-id", FollowerID: "123"}
+	if c.Refollowed(time.Hour, created) {
+		t.Error("refollowed without being lost")
+	}
+
+	c.Lost(created)
+	if c.Refollowed(time.Hour, created.Add(2*time.Hour)) {
+		t.Error("refollowed outside the window")
+	}
+	if !c.Refollowed(time.Hour, created.Add(30*time.Minute)) {
+		t.Error("not refollowed within the window")
+	}
+	if c.Refollowed(time.Hour, created.Add(45*time.Minute)) {
+		t.Error("refollowed twice without being lost again")
+	}
+
+	c.Lost(created.Add(time.Hour))
+	c.Refollowed(time.Hour, created.Add(90*time.Minute))
+	if c.Refollows != 2 {
+		t.Errorf("refollows = %d, want 2", c.Refollows)
+	}
+	if err := c.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFollowerChurn_Deactivated(t *testing.T) {
+	c := FollowerChurn{UserID: "some-user-id", FollowerID: "123"}
+	c.Lost(created)
+	c.Deactivated("some-event-id", created)
+
+	if c.RecheckDue(created.Add(DeactivationPeriod - time.Hour)) {
+		t.Error("re-check due before the deactivation period")
+	}
+	if !c.RecheckDue(created.Add(DeactivationPeriod)) {
+		t.Error("re-check not due after the deactivation period")
+	}
+	if !c.ExpiresAt.After(c.RecheckAt) {
+		t.Errorf("churn expires at %s, before the re-check at %s", c.ExpiresAt, c.RecheckAt)
+	}
+
+	c.Rechecked(created.Add(DeactivationPeriod))
+	if c.RecheckDue(created.Add(DeactivationPeriod)) {
+		t.Error("re-check due twice")
+	}
+}
+
+func TestFollowerChurn_RemovedRecently(t *testing.T) {
+	c := FollowerChurn{UserID: "some-user-id", FollowerID: "123"}
+	if c.RemovedRecently(created) {
+		t.Error("removed without being removed")
+	}
+
+	c.Removed(created)
+	if !c.RemovedRecently(created.Add(time.Hour)) {
+		t.Error("not removed recently")
+	}
+	if c.RemovedRecently(created.Add(RemovalWindow + time.Hour)) {
+		t.Error("removed recently outside the window")
+	}
+}
+
+func TestFollowerChurn_ToItem(t *testing.T) {
+	c := &FollowerChurn{UserID: "some-user-id", FollowerID: "123"}
+	c.Lost(created)
+
+	want := map[string]*dynamodb.AttributeValue{
+		"PK":         {S: aws.String("USER#some-user-id")},
+		"SK":         {S: aws.String("CHURN#123")},
+		"TTL":        {N: aws.String("1607375040")},
+		"Type":       {S: aws.String("FollowerChurn")},
+		"UserID":     {S: aws.String("some-user-id")},
+		"FollowerID": {S: aws.String("123")},
+		"LostAt":     {S: aws.String("2020-11-07T21:04:00Z")},
+		"UpdatedAt":  {S: aws.String("2020-11-07T21:04:00Z")},
+		"ExpiresAt":  {S: aws.String("2020-12-07T21:04:00Z")},
+	}
+
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := dynamo.MarshalItem(c.toItem())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestRateBudget_Take(t *testing.T) {
+	b := NewRateBudget("some-endpoint", 10, 10*time.Minute, created)
+
+	for i := 0; i < 10; i++ {
+		if !b.Take(1, created) {
+			t.Fatalf("take %d failed", i)
+		}
+	}
+	if b.Take(1, created) {
+		t.Error("expected budget to be exhausted")
+	}
+	if diff := cmp.Diff(10, b.Used); diff != "" {
+		t.Error(diff)
+	}
+
+	// One token is refilled per minute
+	if diff := cmp.Diff(3, b.Available(created.Add(3*time.Minute))); diff != "" {
+		t.Error(diff)
+	}
+	if !b.Take(3, created.Add(3*time.Minute)) {
+		t.Error("expected refilled tokens to be available")
+	}
+
+	// A new window resets usage and the bucket never exceeds its capacity
+	if diff := cmp.Diff(10, b.Available(created.Add(1*time.Hour))); diff != "" {
+		t.Error(diff)
+	}
+	if diff := cmp.Diff(0, b.Used); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestRateBudget_ToItem(t *testing.T) {
+	b := NewRateBudget("followers/ids", 300, 15*time.Minute, created)
+	b.Take(5, created)
+	b.Version = 2
+
+	want := map[string]*dynamodb.AttributeValue{
+		"PK":          {S: aws.String("BUDGET")},
+		"SK":          {S: aws.String("BUDGET#followers/ids")},
+		"TTL":         {N: aws.String("1604784840")},
+		"Type":        {S: aws.String("RateBudget")},
+		"Name":        {S: aws.String("followers/ids")},
+		"Capacity":    {N: aws.String("300")},
+		"Window":      {N: aws.String("900000000000")},
+		"Tokens":      {N: aws.String("295")},
+		"Used":        {N: aws.String("5")},
+		"WindowStart": {S: aws.String("2020-11-07T21:04:00Z")},
+		"UpdatedAt":   {S: aws.String("2020-11-07T21:04:00Z")},
+		"Version":     {N: aws.String("2")},
+	}
+
+	if err := b.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := dynamo.MarshalItem(b.toItem())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+}
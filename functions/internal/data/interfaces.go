@@ -2,6 +2,7 @@ package data
 
 import (
 	"context"
+	"time"
 )
 
 //nolint:gofumpt
@@ -12,13 +13,47 @@ type TableAPI interface {
 	GetUser(ctx context.Context, userID string) (*User, error)
 	DeleteUser(ctx context.Context, userID string) error
 	NewUserIter() UserIter
+	NewDueUserIter(now time.Time) UserIter
+	NewUnscheduledUserIter() UserIter
+	UpdateUserSchedule(ctx context.Context, u *User) error
+	UpdateUserStats(ctx context.Context, u *User) error
+	UpdateUserDigest(ctx context.Context, u *User) error
+	UpdateUserSlackThread(ctx context.Context, u *User) error
+	UpdateUserTrackingStatus(ctx context.Context, userID, status string) (bool, error)
+	UpdateUserWebhook(ctx context.Context, u *User, w *Webhook) error
+	RemoveUserPushSubscription(ctx context.Context, u *User, id string) error
 
 	CreateFollowerList(ctx context.Context, l *FollowerList) error
+	QuarantineFollowerList(ctx context.Context, l *FollowerList) error
 	GetUserAndLatestFollowerLists(ctx context.Context, userID string, limit int64) (*User, []*FollowerList, error)
 	GetLatestFollowerLists(ctx context.Context, userID string, limit int64) ([]*FollowerList, error)
 
 	CreateFollowerEvent(ctx context.Context, e *FollowerEvent) error
 	GetLatestFollowerEvents(ctx context.Context, userID string, limit int64) ([]*FollowerEvent, error)
+	GetFollowerEventsBetween(ctx context.Context, userID string, from, to time.Time) ([]*FollowerEvent, error)
+	UpdateFollowerEventReason(ctx context.Context, e *FollowerEvent) error
+
+	GetFollowerChurn(ctx context.Context, userID string) ([]*FollowerChurn, error)
+	GetFollowerChurnByID(ctx context.Context, userID, followerID string) (*FollowerChurn, error)
+	SaveFollowerChurn(ctx context.Context, c *FollowerChurn) error
+
+	GetWebhookDelivery(ctx context.Context, userID, webhookID, id string) (*WebhookDelivery, error)
+	SaveWebhookDelivery(ctx context.Context, d *WebhookDelivery) error
+	GetLatestWebhookDeliveries(ctx context.Context, userID, webhookID string, limit int64) ([]*WebhookDelivery, error)
+	GetDueWebhookDeliveries(ctx context.Context, now time.Time) ([]*WebhookDelivery, error)
+
+	GetNotificationDelivery(ctx context.Context, userID, eventID, channel string) (*NotificationDelivery, error)
+	SaveNotificationDelivery(ctx context.Context, d *NotificationDelivery) error
+	GetDeadLetters(ctx context.Context, userID string, limit int64) ([]*NotificationDelivery, error)
+
+	CreateDeferredEvent(ctx context.Context, d *DeferredEvent) error
+	GetDeferredEvents(ctx context.Context, userID string) ([]*DeferredEvent, error)
+	GetAllDeferredEvents(ctx context.Context) ([]*DeferredEvent, error)
+	DeleteDeferredEvent(ctx context.Context, d *DeferredEvent) error
+
+	GetRateBudget(ctx context.Context, name string) (*RateBudget, error)
+	GetRateBudgets(ctx context.Context) ([]*RateBudget, error)
+	SaveRateBudgets(ctx context.Context, budgets ...*RateBudget) error
 }
 
 type UserIter interface {
@@ -28,5 +63,7 @@ type UserIter interface {
 
 var (
 	_ TableAPI = (*Table)(nil)
+	_ TableAPI = (*LocalTable)(nil)
 	_ UserIter = (*userIter)(nil)
+	_ UserIter = (*localUserIter)(nil)
 )
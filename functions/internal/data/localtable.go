@@ -0,0 +1,765 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+const (
+	usersCollection         = "users"
+	listsCollection         = "lists"
+	eventsCollection        = "events"
+	budgetsCollection       = "budgets"
+	deliveriesCollection    = "deliveries"
+	deferredCollection      = "deferred"
+	notificationsCollection = "notifications"
+	churnCollection         = "churn"
+)
+
+// LocalTable is a stand-in for Table backed by a Store, e.g. to run the
+// pipeline locally or self-hosted. It mirrors the key schema and conditions
+// of the DynamoDB table so that both behave the same way.
+type LocalTable struct {
+	mu    sync.Mutex // serializes read-modify-write cycles
+	store Store
+}
+
+func NewLocalTable(store Store) *LocalTable {
+	return &LocalTable{store: store}
+}
+
+// NewMemoryTable returns a LocalTable that keeps all items in memory.
+func NewMemoryTable() *LocalTable {
+	return NewLocalTable(NewMemoryStore())
+}
+
+func (t *LocalTable) CreateUser(ctx context.Context, u *User) error {
+	if err := u.Validate(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.mustNotExist(usersCollection, u.ID); err != nil {
+		return err
+	}
+	return t.put(usersCollection, u.ID, u)
+}
+
+func (t *LocalTable) UpdateUser(ctx context.Context, u *User) error {
+	if err := u.Validate(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var stored User
+	if err := t.get(usersCollection, u.ID, &stored); err != nil {
+		return err
+	}
+	u.UpdatedAt = time.Now()
+	return t.put(usersCollection, u.ID, u)
+}
+
+func (t *LocalTable) RegisterUser(ctx context.Context, u *User) error {
+	if err := u.Validate(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var stored User
+	err := t.get(usersCollection, u.ID, &stored)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return err
+	}
+	exists := err == nil
+
+	if exists && stored.LastLogin.Equal(u.LastLogin) {
+		// User wasn't updated, return current data
+		*u = stored
+		return nil
+	}
+
+	if exists {
+		// Keep settings and everything else only set if not exists
+		registered := stored
+		registered.Handle = u.Handle
+		registered.Name = u.Name
+		registered.Location = u.Location
+		registered.Bio = u.Bio
+		registered.ProfileImageURL = u.ProfileImageURL
+		registered.AccessToken = u.AccessToken
+		registered.AccessSecret = u.AccessSecret
+		registered.UpdatedAt = u.UpdatedAt
+		registered.LastLogin = u.LastLogin
+		registered.LastIP = u.LastIP
+		registered.LoginsCount = u.LoginsCount
+		registered.IDP = u.IDP
+		if registered.TrackingStatus == "" {
+			registered.TrackingStatus = TrackingStatusActive
+		}
+		*u = registered
+	}
+
+	// A new login comes with a fresh token, see Table.RegisterUser
+	if u.TrackingStatus == TrackingStatusNeedsReauth {
+		u.TrackingStatus = TrackingStatusActive
+	}
+
+	return t.put(usersCollection, u.ID, u)
+}
+
+func (t *LocalTable) GetUser(ctx context.Context, userID string) (*User, error) {
+	var u User
+	if err := t.get(usersCollection, userID, &u); err != nil {
+		return nil, err
+	}
+	if err := u.Validate(); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (t *LocalTable) DeleteUser(ctx context.Context, userID string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var u User
+	if err := t.get(usersCollection, userID, &u); err != nil {
+		return err
+	}
+	return t.store.Delete(usersCollection, userID)
+}
+
+func (t *LocalTable) NewUserIter() UserIter {
+	return t.newUserIter(func(*User) bool { return true }, func(x, y *User) bool {
+		return x.ID < y.ID
+	})
+}
+
+func (t *LocalTable) NewUnscheduledUserIter() UserIter {
+	return t.newUserIter(func(u *User) bool { return u.NextCheckAt.IsZero() }, func(x, y *User) bool {
+		return x.ID < y.ID
+	})
+}
+
+func (t *LocalTable) NewDueUserIter(now time.Time) UserIter {
+	now = now.UTC().Truncate(time.Second)
+	return t.newUserIter(func(u *User) bool { return u.IsTracked() && !u.NextCheckAt.After(now) }, func(x, y *User) bool {
+		return x.NextCheckAt.Before(y.NextCheckAt)
+	})
+}
+
+func (t *LocalTable) newUserIter(match func(*User) bool, less func(x, y *User) bool) UserIter {
+	all, err := scan[User](t.store, usersCollection, "")
+	if err != nil {
+		return &localUserIter{err: err}
+	}
+
+	var users []*User
+	for _, u := range all {
+		if match(u) {
+			users = append(users, u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return less(users[i], users[j]) })
+
+	return &localUserIter{users: users}
+}
+
+type localUserIter struct {
+	users []*User
+	err   error
+}
+
+func (iter *localUserIter) Next(context.Context) *User {
+	if len(iter.users) == 0 {
+		return nil
+	}
+	u := iter.users[0]
+	iter.users = iter.users[1:]
+	return u
+}
+
+func (iter *localUserIter) Err() error {
+	return iter.err
+}
+
+func (t *LocalTable) UpdateUserSchedule(ctx context.Context, u *User) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var stored User
+	if err := t.get(usersCollection, u.ID, &stored); err != nil {
+		return err
+	}
+	stored.CheckInterval = u.CheckInterval
+	stored.NextCheckAt = u.NextCheckAt
+	return t.put(usersCollection, u.ID, &stored)
+}
+
+func (t *LocalTable) UpdateUserStats(ctx context.Context, u *User) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var stored User
+	if err := t.get(usersCollection, u.ID, &stored); err != nil {
+		return err
+	}
+	stored.Stats = u.Stats
+	return t.put(usersCollection, u.ID, &stored)
+}
+
+func (t *LocalTable) UpdateUserDigest(ctx context.Context, u *User) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var stored User
+	if err := t.get(usersCollection, u.ID, &stored); err != nil {
+		return err
+	}
+	stored.Digest = u.Digest
+	return t.put(usersCollection, u.ID, &stored)
+}
+
+func (t *LocalTable) UpdateUserSlackThread(ctx context.Context, u *User) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var stored User
+	if err := t.get(usersCollection, u.ID, &stored); err != nil {
+		return err
+	}
+	if thread := stored.Slack.Thread; thread != nil && thread.RunID == u.Slack.Thread.RunID {
+		return ErrSlackThreadExists
+	}
+	stored.Slack.Thread = u.Slack.Thread
+	return t.put(usersCollection, u.ID, &stored)
+}
+
+func (t *LocalTable) UpdateUserTrackingStatus(ctx context.Context, userID, status string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var stored User
+	err := t.get(usersCollection, userID, &stored)
+	if errors.Is(err, ErrUserNotFound) || (err == nil && stored.TrackingStatus == status) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	stored.TrackingStatus = status
+	return true, t.put(usersCollection, userID, &stored)
+}
+
+func (t *LocalTable) UpdateUserWebhook(ctx context.Context, u *User, w *Webhook) error {
+	if err := w.Validate(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var stored User
+	if err := t.get(usersCollection, u.ID, &stored); err != nil {
+		return err
+	}
+	i := webhookIndex(&stored, w.ID)
+	if i < 0 {
+		return ErrWebhookNotFound
+	}
+	stored.Webhooks[i] = w
+	return t.put(usersCollection, u.ID, &stored)
+}
+
+func (t *LocalTable) RemoveUserPushSubscription(ctx context.Context, u *User, id string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var stored User
+	if err := t.get(usersCollection, u.ID, &stored); err != nil {
+		return err
+	}
+	if err := stored.RemovePushSubscription(id); err != nil {
+		return err
+	}
+	return t.put(usersCollection, u.ID, &stored)
+}
+
+func (t *LocalTable) CreateFollowerList(ctx context.Context, l *FollowerList) error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := l.UserID + "#" + l.sk()
+	if err := t.mustNotExist(listsCollection, key); err != nil {
+		return err
+	}
+	return t.put(listsCollection, key, l)
+}
+
+func (t *LocalTable) QuarantineFollowerList(ctx context.Context, l *FollowerList) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var stored FollowerList
+	key := l.UserID + "#" + l.sk()
+	if err := t.get(listsCollection, key, &stored); err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			return ErrFollowerListNotFound
+		}
+		return err
+	}
+	stored.Quarantined = true
+	if err := t.put(listsCollection, key, &stored); err != nil {
+		return err
+	}
+	l.Quarantined = true
+	return nil
+}
+
+func (t *LocalTable) GetUserAndLatestFollowerLists(ctx context.Context, userID string, limit int64) (*User, []*FollowerList, error) {
+	u, err := t.GetUser(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lists, err := t.GetLatestFollowerLists(ctx, userID, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return u, lists, nil
+}
+
+func (t *LocalTable) GetLatestFollowerLists(ctx context.Context, userID string, limit int64) ([]*FollowerList, error) {
+	lists, err := scan[FollowerList](t.store, listsCollection, userID+"#")
+	if err != nil {
+		return nil, err
+	}
+	return latest(lists, limit), nil
+}
+
+func (t *LocalTable) CreateFollowerEvent(ctx context.Context, e *FollowerEvent) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := e.UserID + "#" + e.sk()
+	if err := t.mustNotExist(eventsCollection, key); err != nil {
+		return err
+	}
+	return t.put(eventsCollection, key, e)
+}
+
+func (t *LocalTable) GetLatestFollowerEvents(ctx context.Context, userID string, limit int64) ([]*FollowerEvent, error) {
+	events, err := scan[FollowerEvent](t.store, eventsCollection, userID+"#")
+	if err != nil {
+		return nil, err
+	}
+	return latest(events, limit), nil
+}
+
+func (t *LocalTable) GetFollowerEventsBetween(ctx context.Context, userID string, from, to time.Time) ([]*FollowerEvent, error) {
+	events, err := scan[FollowerEvent](t.store, eventsCollection, userID+"#")
+	if err != nil {
+		return nil, err
+	}
+	return between(latest(events, 0), from, to), nil
+}
+
+func (t *LocalTable) UpdateFollowerEventReason(ctx context.Context, e *FollowerEvent) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := e.UserID + "#" + e.sk()
+	var stored FollowerEvent
+	if err := t.get(eventsCollection, key, &stored); err != nil {
+		return err
+	}
+	stored.FollowerStateReason = e.FollowerStateReason
+	return t.put(eventsCollection, key, &stored)
+}
+
+func (t *LocalTable) GetFollowerChurn(ctx context.Context, userID string) ([]*FollowerChurn, error) {
+	c := FollowerChurn{UserID: userID}
+	return scan[FollowerChurn](t.store, churnCollection, userID+"#"+c.sk())
+}
+
+func (t *LocalTable) GetFollowerChurnByID(ctx context.Context, userID, followerID string) (*FollowerChurn, error) {
+	c := FollowerChurn{UserID: userID, FollowerID: followerID}
+	if err := t.get(churnCollection, userID+"#"+c.sk(), &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (t *LocalTable) SaveFollowerChurn(ctx context.Context, c *FollowerChurn) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	return t.put(churnCollection, c.UserID+"#"+c.sk(), c)
+}
+
+func (t *LocalTable) GetWebhookDelivery(ctx context.Context, userID, webhookID, id string) (*WebhookDelivery, error) {
+	d := WebhookDelivery{ID: id, UserID: userID, WebhookID: webhookID}
+	if err := t.get(deliveriesCollection, userID+"#"+d.sk(), &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (t *LocalTable) SaveWebhookDelivery(ctx context.Context, d *WebhookDelivery) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	return t.put(deliveriesCollection, d.UserID+"#"+d.sk(), d)
+}
+
+func (t *LocalTable) GetLatestWebhookDeliveries(ctx context.Context, userID, webhookID string, limit int64) ([]*WebhookDelivery, error) {
+	d := WebhookDelivery{UserID: userID, WebhookID: webhookID}
+	deliveries, err := scan[WebhookDelivery](t.store, deliveriesCollection, userID+"#"+d.sk())
+	if err != nil {
+		return nil, err
+	}
+	return latest(deliveries, limit), nil
+}
+
+func (t *LocalTable) GetDueWebhookDeliveries(ctx context.Context, now time.Time) ([]*WebhookDelivery, error) {
+	all, err := scan[WebhookDelivery](t.store, deliveriesCollection, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var due []*WebhookDelivery
+	for _, d := range all {
+		if !d.NextAttemptAt.IsZero() && !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+	return due, nil
+}
+
+func (t *LocalTable) GetNotificationDelivery(ctx context.Context, userID, eventID, channel string) (*NotificationDelivery, error) {
+	d := NotificationDelivery{UserID: userID, EventID: eventID, Channel: channel}
+	if err := t.get(notificationsCollection, userID+"#"+d.sk(), &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (t *LocalTable) SaveNotificationDelivery(ctx context.Context, d *NotificationDelivery) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := d.UserID + "#" + d.sk()
+	var stored NotificationDelivery
+	if err := t.get(notificationsCollection, key, &stored); err != nil && !errors.Is(err, ErrNotificationNotFound) {
+		return err
+	}
+	if stored.Version != d.Version {
+		return ErrNotificationConflict
+	}
+	d.Version++
+	if err := t.put(notificationsCollection, key, d); err != nil {
+		d.Version--
+		return err
+	}
+	return nil
+}
+
+func (t *LocalTable) GetDeadLetters(ctx context.Context, userID string, limit int64) ([]*NotificationDelivery, error) {
+	deliveries, err := scan[NotificationDelivery](t.store, notificationsCollection, userID+"#"+notificationPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var failed []*NotificationDelivery
+	for _, d := range deliveries {
+		if d.Status == DeliveryStatusFailed && !d.Alert {
+			failed = append(failed, d)
+		}
+	}
+	return latest(failed, limit), nil
+}
+
+func (t *LocalTable) CreateDeferredEvent(ctx context.Context, d *DeferredEvent) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := d.UserID + "#" + d.sk()
+	if err := t.mustNotExist(deferredCollection, key); err != nil {
+		if isConditionalCheckErr(err) {
+			return ErrDeferredEventExists
+		}
+		return err
+	}
+	return t.put(deferredCollection, key, d)
+}
+
+func (t *LocalTable) GetDeferredEvents(ctx context.Context, userID string) ([]*DeferredEvent, error) {
+	d := DeferredEvent{UserID: userID}
+	return scan[DeferredEvent](t.store, deferredCollection, userID+"#"+d.sk())
+}
+
+func (t *LocalTable) GetAllDeferredEvents(ctx context.Context) ([]*DeferredEvent, error) {
+	deferred, err := scan[DeferredEvent](t.store, deferredCollection, "")
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(deferred, func(i, j int) bool { return deferred[i].ID < deferred[j].ID })
+	return deferred, nil
+}
+
+func (t *LocalTable) DeleteDeferredEvent(ctx context.Context, d *DeferredEvent) error {
+	return t.store.Delete(deferredCollection, d.UserID+"#"+d.sk())
+}
+
+func (t *LocalTable) GetRateBudget(ctx context.Context, name string) (*RateBudget, error) {
+	var b RateBudget
+	if err := t.get(budgetsCollection, name, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (t *LocalTable) GetRateBudgets(ctx context.Context) ([]*RateBudget, error) {
+	return scan[RateBudget](t.store, budgetsCollection, "")
+}
+
+func (t *LocalTable) SaveRateBudgets(ctx context.Context, budgets ...*RateBudget) error {
+	for _, b := range budgets {
+		if err := b.Validate(); err != nil {
+			return err
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, b := range budgets {
+		var stored RateBudget
+		if err := t.get(budgetsCollection, b.Name, &stored); err != nil && !errors.Is(err, ErrRateBudgetNotFound) {
+			return err
+		}
+		if stored.Version != b.Version {
+			return ErrRateBudgetConflict
+		}
+	}
+	for _, b := range budgets {
+		b.Version++
+		if err := t.put(budgetsCollection, b.Name, b); err != nil {
+			b.Version--
+			return err
+		}
+	}
+	return nil
+}
+
+// Expire deletes follower lists, events, webhook and notification
+// deliveries, deferred events, and follower churn whose TTL has passed, which
+// DynamoDB would otherwise do for us.
+func (t *LocalTable) Expire(ctx context.Context, now time.Time) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	type itemKey struct{ collection, key string }
+	var expired []itemKey
+
+	lists, err := t.store.Scan(listsCollection, "")
+	if err != nil {
+		return 0, err
+	}
+	for _, item := range lists {
+		var l FollowerList
+		if err := decode(item.Value, &l); err != nil {
+			return 0, err
+		}
+		if !l.ExpiresAt.IsZero() && l.ExpiresAt.Before(now) {
+			expired = append(expired, itemKey{listsCollection, item.Key})
+		}
+	}
+
+	events, err := t.store.Scan(eventsCollection, "")
+	if err != nil {
+		return 0, err
+	}
+	for _, item := range events {
+		var e FollowerEvent
+		if err := decode(item.Value, &e); err != nil {
+			return 0, err
+		}
+		if !e.ExpiresAt.IsZero() && e.ExpiresAt.Before(now) {
+			expired = append(expired, itemKey{eventsCollection, item.Key})
+		}
+	}
+
+	deliveries, err := t.store.Scan(deliveriesCollection, "")
+	if err != nil {
+		return 0, err
+	}
+	for _, item := range deliveries {
+		var d WebhookDelivery
+		if err := decode(item.Value, &d); err != nil {
+			return 0, err
+		}
+		if !d.ExpiresAt.IsZero() && d.ExpiresAt.Before(now) {
+			expired = append(expired, itemKey{deliveriesCollection, item.Key})
+		}
+	}
+
+	notifications, err := t.store.Scan(notificationsCollection, "")
+	if err != nil {
+		return 0, err
+	}
+	for _, item := range notifications {
+		var d NotificationDelivery
+		if err := decode(item.Value, &d); err != nil {
+			return 0, err
+		}
+		if !d.ExpiresAt.IsZero() && d.ExpiresAt.Before(now) {
+			expired = append(expired, itemKey{notificationsCollection, item.Key})
+		}
+	}
+
+	deferred, err := t.store.Scan(deferredCollection, "")
+	if err != nil {
+		return 0, err
+	}
+	for _, item := range deferred {
+		var d DeferredEvent
+		if err := decode(item.Value, &d); err != nil {
+			return 0, err
+		}
+		if !d.ExpiresAt.IsZero() && d.ExpiresAt.Before(now) {
+			expired = append(expired, itemKey{deferredCollection, item.Key})
+		}
+	}
+
+	churn, err := t.store.Scan(churnCollection, "")
+	if err != nil {
+		return 0, err
+	}
+	for _, item := range churn {
+		var c FollowerChurn
+		if err := decode(item.Value, &c); err != nil {
+			return 0, err
+		}
+		if !c.ExpiresAt.IsZero() && c.ExpiresAt.Before(now) {
+			expired = append(expired, itemKey{churnCollection, item.Key})
+		}
+	}
+
+	for _, k := range expired {
+		if err := t.store.Delete(k.collection, k.key); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(expired), nil
+}
+
+func (t *LocalTable) get(collection, key string, v interface{}) error {
+	value, err := t.store.Get(collection, key)
+	if errors.Is(err, ErrItemNotFound) {
+		switch collection {
+		case usersCollection:
+			return ErrUserNotFound
+		case eventsCollection:
+			return ErrFollowerEventNotFound
+		case budgetsCollection:
+			return ErrRateBudgetNotFound
+		case notificationsCollection:
+			return ErrNotificationNotFound
+		case churnCollection:
+			return ErrFollowerChurnNotFound
+		case deliveriesCollection:
+			return ErrWebhookDeliveryNotFound
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return decode(value, v)
+}
+
+func (t *LocalTable) put(collection, key string, v interface{}) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	return t.store.Put(collection, key, buf.Bytes())
+}
+
+// mustNotExist fails like a conditional put with attribute_not_exists(PK).
+func (t *LocalTable) mustNotExist(collection, key string) error {
+	_, err := t.store.Get(collection, key)
+	if errors.Is(err, ErrItemNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return errConditionalCheckFailed
+}
+
+func decode(value []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(value)).Decode(v)
+}
+
+func scan[T any](store Store, collection, prefix string) ([]*T, error) {
+	items, err := store.Scan(collection, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]*T, len(items))
+	for i, item := range items {
+		values[i] = new(T)
+		if err := decode(item.Value, values[i]); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// latest returns up to limit items in descending key order, just like
+// querying a partition with ScanIndexForward set to false.
+func latest[T any](items []*T, limit int64) []*T {
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+	if limit > 0 && int64(len(items)) > limit {
+		items = items[:limit]
+	}
+	return items
+}
+
+var errConditionalCheckFailed = awserr.NewRequestFailure(
+	awserr.New("ConditionalCheckFailedException", "The conditional request failed", nil), 400, "")
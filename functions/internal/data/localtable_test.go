@@ -0,0 +1,322 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+func newFollowerList() *FollowerList {
+	return &FollowerList{
+		UserID:         "1234",
+		S3Bucket:       "some-bucket",
+		S3Key:          "/some/path",
+		TotalFollowers: 1000,
+		CreatedAt:      created,
+		ExpiresAt:      created.Add(24 * time.Hour),
+	}
+}
+
+func TestLocalTable_SaveLoad(t *testing.T) {
+	ctx := context.Background()
+
+	followerList := newFollowerList()
+
+	store := NewMemoryStore()
+	if err := NewLocalTable(store).CreateFollowerList(ctx, followerList); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := store.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadMemoryStore(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lists, err := NewLocalTable(loaded).GetLatestFollowerLists(ctx, followerList.UserID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*FollowerList{followerList}, lists); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestLocalTable_CreateFollowerListTwice(t *testing.T) {
+	ctx := context.Background()
+
+	followerList := newFollowerList()
+
+	table := NewMemoryTable()
+	if err := table.CreateFollowerList(ctx, followerList); err != nil {
+		t.Fatal(err)
+	}
+
+	// Same key, just like a conditional put on DynamoDB
+	var aerr awserr.Error
+	err := table.CreateFollowerList(ctx, followerList)
+	if !errors.As(err, &aerr) || aerr.Code() != "ConditionalCheckFailedException" {
+		t.Fatalf("expected conditional check to fail, got %v", err)
+	}
+}
+
+func TestLocalTable_Expire(t *testing.T) {
+	ctx := context.Background()
+
+	table := NewMemoryTable()
+	if err := table.CreateFollowerList(ctx, newFollowerList()); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := table.Expire(ctx, created.Add(48*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(1, n); diff != "" {
+		t.Error(diff)
+	}
+
+	lists, err := table.GetLatestFollowerLists(ctx, "1234", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(0, len(lists)); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestLocalTable_DeferredEvents(t *testing.T) {
+	ctx := context.Background()
+	table := NewMemoryTable()
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		e := &FollowerEvent{
+			ID:                  fmt.Sprintf("event-%d", i),
+			UserID:              "1234",
+			Follower:            &twitter.User{ID: "123"},
+			FollowerState:       FollowerStateLost,
+			FollowerStateReason: FollowerStateReasonDeleted,
+			CreatedAt:           created,
+			ExpiresAt:           created.Add(24 * time.Hour),
+		}
+		d := NewDeferredEvent(e, created.Add(time.Duration(i)*time.Minute))
+		if err := table.CreateDeferredEvent(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, d.ID)
+	}
+
+	// Queuing an event again, e.g. on retry, fails
+	d := NewDeferredEvent(&FollowerEvent{ID: "event-1", UserID: "1234"}, created.Add(time.Hour))
+	if err := table.CreateDeferredEvent(ctx, d); !errors.Is(err, ErrDeferredEventExists) {
+		t.Errorf("expected deferred event to exist, got %v", err)
+	}
+
+	deferred, err := table.GetDeferredEvents(ctx, "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range deferred {
+		got = append(got, d.ID)
+	}
+	if diff := cmp.Diff(ids, got); diff != "" {
+		t.Error(diff)
+	}
+
+	if err := table.DeleteDeferredEvent(ctx, deferred[0]); err != nil {
+		t.Fatal(err)
+	}
+	deferred, err = table.GetDeferredEvents(ctx, "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(2, len(deferred)); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestLocalTable_NotificationDeliveries(t *testing.T) {
+	ctx := context.Background()
+	table := NewMemoryTable()
+
+	for _, id := range []string{"event-1", "event-2", "event-3"} {
+		e := &FollowerEvent{ID: id, UserID: "1234", Follower: &twitter.User{ID: "123"}}
+		d := NewNotificationDelivery(e, "slack", created)
+		if id != "event-2" {
+			d.Status = DeliveryStatusFailed
+		}
+		if err := table.SaveNotificationDelivery(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Failed alerts are no dead letters
+	alert := NewAlertDelivery("1234", "alert-1", "slack", created)
+	alert.Status = DeliveryStatusFailed
+	if err := table.SaveNotificationDelivery(ctx, alert); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := table.GetNotificationDelivery(ctx, "1234", "event-2", "slack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := *d
+
+	d.Status = DeliveryStatusSent
+	if err := table.SaveNotificationDelivery(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	stale.Attempts++
+	if err := table.SaveNotificationDelivery(ctx, &stale); !errors.Is(err, ErrNotificationConflict) {
+		t.Errorf("expected conflict, got %v", err)
+	}
+
+	if _, err := table.GetNotificationDelivery(ctx, "1234", "event-2", "email"); !errors.Is(err, ErrNotificationNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	dead, err := table.GetDeadLetters(ctx, "1234", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range dead {
+		got = append(got, d.EventID)
+	}
+	if diff := cmp.Diff([]string{"event-3", "event-1"}, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestLocalTable_FollowerChurn(t *testing.T) {
+	ctx := context.Background()
+	table := NewMemoryTable()
+
+	c := &FollowerChurn{UserID: "1234", FollowerID: "123"}
+	c.Lost(created)
+	if err := table.SaveFollowerChurn(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	c.Refollowed(time.Hour, created.Add(time.Minute))
+	if err := table.SaveFollowerChurn(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+
+	churn, err := table.GetFollowerChurn(ctx, "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*FollowerChurn{c}, churn); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestLocalTable_UpdateFollowerEventReason(t *testing.T) {
+	ctx := context.Background()
+	table := NewMemoryTable()
+
+	e := &FollowerEvent{
+		ID:                  "some-event-id",
+		UserID:              "1234",
+		Follower:            &twitter.User{ID: "123"},
+		FollowerState:       FollowerStateLost,
+		FollowerStateReason: FollowerStateReasonDeactivated,
+		CreatedAt:           created,
+		ExpiresAt:           created.Add(24 * time.Hour),
+	}
+	if err := table.CreateFollowerEvent(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+
+	update := &FollowerEvent{ID: e.ID, UserID: e.UserID, FollowerStateReason: FollowerStateReasonDeleted}
+	if err := table.UpdateFollowerEventReason(ctx, update); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := table.GetLatestFollowerEvents(ctx, "1234", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.FollowerStateReason = FollowerStateReasonDeleted
+	if diff := cmp.Diff([]*FollowerEvent{e}, events); diff != "" {
+		t.Error(diff)
+	}
+
+	update.ID = "other-event-id"
+	if err := table.UpdateFollowerEventReason(ctx, update); !errors.Is(err, ErrFollowerEventNotFound) {
+		t.Errorf("expected event not to be found, got %v", err)
+	}
+}
+
+func TestLocalTable_GetFollowerEventsBetween(t *testing.T) {
+	ctx := context.Background()
+	table := NewMemoryTable()
+
+	var events []*FollowerEvent
+	for i := 0; i < 3; i++ {
+		e := &FollowerEvent{
+			ID:                  fmt.Sprintf("event-%d", i),
+			UserID:              "1234",
+			Follower:            &twitter.User{ID: "123"},
+			FollowerState:       FollowerStateNew,
+			FollowerStateReason: FollowerStateReasonFollowed,
+			CreatedAt:           created.Add(time.Duration(i) * time.Hour),
+			ExpiresAt:           created.Add(24 * time.Hour),
+		}
+		if err := table.CreateFollowerEvent(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+
+	got, err := table.GetFollowerEventsBetween(ctx, "1234", created.Add(time.Hour), created.Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*FollowerEvent{events[2], events[1]}, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestLocalTable_NewDueUserIter(t *testing.T) {
+	ctx := context.Background()
+	table := NewMemoryTable()
+
+	for _, status := range []string{TrackingStatusActive, TrackingStatusPaused, TrackingStatusNeedsReauth} {
+		u := NewUser(status)
+		u.Handle, u.Name, u.ProfileImageURL = "alice", "Alice", "https://example.com/alice.png"
+		u.AccessToken, u.AccessSecret = "token", "secret"
+		u.LastLogin, u.LastIP, u.LoginsCount = u.CreatedAt, "127.0.0.1", 1
+		u.TrackingStatus = status
+		u.ScheduleCheckAt(created)
+		if err := table.CreateUser(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var due []string
+	iter := table.NewDueUserIter(created.Add(time.Hour))
+	for u := iter.Next(ctx); u != nil; u = iter.Next(ctx) {
+		due = append(due, u.ID)
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{TrackingStatusActive}, due); diff != "" {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,105 @@
+package data
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store persists the encoded items of a LocalTable, grouped into collections
+// and identified by key.
+type Store interface {
+	// Get returns the item with the key, or ErrItemNotFound.
+	Get(collection, key string) ([]byte, error)
+	Put(collection, key string, value []byte) error
+	Delete(collection, key string) error
+	// Scan returns all items whose key starts with prefix, ordered by key.
+	Scan(collection, prefix string) ([]StoreItem, error)
+}
+
+type StoreItem struct {
+	Key   string
+	Value []byte
+}
+
+var ErrItemNotFound = errors.New("item not found")
+
+var _ Store = (*MemoryStore)(nil)
+
+// MemoryStore keeps all items in memory. Snapshots can be written with Save
+// and restored with LoadMemoryStore.
+type MemoryStore struct {
+	mu          sync.Mutex
+	collections map[string]map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{collections: map[string]map[string][]byte{}}
+}
+
+// LoadMemoryStore restores a store previously written with Save.
+func LoadMemoryStore(r io.Reader) (*MemoryStore, error) {
+	s := NewMemoryStore()
+	if err := gob.NewDecoder(r).Decode(&s.collections); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save writes a snapshot of all items.
+func (s *MemoryStore) Save(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return gob.NewEncoder(w).Encode(s.collections)
+}
+
+func (s *MemoryStore) Get(collection, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.collections[collection][key]
+	if !ok {
+		return nil, ErrItemNotFound
+	}
+	return value, nil
+}
+
+func (s *MemoryStore) Put(collection, key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := s.collections[collection]
+	if items == nil {
+		items = map[string][]byte{}
+		s.collections[collection] = items
+	}
+	items[key] = value
+	return nil
+}
+
+func (s *MemoryStore) Delete(collection, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.collections[collection], key)
+	return nil
+}
+
+func (s *MemoryStore) Scan(collection, prefix string) ([]StoreItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var items []StoreItem
+	for key, value := range s.collections[collection] {
+		if strings.HasPrefix(key, prefix) {
+			items = append(items, StoreItem{Key: key, Value: value})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+
+	return items, nil
+}
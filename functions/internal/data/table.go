@@ -3,6 +3,7 @@ package data
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -13,20 +14,25 @@ import (
 
 // Table implements the Table Module pattern: https://www.martinfowler.com/eaaCatalog/tableModule.html
 type Table struct {
+	db              *dynamo.DB
 	inner           dynamo.Table
 	consistentReads bool
 }
 
 func NewTable(p client.ConfigProvider, name string) *Table {
+	db := dynamo.New(p)
 	return &Table{
-		inner:           dynamo.New(p).Table(name),
+		db:              db,
+		inner:           db.Table(name),
 		consistentReads: false,
 	}
 }
 
 func NewConsistentTable(p client.ConfigProvider, name string) *Table {
+	db := dynamo.New(p)
 	return &Table{
-		inner:           dynamo.New(p).Table(name),
+		db:              db,
+		inner:           db.Table(name),
 		consistentReads: true,
 	}
 }
@@ -68,6 +74,11 @@ func (t *Table) RegisterUser(ctx context.Context, u *User) error {
 		Set("ProfileImageURL", item.ProfileImageURL).
 		Set("AccessToken", item.AccessToken).
 		Set("AccessSecret", item.AccessSecret).
+		SetIfNotExists("TrackingStatus", TrackingStatusActive).
+		// Also re-adds paused users, which enqueue-users drops again when due
+		SetIfNotExists(scheduleIndex, scheduleKey).
+		SetIfNotExists("CheckInterval", item.CheckInterval).
+		SetIfNotExists("NextCheckAt", item.NextCheckAt).
 		SetIfNotExists("CreatedAt", item.CreatedAt).
 		Set("UpdatedAt", item.UpdatedAt).
 		Set("LastLogin", item.LastLogin).
@@ -86,7 +97,19 @@ func (t *Table) RegisterUser(ctx context.Context, u *User) error {
 		*u = *u2
 		return nil
 	}
-	return err
+	if err != nil {
+		return err
+	}
+
+	// A new login comes with a fresh token, so resume tracking if it was
+	// suspended because the previous token was revoked.
+	if u.TrackingStatus == TrackingStatusNeedsReauth {
+		if _, err := t.UpdateUserTrackingStatus(ctx, u.ID, TrackingStatusActive); err != nil {
+			return err
+		}
+		u.TrackingStatus = TrackingStatusActive
+	}
+	return nil
 }
 
 func (t *Table) GetUser(ctx context.Context, userID string) (*User, error) {
@@ -122,6 +145,170 @@ func (t *Table) NewUserIter() UserIter {
 	}
 }
 
+// NewUnscheduledUserIter returns all users without a next check, e.g. those
+// who signed up before checks were scheduled, which leaves them out of
+// ScheduleIndex. It scans the whole table.
+func (t *Table) NewUnscheduledUserIter() UserIter {
+	return &userIter{
+		inner: t.inner.Scan().Index(userIndex).
+			Filter("attribute_not_exists('NextCheckAt')").
+			Consistent(t.consistentReads).
+			Iter(),
+	}
+}
+
+// NewDueUserIter returns all users whose next check is due at the given time.
+// It queries the sparse ScheduleIndex rather than scanning the whole table.
+func (t *Table) NewDueUserIter(now time.Time) UserIter {
+	return &userIter{
+		inner: t.inner.Get(scheduleIndex, scheduleKey).
+			Index(scheduleIndex).
+			Range("NextCheckAt", dynamo.LessOrEqual, now.UTC().Truncate(time.Second)).
+			Iter(),
+	}
+}
+
+// scheduled adds a user to ScheduleIndex, or removes them if they aren't
+// tracked, so that only tracked users are due.
+func scheduled(update *dynamo.Update, tracked bool) *dynamo.Update {
+	if tracked {
+		return update.Set(scheduleIndex, scheduleKey)
+	}
+	return update.Remove(scheduleIndex)
+}
+
+// UpdateUserSchedule only updates the scheduling attributes of a user so that
+// it doesn't race with concurrent changes to the rest of the user item.
+func (t *Table) UpdateUserSchedule(ctx context.Context, u *User) error {
+	item := u.toItem()
+	err := scheduled(t.inner.Update("PK", item.PK).Range("SK", item.SK), u.IsTracked()).
+		If("attribute_exists(PK)").
+		Set("CheckInterval", item.CheckInterval).
+		Set("NextCheckAt", item.NextCheckAt).
+		RunWithContext(ctx)
+	if isConditionalCheckErr(err) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// UpdateUserStats only updates the follower stats of a user, which are kept
+// by diff-followers.
+func (t *Table) UpdateUserStats(ctx context.Context, u *User) error {
+	err := t.inner.Update("PK", u.pk()).Range("SK", u.sk()).
+		If("attribute_exists(PK)").
+		Set("Stats", u.Stats).
+		RunWithContext(ctx)
+	if isConditionalCheckErr(err) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// UpdateUserDigest only updates the digest settings of a user, e.g. to record
+// when the last digest was sent.
+func (t *Table) UpdateUserDigest(ctx context.Context, u *User) error {
+	err := t.inner.Update("PK", u.pk()).Range("SK", u.sk()).
+		If("attribute_exists(PK)").
+		Set("Digest", u.Digest).
+		RunWithContext(ctx)
+	if isConditionalCheckErr(err) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// UpdateUserSlackThread only updates the Slack thread of a user. It fails
+// with ErrSlackThreadExists if a thread was already started for the same
+// run, e.g. by a concurrent notification.
+func (t *Table) UpdateUserSlackThread(ctx context.Context, u *User) error {
+	err := t.inner.Update("PK", u.pk()).Range("SK", u.sk()).
+		If("attribute_exists(PK)").
+		If("attribute_not_exists($) OR $ <> ?", "Slack.Thread", "Slack.Thread.RunID", u.Slack.Thread.RunID).
+		Set("Slack.Thread", u.Slack.Thread).
+		RunWithContext(ctx)
+	if isConditionalCheckErr(err) {
+		// Only users that exist can have a thread
+		if _, err := t.GetUser(ctx, u.ID); err != nil {
+			return err
+		}
+		return ErrSlackThreadExists
+	}
+	return err
+}
+
+// UpdateUserTrackingStatus sets the tracking status of a user and reports
+// whether it actually changed, which allows callers to act on a transition
+// only once. It also reports false if the user doesn't exist.
+func (t *Table) UpdateUserTrackingStatus(ctx context.Context, userID, status string) (bool, error) {
+	u := NewUser(userID)
+	u.TrackingStatus = status
+	err := scheduled(t.inner.Update("PK", u.pk()).Range("SK", u.sk()), u.IsTracked()).
+		If("attribute_exists(PK)").
+		If("attribute_not_exists('TrackingStatus') OR 'TrackingStatus' <> ?", status).
+		Set("TrackingStatus", status).
+		RunWithContext(ctx)
+	if isConditionalCheckErr(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// UpdateUserWebhook only updates a single webhook of a user, e.g. to record
+// failed deliveries, without overwriting concurrent changes to the user item.
+// It fails with ErrWebhookNotFound if the user's webhooks were changed since
+// u was read.
+func (t *Table) UpdateUserWebhook(ctx context.Context, u *User, w *Webhook) error {
+	if err := w.Validate(); err != nil {
+		return err
+	}
+
+	i := webhookIndex(u, w.ID)
+	if i < 0 {
+		return ErrWebhookNotFound
+	}
+
+	path := fmt.Sprintf("Webhooks[%d]", i)
+	err := t.inner.Update("PK", u.pk()).Range("SK", u.sk()).
+		If("$ = ?", path+".ID", w.ID).
+		Set(path, w).
+		RunWithContext(ctx)
+	if isConditionalCheckErr(err) {
+		return ErrWebhookNotFound
+	}
+	return err
+}
+
+// RemoveUserPushSubscription only removes a single push subscription of a
+// user, e.g. after the push service reported it as gone. It fails with
+// ErrPushSubscriptionNotFound if the user's subscriptions were changed since
+// u was read.
+func (t *Table) RemoveUserPushSubscription(ctx context.Context, u *User, id string) error {
+	i := pushSubscriptionIndex(u, id)
+	if i < 0 {
+		return ErrPushSubscriptionNotFound
+	}
+
+	path := fmt.Sprintf("Push.Subscriptions[%d]", i)
+	err := t.inner.Update("PK", u.pk()).Range("SK", u.sk()).
+		If("$ = ?", path+".ID", id).
+		Remove(path).
+		RunWithContext(ctx)
+	if isConditionalCheckErr(err) {
+		return ErrPushSubscriptionNotFound
+	}
+	return err
+}
+
+func webhookIndex(u *User, id string) int {
+	for i, w := range u.Webhooks {
+		if w.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
 type userIter struct {
 	inner dynamo.PagingIter
 }
@@ -152,7 +339,9 @@ func (t *Table) GetUserAndLatestFollowerLists(ctx context.Context, userID string
 	// individually via dynamo.UnmarshalItem after the fact.
 	var items []map[string]*dynamodb.AttributeValue
 
+	// Only the user sorts after the lists
 	err := t.inner.Get("PK", u.pk()).
+		Range("SK", dynamo.Greater, "FOLLOWERS#").
 		Limit(limit+1).
 		Order(dynamo.Descending).
 		Consistent(t.consistentReads).
@@ -186,6 +375,21 @@ func (t *Table) GetUserAndLatestFollowerLists(ctx context.Context, userID string
 	return u, lists, nil
 }
 
+// QuarantineFollowerList marks a list as not to be compared with.
+func (t *Table) QuarantineFollowerList(ctx context.Context, l *FollowerList) error {
+	err := t.inner.Update("PK", l.pk()).Range("SK", l.sk()).
+		If("attribute_exists(PK)").
+		Set("Quarantined", true).
+		RunWithContext(ctx)
+	if isConditionalCheckErr(err) {
+		return ErrFollowerListNotFound
+	}
+	if err == nil {
+		l.Quarantined = true
+	}
+	return err
+}
+
 func (t *Table) GetLatestFollowerLists(ctx context.Context, userID string, limit int64) ([]*FollowerList, error) {
 	_, lists, err := t.GetUserAndLatestFollowerLists(ctx, userID, limit)
 	return lists, err
@@ -215,17 +419,348 @@ func (t *Table) GetLatestFollowerEvents(ctx context.Context, userID string, limi
 	return events, nil
 }
 
+// GetFollowerEventsBetween returns all events created within [from, to),
+// ordered from latest to oldest.
+func (t *Table) GetFollowerEventsBetween(ctx context.Context, userID string, from, to time.Time) ([]*FollowerEvent, error) {
+	e := FollowerEvent{UserID: userID}
+
+	var events []*FollowerEvent
+	err := t.inner.Get("PK", e.pk()).
+		Range("SK", dynamo.Between, eventSKAt(from), eventSKAt(to)).
+		Order(dynamo.Descending).
+		Consistent(t.consistentReads).
+		AllWithContext(ctx, &events)
+	if err != nil {
+		return nil, err
+	}
+
+	return between(events, from, to), nil
+}
+
+// UpdateFollowerEventReason only updates the reason of an event, e.g. once a
+// deactivated follower turned out to be deleted. It fails with
+// ErrFollowerEventNotFound if the event expired.
+func (t *Table) UpdateFollowerEventReason(ctx context.Context, e *FollowerEvent) error {
+	err := t.inner.Update("PK", e.pk()).Range("SK", e.sk()).
+		If("attribute_exists(PK)").
+		Set("FollowerStateReason", e.FollowerStateReason).
+		RunWithContext(ctx)
+	if isConditionalCheckErr(err) {
+		return ErrFollowerEventNotFound
+	}
+	return err
+}
+
+// GetFollowerChurn returns the churn of all followers lost or refollowed
+// recently.
+func (t *Table) GetFollowerChurn(ctx context.Context, userID string) ([]*FollowerChurn, error) {
+	c := FollowerChurn{UserID: userID}
+
+	var churn []*FollowerChurn
+	err := t.inner.Get("PK", c.pk()).
+		Range("SK", dynamo.BeginsWith, c.sk()).
+		Consistent(t.consistentReads).
+		AllWithContext(ctx, &churn)
+	if err != nil {
+		return nil, err
+	}
+
+	return churn, nil
+}
+
+func (t *Table) GetFollowerChurnByID(ctx context.Context, userID, followerID string) (*FollowerChurn, error) {
+	c := FollowerChurn{UserID: userID, FollowerID: followerID}
+	err := t.inner.Get("PK", c.pk()).
+		Range("SK", dynamo.Equal, c.sk()).
+		Consistent(t.consistentReads).
+		OneWithContext(ctx, &c)
+	if err != nil {
+		if errors.Is(err, dynamo.ErrNotFound) {
+			return nil, ErrFollowerChurnNotFound
+		}
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (t *Table) SaveFollowerChurn(ctx context.Context, c *FollowerChurn) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	return t.inner.Put(c.toItem()).RunWithContext(ctx)
+}
+
+func (t *Table) GetWebhookDelivery(ctx context.Context, userID, webhookID, id string) (*WebhookDelivery, error) {
+	d := WebhookDelivery{ID: id, UserID: userID, WebhookID: webhookID}
+	err := t.inner.Get("PK", d.pk()).
+		Range("SK", dynamo.Equal, d.sk()).
+		Consistent(t.consistentReads).
+		OneWithContext(ctx, &d)
+	if err != nil {
+		if errors.Is(err, dynamo.ErrNotFound) {
+			return nil, ErrWebhookDeliveryNotFound
+		}
+		return nil, err
+	}
+	return &d, nil
+}
+
+// SaveWebhookDelivery creates or updates a delivery, which is tried again
+// once its next attempt is due, see GetDueWebhookDeliveries.
+func (t *Table) SaveWebhookDelivery(ctx context.Context, d *WebhookDelivery) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	return t.inner.Put(d.toItem()).RunWithContext(ctx)
+}
+
+func (t *Table) GetLatestWebhookDeliveries(ctx context.Context, userID, webhookID string, limit int64) ([]*WebhookDelivery, error) {
+	d := WebhookDelivery{UserID: userID, WebhookID: webhookID}
+
+	var deliveries []*WebhookDelivery
+	err := t.inner.Get("PK", d.pk()).
+		Range("SK", dynamo.BeginsWith, d.sk()).
+		Limit(limit).
+		Order(dynamo.Descending).
+		Consistent(t.consistentReads).
+		AllWithContext(ctx, &deliveries)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// GetDueWebhookDeliveries returns the deliveries of all users whose next
+// attempt is due, the longest overdue first. It queries their own partition
+// of the sparse ScheduleIndex.
+func (t *Table) GetDueWebhookDeliveries(ctx context.Context, now time.Time) ([]*WebhookDelivery, error) {
+	var deliveries []*WebhookDelivery
+	err := t.inner.Get(scheduleIndex, retryKey).
+		Index(scheduleIndex).
+		Range("NextCheckAt", dynamo.LessOrEqual, now.UTC()).
+		AllWithContext(ctx, &deliveries)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (t *Table) GetNotificationDelivery(ctx context.Context, userID, eventID, channel string) (*NotificationDelivery, error) {
+	d := NotificationDelivery{UserID: userID, EventID: eventID, Channel: channel}
+	err := t.inner.Get("PK", d.pk()).
+		Range("SK", dynamo.Equal, d.sk()).
+		Consistent(t.consistentReads).
+		OneWithContext(ctx, &d)
+	if err != nil {
+		if errors.Is(err, dynamo.ErrNotFound) {
+			return nil, ErrNotificationNotFound
+		}
+		return nil, err
+	}
+	return &d, nil
+}
+
+// SaveNotificationDelivery creates or updates a delivery. It fails with
+// ErrNotificationConflict if the delivery was changed since it was read.
+func (t *Table) SaveNotificationDelivery(ctx context.Context, d *NotificationDelivery) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+
+	prev := d.Version
+	d.Version++
+
+	put := t.inner.Put(d.toItem())
+	if prev == 0 {
+		put = put.If("attribute_not_exists(PK)")
+	} else {
+		put = put.If("'Version' = ?", prev)
+	}
+
+	err := put.RunWithContext(ctx)
+	if err != nil {
+		d.Version = prev
+		if isConditionalCheckErr(err) {
+			return ErrNotificationConflict
+		}
+	}
+	return err
+}
+
+// GetDeadLetters returns the failed deliveries of a user, latest first.
+func (t *Table) GetDeadLetters(ctx context.Context, userID string, limit int64) ([]*NotificationDelivery, error) {
+	d := NotificationDelivery{UserID: userID}
+
+	var deliveries []*NotificationDelivery
+	err := t.inner.Get("PK", d.pk()).
+		Range("SK", dynamo.BeginsWith, notificationPrefix).
+		Filter("'Status' = ? AND attribute_not_exists('Alert')", DeliveryStatusFailed).
+		Limit(limit).
+		Order(dynamo.Descending).
+		Consistent(t.consistentReads).
+		AllWithContext(ctx, &deliveries)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// CreateDeferredEvent queues an event, or fails with ErrDeferredEventExists if
+// it was queued before.
+func (t *Table) CreateDeferredEvent(ctx context.Context, d *DeferredEvent) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	err := t.inner.Put(d.toItem()).If("attribute_not_exists(PK)").RunWithContext(ctx)
+	if isConditionalCheckErr(err) {
+		return ErrDeferredEventExists
+	}
+	return err
+}
+
+// GetDeferredEvents returns the held events of a user, oldest first.
+func (t *Table) GetDeferredEvents(ctx context.Context, userID string) ([]*DeferredEvent, error) {
+	d := DeferredEvent{UserID: userID}
+
+	var deferred []*DeferredEvent
+	err := t.inner.Get("PK", d.pk()).
+		Range("SK", dynamo.BeginsWith, d.sk()).
+		Consistent(t.consistentReads).
+		AllWithContext(ctx, &deferred)
+	if err != nil {
+		return nil, err
+	}
+
+	return deferred, nil
+}
+
+// GetAllDeferredEvents returns the held events of all users, oldest first. It
+// queries their own partition of the sparse ScheduleIndex rather than
+// scanning the whole table.
+func (t *Table) GetAllDeferredEvents(ctx context.Context) ([]*DeferredEvent, error) {
+	var deferred []*DeferredEvent
+	err := t.inner.Get(scheduleIndex, deferredKey).
+		Index(scheduleIndex).
+		AllWithContext(ctx, &deferred)
+	if err != nil {
+		return nil, err
+	}
+
+	return deferred, nil
+}
+
+func (t *Table) DeleteDeferredEvent(ctx context.Context, d *DeferredEvent) error {
+	return t.inner.Delete("PK", d.pk()).Range("SK", d.sk()).RunWithContext(ctx)
+}
+
+func (t *Table) GetRateBudget(ctx context.Context, name string) (*RateBudget, error) {
+	b := RateBudget{Name: name}
+	err := t.inner.Get("PK", b.pk()).
+		Range("SK", dynamo.Equal, b.sk()).
+		Consistent(t.consistentReads).
+		OneWithContext(ctx, &b)
+	if err != nil {
+		if errors.Is(err, dynamo.ErrNotFound) {
+			return nil, ErrRateBudgetNotFound
+		}
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (t *Table) GetRateBudgets(ctx context.Context) ([]*RateBudget, error) {
+	b := RateBudget{}
+
+	var budgets []*RateBudget
+	err := t.inner.Get("PK", b.pk()).
+		Range("SK", dynamo.BeginsWith, b.sk()).
+		Consistent(t.consistentReads).
+		AllWithContext(ctx, &budgets)
+	if err != nil {
+		return nil, err
+	}
+
+	return budgets, nil
+}
+
+// SaveRateBudgets stores budgets using optimistic locking, all of them or
+// none in a single transaction. It fails with ErrRateBudgetConflict if any
+// budget was changed since it was read.
+func (t *Table) SaveRateBudgets(ctx context.Context, budgets ...*RateBudget) error {
+	for _, b := range budgets {
+		if err := b.Validate(); err != nil {
+			return err
+		}
+	}
+
+	puts := make([]*dynamo.Put, len(budgets))
+	for i, b := range budgets {
+		put := t.inner.Put(b.toItem())
+		if b.Version == 0 {
+			put = put.If("attribute_not_exists(PK)")
+		} else {
+			put = put.If("'Version' = ?", b.Version)
+		}
+		puts[i] = put
+	}
+
+	for _, b := range budgets {
+		b.Version++
+	}
+
+	var err error
+	if len(puts) == 1 {
+		err = puts[0].RunWithContext(ctx)
+	} else {
+		tx := t.db.WriteTx()
+		for _, put := range puts {
+			tx = tx.Put(put)
+		}
+		err = tx.RunWithContext(ctx)
+	}
+	if err != nil {
+		for _, b := range budgets {
+			b.Version--
+		}
+		if isConditionalCheckErr(err) {
+			return ErrRateBudgetConflict
+		}
+	}
+	return err
+}
+
+// isConditionalCheckErr also reports transactions canceled by a failed
+// condition.
 func isConditionalCheckErr(err error) bool {
 	var ae awserr.RequestFailure
 
-	if errors.As(err, &ae) {
-		return ae.Code() == "ConditionalCheckFailedException"
+	if errors.As(err, &ae) && ae.Code() == "ConditionalCheckFailedException" {
+		return true
 	}
 
-	return false
+	return dynamo.IsCondCheckFailed(err)
 }
 
 var (
-	ErrUserNotFound         = errors.New("user not found")
-	ErrFollowerListNotFound = errors.New("follower list not found")
+	ErrUserNotFound            = errors.New("user not found")
+	ErrTrackingStatusLocked    = errors.New("tracking status can only be changed between ACTIVE and PAUSED")
+	ErrFollowerListNotFound    = errors.New("follower list not found")
+	ErrFollowerEventNotFound   = errors.New("follower event not found")
+	ErrRateBudgetNotFound      = errors.New("rate budget not found")
+	ErrRateBudgetConflict      = errors.New("rate budget was changed concurrently")
+	ErrNotificationNotFound    = errors.New("notification delivery not found")
+	ErrNotificationConflict    = errors.New("notification delivery was changed concurrently")
+	ErrNotificationNotFailed   = errors.New("only failed notification deliveries can be replayed")
+	ErrWebhookNotFound         = errors.New("webhook not found")
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+	ErrFollowerChurnNotFound   = errors.New("follower churn not found")
+	ErrDeferredEventExists     = errors.New("deferred event already exists")
+
+	ErrTelegramLinkCodeInvalid  = errors.New("telegram link code is invalid or expired")
+	ErrSlackInstallStateInvalid = errors.New("slack install state is invalid or expired")
+	ErrSlackThreadExists        = errors.New("slack thread of the run was already started")
+	ErrPushSubscriptionNotFound = errors.New("push subscription not found")
 )
@@ -0,0 +1,133 @@
+package difffollowers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+)
+
+const (
+	// minAnomalyChanges keeps small accounts from alerts about a handful of
+	// changes, which may well be a large share of their followers.
+	minAnomalyChanges = 10
+
+	// minAnomalyRuns is how many runs the trailing average must span before
+	// spikes are detected.
+	minAnomalyRuns = 5
+)
+
+// alerts returns the milestone and anomaly of a run, if the user enabled
+// alerts about them, and updates the user's stats accordingly. Stats are kept
+// even if alerts are disabled, so that enabling them won't alert about the
+// past.
+func alerts(user *data.User, runID string, previous, total int, events []*data.FollowerEvent,
+	now time.Time,
+) (*data.MilestoneEvent, *data.AnomalyEvent) {
+	var gained, lost int
+	for _, e := range events {
+		if e.FollowerState == data.FollowerStateNew {
+			gained++
+		} else {
+			lost++
+		}
+	}
+
+	var (
+		milestoneEvent *data.MilestoneEvent
+		anomalyEvent   *data.AnomalyEvent
+	)
+
+	if m := milestone(user, previous, total); m > 0 {
+		user.Stats.Milestone = m
+		if user.Alerts.Milestones {
+			milestoneEvent = &data.MilestoneEvent{
+				UserID:         user.ID,
+				RunID:          runID,
+				Milestone:      m,
+				TotalFollowers: total,
+				CreatedAt:      now,
+			}
+		}
+	}
+
+	if kind := anomaly(user, previous, gained, lost); kind != "" && user.Alerts.Anomalies {
+		anomalyEvent = &data.AnomalyEvent{
+			UserID:            user.ID,
+			RunID:             runID,
+			Kind:              kind,
+			PreviousFollowers: previous,
+			TotalFollowers:    total,
+			Gained:            gained,
+			Lost:              lost,
+			AvgChanges:        user.Stats.AvgChanges,
+			CreatedAt:         now,
+		}
+	}
+
+	user.Stats.AddRun(gained + lost)
+
+	return milestoneEvent, anomalyEvent
+}
+
+// sendAlerts saves the user's stats and publishes the alerts of a run. It is
+// called once the run's events are saved, as a retry would alert again.
+func (h *Handler) sendAlerts(ctx context.Context, user *data.User, milestoneEvent *data.MilestoneEvent,
+	anomalyEvent *data.AnomalyEvent,
+) error {
+	if err := h.Table.UpdateUserStats(ctx, user); err != nil {
+		return err
+	}
+
+	if milestoneEvent != nil {
+		log.Printf("milestone = %+v", milestoneEvent)
+		if err := h.EVB.Send(ctx, "Follower Milestone", milestoneEvent); err != nil {
+			return err
+		}
+	}
+	if anomalyEvent != nil {
+		log.Printf("anomaly = %+v", anomalyEvent)
+		if err := h.EVB.Send(ctx, "Follower Anomaly", anomalyEvent); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// milestone returns the highest milestone the follower count passed in this
+// run, unless the user reached it before.
+func milestone(user *data.User, previous, total int) int {
+	var reached int
+	for _, m := range user.Alerts.AllMilestones() {
+		if m > previous && m <= total && m > user.Stats.Milestone {
+			reached = m
+		}
+	}
+	return reached
+}
+
+// anomaly returns the kind of anomaly of a run, if any. A mass loss takes
+// precedence over a spike.
+func anomaly(user *data.User, previous, gained, lost int) string {
+	lossPercent := user.Alerts.LossPercent
+	if lossPercent == 0 {
+		lossPercent = data.DefaultLossPercent
+	}
+	if lost >= minAnomalyChanges && lost*100 > lossPercent*previous {
+		return data.AnomalyKindLoss
+	}
+
+	spikeFactor := user.Alerts.SpikeFactor
+	if spikeFactor == 0 {
+		spikeFactor = data.DefaultSpikeFactor
+	}
+	changes := gained + lost
+	if user.Stats.Runs >= minAnomalyRuns && changes >= minAnomalyChanges &&
+		float64(changes) > float64(spikeFactor)*user.Stats.AvgChanges {
+		return data.AnomalyKindSpike
+	}
+
+	return ""
+}
@@ -0,0 +1,62 @@
+package difffollowers
+
+import (
+	"testing"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+)
+
+func TestMilestone(t *testing.T) {
+	tests := []struct {
+		previous, total int
+		custom          []int
+		reached         int
+		want            int
+	}{
+		{previous: 990, total: 1002, want: 1000},
+		{previous: 1000, total: 1002, want: 0},
+		{previous: 999, total: 1000, want: 1000},
+		{previous: 999, total: 1000, reached: 1000, want: 0}, // lost and regained
+		{previous: 900, total: 5500, want: 5000},
+		{previous: 1200, total: 1300, custom: []int{1250}, want: 1250},
+		{previous: 1300, total: 1200, custom: []int{1250}, want: 0},
+	}
+
+	for _, test := range tests {
+		user := &data.User{
+			Alerts: data.AlertConfig{Milestones: true, CustomMilestones: test.custom},
+			Stats:  data.FollowerStats{Milestone: test.reached},
+		}
+		if got := milestone(user, test.previous, test.total); got != test.want {
+			t.Errorf("%+v: got %d", test, got)
+		}
+	}
+}
+
+func TestAnomaly(t *testing.T) {
+	usual := data.FollowerStats{AvgChanges: 3, Runs: 10}
+
+	tests := []struct {
+		alerts       data.AlertConfig
+		stats        data.FollowerStats
+		previous     int
+		gained, lost int
+		want         string
+	}{
+		{previous: 1000, lost: 60, stats: usual, want: data.AnomalyKindLoss},
+		{previous: 1000, lost: 50, stats: usual, want: data.AnomalyKindSpike}, // 5% isn't more than 5%
+		{previous: 1000, lost: 20, alerts: data.AlertConfig{LossPercent: 1}, want: data.AnomalyKindLoss},
+		{previous: 100, lost: 9, want: ""}, // too few changes
+		{previous: 1000, gained: 16, stats: usual, want: data.AnomalyKindSpike},
+		{previous: 1000, gained: 15, stats: usual, want: ""},
+		{previous: 1000, gained: 15, stats: usual, alerts: data.AlertConfig{SpikeFactor: 4}, want: data.AnomalyKindSpike},
+		{previous: 1000, gained: 50, stats: data.FollowerStats{AvgChanges: 1, Runs: 4}, want: ""}, // too few runs
+	}
+
+	for _, test := range tests {
+		user := &data.User{Alerts: test.alerts, Stats: test.stats}
+		if got := anomaly(user, test.previous, test.gained, test.lost); got != test.want {
+			t.Errorf("%+v: got %q, want %q", test, got, test.want)
+		}
+	}
+}
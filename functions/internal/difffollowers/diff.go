@@ -1,4 +1,4 @@
-package main
+package difffollowers
 
 import (
 	"sort"
@@ -6,8 +6,9 @@ import (
 	mapset "github.com/deckarep/golang-set"
 )
 
-//nolint:forcetypeassert
 // diffInt64Slices compares two int64 slices and returns any differences between them.
+//
+//nolint:forcetypeassert
 func diffInt64Slices(x, y []int64) (eq bool, xd, yd []int64) {
 	xs := mapset.NewSet()
 	for _, i := range x {
@@ -0,0 +1,68 @@
+package difffollowers
+
+import (
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+)
+
+const (
+	// numListsToFetch is the latest follower list plus older ones to compare
+	// it with, in case some of them were quarantined.
+	numListsToFetch = 5
+
+	// minImplausibleLoss keeps small accounts from being quarantined for
+	// losing a handful of followers.
+	minImplausibleLoss = 20
+
+	// maxPlausibleLossPercent is the share of followers that can be lost in
+	// one run if there are no profile counts to tell otherwise.
+	maxPlausibleLossPercent = 50
+)
+
+// baseline returns the most recent list that is trusted, if any.
+func baseline(lists []*data.FollowerList) *data.FollowerList {
+	for _, l := range lists {
+		if l.Trusted() {
+			return l
+		}
+	}
+	return nil
+}
+
+// implausibleLoss reports whether a diff lost more followers than can be
+// true, most likely because the follower API returned too few IDs. A real
+// mass unfollow also shows in the followers_count of the profile.
+func implausibleLoss(previous, current *data.FollowerList, gained, lost int) bool {
+	if lost < minImplausibleLoss {
+		return false
+	}
+	if previous.ProfileFollowers > 0 && current.ProfileFollowers > 0 {
+		drop := previous.ProfileFollowers - current.ProfileFollowers
+		if drop < 0 {
+			drop = 0
+		}
+		return lost-gained > 2*drop+data.FollowerCountTolerance(previous.ProfileFollowers)
+	}
+	return lost*100 > maxPlausibleLossPercent*previous.TotalFollowers
+}
+
+// confirmed reports whether the list has the same followers as the one
+// before, which was quarantined. An implausible loss that persists is real.
+func confirmed(before, current *data.FollowerList) bool {
+	return before.Quarantined && before.S3Key == current.S3Key
+}
+
+// window returns ids up to the last one also in other. Followers are listed
+// most recent first, so if other was truncated, the IDs after that are
+// beyond its window, which makes them unknown rather than gone.
+func window(ids, other []int64) []int64 {
+	in := make(map[int64]bool, len(other))
+	for _, id := range other {
+		in[id] = true
+	}
+	for i := len(ids) - 1; i >= 0; i-- {
+		if in[ids[i]] {
+			return ids[:i+1]
+		}
+	}
+	return nil
+}
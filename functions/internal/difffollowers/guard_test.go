@@ -0,0 +1,100 @@
+package difffollowers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+)
+
+func TestWindow(t *testing.T) {
+	tests := []struct {
+		ids   []int64
+		other []int64
+		want  []int64
+	}{
+		{ids: nil, other: nil, want: nil},
+		{ids: []int64{1, 2, 3}, other: nil, want: nil},
+		{ids: []int64{1, 2, 3}, other: []int64{3}, want: []int64{1, 2, 3}},
+		{ids: []int64{1, 2, 3, 4}, other: []int64{5, 2}, want: []int64{1, 2}},
+	}
+
+	for _, tt := range tests {
+		if diff := cmp.Diff(tt.want, window(tt.ids, tt.other)); diff != "" {
+			t.Errorf("window(%v, %v): %s", tt.ids, tt.other, diff)
+		}
+	}
+}
+
+func TestImplausibleLoss(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous data.FollowerList
+		current  data.FollowerList
+		gained   int
+		lost     int
+		want     bool
+	}{
+		{
+			name:     "few lost",
+			previous: data.FollowerList{TotalFollowers: 20},
+			lost:     19,
+			want:     false,
+		},
+		{
+			name:     "half lost without profile counts",
+			previous: data.FollowerList{TotalFollowers: 100},
+			lost:     50,
+			want:     false,
+		},
+		{
+			name:     "most lost without profile counts",
+			previous: data.FollowerList{TotalFollowers: 100},
+			lost:     51,
+			want:     true,
+		},
+		{
+			name:     "loss matches profile counts",
+			previous: data.FollowerList{ProfileFollowers: 1000},
+			current:  data.FollowerList{ProfileFollowers: 900},
+			lost:     100,
+			want:     false,
+		},
+		{
+			name:     "loss exceeds profile counts",
+			previous: data.FollowerList{ProfileFollowers: 1000},
+			current:  data.FollowerList{ProfileFollowers: 1000},
+			lost:     100,
+			want:     true,
+		},
+		{
+			name:     "loss offset by gains",
+			previous: data.FollowerList{ProfileFollowers: 1000},
+			current:  data.FollowerList{ProfileFollowers: 1000},
+			gained:   95,
+			lost:     100,
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := implausibleLoss(&tt.previous, &tt.current, tt.gained, tt.lost); got != tt.want {
+			t.Errorf("%s: implausibleLoss = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	lists := []*data.FollowerList{
+		{S3Key: "a", Quarantined: true},
+		{S3Key: "b", TotalFollowers: 10, ProfileFollowers: 100},
+		{S3Key: "c"},
+	}
+	if got := baseline(lists); got == nil || got.S3Key != "c" {
+		t.Errorf("baseline = %+v, want c", got)
+	}
+	if got := baseline(lists[:2]); got != nil {
+		t.Errorf("baseline = %+v, want nil", got)
+	}
+}
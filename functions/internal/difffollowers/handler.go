@@ -0,0 +1,359 @@
+package difffollowers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"github.com/segmentio/ksuid"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/budget"
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/evb"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+const numListsToCompare = 2
+
+type Input struct {
+	UserID string
+}
+
+type Output struct {
+	Events      []*data.FollowerEvent `json:",omitempty"` //nolint:tagliatelle
+	Quarantined bool                  `json:",omitempty"` //nolint:tagliatelle
+	Milestone   *data.MilestoneEvent  `json:",omitempty"` //nolint:tagliatelle
+	Anomaly     *data.AnomalyEvent    `json:",omitempty"` //nolint:tagliatelle
+}
+
+type Handler struct {
+	Table        data.TableAPI
+	EventTTL     time.Duration
+	EVB          evb.API
+	S3Downloader s3manageriface.DownloaderAPI
+	Twitter      twitter.API
+}
+
+//nolint:cyclop,gocognit
+func (h *Handler) Handle(ctx context.Context, in Input) (*Output, error) {
+	if in.UserID == "" {
+		return nil, errors.New("user ID must be passed as input")
+	}
+
+	log.SetPrefix(in.UserID + " ")
+	log.Printf("input = %+v", in)
+
+	user, followerLists, err := h.Table.GetUserAndLatestFollowerLists(ctx, in.UserID, numListsToFetch)
+	if err != nil {
+		return nil, err
+	}
+	if len(followerLists) < numListsToCompare {
+		log.Print("too few follower lists, skipping diff")
+		return &Output{}, nil
+	}
+
+	// Compare the latest list with the latest one that wasn't quarantined
+	current, previous := followerLists[0], baseline(followerLists[1:])
+	if previous == nil {
+		log.Print("no trusted follower list to compare with, skipping diff")
+		return &Output{}, nil
+	}
+	lists := [numListsToCompare]*data.FollowerList{current, previous}
+
+	// Only download and compare follower lists if the key (content hash) has changed
+	changed := current.S3Key != previous.S3Key
+
+	// Check active accounts more often than those that rarely change
+	user.ScheduleNextCheck(changed, time.Now())
+	if err := h.Table.UpdateUserSchedule(ctx, user); err != nil {
+		return nil, err
+	}
+	log.Printf("next check at %s (interval %s)", user.NextCheckAt, user.CheckInterval)
+
+	if !changed {
+		log.Print("follower lists did not change")
+		// Deactivated followers are due to be re-checked even if nothing changed
+		if err := h.recheckChurn(ctx, user); err != nil {
+			return nil, err
+		}
+		return &Output{}, nil
+	}
+
+	if current.Incomplete() {
+		log.Printf("follower list has %d of %d followers", current.TotalFollowers, current.ProfileFollowers)
+		return h.quarantine(ctx, current)
+	}
+
+	var followerIDs [numListsToCompare][]int64
+
+	for i := 0; i < numListsToCompare; i++ {
+		var buf aws.WriteAtBuffer
+
+		_, err := h.S3Downloader.DownloadWithContext(ctx, &buf, &s3.GetObjectInput{
+			Bucket: aws.String(lists[i].S3Bucket),
+			Key:    aws.String(lists[i].S3Key),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var ids []int64
+		if err := json.Unmarshal(buf.Bytes(), &ids); err != nil {
+			return nil, err
+		}
+
+		followerIDs[i] = ids
+	}
+
+	// Only compare followers within the window of truncated lists
+	currentIDs, previousIDs := followerIDs[0], followerIDs[1]
+	if current.Truncated {
+		previousIDs = window(previousIDs, followerIDs[0])
+	}
+	if previous.Truncated {
+		currentIDs = window(currentIDs, followerIDs[1])
+	}
+
+	_, lostFollowers, newFollowers := diffInt64Slices(previousIDs, currentIDs)
+
+	if implausibleLoss(previous, current, len(newFollowers), len(lostFollowers)) {
+		if !confirmed(followerLists[1], current) {
+			log.Printf("implausible loss of %d followers (%d before)", len(lostFollowers), previous.TotalFollowers)
+			return h.quarantine(ctx, current)
+		}
+		log.Printf("loss of %d followers confirmed by the previous list", len(lostFollowers))
+	}
+
+	ctx = budget.WithUser(ctx, user.ID)
+
+	churn, err := h.followerChurn(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		totalFollowers = current.TotalFollowers
+		runID          = ksuid.New()
+		seq            = ksuid.Sequence{Seed: runID}
+		now            = time.Now()
+		changedChurn   = map[string]*data.FollowerChurn{}
+		lookup         = &profiles{twitter: h.Twitter, user: user}
+	)
+
+	events := make([]*data.FollowerEvent, 0, len(newFollowers)+len(lostFollowers))
+
+	for _, id := range newFollowers {
+		follower, err := lookup.userByID(ctx, id)
+		switch {
+		case err == nil:
+		case errors.Is(err, twitter.ErrUserNotFound) || errors.Is(err, twitter.ErrUserSuspended):
+			// Ignore new follower gone in the meantime
+			continue
+		case rateLimited(err):
+			follower = idOnly(id)
+		default:
+			return nil, err
+		}
+
+		if user.IgnoresFollower(follower.ID, follower.Handle) {
+			log.Printf("ignoring new follower: %+v", follower)
+			continue
+		}
+
+		reason, refollows := data.FollowerStateReasonFollowed, 0
+
+		c := churn[strconv.FormatInt(id, 10)] //nolint:gomnd
+		if c != nil && !c.RecheckAt.IsZero() {
+			// Deactivated when lost, but reactivated in time
+			c.Rechecked(now)
+			changedChurn[c.FollowerID] = c
+		}
+
+		// Refollows are counted even if they aren't merged
+		if c != nil && c.Refollowed(user.RefollowWindow(), now) {
+			changedChurn[c.FollowerID] = c
+			if user.Refollow.Enabled {
+				if user.Refollow.Suppress {
+					log.Printf("suppressing refollow #%d: %+v", c.Refollows, follower)
+					continue
+				}
+				reason, refollows = data.FollowerStateReasonRefollowed, c.Refollows
+			}
+		}
+
+		eid, _ := seq.Next()
+		events = append(events, &data.FollowerEvent{
+			ID:                  eid.String(),
+			UserID:              user.ID,
+			RunID:               runID.String(),
+			TotalFollowers:      totalFollowers,
+			Follower:            follower,
+			FollowerState:       data.FollowerStateNew,
+			FollowerStateReason: reason,
+			Refollows:           refollows,
+			CreatedAt:           eid.Time(),
+			ExpiresAt:           eid.Time().Add(h.EventTTL),
+		})
+	}
+
+	for _, id := range lostFollowers {
+		follower, reason, err := lostFollower(ctx, lookup, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if user.IgnoresFollower(follower.ID, follower.Handle) {
+			log.Printf("ignoring lost follower: %+v", follower)
+			continue
+		}
+
+		fid := strconv.FormatInt(id, 10) //nolint:gomnd
+		c := churn[fid]
+		if c == nil {
+			c = &data.FollowerChurn{UserID: user.ID, FollowerID: fid}
+		}
+		if reason == data.FollowerStateReasonUnfollowed {
+			reason = unfollowReason(ctx, lookup, id, follower, c, now)
+		}
+
+		eid, _ := seq.Next()
+
+		c.Lost(now)
+		if reason == data.FollowerStateReasonDeactivated {
+			c.Deactivated(eid.String(), now)
+		}
+		changedChurn[c.FollowerID] = c
+
+		events = append(events, &data.FollowerEvent{
+			ID:                  eid.String(),
+			UserID:              user.ID,
+			RunID:               runID.String(),
+			TotalFollowers:      totalFollowers,
+			Follower:            follower,
+			FollowerState:       data.FollowerStateLost,
+			FollowerStateReason: reason,
+			CreatedAt:           eid.Time(),
+			ExpiresAt:           eid.Time().Add(h.EventTTL),
+		})
+	}
+
+	deleted, err := recheckDeactivated(ctx, lookup, churn, changedChurn, now)
+	if err != nil {
+		return nil, err
+	}
+
+	out := Output{
+		Events: events,
+	}
+
+	out.Milestone, out.Anomaly = alerts(user, runID.String(), previous.TotalFollowers, totalFollowers, events, now)
+
+	log.Printf("output = %+v", out)
+
+	if err := h.markDeleted(ctx, deleted); err != nil {
+		return nil, err
+	}
+
+	for _, c := range changedChurn {
+		if err := h.Table.SaveFollowerChurn(ctx, c); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, e := range out.Events {
+		if err := h.Table.CreateFollowerEvent(ctx, e); err != nil {
+			return nil, err
+		}
+		if err := h.EVB.Send(ctx, "Twitter Follower Change", e); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := h.sendAlerts(ctx, user, out.Milestone, out.Anomaly); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+// recheckChurn re-checks deactivated followers outside of a diff, e.g. for
+// accounts whose followers rarely change.
+func (h *Handler) recheckChurn(ctx context.Context, user *data.User) error {
+	ctx = budget.WithUser(ctx, user.ID)
+
+	churn, err := h.followerChurn(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	changed := map[string]*data.FollowerChurn{}
+	lookup := &profiles{twitter: h.Twitter, user: user}
+	deleted, err := recheckDeactivated(ctx, lookup, churn, changed, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if err := h.markDeleted(ctx, deleted); err != nil {
+		return err
+	}
+	for _, c := range changed {
+		if err := h.Table.SaveFollowerChurn(ctx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markDeleted corrects the events that reported deleted followers as
+// deactivated, rather than reporting them as lost twice.
+func (h *Handler) markDeleted(ctx context.Context, deleted []*data.FollowerChurn) error {
+	for _, c := range deleted {
+		log.Printf("deactivated follower %s was deleted", c.FollowerID)
+		if c.EventID == "" {
+			continue
+		}
+		e := &data.FollowerEvent{
+			ID:                  c.EventID,
+			UserID:              c.UserID,
+			FollowerStateReason: data.FollowerStateReasonDeleted,
+		}
+		err := h.Table.UpdateFollowerEventReason(ctx, e)
+		if errors.Is(err, data.ErrFollowerEventNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quarantine keeps the list from being compared with and from producing
+// events. The next list is compared with the previous one instead.
+func (h *Handler) quarantine(ctx context.Context, list *data.FollowerList) (*Output, error) {
+	log.Printf("quarantining follower list %s", list.S3Key)
+	if err := h.Table.QuarantineFollowerList(ctx, list); err != nil {
+		return nil, err
+	}
+	return &Output{Quarantined: true}, nil
+}
+
+// followerChurn returns the churn of recently lost or refollowed followers
+// by their ID.
+func (h *Handler) followerChurn(ctx context.Context, userID string) (map[string]*data.FollowerChurn, error) {
+	churn, err := h.Table.GetFollowerChurn(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]*data.FollowerChurn, len(churn))
+	for _, c := range churn {
+		m[c.FollowerID] = c
+	}
+	return m, nil
+}
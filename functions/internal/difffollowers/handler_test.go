@@ -0,0 +1,1017 @@
+package difffollowers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/budget"
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/evb"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+var ignoreFollowerEventFields = cmpopts.IgnoreFields(data.FollowerEvent{}, "ID", "RunID", "CreatedAt", "ExpiresAt")
+
+type tableStub struct {
+	data.TableAPI
+
+	lists           []*data.FollowerList
+	ignoreFollowers []string
+	alerts          data.AlertConfig
+	refollow        data.RefollowConfig
+	churn           []*data.FollowerChurn
+	stats           data.FollowerStats
+	checkInterval   time.Duration
+	quarantined     []string
+	updatedEvents   map[string]string
+	createErr       error
+}
+
+func (t *tableStub) GetUser(ctx context.Context, userID string) (*data.User, error) {
+	user := data.User{
+		ID:              userID,
+		IgnoreFollowers: t.ignoreFollowers,
+		Alerts:          t.alerts,
+		Refollow:        t.refollow,
+		Stats:           t.stats,
+	}
+	return &user, nil
+}
+
+func (t *tableStub) GetUserAndLatestFollowerLists(ctx context.Context, userID string, limit int64) (*data.User, []*data.FollowerList, error) {
+	user, _ := t.GetUser(ctx, userID)
+	return user, t.lists, nil
+}
+
+func (t *tableStub) UpdateUserSchedule(ctx context.Context, u *data.User) error {
+	t.checkInterval = u.CheckInterval
+	return nil
+}
+
+func (t *tableStub) UpdateUserStats(ctx context.Context, u *data.User) error {
+	t.stats = u.Stats
+	return nil
+}
+
+func (t *tableStub) QuarantineFollowerList(ctx context.Context, l *data.FollowerList) error {
+	t.quarantined = append(t.quarantined, l.S3Key)
+	return nil
+}
+
+func (t *tableStub) GetFollowerChurn(ctx context.Context, userID string) ([]*data.FollowerChurn, error) {
+	return t.churn, nil
+}
+
+func (t *tableStub) SaveFollowerChurn(ctx context.Context, c *data.FollowerChurn) error {
+	for i, old := range t.churn {
+		if old.FollowerID == c.FollowerID {
+			t.churn[i] = c
+			return nil
+		}
+	}
+	t.churn = append(t.churn, c)
+	return nil
+}
+
+func (t *tableStub) CreateFollowerEvent(ctx context.Context, e *data.FollowerEvent) error {
+	return t.createErr
+}
+
+func (t *tableStub) UpdateFollowerEventReason(ctx context.Context, e *data.FollowerEvent) error {
+	if t.updatedEvents == nil {
+		t.updatedEvents = map[string]string{}
+	}
+	t.updatedEvents[e.ID] = e.FollowerStateReason
+	return nil
+}
+
+type s3DownloaderStub struct {
+	s3manageriface.DownloaderAPI
+
+	followerIDs map[string][]int64
+}
+
+func (d *s3DownloaderStub) DownloadWithContext(ctx context.Context, w io.WriterAt, input *s3.GetObjectInput, f ...func(*s3manager.Downloader)) (int64, error) {
+	buf, err := json.Marshal(d.followerIDs[*input.Key])
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.WriteAt(buf, 0)
+	return int64(n), err
+}
+
+type evbStub struct {
+	evb.API
+
+	sent []string
+}
+
+func (e *evbStub) Send(ctx context.Context, eventType string, events ...interface{}) error {
+	e.sent = append(e.sent, eventType)
+	return nil
+}
+
+type twitterStub struct {
+	twitter.API
+
+	users         map[int64]*twitter.User
+	errors        map[int64]error
+	relationships map[int64]*twitter.Relationship
+	relErrors     map[int64]error
+	lookups       int
+}
+
+func (t *twitterStub) UserByID(ctx context.Context, accessToken, accessSecret string, userID int64) (*twitter.User, error) {
+	t.lookups++
+	if e, ok := t.errors[userID]; ok {
+		return nil, e
+	}
+	if u, ok := t.users[userID]; ok {
+		return u, nil
+	}
+	return nil, data.ErrUserNotFound
+}
+
+func (t *twitterStub) Relationship(ctx context.Context, accessToken, accessSecret string, userID int64) (*twitter.Relationship, error) {
+	if e, ok := t.relErrors[userID]; ok {
+		return nil, e
+	}
+	if r, ok := t.relationships[userID]; ok {
+		return r, nil
+	}
+	return &twitter.Relationship{}, nil
+}
+
+func TestNoChanges(t *testing.T) {
+	h := Handler{
+		Table: &tableStub{
+			lists: []*data.FollowerList{
+				{S3Key: "/some/path"},
+				{S3Key: "/some/path"},
+			},
+		},
+	}
+
+	want := &Output{}
+
+	got, err := h.Handle(context.Background(), Input{UserID: "000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+
+	if got, want := h.Table.(*tableStub).checkInterval, 90*time.Minute; got != want {
+		t.Errorf("check interval = %s, want %s", got, want)
+	}
+}
+
+func TestNewFollower(t *testing.T) {
+	h := Handler{
+		Table: &tableStub{
+			lists: []*data.FollowerList{
+				{S3Key: "/new/path", TotalFollowers: 2},
+				{S3Key: "/old/path"},
+			},
+		},
+		S3Downloader: &s3DownloaderStub{
+			followerIDs: map[string][]int64{
+				"/new/path": {111, 222},
+				"/old/path": {111},
+			},
+		},
+		EVB: &evbStub{},
+		Twitter: &twitterStub{
+			users: map[int64]*twitter.User{
+				222: {Handle: "bob"},
+			},
+		},
+	}
+
+	want := &Output{
+		Events: []*data.FollowerEvent{
+			{
+				UserID:              "000",
+				TotalFollowers:      2,
+				Follower:            &twitter.User{Handle: "bob"},
+				FollowerState:       data.FollowerStateNew,
+				FollowerStateReason: data.FollowerStateReasonFollowed,
+			},
+		},
+	}
+
+	got, err := h.Handle(context.Background(), Input{UserID: "000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got, ignoreFollowerEventFields); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestLostFollower(t *testing.T) {
+	table := &tableStub{
+		lists: []*data.FollowerList{
+			{S3Key: "/new/path", TotalFollowers: 1},
+			{S3Key: "/old/path"},
+		},
+	}
+	h := Handler{
+		Table: table,
+		S3Downloader: &s3DownloaderStub{
+			followerIDs: map[string][]int64{
+				"/new/path": {111},
+				"/old/path": {111, 222, 333, 444},
+			},
+		},
+		EVB: &evbStub{},
+		Twitter: &twitterStub{
+			users: map[int64]*twitter.User{
+				222: {Handle: "bob"},
+			},
+			errors: map[int64]error{
+				333: twitter.ErrUserNotFound,
+				444: twitter.ErrUserSuspended,
+			},
+		},
+	}
+
+	want := &Output{
+		Events: []*data.FollowerEvent{
+			{
+				UserID:              "000",
+				TotalFollowers:      1,
+				Follower:            &twitter.User{Handle: "bob"},
+				FollowerState:       data.FollowerStateLost,
+				FollowerStateReason: data.FollowerStateReasonUnfollowed,
+			},
+			{
+				UserID:              "000",
+				TotalFollowers:      1,
+				Follower:            &twitter.User{ID: "333"},
+				FollowerState:       data.FollowerStateLost,
+				FollowerStateReason: data.FollowerStateReasonDeactivated,
+			},
+			{
+				UserID:              "000",
+				TotalFollowers:      1,
+				Follower:            &twitter.User{ID: "444"},
+				FollowerState:       data.FollowerStateLost,
+				FollowerStateReason: data.FollowerStateReasonSuspended,
+			},
+		},
+	}
+
+	got, err := h.Handle(context.Background(), Input{UserID: "000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got, ignoreFollowerEventFields); diff != "" {
+		t.Error(diff)
+	}
+
+	// The event is corrected if the deactivated follower turns out to be deleted
+	for _, c := range table.churn {
+		if c.FollowerID == "333" && c.EventID != got.Events[1].ID {
+			t.Errorf("churn refers to event %q, want %q", c.EventID, got.Events[1].ID)
+		}
+	}
+}
+
+func TestNewAndLostFollower(t *testing.T) {
+	h := Handler{
+		Table: &tableStub{
+			lists: []*data.FollowerList{
+				{S3Key: "/new/path", TotalFollowers: 2},
+				{S3Key: "/old/path"},
+			},
+		},
+		S3Downloader: &s3DownloaderStub{
+			followerIDs: map[string][]int64{
+				"/new/path": {111, 222},
+				"/old/path": {111, 333},
+			},
+		},
+		EVB: &evbStub{},
+		Twitter: &twitterStub{
+			users: map[int64]*twitter.User{
+				222: {Handle: "bob"},
+				333: {Handle: "carlos"},
+			},
+		},
+	}
+
+	want := &Output{
+		Events: []*data.FollowerEvent{
+			{
+				UserID:              "000",
+				TotalFollowers:      2,
+				Follower:            &twitter.User{Handle: "bob"},
+				FollowerState:       data.FollowerStateNew,
+				FollowerStateReason: data.FollowerStateReasonFollowed,
+			},
+			{
+				UserID:              "000",
+				TotalFollowers:      2,
+				Follower:            &twitter.User{Handle: "carlos"},
+				FollowerState:       data.FollowerStateLost,
+				FollowerStateReason: data.FollowerStateReasonUnfollowed,
+			},
+		},
+	}
+
+	got, err := h.Handle(context.Background(), Input{UserID: "000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got, ignoreFollowerEventFields); diff != "" {
+		t.Error(diff)
+	}
+	if runID := got.Events[0].RunID; runID == "" || got.Events[1].RunID != runID {
+		t.Errorf("events not from the same run: %q, %q", runID, got.Events[1].RunID)
+	}
+}
+
+func TestIgnoreFollowers(t *testing.T) {
+	h := Handler{
+		Table: &tableStub{
+			lists: []*data.FollowerList{
+				{S3Key: "/new/path", TotalFollowers: 2},
+				{S3Key: "/old/path"},
+			},
+			ignoreFollowers: []string{"111", "carlos", "@dan"},
+		},
+		S3Downloader: &s3DownloaderStub{
+			followerIDs: map[string][]int64{
+				"/new/path": {111, 222},
+				"/old/path": {333, 444},
+			},
+		},
+		EVB: &evbStub{},
+		Twitter: &twitterStub{
+			users: map[int64]*twitter.User{
+				111: {ID: "111"},
+				222: {ID: "222"},
+				333: {Handle: "carlos"},
+				444: {Handle: "dan"},
+			},
+		},
+	}
+
+	want := &Output{
+		Events: []*data.FollowerEvent{
+			{
+				UserID:              "000",
+				TotalFollowers:      2,
+				Follower:            &twitter.User{ID: "222"},
+				FollowerState:       data.FollowerStateNew,
+				FollowerStateReason: data.FollowerStateReasonFollowed,
+			},
+		},
+	}
+
+	got, err := h.Handle(context.Background(), Input{UserID: "000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got, ignoreFollowerEventFields); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestAlerts(t *testing.T) {
+	var (
+		old   []int64
+		users = map[int64]*twitter.User{}
+	)
+	for id := int64(1); id <= 1010; id++ {
+		old = append(old, id)
+		users[id] = &twitter.User{Handle: "user"}
+	}
+	// 12 of 990 followers unfollowed and 20 followed, passing 1000
+	current := append(append([]int64(nil), old[12:990]...), old[990:]...)
+
+	var (
+		table = &tableStub{
+			lists: []*data.FollowerList{
+				{S3Key: "/new/path", TotalFollowers: 1000},
+				{S3Key: "/old/path", TotalFollowers: 990},
+			},
+			alerts: data.AlertConfig{Milestones: true, Anomalies: true, LossPercent: 1},
+			stats:  data.FollowerStats{AvgChanges: 2, Runs: 10},
+		}
+		bus = &evbStub{}
+		h   = Handler{
+			Table: table,
+			S3Downloader: &s3DownloaderStub{
+				followerIDs: map[string][]int64{
+					"/new/path": current,
+					"/old/path": old[:990],
+				},
+			},
+			EVB:     bus,
+			Twitter: &twitterStub{users: users},
+		}
+	)
+
+	got, err := h.Handle(context.Background(), Input{UserID: "000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Milestone == nil || got.Milestone.Milestone != 1000 {
+		t.Errorf("expected milestone 1000, got %+v", got.Milestone)
+	}
+	if got.Anomaly == nil || got.Anomaly.Kind != data.AnomalyKindLoss || got.Anomaly.Lost != 12 || got.Anomaly.Gained != 20 {
+		t.Errorf("expected loss anomaly, got %+v", got.Anomaly)
+	}
+	if diff := cmp.Diff([]string{"Follower Milestone", "Follower Anomaly"}, bus.sent[len(bus.sent)-2:]); diff != "" {
+		t.Error(diff)
+	}
+
+	want := data.FollowerStats{Milestone: 1000, AvgChanges: 2 + (32-2)/11.0, Runs: 11}
+	if diff := cmp.Diff(want, table.stats, cmpopts.EquateApprox(0, 1e-9)); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestAlertsAfterEventsSaved(t *testing.T) {
+	var (
+		old   []int64
+		users = map[int64]*twitter.User{}
+	)
+	for id := int64(1); id <= 1010; id++ {
+		old = append(old, id)
+		users[id] = &twitter.User{Handle: "user"}
+	}
+
+	var (
+		table = &tableStub{
+			lists: []*data.FollowerList{
+				{S3Key: "/new/path", TotalFollowers: 1010},
+				{S3Key: "/old/path", TotalFollowers: 990},
+			},
+			alerts:    data.AlertConfig{Milestones: true},
+			createErr: errors.New("some error"),
+		}
+		bus = &evbStub{}
+		h   = Handler{
+			Table: table,
+			S3Downloader: &s3DownloaderStub{
+				followerIDs: map[string][]int64{
+					"/new/path": old,
+					"/old/path": old[:990],
+				},
+			},
+			EVB:     bus,
+			Twitter: &twitterStub{users: users},
+		}
+	)
+
+	if _, err := h.Handle(context.Background(), Input{UserID: "000"}); !errors.Is(err, table.createErr) {
+		t.Fatalf("expected error saving events, got %v", err)
+	}
+
+	// A retry must still alert about the milestone
+	if diff := cmp.Diff([]string(nil), bus.sent); diff != "" {
+		t.Error(diff)
+	}
+	if diff := cmp.Diff(data.FollowerStats{}, table.stats); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func ids(from, n int64) []int64 {
+	var s []int64
+	for id := from; id < from+n; id++ {
+		s = append(s, id)
+	}
+	return s
+}
+
+func TestQuarantineIncompleteList(t *testing.T) {
+	table := &tableStub{
+		lists: []*data.FollowerList{
+			{S3Key: "/new/path", TotalFollowers: 100, ProfileFollowers: 500},
+			{S3Key: "/old/path", TotalFollowers: 500, ProfileFollowers: 500},
+		},
+	}
+	h := Handler{Table: table}
+
+	got, err := h.Handle(context.Background(), Input{UserID: "000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(&Output{Quarantined: true}, got); diff != "" {
+		t.Error(diff)
+	}
+	if diff := cmp.Diff([]string{"/new/path"}, table.quarantined); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestQuarantineImplausibleLoss(t *testing.T) {
+	table := &tableStub{
+		lists: []*data.FollowerList{
+			{S3Key: "/new/path", TotalFollowers: 40},
+			{S3Key: "/old/path", TotalFollowers: 100},
+		},
+	}
+	h := Handler{
+		Table: table,
+		S3Downloader: &s3DownloaderStub{
+			followerIDs: map[string][]int64{
+				"/new/path": ids(1, 40),
+				"/old/path": ids(1, 100),
+			},
+		},
+	}
+
+	got, err := h.Handle(context.Background(), Input{UserID: "000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(&Output{Quarantined: true}, got); diff != "" {
+		t.Error(diff)
+	}
+	if diff := cmp.Diff([]string{"/new/path"}, table.quarantined); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestSkipQuarantinedList(t *testing.T) {
+	h := Handler{
+		Table: &tableStub{
+			lists: []*data.FollowerList{
+				{S3Key: "/new/path", TotalFollowers: 2},
+				{S3Key: "/bad/path", TotalFollowers: 1, Quarantined: true},
+				{S3Key: "/old/path", TotalFollowers: 1},
+			},
+		},
+		S3Downloader: &s3DownloaderStub{
+			followerIDs: map[string][]int64{
+				"/new/path": {111, 222},
+				"/bad/path": {333},
+				"/old/path": {111},
+			},
+		},
+		EVB: &evbStub{},
+		Twitter: &twitterStub{
+			users: map[int64]*twitter.User{
+				222: {Handle: "bob"},
+			},
+		},
+	}
+
+	want := &Output{
+		Events: []*data.FollowerEvent{
+			{
+				UserID:              "000",
+				TotalFollowers:      2,
+				Follower:            &twitter.User{Handle: "bob"},
+				FollowerState:       data.FollowerStateNew,
+				FollowerStateReason: data.FollowerStateReasonFollowed,
+			},
+		},
+	}
+
+	got, err := h.Handle(context.Background(), Input{UserID: "000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got, ignoreFollowerEventFields); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestTruncatedList(t *testing.T) {
+	h := Handler{
+		Table: &tableStub{
+			lists: []*data.FollowerList{
+				{S3Key: "/new/path", TotalFollowers: 4, Truncated: true},
+				{S3Key: "/old/path", TotalFollowers: 4},
+			},
+		},
+		S3Downloader: &s3DownloaderStub{
+			followerIDs: map[string][]int64{
+				// Followers are listed most recent first
+				"/new/path": {555, 111, 222},
+				"/old/path": {111, 333, 222, 444},
+			},
+		},
+		EVB: &evbStub{},
+		Twitter: &twitterStub{
+			users: map[int64]*twitter.User{
+				333: {Handle: "bob"},
+				555: {Handle: "eve"},
+			},
+		},
+	}
+
+	// 444 is beyond the window of the truncated list and must not be lost
+	want := &Output{
+		Events: []*data.FollowerEvent{
+			{
+				UserID:              "000",
+				TotalFollowers:      4,
+				Follower:            &twitter.User{Handle: "eve"},
+				FollowerState:       data.FollowerStateNew,
+				FollowerStateReason: data.FollowerStateReasonFollowed,
+			},
+			{
+				UserID:              "000",
+				TotalFollowers:      4,
+				Follower:            &twitter.User{Handle: "bob"},
+				FollowerState:       data.FollowerStateLost,
+				FollowerStateReason: data.FollowerStateReasonUnfollowed,
+			},
+		},
+	}
+
+	got, err := h.Handle(context.Background(), Input{UserID: "000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got, ignoreFollowerEventFields); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestRefollow(t *testing.T) {
+	newHandler := func(refollow data.RefollowConfig, lostAt time.Time) *Handler {
+		return &Handler{
+			Table: &tableStub{
+				lists: []*data.FollowerList{
+					{S3Key: "/new/path", TotalFollowers: 2},
+					{S3Key: "/old/path", TotalFollowers: 1},
+				},
+				refollow: refollow,
+				churn: []*data.FollowerChurn{
+					{UserID: "000", FollowerID: "222", LostAt: lostAt, Refollows: 2},
+				},
+			},
+			S3Downloader: &s3DownloaderStub{
+				followerIDs: map[string][]int64{
+					"/new/path": {111, 222},
+					"/old/path": {111},
+				},
+			},
+			EVB: &evbStub{},
+			Twitter: &twitterStub{
+				users: map[int64]*twitter.User{
+					222: {ID: "222", Handle: "bob"},
+				},
+			},
+		}
+	}
+
+	event := func(reason string, refollows int) []*data.FollowerEvent {
+		return []*data.FollowerEvent{
+			{
+				UserID:              "000",
+				TotalFollowers:      2,
+				Follower:            &twitter.User{ID: "222", Handle: "bob"},
+				FollowerState:       data.FollowerStateNew,
+				FollowerStateReason: reason,
+				Refollows:           refollows,
+			},
+		}
+	}
+
+	var (
+		recently = time.Now().Add(-1 * time.Hour)
+		longAgo  = time.Now().Add(-48 * time.Hour)
+		enabled  = data.RefollowConfig{Enabled: true}
+	)
+
+	tests := []struct {
+		name      string
+		refollow  data.RefollowConfig
+		lostAt    time.Time
+		want      *Output
+		refollows int
+	}{
+		{
+			name:      "merged",
+			refollow:  enabled,
+			lostAt:    recently,
+			want:      &Output{Events: event(data.FollowerStateReasonRefollowed, 3)},
+			refollows: 3,
+		},
+		{
+			name:      "suppressed",
+			refollow:  data.RefollowConfig{Enabled: true, Suppress: true},
+			lostAt:    recently,
+			want:      &Output{Events: []*data.FollowerEvent{}},
+			refollows: 3,
+		},
+		{
+			name:      "counted but disabled",
+			lostAt:    recently,
+			want:      &Output{Events: event(data.FollowerStateReasonFollowed, 0)},
+			refollows: 3,
+		},
+		{
+			name:      "outside window",
+			refollow:  enabled,
+			lostAt:    longAgo,
+			want:      &Output{Events: event(data.FollowerStateReasonFollowed, 0)},
+			refollows: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		h := newHandler(tt.refollow, tt.lostAt)
+
+		got, err := h.Handle(context.Background(), Input{UserID: "000"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(tt.want, got, ignoreFollowerEventFields); diff != "" {
+			t.Errorf("%s: %s", tt.name, diff)
+		}
+		if got := h.Table.(*tableStub).churn[0].Refollows; got != tt.refollows {
+			t.Errorf("%s: refollows = %d, want %d", tt.name, got, tt.refollows)
+		}
+	}
+}
+
+func TestLostFollowerChurn(t *testing.T) {
+	table := &tableStub{
+		lists: []*data.FollowerList{
+			{S3Key: "/new/path", TotalFollowers: 1},
+			{S3Key: "/old/path", TotalFollowers: 2},
+		},
+	}
+	h := Handler{
+		Table: table,
+		S3Downloader: &s3DownloaderStub{
+			followerIDs: map[string][]int64{
+				"/new/path": {111},
+				"/old/path": {111, 222},
+			},
+		},
+		EVB: &evbStub{},
+		Twitter: &twitterStub{
+			users: map[int64]*twitter.User{
+				222: {ID: "222", Handle: "bob"},
+			},
+		},
+	}
+
+	if _, err := h.Handle(context.Background(), Input{UserID: "000"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(table.churn) != 1 || table.churn[0].FollowerID != "222" || table.churn[0].LostAt.IsZero() {
+		t.Errorf("churn = %+v, want lost follower 222", table.churn)
+	}
+}
+
+func TestLostFollowerReasons(t *testing.T) {
+	now := time.Now()
+
+	table := &tableStub{
+		lists: []*data.FollowerList{
+			{S3Key: "/new/path", TotalFollowers: 1},
+			{S3Key: "/old/path", TotalFollowers: 7},
+		},
+		churn: []*data.FollowerChurn{
+			{UserID: "000", FollowerID: "222", RemovedAt: now.Add(-1 * time.Hour)},
+		},
+	}
+	h := Handler{
+		Table: table,
+		S3Downloader: &s3DownloaderStub{
+			followerIDs: map[string][]int64{
+				"/new/path": {111},
+				"/old/path": {111, 222, 333, 444, 555, 666, 777},
+			},
+		},
+		EVB: &evbStub{},
+		Twitter: &twitterStub{
+			users: map[int64]*twitter.User{
+				222: {ID: "222", Handle: "bob"},
+				333: {ID: "333", Handle: "carol"},
+				444: {ID: "444", Handle: "dave"},
+				555: {ID: "555", Handle: "eve", Protected: true},
+				777: {ID: "777", Handle: "frank"},
+			},
+			errors: map[int64]error{
+				666: twitter.ErrBlocked,
+			},
+			relationships: map[int64]*twitter.Relationship{
+				333: {Blocking: true},
+				444: {BlockedBy: true},
+				555: {FollowedBy: true},
+			},
+			relErrors: map[int64]error{
+				777: errors.New("internal error"),
+			},
+		},
+	}
+
+	got, err := h.Handle(context.Background(), Input{UserID: "000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"222": data.FollowerStateReasonRemoved,
+		"333": data.FollowerStateReasonRemoved,
+		"444": data.FollowerStateReasonBlocked,
+		"555": data.FollowerStateReasonProtected,
+		"666": data.FollowerStateReasonBlocked,
+		"777": data.FollowerStateReasonUnfollowed,
+	}
+	reasons := map[string]string{}
+	for _, e := range got.Events {
+		reasons[e.Follower.ID] = e.FollowerStateReason
+	}
+	if diff := cmp.Diff(want, reasons); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestRecheckDeactivated(t *testing.T) {
+	due := time.Now().Add(-1 * time.Minute)
+
+	table := &tableStub{
+		lists: []*data.FollowerList{
+			{S3Key: "/new/path", TotalFollowers: 2},
+			{S3Key: "/old/path", TotalFollowers: 1},
+		},
+		churn: []*data.FollowerChurn{
+			{UserID: "000", FollowerID: "333", RecheckAt: due, EventID: "some-event-id"},
+			{UserID: "000", FollowerID: "444", RecheckAt: due},
+			{UserID: "000", FollowerID: "555", RecheckAt: time.Now().Add(time.Hour)},
+		},
+	}
+	h := Handler{
+		Table: table,
+		S3Downloader: &s3DownloaderStub{
+			followerIDs: map[string][]int64{
+				"/new/path": {111, 222},
+				"/old/path": {111},
+			},
+		},
+		EVB: &evbStub{},
+		Twitter: &twitterStub{
+			users: map[int64]*twitter.User{
+				222: {ID: "222", Handle: "bob"},
+				444: {ID: "444", Handle: "dave"},
+			},
+			errors: map[int64]error{
+				333: twitter.ErrUserNotFound,
+			},
+		},
+	}
+
+	want := &Output{
+		Events: []*data.FollowerEvent{
+			{
+				UserID:              "000",
+				TotalFollowers:      2,
+				Follower:            &twitter.User{ID: "222", Handle: "bob"},
+				FollowerState:       data.FollowerStateNew,
+				FollowerStateReason: data.FollowerStateReasonFollowed,
+			},
+		},
+	}
+
+	got, err := h.Handle(context.Background(), Input{UserID: "000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got, ignoreFollowerEventFields); diff != "" {
+		t.Error(diff)
+	}
+
+	// The follower was reported as lost when they deactivated their account
+	wantUpdated := map[string]string{"some-event-id": data.FollowerStateReasonDeleted}
+	if diff := cmp.Diff(wantUpdated, table.updatedEvents); diff != "" {
+		t.Error(diff)
+	}
+
+	// Deleted and reactivated followers aren't re-checked again
+	for _, c := range table.churn {
+		if c.RecheckDue(time.Now()) {
+			t.Errorf("follower %s is still due to be re-checked", c.FollowerID)
+		}
+	}
+	if table.churn[2].RecheckAt.IsZero() {
+		t.Error("re-check of follower 555 cleared before it was due")
+	}
+}
+
+func TestRecheckDeactivatedWithoutChanges(t *testing.T) {
+	due := time.Now().Add(-1 * time.Minute)
+
+	table := &tableStub{
+		lists: []*data.FollowerList{
+			{S3Key: "/some/path"},
+			{S3Key: "/some/path"},
+		},
+		churn: []*data.FollowerChurn{
+			{UserID: "000", FollowerID: "333", RecheckAt: due, EventID: "some-event-id"},
+		},
+	}
+	h := Handler{
+		Table: table,
+		Twitter: &twitterStub{
+			errors: map[int64]error{
+				333: twitter.ErrUserNotFound,
+			},
+		},
+	}
+
+	got, err := h.Handle(context.Background(), Input{UserID: "000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(&Output{}, got); diff != "" {
+		t.Error(diff)
+	}
+	wantUpdated := map[string]string{"some-event-id": data.FollowerStateReasonDeleted}
+	if diff := cmp.Diff(wantUpdated, table.updatedEvents); diff != "" {
+		t.Error(diff)
+	}
+	if table.churn[0].RecheckDue(time.Now()) {
+		t.Error("follower 333 is still due to be re-checked")
+	}
+}
+
+func TestRateBudgetExhausted(t *testing.T) {
+	tw := &twitterStub{
+		users: map[int64]*twitter.User{
+			222: {ID: "222", Handle: "bob"},
+		},
+		errors: map[int64]error{
+			333: budget.ErrExhausted,
+		},
+	}
+	h := Handler{
+		Table: &tableStub{
+			lists: []*data.FollowerList{
+				{S3Key: "/new/path", TotalFollowers: 3},
+				{S3Key: "/old/path", TotalFollowers: 2},
+			},
+		},
+		S3Downloader: &s3DownloaderStub{
+			followerIDs: map[string][]int64{
+				"/new/path": {222, 333, 444},
+				"/old/path": {555, 666},
+			},
+		},
+		EVB:     &evbStub{},
+		Twitter: tw,
+	}
+
+	got, err := h.Handle(context.Background(), Input{UserID: "000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes are reported even without profiles
+	want := map[string]string{
+		"222": "bob",
+		"333": "",
+		"444": "",
+		"555": "",
+		"666": "",
+	}
+	handles := map[string]string{}
+	for _, e := range got.Events {
+		handles[e.Follower.ID] = e.Follower.Handle
+	}
+	if diff := cmp.Diff(want, handles); diff != "" {
+		t.Error(diff)
+	}
+
+	if tw.lookups != 2 {
+		t.Errorf("expected lookups to stop once the budget is used up, got %d", tw.lookups)
+	}
+}
@@ -0,0 +1,64 @@
+package difffollowers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/budget"
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+// profiles looks up the profiles of changed followers during a run. Once the
+// rate budget of an endpoint is used up, it stops asking Twitter and
+// followers come with their ID only. Failing the run instead would drop its
+// changes for good, as the next run compares with a newer list.
+type profiles struct {
+	twitter twitter.API
+	user    *data.User
+	limited map[string]error // by endpoint
+}
+
+func (p *profiles) userByID(ctx context.Context, id int64) (*twitter.User, error) {
+	if err := p.limited[twitter.EndpointUsersShow]; err != nil {
+		return nil, err
+	}
+
+	follower, err := p.twitter.UserByID(ctx, p.user.AccessToken, p.user.AccessSecret, id)
+	p.check(twitter.EndpointUsersShow, err)
+
+	return follower, err
+}
+
+func (p *profiles) relationship(ctx context.Context, id int64) (*twitter.Relationship, error) {
+	if err := p.limited[twitter.EndpointFriendshipsShow]; err != nil {
+		return nil, err
+	}
+
+	rel, err := p.twitter.Relationship(ctx, p.user.AccessToken, p.user.AccessSecret, id)
+	p.check(twitter.EndpointFriendshipsShow, err)
+
+	return rel, err
+}
+
+func (p *profiles) check(endpoint string, err error) {
+	if !rateLimited(err) {
+		return
+	}
+	log.Printf("skipping further %s lookups: %s", endpoint, err)
+	if p.limited == nil {
+		p.limited = map[string]error{}
+	}
+	p.limited[endpoint] = err
+}
+
+func rateLimited(err error) bool {
+	return errors.Is(err, budget.ErrExhausted) || errors.Is(err, twitter.ErrRateLimitExceeded)
+}
+
+// idOnly is a follower whose profile is unknown.
+func idOnly(id int64) *twitter.User {
+	return &twitter.User{ID: strconv.FormatInt(id, 10)} //nolint:gomnd
+}
@@ -0,0 +1,115 @@
+package difffollowers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+// lostFollower returns the profile of a lost follower and why they were
+// lost, as far as the profile tells. Followers without a profile come with
+// their ID only. Those not found may have deactivated their account, which
+// is only known to be deleted once they were re-checked.
+func lostFollower(ctx context.Context, lookup *profiles, id int64) (*twitter.User, string, error) {
+	follower, err := lookup.userByID(ctx, id)
+	if err == nil {
+		return follower, data.FollowerStateReasonUnfollowed, nil
+	}
+
+	follower = idOnly(id)
+
+	switch {
+	case rateLimited(err):
+		return follower, data.FollowerStateReasonUnfollowed, nil
+	case errors.Is(err, twitter.ErrUserNotFound):
+		return follower, data.FollowerStateReasonDeactivated, nil
+	case errors.Is(err, twitter.ErrUserSuspended):
+		return follower, data.FollowerStateReasonSuspended, nil
+	case errors.Is(err, twitter.ErrBlocked):
+		return follower, data.FollowerStateReasonBlocked, nil
+	default:
+		return nil, "", err
+	}
+}
+
+// unfollowReason tells apart followers who unfollowed from those the user
+// removed or blocked, via Listkeeper or otherwise, those who blocked the
+// user, and those who still follow but went protected, which hides them
+// from the list of followers. If Twitter can't tell, they unfollowed.
+func unfollowReason(ctx context.Context, lookup *profiles, id int64, follower *twitter.User,
+	churn *data.FollowerChurn, now time.Time,
+) string {
+	// Removing a follower unblocks them right away, so only we know
+	if churn.RemovedRecently(now) {
+		return data.FollowerStateReasonRemoved
+	}
+
+	rel, err := lookup.relationship(ctx, id)
+	if err != nil {
+		log.Printf("failed to get relationship with follower %d: %s", id, err)
+		return data.FollowerStateReasonUnfollowed
+	}
+
+	switch {
+	case rel.Blocking:
+		return data.FollowerStateReasonRemoved
+	case rel.BlockedBy:
+		return data.FollowerStateReasonBlocked
+	case rel.FollowedBy && follower.Protected:
+		return data.FollowerStateReasonProtected
+	default:
+		return data.FollowerStateReasonUnfollowed
+	}
+}
+
+// recheckDeactivated looks up deactivated followers once Twitter would have
+// deleted them and returns the churn of those that are gone for good. Others
+// reactivated their account, but didn't follow again. Those left when the
+// rate budget runs out are re-checked by a later run.
+func recheckDeactivated(ctx context.Context, lookup *profiles,
+	churn, changed map[string]*data.FollowerChurn, now time.Time,
+) ([]*data.FollowerChurn, error) {
+	ids := make([]string, 0, len(churn))
+	for id, c := range churn {
+		if c.RecheckDue(now) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var deleted []*data.FollowerChurn
+	for _, id := range ids {
+		n, err := strconv.ParseInt(id, 10, 64) //nolint:gomnd
+		if err != nil {
+			return nil, err
+		}
+
+		c := churn[id]
+
+		_, err = lookup.userByID(ctx, n)
+		switch {
+		case rateLimited(err):
+			return deleted, nil
+		case err == nil:
+			log.Printf("deactivated follower %s reactivated", id)
+		case errors.Is(err, twitter.ErrUserNotFound):
+			d := *c
+			deleted = append(deleted, &d)
+		case errors.Is(err, twitter.ErrUserSuspended):
+			log.Printf("deactivated follower %s is suspended", id)
+		default:
+			return nil, err
+		}
+
+		c.Rechecked(now)
+		changed[id] = c
+	}
+
+	return deleted, nil
+}
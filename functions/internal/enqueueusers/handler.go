@@ -0,0 +1,151 @@
+package enqueueusers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	lambdasvc "github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/davecgh/go-spew/spew"
+	"github.com/pkg/errors"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/budget"
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+type Output struct {
+	UserIDs       []string
+	TotalUsers    int
+	DeferredUsers int
+}
+
+type Handler struct {
+	Table        data.TableAPI
+	Budget       *budget.Planner
+	Lambda       lambdaiface.LambdaAPI
+	FunctionName string
+
+	backfilled bool
+}
+
+func (h *Handler) Handle(ctx context.Context, event events.CloudWatchEvent) (*Output, error) {
+	spew.Printf("event = %+v\n", event)
+
+	// Users who signed up before checks were scheduled are missing from the
+	// schedule. Finding them takes a scan, so it only runs once per cold start.
+	if !h.backfilled {
+		if err := h.backfillSchedules(ctx, time.Now()); err != nil {
+			return nil, err
+		}
+		h.backfilled = true
+	}
+
+	// Every follower fetch needs at least one request
+	available, err := h.Budget.Available(ctx, twitter.EndpointFollowerIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		userIDs  []string
+		deferred int
+		window   = h.Budget.Window(twitter.EndpointFollowerIDs)
+		now      = time.Now()
+		iter     = h.Table.NewDueUserIter(now)
+	)
+
+	for {
+		user := iter.Next(ctx)
+		if user == nil {
+			break
+		}
+
+		if !user.IsTracked() {
+			// Tracking stopped without the user leaving the schedule, e.g.
+			// before untracked users were removed from it
+			log.Printf("skipping user with ID %s, tracking status is %s", user.ID, user.TrackingStatus)
+			if err := h.Table.UpdateUserSchedule(ctx, user); err != nil {
+				return nil, errors.Wrapf(err, "failed to unschedule user with ID %s", user.ID)
+			}
+			continue
+		}
+
+		if len(userIDs) >= available {
+			// Spread users beyond the current budget across the window,
+			// with jitter to keep them from all becoming due at once.
+			user.ScheduleCheckAt(now.Add(time.Duration(rand.Int63n(int64(window))))) //nolint:gosec
+			if err := h.Table.UpdateUserSchedule(ctx, user); err != nil {
+				return nil, errors.Wrapf(err, "failed to schedule next check for user with ID %s", user.ID)
+			}
+			deferred++
+			continue
+		}
+
+		// Defer the next check right away so that a failed fetch won't be
+		// retried on every tick; diff-followers adapts the interval later.
+		user.DeferNextCheck(now)
+		if err := h.Table.UpdateUserSchedule(ctx, user); err != nil {
+			return nil, errors.Wrapf(err, "failed to schedule next check for user with ID %s", user.ID)
+		}
+
+		_, err := h.Lambda.InvokeWithContext(ctx, &lambdasvc.InvokeInput{
+			FunctionName:   aws.String(h.FunctionName),
+			Payload:        []byte(fmt.Sprintf(`{"UserID": "%s"}`, user.ID)),
+			InvocationType: aws.String(lambdasvc.InvocationTypeEvent),
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"failed to start function %s for user with ID %s", h.FunctionName, user.ID)
+		}
+
+		userIDs = append(userIDs, user.ID)
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	// FIXME: this will break for many users
+	out := Output{UserIDs: userIDs, TotalUsers: len(userIDs), DeferredUsers: deferred}
+
+	spew.Printf("output = %+v\n", out)
+
+	return &out, nil
+}
+
+// backfillSchedules makes users without a schedule due right away.
+func (h *Handler) backfillSchedules(ctx context.Context, now time.Time) error {
+	var (
+		n    int
+		iter = h.Table.NewUnscheduledUserIter()
+	)
+
+	for {
+		user := iter.Next(ctx)
+		if user == nil {
+			break
+		}
+
+		user.ScheduleCheckAt(now)
+		if err := h.Table.UpdateUserSchedule(ctx, user); err != nil {
+			return errors.Wrapf(err, "failed to schedule first check for user with ID %s", user.ID)
+		}
+		n++
+	}
+
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	if n > 0 {
+		log.Printf("scheduled %d users without a schedule", n)
+	}
+
+	return nil
+}
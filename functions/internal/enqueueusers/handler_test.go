@@ -0,0 +1,323 @@
+package enqueueusers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/request"
+	lambdasvc "github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/budget"
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+type tableStub struct {
+	data.TableAPI
+
+	users       []*data.User
+	budget      *data.RateBudget
+	unscheduled []string
+}
+
+func (t *tableStub) GetRateBudget(ctx context.Context, name string) (*data.RateBudget, error) {
+	if t.budget == nil {
+		return nil, data.ErrRateBudgetNotFound
+	}
+	return t.budget, nil
+}
+
+// Like ScheduleIndex, only users with a next check can be due.
+func (t *tableStub) NewDueUserIter(now time.Time) data.UserIter {
+	var due []*data.User
+	for _, u := range t.users {
+		if !u.NextCheckAt.IsZero() && !u.NextCheckAt.After(now) {
+			due = append(due, u)
+		}
+	}
+	return &userIterStub{users: due}
+}
+
+func (t *tableStub) NewUnscheduledUserIter() data.UserIter {
+	var unscheduled []*data.User
+	for _, u := range t.users {
+		if u.NextCheckAt.IsZero() {
+			unscheduled = append(unscheduled, u)
+		}
+	}
+	return &userIterStub{users: unscheduled}
+}
+
+func (t *tableStub) UpdateUserSchedule(ctx context.Context, u *data.User) error {
+	if !u.IsTracked() {
+		t.unscheduled = append(t.unscheduled, u.ID)
+	}
+	return nil
+}
+
+type userIterStub struct {
+	data.UserIter
+
+	users []*data.User
+	index int
+}
+
+func (iter *userIterStub) Next(context.Context) *data.User {
+	if iter.index >= len(iter.users) {
+		return nil
+	}
+	u := iter.users[iter.index]
+	iter.index++
+	return u
+}
+
+func (iter *userIterStub) Err() error {
+	return nil
+}
+
+type lambdaStub struct {
+	lambdaiface.LambdaAPI
+}
+
+func (*lambdaStub) InvokeWithContext(_ context.Context, _ *lambdasvc.InvokeInput, _ ...request.Option) (*lambdasvc.InvokeOutput, error) {
+	return nil, nil //nolint:nilnil
+}
+
+func TestEnqueueNoUsers(t *testing.T) {
+	table := &tableStub{}
+	h := Handler{
+		Table:  table,
+		Budget: budget.NewPlanner(table),
+	}
+
+	want := &Output{
+		TotalUsers: 0,
+	}
+
+	got, err := h.Handle(context.Background(), events.CloudWatchEvent{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestEnqueueOneUser(t *testing.T) {
+	table := &tableStub{
+		users: []*data.User{
+			data.NewUser("111"),
+		},
+	}
+	h := Handler{
+		Table:  table,
+		Budget: budget.NewPlanner(table),
+		Lambda: &lambdaStub{},
+	}
+
+	want := &Output{
+		UserIDs:    []string{"111"},
+		TotalUsers: 1,
+	}
+
+	got, err := h.Handle(context.Background(), events.CloudWatchEvent{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestEnqueueSomeUsers(t *testing.T) {
+	table := &tableStub{
+		users: []*data.User{
+			data.NewUser("111"),
+			data.NewUser("222"),
+			data.NewUser("333"),
+		},
+	}
+	h := Handler{
+		Table:  table,
+		Budget: budget.NewPlanner(table),
+		Lambda: &lambdaStub{},
+	}
+
+	want := &Output{
+		UserIDs:    []string{"111", "222", "333"},
+		TotalUsers: 3,
+	}
+
+	got, err := h.Handle(context.Background(), events.CloudWatchEvent{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestEnqueueDueUsers(t *testing.T) {
+	later := data.NewUser("222")
+	later.NextCheckAt = time.Now().Add(1 * time.Hour)
+
+	table := &tableStub{
+		users: []*data.User{
+			data.NewUser("111"),
+			later,
+			data.NewUser("333"),
+		},
+	}
+	h := Handler{
+		Table:  table,
+		Budget: budget.NewPlanner(table),
+		Lambda: &lambdaStub{},
+	}
+
+	want := &Output{
+		UserIDs:    []string{"111", "333"},
+		TotalUsers: 2,
+	}
+
+	got, err := h.Handle(context.Background(), events.CloudWatchEvent{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+
+	for _, u := range table.users {
+		if u.NextCheckAt.Before(time.Now().Add(30 * time.Minute)) {
+			t.Errorf("next check of user %s not deferred: %s", u.ID, u.NextCheckAt)
+		}
+	}
+}
+
+func TestEnqueueUnscheduledUsers(t *testing.T) {
+	unscheduled := data.NewUser("222")
+	unscheduled.NextCheckAt = time.Time{}
+
+	table := &tableStub{
+		users: []*data.User{
+			data.NewUser("111"),
+			unscheduled,
+		},
+	}
+	h := Handler{
+		Table:  table,
+		Budget: budget.NewPlanner(table),
+		Lambda: &lambdaStub{},
+	}
+
+	want := &Output{
+		UserIDs:    []string{"111", "222"},
+		TotalUsers: 2,
+	}
+
+	got, err := h.Handle(context.Background(), events.CloudWatchEvent{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestEnqueueTrackedUsers(t *testing.T) {
+	paused := data.NewUser("222")
+	paused.TrackingStatus = data.TrackingStatusPaused
+
+	reauth := data.NewUser("333")
+	reauth.TrackingStatus = data.TrackingStatusNeedsReauth
+
+	legacy := data.NewUser("444")
+	legacy.TrackingStatus = ""
+
+	table := &tableStub{
+		users: []*data.User{
+			data.NewUser("111"),
+			paused,
+			reauth,
+			legacy,
+		},
+	}
+	h := Handler{
+		Table:  table,
+		Budget: budget.NewPlanner(table),
+		Lambda: &lambdaStub{},
+	}
+
+	want := &Output{
+		UserIDs:    []string{"111", "444"},
+		TotalUsers: 2,
+	}
+
+	got, err := h.Handle(context.Background(), events.CloudWatchEvent{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+	// Users who aren't tracked leave the schedule instead of staying due
+	if diff := cmp.Diff([]string{"222", "333"}, table.unscheduled); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestEnqueueOverBudget(t *testing.T) {
+	now := time.Now()
+
+	table := &tableStub{
+		users: []*data.User{
+			data.NewUser("111"),
+			data.NewUser("222"),
+			data.NewUser("333"),
+		},
+		budget: &data.RateBudget{
+			Name:        twitter.EndpointFollowerIDs,
+			Capacity:    300,
+			Window:      15 * time.Minute,
+			Tokens:      1,
+			WindowStart: now,
+			UpdatedAt:   now,
+		},
+	}
+	h := Handler{
+		Table:  table,
+		Budget: budget.NewPlanner(table),
+		Lambda: &lambdaStub{},
+	}
+
+	want := &Output{
+		UserIDs:       []string{"111"},
+		TotalUsers:    1,
+		DeferredUsers: 2,
+	}
+
+	got, err := h.Handle(context.Background(), events.CloudWatchEvent{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+
+	for _, u := range table.users[1:] {
+		if u.NextCheckAt.Before(now.Truncate(time.Second)) || u.NextCheckAt.After(now.Add(15*time.Minute)) {
+			t.Errorf("user %s not spread across window: %s", u.ID, u.NextCheckAt)
+		}
+	}
+}
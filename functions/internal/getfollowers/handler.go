@@ -0,0 +1,140 @@
+package getfollowers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/budget"
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/evb"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+type Input struct {
+	UserID string
+}
+
+type Handler struct {
+	Table      data.TableAPI
+	TableTTL   time.Duration
+	S3Uploader s3manageriface.UploaderAPI
+	BucketName string
+	EVB        evb.API
+	Twitter    twitter.API
+}
+
+func (h *Handler) Handle(ctx context.Context, in Input) (*data.FollowerList, error) {
+	log.SetPrefix(in.UserID + " ")
+	log.Printf("input = %+v", in)
+
+	if in.UserID == "" {
+		return nil, errors.New("user ID must be passed as input")
+	}
+
+	// The latest list tells how many pages to budget for
+	user, lists, err := h.Table.GetUserAndLatestFollowerLists(ctx, in.UserID, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.IsTracked() {
+		return nil, fmt.Errorf("tracking of user with ID %s is %s", user.ID, user.TrackingStatus)
+	}
+
+	ctx = budget.WithUser(ctx, user.ID)
+	if len(lists) > 0 {
+		ctx = twitter.WithFollowerPages(ctx, lists[0].Pages)
+	}
+
+	followers, err := h.Twitter.FollowerIDs(ctx, user.AccessToken, user.AccessSecret)
+	if err != nil {
+		if errors.Is(err, twitter.ErrInvalidToken) {
+			if err := h.suspendTracking(ctx, user); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+
+	// The profile tells diff-followers whether the list is complete. Without
+	// it, the list is still worth keeping.
+	var profileFollowers int
+	if profile, err := h.Twitter.CurrentUser(ctx, user.AccessToken, user.AccessSecret); err != nil {
+		log.Printf("failed to get profile: %s", err)
+	} else {
+		profileFollowers = profile.TotalFollowers
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(followers.IDs); err != nil {
+		return nil, err
+	}
+
+	var (
+		digest = sha256.Sum256(buf.Bytes())
+		s3Key  = fmt.Sprintf("user/%s/followers/%s", user.ID, hex.EncodeToString(digest[:]))
+		now    = time.Now()
+	)
+
+	_, err = h.S3Uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(h.BucketName),
+		Key:         aws.String(s3Key),
+		ContentType: aws.String("application/json"),
+		Body:        &buf,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list := data.FollowerList{
+		UserID:           user.ID,
+		S3Bucket:         h.BucketName,
+		S3Key:            s3Key,
+		TotalFollowers:   len(followers.IDs),
+		CreatedAt:        now,
+		ExpiresAt:        now.Add(h.TableTTL),
+		Pages:            followers.Pages,
+		Truncated:        followers.Truncated,
+		ProfileFollowers: profileFollowers,
+	}
+	if list.Truncated && profileFollowers > 0 {
+		// The list only has the most recent followers
+		list.TotalFollowers = profileFollowers
+	}
+
+	if err := h.Table.CreateFollowerList(ctx, &list); err != nil {
+		return nil, err
+	}
+
+	log.Printf("output = %+v", list)
+
+	return &list, nil
+}
+
+// suspendTracking stops checking the followers of a user whose token was
+// revoked until they log in again. The user is asked to reconnect their
+// account only once, when the tracking status actually changes.
+func (h *Handler) suspendTracking(ctx context.Context, user *data.User) error {
+	changed, err := h.Table.UpdateUserTrackingStatus(ctx, user.ID, data.TrackingStatusNeedsReauth)
+	if err != nil || !changed {
+		return err
+	}
+
+	log.Print("token revoked, suspending tracking")
+
+	return h.EVB.Send(ctx, "Tracking Status Change", data.TrackingStatusEvent{
+		UserID:         user.ID,
+		TrackingStatus: data.TrackingStatusNeedsReauth,
+	})
+}
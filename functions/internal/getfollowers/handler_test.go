@@ -0,0 +1,181 @@
+package getfollowers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/evb"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+type tableStub struct {
+	data.TableAPI
+
+	user           *data.User
+	trackingStatus string
+}
+
+func (t tableStub) GetUserAndLatestFollowerLists(ctx context.Context, userID string, limit int64) (*data.User, []*data.FollowerList, error) {
+	return t.user, nil, nil
+}
+
+func (t *tableStub) CreateFollowerList(ctx context.Context, l *data.FollowerList) error {
+	return nil
+}
+
+func (t *tableStub) UpdateUserTrackingStatus(ctx context.Context, userID, status string) (bool, error) {
+	changed := t.trackingStatus != status
+	t.trackingStatus = status
+	return changed, nil
+}
+
+type s3UploaderStub struct {
+	s3manageriface.UploaderAPI
+}
+
+func (*s3UploaderStub) UploadWithContext(ctx context.Context, input *s3manager.UploadInput, f ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	return nil, nil //nolint:nilnil
+}
+
+type evbStub struct {
+	evb.API
+
+	events []interface{}
+}
+
+func (e *evbStub) Send(ctx context.Context, eventType string, events ...interface{}) error {
+	e.events = append(e.events, events...)
+	return nil
+}
+
+type twitterStub struct {
+	twitter.API
+
+	followerIDs      []int64
+	truncated        bool
+	profileFollowers int
+	err              error
+}
+
+func (t *twitterStub) FollowerIDs(ctx context.Context, accessToken, accessSecret string) (*twitter.FollowerIDs, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	return &twitter.FollowerIDs{IDs: t.followerIDs, Pages: 1, Truncated: t.truncated}, nil
+}
+
+func (t *twitterStub) CurrentUser(ctx context.Context, accessToken, accessSecret string) (*twitter.User, error) {
+	if t.profileFollowers == 0 {
+		return nil, twitter.ErrRateLimitExceeded
+	}
+	return &twitter.User{TotalFollowers: t.profileFollowers}, nil
+}
+
+func TestGetFollowers(t *testing.T) {
+	h := Handler{
+		Table: &tableStub{
+			user: data.NewUser("000"),
+		},
+		S3Uploader: &s3UploaderStub{},
+		BucketName: "some-bucket",
+		Twitter: &twitterStub{
+			followerIDs:      []int64{123, 456, 789},
+			profileFollowers: 4,
+		},
+	}
+
+	// $ echo '[123,456,789]' | sha256sum
+	// 9b4620ebc5b5ebf2c51da1b778f141c22d7c48b68c74e7c3c3931d4a17894c81  -
+	want := &data.FollowerList{
+		UserID:           "000",
+		S3Bucket:         "some-bucket",
+		S3Key:            "user/000/followers/9b4620ebc5b5ebf2c51da1b778f141c22d7c48b68c74e7c3c3931d4a17894c81",
+		TotalFollowers:   3,
+		Pages:            1,
+		ProfileFollowers: 4,
+	}
+
+	got, err := h.Handle(context.Background(), Input{UserID: "000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := cmpopts.IgnoreFields(data.FollowerList{}, "CreatedAt", "ExpiresAt")
+
+	if diff := cmp.Diff(want, got, opts); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestGetFollowersTruncated(t *testing.T) {
+	h := Handler{
+		Table:      &tableStub{user: data.NewUser("000")},
+		S3Uploader: &s3UploaderStub{},
+		BucketName: "some-bucket",
+		Twitter: &twitterStub{
+			followerIDs:      []int64{123, 456},
+			truncated:        true,
+			profileFollowers: 80000,
+		},
+	}
+
+	got, err := h.Handle(context.Background(), Input{UserID: "000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Truncated || got.TotalFollowers != 80000 || got.Incomplete() {
+		t.Errorf("unexpected list %+v", got)
+	}
+}
+
+func TestGetFollowersInvalidToken(t *testing.T) {
+	var (
+		table = &tableStub{user: data.NewUser("000")}
+		bus   = &evbStub{}
+		h     = Handler{
+			Table:   table,
+			EVB:     bus,
+			Twitter: &twitterStub{err: twitter.ErrInvalidToken},
+		}
+	)
+
+	// Only the first failure should ask the user to reconnect
+	for i := 0; i < 2; i++ {
+		if _, err := h.Handle(context.Background(), Input{UserID: "000"}); !errors.Is(err, twitter.ErrInvalidToken) {
+			t.Fatalf("expected invalid token error, got %v", err)
+		}
+	}
+
+	if diff := cmp.Diff(data.TrackingStatusNeedsReauth, table.trackingStatus); diff != "" {
+		t.Error(diff)
+	}
+
+	want := []interface{}{
+		data.TrackingStatusEvent{UserID: "000", TrackingStatus: data.TrackingStatusNeedsReauth},
+	}
+
+	if diff := cmp.Diff(want, bus.events); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestGetFollowersNotTracked(t *testing.T) {
+	user := data.NewUser("000")
+	user.TrackingStatus = data.TrackingStatusPaused
+
+	h := Handler{
+		Table:   &tableStub{user: user},
+		Twitter: &twitterStub{followerIDs: []int64{123}},
+	}
+
+	if _, err := h.Handle(context.Background(), Input{UserID: "000"}); err == nil {
+		t.Fatal("expected error for paused user")
+	}
+}
@@ -0,0 +1,336 @@
+// Package graphql serves the operations of schema.graphql over HTTP, for
+// running Listkeeper without AppSync. Like AppSync, it hands every top-level
+// field to a resolver and shapes the resolver's JSON result according to the
+// selection set.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/validator"
+)
+
+// awsDefinitions declares the scalars and directives AppSync provides.
+const awsDefinitions = `
+scalar AWSDate
+scalar AWSTime
+scalar AWSDateTime
+scalar AWSTimestamp
+scalar AWSEmail
+scalar AWSJSON
+scalar AWSURL
+scalar AWSPhone
+scalar AWSIPAddress
+
+directive @aws_api_key on FIELD_DEFINITION | OBJECT
+directive @aws_oidc on FIELD_DEFINITION | OBJECT
+directive @aws_iam on FIELD_DEFINITION | OBJECT
+directive @aws_cognito_user_pools(cognito_groups: [String]) on FIELD_DEFINITION | OBJECT
+directive @aws_lambda on FIELD_DEFINITION | OBJECT
+directive @aws_subscribe(mutations: [String]) on FIELD_DEFINITION
+`
+
+// LoadSchema parses an AppSync schema.
+func LoadSchema(name, sdl string) (*ast.Schema, error) {
+	return gqlparser.LoadSchema(
+		&ast.Source{Name: "aws.graphql", Input: awsDefinitions, BuiltIn: true},
+		&ast.Source{Name: name, Input: sdl},
+	)
+}
+
+// Field is a top-level field of a query or mutation to be resolved.
+type Field struct {
+	ParentTypeName   string
+	Name             string
+	Arguments        map[string]interface{}
+	Variables        map[string]interface{}
+	SelectionSetList []string
+}
+
+// Resolver returns the value of a top-level field. The value is encoded as
+// JSON before the selection set is applied, so it only needs JSON field
+// names matching the schema.
+type Resolver func(ctx context.Context, field *Field) (interface{}, error)
+
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+type Response struct {
+	Data   interface{}   `json:"data"`
+	Errors gqlerror.List `json:"errors,omitempty"`
+}
+
+type Server struct {
+	schema  *ast.Schema
+	resolve Resolver
+}
+
+func NewServer(schema *ast.Schema, resolve Resolver) *Server {
+	return &Server{schema: schema, resolve: resolve}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.Execute(r.Context(), &req)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Execute runs a single operation. Fields are resolved one after the other,
+// as required for mutations.
+func (s *Server) Execute(ctx context.Context, req *Request) *Response {
+	doc, errs := gqlparser.LoadQuery(s.schema, req.Query)
+	if len(errs) > 0 {
+		return &Response{Errors: errs}
+	}
+
+	op := doc.Operations.ForName(req.OperationName)
+	if op == nil {
+		return &Response{Errors: gqlerror.List{gqlerror.Errorf("operation %q not found", req.OperationName)}}
+	}
+
+	vars, err := validator.VariableValues(s.schema, op, req.Variables)
+	if err != nil {
+		var gqlErr *gqlerror.Error
+		if errors.As(err, &gqlErr) {
+			return &Response{Errors: gqlerror.List{gqlErr}}
+		}
+		return &Response{Errors: gqlerror.List{gqlerror.Wrap(err)}}
+	}
+
+	var parent *ast.Definition
+	switch op.Operation {
+	case ast.Query:
+		parent = s.schema.Query
+	case ast.Mutation:
+		parent = s.schema.Mutation
+	default:
+		return &Response{Errors: gqlerror.List{gqlerror.Errorf("%s operations are not supported", op.Operation)}}
+	}
+
+	e := executor{vars: vars}
+	data := orderedMap{}
+
+	for _, field := range e.collectFields(op.SelectionSet) {
+		path := ast.Path{ast.PathName(field.Alias)}
+
+		if strings.HasPrefix(field.Name, "__") {
+			if field.Name == "__typename" {
+				data = data.set(field.Alias, parent.Name)
+				continue
+			}
+			e.errorf(path, "introspection is not supported")
+			data = data.set(field.Alias, nil)
+			continue
+		}
+
+		value, err := s.resolve(ctx, &Field{
+			ParentTypeName:   parent.Name,
+			Name:             field.Name,
+			Arguments:        field.ArgumentMap(vars),
+			Variables:        vars,
+			SelectionSetList: e.selectionSetList(field.SelectionSet, ""),
+		})
+		if err != nil {
+			e.errs = append(e.errs, &gqlerror.Error{Message: err.Error(), Path: path})
+			data = data.set(field.Alias, nil)
+			continue
+		}
+
+		if value, err = toJSON(value); err != nil {
+			e.errs = append(e.errs, &gqlerror.Error{Message: err.Error(), Path: path})
+			data = data.set(field.Alias, nil)
+			continue
+		}
+
+		data = data.set(field.Alias, e.completeValue(path, field.Definition.Type, field.SelectionSet, value))
+	}
+
+	return &Response{Data: data, Errors: e.errs}
+}
+
+type executor struct {
+	vars map[string]interface{}
+	errs gqlerror.List
+}
+
+func (e *executor) errorf(path ast.Path, format string, args ...interface{}) {
+	e.errs = append(e.errs, &gqlerror.Error{Message: fmt.Sprintf(format, args...), Path: path})
+}
+
+// toJSON converts a resolver's result into plain JSON values.
+func toJSON(value interface{}) (interface{}, error) {
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(buf))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// completeValue shapes a value according to its type and selection set.
+func (e *executor) completeValue(path ast.Path, typ *ast.Type, sel ast.SelectionSet, value interface{}) interface{} {
+	if value == nil {
+		if typ.NonNull {
+			e.errorf(path, "cannot return null for non-nullable field")
+		}
+		return nil
+	}
+
+	if typ.Elem != nil {
+		list, ok := value.([]interface{})
+		if !ok {
+			e.errorf(path, "expected a list")
+			return nil
+		}
+		completed := make([]interface{}, len(list))
+		for i, item := range list {
+			itemPath := append(append(ast.Path{}, path...), ast.PathIndex(i))
+			completed[i] = e.completeValue(itemPath, typ.Elem, sel, item)
+		}
+		return completed
+	}
+
+	if len(sel) == 0 {
+		return value // scalar or enum
+	}
+
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		e.errorf(path, "expected an object")
+		return nil
+	}
+
+	result := orderedMap{}
+	for _, field := range e.collectFields(sel) {
+		if field.Name == "__typename" {
+			result = result.set(field.Alias, typ.Name())
+			continue
+		}
+		fieldPath := append(append(ast.Path{}, path...), ast.PathName(field.Alias))
+		result = result.set(field.Alias, e.completeValue(fieldPath, field.Definition.Type, field.SelectionSet, object[field.Name]))
+	}
+	return result
+}
+
+// collectFields flattens fragments and honors @skip and @include.
+func (e *executor) collectFields(sel ast.SelectionSet) []*ast.Field {
+	var fields []*ast.Field
+	for _, s := range sel {
+		switch s := s.(type) {
+		case *ast.Field:
+			if e.included(s.Directives) {
+				fields = append(fields, s)
+			}
+		case *ast.InlineFragment:
+			if e.included(s.Directives) {
+				fields = append(fields, e.collectFields(s.SelectionSet)...)
+			}
+		case *ast.FragmentSpread:
+			if e.included(s.Directives) {
+				fields = append(fields, e.collectFields(s.Definition.SelectionSet)...)
+			}
+		}
+	}
+	return fields
+}
+
+func (e *executor) included(directives ast.DirectiveList) bool {
+	if d := directives.ForName("skip"); d != nil {
+		if skip, _ := d.ArgumentMap(e.vars)["if"].(bool); skip {
+			return false
+		}
+	}
+	if d := directives.ForName("include"); d != nil {
+		if include, _ := d.ArgumentMap(e.vars)["if"].(bool); !include {
+			return false
+		}
+	}
+	return true
+}
+
+// selectionSetList lists the selected fields like AppSync's
+// $context.info.selectionSetList, e.g. "slack" and "slack/enabled".
+func (e *executor) selectionSetList(sel ast.SelectionSet, prefix string) []string {
+	var list []string
+	for _, field := range e.collectFields(sel) {
+		if strings.HasPrefix(field.Name, "__") {
+			continue
+		}
+		name := prefix + field.Name
+		list = append(list, name)
+		list = append(list, e.selectionSetList(field.SelectionSet, name+"/")...)
+	}
+	return list
+}
+
+// orderedMap keeps fields in the order they were selected.
+type orderedMap []orderedField
+
+type orderedField struct {
+	name  string
+	value interface{}
+}
+
+func (m orderedMap) set(name string, value interface{}) orderedMap {
+	for i := range m {
+		if m[i].name == name {
+			m[i].value = value
+			return m
+		}
+	}
+	return append(m, orderedField{name, value})
+}
+
+func (m orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range m {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(f.name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
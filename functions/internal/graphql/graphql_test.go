@@ -0,0 +1,121 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func loadSchema(t *testing.T) *ast.Schema {
+	t.Helper()
+
+	sdl, err := os.ReadFile("../../../schema.graphql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := LoadSchema("schema.graphql", string(sdl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+type user struct {
+	ID     string `json:"id"`
+	Handle string `json:"handle"`
+	Secret string `json:"secret"`
+	Slack  struct {
+		Enabled bool `json:"enabled"`
+	} `json:"slack"`
+}
+
+func serve(t *testing.T, resolve Resolver, req *Request) string {
+	t.Helper()
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	NewServer(loadSchema(t), resolve).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body)))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body)
+	}
+	return rec.Body.String()
+}
+
+func TestQuery(t *testing.T) {
+	var got *Field
+	resolve := func(ctx context.Context, field *Field) (interface{}, error) {
+		got = field
+		return &user{ID: "1234", Handle: "alice", Secret: "hidden"}, nil
+	}
+
+	body := serve(t, resolve, &Request{
+		Query: `query GetUser($id: ID!) {
+			user: getUser(id: $id) { __typename id ...Profile }
+		}
+		fragment Profile on User { handle slack { enabled } }`,
+		Variables: map[string]interface{}{"id": "1234"},
+	})
+
+	want := `{"data":{"user":{"__typename":"User","id":"1234","handle":"alice","slack":{"enabled":false}}}}` + "\n"
+	if diff := cmp.Diff(want, body); diff != "" {
+		t.Error(diff)
+	}
+
+	wantField := &Field{
+		ParentTypeName:   "Query",
+		Name:             "getUser",
+		Arguments:        map[string]interface{}{"id": "1234"},
+		Variables:        map[string]interface{}{"id": "1234"},
+		SelectionSetList: []string{"id", "handle", "slack", "slack/enabled"},
+	}
+	if diff := cmp.Diff(wantField, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestMutationError(t *testing.T) {
+	resolve := func(ctx context.Context, field *Field) (interface{}, error) {
+		return nil, errors.New("user not found")
+	}
+
+	body := serve(t, resolve, &Request{
+		Query: `mutation { deleteUser(id: "1234") }`,
+	})
+
+	want := `{"data":{"deleteUser":null},"errors":[{"message":"user not found","path":["deleteUser"]}]}` + "\n"
+	if diff := cmp.Diff(want, body); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestInvalidQuery(t *testing.T) {
+	resolve := func(ctx context.Context, field *Field) (interface{}, error) {
+		t.Fatal("resolver must not be called")
+		return nil, nil //nolint:nilnil
+	}
+
+	res := NewServer(loadSchema(t), resolve).Execute(context.Background(), &Request{
+		Query: `{ getUser(id: "1234") { password } }`,
+	})
+
+	if diff := cmp.Diff(1, len(res.Errors)); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff(`Cannot query field "password" on type "User".`, res.Errors[0].Message); diff != "" {
+		t.Error(diff)
+	}
+}
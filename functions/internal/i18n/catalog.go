@@ -0,0 +1,214 @@
+package i18n
+
+import (
+	"golang.org/x/text/feature/plural"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message/catalog"
+)
+
+// entry is a message with its translations. Plain strings are translated
+// as is; plural messages select the translation by the count argument.
+type entry struct {
+	key string
+	en  catalog.Message // defaults to key
+	de  catalog.Message
+}
+
+func str(s string) catalog.Message { return catalog.String(s) }
+
+var entries = []entry{
+	// notify-user
+	{key: "New follower", de: str("Neuer Follower")},
+	{key: "Lost follower", de: str("Follower verloren")},
+	{
+		key: "%s (<https://twitter.com/%s|@%s>) followed you :tada:",
+		de:  str("%s (<https://twitter.com/%s|@%s>) folgt dir jetzt :tada:"),
+	},
+	{
+		key: "%s (<https://twitter.com/%s|@%s>) unfollowed you",
+		de:  str("%s (<https://twitter.com/%s|@%s>) folgt dir nicht mehr"),
+	},
+	{
+		key: "%s (<https://twitter.com/%s|@%s>) followed you again",
+		de:  str("%s (<https://twitter.com/%s|@%s>) folgt dir wieder"),
+	},
+	{key: "Follower returned", de: str("Follower zurück")},
+	{
+		key: ":warning: Came back %d times recently, they keep unfollowing and refollowing you%s",
+		de:  str(":warning: Kam zuletzt %d-mal zurück und entfolgt dir immer wieder%s"),
+	},
+	{key: "User with ID %s was deleted", de: str("Der Account mit der ID %s wurde gelöscht")},
+	{key: "User with ID %s was suspended", de: str("Der Account mit der ID %s wurde gesperrt")},
+	{key: "User with ID %s deactivated their account", de: str("Der Account mit der ID %s wurde deaktiviert")},
+	{
+		key: "%s (<https://twitter.com/%s|@%s>) went protected and is no longer listed as your follower",
+		de:  str("%s (<https://twitter.com/%s|@%s>) ist jetzt geschützt und wird nicht mehr als dein Follower angezeigt"),
+	},
+	{key: "User with ID %s blocked you", de: str("Der Account mit der ID %s hat dich blockiert")},
+	{key: "User with ID %s followed you :tada:", de: str("Der Account mit der ID %s folgt dir jetzt :tada:")},
+	{key: "User with ID %s unfollowed you", de: str("Der Account mit der ID %s folgt dir nicht mehr")},
+	{key: "User with ID %s followed you again", de: str("Der Account mit der ID %s folgt dir wieder")},
+	{
+		key: "User with ID %s went protected and is no longer listed as your follower",
+		de:  str("Der Account mit der ID %s ist jetzt geschützt und wird nicht mehr als dein Follower angezeigt"),
+	},
+	{key: "You removed the user with ID %s from your followers", de: str("Du hast den Account mit der ID %s als Follower entfernt")},
+	{
+		key: "%s (<https://twitter.com/%s|@%s>) blocked you",
+		de:  str("%s (<https://twitter.com/%s|@%s>) hat dich blockiert"),
+	},
+	{
+		key: "You removed %s (<https://twitter.com/%s|@%s>) from your followers",
+		de:  str("Du hast %s (<https://twitter.com/%s|@%s>) als Follower entfernt"),
+	},
+	{key: "*Bio:* %s%s", de: str("*Bio:* %s%s")},
+	{key: "*Location:* %s%s", de: str("*Ort:* %s%s")},
+	{key: "*Followers:* %d%s", de: str("*Follower:* %d%s")},
+	{
+		key: "You (@%s) now have %d Twitter followers",
+		en: plural.Selectf(2, "%d",
+			plural.One, "You (@%s) now have %d Twitter follower",
+			plural.Other, "You (@%s) now have %d Twitter followers",
+		),
+		de: str("Du (@%s) hast jetzt %d Twitter-Follower"),
+	},
+	{
+		key: "%d follower changes during quiet hours",
+		en: plural.Selectf(1, "%d",
+			plural.One, "%d follower change during quiet hours",
+			plural.Other, "%d follower changes during quiet hours",
+		),
+		de: plural.Selectf(1, "%d",
+			plural.One, "%d Änderung bei deinen Followern während der Ruhezeit",
+			plural.Other, "%d Änderungen bei deinen Followern während der Ruhezeit",
+		),
+	},
+	{
+		key: "%d follower changes",
+		en: plural.Selectf(1, "%d",
+			plural.One, "%d follower change",
+			plural.Other, "%d follower changes",
+		),
+		de: plural.Selectf(1, "%d",
+			plural.One, "%d Änderung bei deinen Followern",
+			plural.Other, "%d Änderungen bei deinen Followern",
+		),
+	},
+	{key: "*New followers:*", de: str("*Neue Follower:*")},
+	{key: "*Lost followers:*", de: str("*Verlorene Follower:*")},
+	{key: "…and %d more", de: str("…und %d weitere")},
+	{key: "See all changes on your <%s|dashboard>", de: str("Alle Änderungen findest du in deinem <%s|Dashboard>")},
+	{
+		key: "You reached %d followers",
+		en: plural.Selectf(1, "%d",
+			plural.One, "You reached %d follower",
+			plural.Other, "You reached %d followers",
+		),
+		de: str("Du hast %d Follower erreicht"),
+	},
+	{
+		key: "Congratulations! Your Twitter account @%s has %d followers now :trophy:",
+		de:  str("Glückwunsch! Dein Twitter-Account @%s hat jetzt %d Follower :trophy:"),
+	},
+	{key: "Manage your alerts at <%s|Listkeeper>", de: str("Verwalte deine Benachrichtigungen bei <%s|Listkeeper>")},
+	{
+		key: ":rotating_light: Lost %d followers at once",
+		en: plural.Selectf(1, "%d",
+			plural.One, ":rotating_light: Lost %d follower at once",
+			plural.Other, ":rotating_light: Lost %d followers at once",
+		),
+		de: str(":rotating_light: %d Follower auf einmal verloren"),
+	},
+	{
+		key: "%d of your %d followers (%.1f%%) are gone since the last check.",
+		de:  str("%d deiner %d Follower (%.1f %%) sind seit der letzten Prüfung weg."),
+	},
+	{key: ":chart_with_upwards_trend: Unusual follower activity", de: str(":chart_with_upwards_trend: Ungewöhnliche Aktivität bei deinen Followern")},
+	{
+		key: "%d new and %d lost followers since the last check, compared with %.1f changes per check on average.",
+		de:  str("%d neue und %d verlorene Follower seit der letzten Prüfung, verglichen mit durchschnittlich %.1f Änderungen pro Prüfung."),
+	},
+	{key: "Please reconnect your account", de: str("Bitte verbinde deinen Account erneut")},
+	{
+		key: "Listkeeper can no longer access your Twitter account @%s, most likely because access was revoked. Please <%s|log in again> to resume tracking your followers.",
+		de:  str("Listkeeper kann nicht mehr auf deinen Twitter-Account @%s zugreifen, vermutlich weil der Zugriff widerrufen wurde. Bitte <%s|melde dich erneut an>, um deine Follower weiter zu verfolgen."),
+	},
+	{
+		key: "Tracking of your followers is paused until you log in again",
+		de:  str("Das Verfolgen deiner Follower ist pausiert, bis du dich erneut anmeldest"),
+	},
+
+	// resolve-graphql
+	{key: "[Test] %s", de: str("[Test] %s")},
+
+	// send-digests
+	{key: "Your daily follower digest", de: str("Deine tägliche Follower-Zusammenfassung")},
+	{key: "Your weekly follower digest", de: str("Deine wöchentliche Follower-Zusammenfassung")},
+	{key: "No follower changes, all quiet :zzz:", de: str("Keine Änderungen bei deinen Followern, alles ruhig :zzz:")},
+	{key: "*Net change:* %+d\n", de: str("*Veränderung:* %+d\n")},
+	{key: "*Gained:* %d\n", de: str("*Gewonnen:* %d\n")},
+	{key: "*Lost:* %d", de: str("*Verloren:* %d")},
+	{key: " (%d deactivated, %d suspended)", de: str(" (%d deaktiviert, %d gesperrt)")},
+	{key: "\n*Notable new followers:*\n", de: str("\n*Bemerkenswerte neue Follower:*\n")},
+	{key: "\n*Notable lost followers:*\n", de: str("\n*Bemerkenswerte verlorene Follower:*\n")},
+	{
+		key: "• %s (<https://twitter.com/%s|@%s>), %d followers\n",
+		en: plural.Selectf(4, "%d",
+			plural.One, "• %s (<https://twitter.com/%s|@%s>), %d follower\n",
+			plural.Other, "• %s (<https://twitter.com/%s|@%s>), %d followers\n",
+		),
+		de: str("• %s (<https://twitter.com/%s|@%s>), %d Follower\n"),
+	},
+	{
+		key: "You (@%s) have %d Twitter followers",
+		en: plural.Selectf(2, "%d",
+			plural.One, "You (@%s) have %d Twitter follower",
+			plural.Other, "You (@%s) have %d Twitter followers",
+		),
+		de: str("Du (@%s) hast %d Twitter-Follower"),
+	},
+	{key: "Manage your digest at <%s|Listkeeper>", de: str("Verwalte deine Zusammenfassung bei <%s|Listkeeper>")},
+
+	// serve-feed
+	{key: "Followers of @%s", de: str("Follower von @%s")},
+
+	// Slack actions
+	{key: "Ignore this account", de: str("Account ignorieren")},
+	{key: "Remove follower", de: str("Follower entfernen")},
+	{key: "Block", de: str("Blockieren")},
+	{key: "Block this account?", de: str("Diesen Account blockieren?")},
+	{
+		key: "They won't be able to follow you or see your tweets.",
+		de:  str("Der Account kann dir dann nicht mehr folgen oder deine Tweets sehen."),
+	},
+	{key: "Cancel", de: str("Abbrechen")},
+	{key: ":mute: %s is ignored from now on", de: str(":mute: %s wird ab jetzt ignoriert")},
+	{key: ":wave: %s was removed from your followers", de: str(":wave: %s wurde aus deinen Followern entfernt")},
+	{key: ":no_entry_sign: %s was blocked", de: str(":no_entry_sign: %s wurde blockiert")},
+	{key: ":warning: %s was removed from your followers, but is still blocked. Unblock them on Twitter to let them follow you again.", de: str(":warning: %s wurde aus deinen Followern entfernt, ist aber noch blockiert. Hebe die Blockierung auf Twitter auf, damit der Account dir wieder folgen kann.")},
+	{
+		key: "Sorry, that didn't work. Please try again later.",
+		de:  str("Das hat leider nicht geklappt. Bitte versuche es später noch einmal."),
+	},
+}
+
+var messages = newCatalog()
+
+func newCatalog() *catalog.Builder {
+	b := catalog.NewBuilder(catalog.Fallback(language.English))
+	for _, e := range entries {
+		en := e.en
+		if en == nil {
+			en = str(e.key)
+		}
+		if err := b.Set(language.English, e.key, en); err != nil {
+			panic(err)
+		}
+		if e.de != nil {
+			if err := b.Set(language.German, e.key, e.de); err != nil {
+				panic(err)
+			}
+		}
+	}
+	return b
+}
@@ -0,0 +1,56 @@
+// Package i18n translates notifications into the language of the user.
+//
+// Messages are keyed by their English format string, so every string passed
+// to a message.Printer is a translation unit. To find new or changed
+// messages, run go generate and compare locales/*/out.gotext.json with the
+// translations in catalog.go.
+package i18n
+
+//go:generate gotext -srclang=en extract -lang=en,de github.com/mlafeldt/listkeeper/functions/internal/notifyuser github.com/mlafeldt/listkeeper/functions/internal/senddigests github.com/mlafeldt/listkeeper/functions/internal/notify github.com/mlafeldt/listkeeper/functions/internal/slackapp github.com/mlafeldt/listkeeper/functions/internal/resolvegraphql github.com/mlafeldt/listkeeper/functions/internal/servefeed
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// NewPrinter returns a printer that translates messages and formats numbers
+// for the given language.
+func NewPrinter(tag language.Tag) *message.Printer {
+	return message.NewPrinter(tag, message.Catalog(messages))
+}
+
+// Date formats a date including the weekday, e.g. "Friday, Nov 6, 2020".
+func Date(tag language.Tag, t time.Time) string {
+	if tag == language.German {
+		return fmt.Sprintf("%s, %s", germanWeekdays[t.Weekday()], germanDate(t, true))
+	}
+	return t.Format("Monday, Jan 2, 2006")
+}
+
+// DateRange formats the dates from the first to the last day of a period,
+// e.g. "Nov 2 – Nov 8, 2020".
+func DateRange(tag language.Tag, first, last time.Time) string {
+	if tag == language.German {
+		return fmt.Sprintf("%s – %s", germanDate(first, false), germanDate(last, true))
+	}
+	return fmt.Sprintf("%s – %s", first.Format("Jan 2"), last.Format("Jan 2, 2006"))
+}
+
+var germanWeekdays = [...]string{
+	"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag",
+}
+
+var germanMonths = [...]string{
+	"Jan.", "Feb.", "März", "Apr.", "Mai", "Juni", "Juli", "Aug.", "Sept.", "Okt.", "Nov.", "Dez.",
+}
+
+func germanDate(t time.Time, year bool) string {
+	s := fmt.Sprintf("%d. %s", t.Day(), germanMonths[t.Month()-1])
+	if year {
+		s += fmt.Sprintf(" %d", t.Year())
+	}
+	return s
+}
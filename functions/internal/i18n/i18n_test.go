@@ -0,0 +1,73 @@
+package i18n
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+func TestNewPrinter(t *testing.T) {
+	tests := []struct {
+		lang language.Tag
+		key  string
+		args []interface{}
+		want string
+	}{
+		{language.English, "New follower", nil, "New follower"},
+		{language.English, "You (@%s) now have %d Twitter followers", []interface{}{"alice", 1}, "You (@alice) now have 1 Twitter follower"},
+		{language.English, "You (@%s) now have %d Twitter followers", []interface{}{"alice", 1234}, "You (@alice) now have 1,234 Twitter followers"},
+		{language.English, "%d follower changes during quiet hours", []interface{}{1}, "1 follower change during quiet hours"},
+		{language.English, "%d follower changes during quiet hours", []interface{}{2}, "2 follower changes during quiet hours"},
+		{language.English, "*Net change:* %+d\n", []interface{}{-1500}, "*Net change:* -1,500\n"},
+		{language.English, "Not in the catalog %d", []interface{}{1000}, "Not in the catalog 1,000"},
+
+		{language.German, "New follower", nil, "Neuer Follower"},
+		{language.German, "You (@%s) now have %d Twitter followers", []interface{}{"alice", 1}, "Du (@alice) hast jetzt 1 Twitter-Follower"},
+		{language.German, "You (@%s) now have %d Twitter followers", []interface{}{"alice", 1234}, "Du (@alice) hast jetzt 1.234 Twitter-Follower"},
+		{language.German, "%d follower changes during quiet hours", []interface{}{1}, "1 Änderung bei deinen Followern während der Ruhezeit"},
+		{language.German, "%d follower changes during quiet hours", []interface{}{2}, "2 Änderungen bei deinen Followern während der Ruhezeit"},
+		{language.German, "*Net change:* %+d\n", []interface{}{-1500}, "*Veränderung:* -1.500\n"},
+		{language.German, "Not in the catalog %d", []interface{}{1000}, "Not in the catalog 1.000"},
+	}
+
+	for _, test := range tests {
+		got := NewPrinter(test.lang).Sprintf(test.key, test.args...)
+		if got != test.want {
+			t.Errorf("%s: %q: want %q, got %q", test.lang, test.key, test.want, got)
+		}
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	for _, e := range entries {
+		if e.de == nil {
+			t.Errorf("missing German translation of %q", e.key)
+		}
+	}
+}
+
+func TestDate(t *testing.T) {
+	var (
+		first = time.Date(2020, 11, 2, 0, 0, 0, 0, time.UTC)
+		last  = time.Date(2020, 11, 8, 0, 0, 0, 0, time.UTC)
+	)
+
+	tests := []struct {
+		lang      language.Tag
+		date      string
+		dateRange string
+	}{
+		{language.English, "Monday, Nov 2, 2020", "Nov 2 – Nov 8, 2020"},
+		{language.German, "Montag, 2. Nov. 2020", "2. Nov. – 8. Nov. 2020"},
+	}
+
+	for _, test := range tests {
+		if got := Date(test.lang, first); got != test.date {
+			t.Errorf("%s: want %q, got %q", test.lang, test.date, got)
+		}
+		if got := DateRange(test.lang, first, last); got != test.dateRange {
+			t.Errorf("%s: want %q, got %q", test.lang, test.dateRange, got)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Package lambdaurl serves HTTP handlers via Lambda function URLs, so that
+// the same handler can be mounted in self-hosted mode.
+package lambdaurl
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Serve passes the request to the handler and returns its response.
+func Serve(ctx context.Context, h http.Handler, req events.LambdaFunctionURLRequest) (*events.LambdaFunctionURLResponse, error) {
+	body := []byte(req.Body)
+	if req.IsBase64Encoded {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(req.Body); err != nil {
+			return &events.LambdaFunctionURLResponse{StatusCode: http.StatusBadRequest}, nil
+		}
+	}
+
+	u := url.URL{
+		Scheme:   "https",
+		Host:     req.RequestContext.DomainName,
+		Path:     req.RawPath,
+		RawQuery: req.RawQueryString,
+	}
+	r, err := http.NewRequestWithContext(ctx, req.RequestContext.HTTP.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range req.Headers {
+		r.Header.Set(k, v)
+	}
+
+	w := &responseWriter{header: http.Header{}, status: http.StatusOK}
+	h.ServeHTTP(w, r)
+
+	resp := &events.LambdaFunctionURLResponse{
+		StatusCode: w.status,
+		Headers:    map[string]string{},
+		Body:       w.body.String(),
+	}
+	for k := range w.header {
+		resp.Headers[k] = w.header.Get(k)
+	}
+	return resp, nil
+}
+
+// responseWriter records the response to a Lambda request.
+type responseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseWriter) Header() http.Header { return w.header }
+
+func (w *responseWriter) Write(b []byte) (int, error) { return w.body.Write(b) }
+
+func (w *responseWriter) WriteHeader(status int) { w.status = status }
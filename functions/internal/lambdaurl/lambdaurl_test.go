@@ -0,0 +1,54 @@
+package lambdaurl
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestServe(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, r.Method+" "+r.URL.String()+" "+r.Header.Get("X-Token")+" "+string(body)) //nolint:errcheck
+	})
+
+	req := events.LambdaFunctionURLRequest{
+		RawPath:         "/slack/actions",
+		RawQueryString:  "a=1",
+		Headers:         map[string]string{"x-token": "secret"},
+		Body:            base64.StdEncoding.EncodeToString([]byte("payload")),
+		IsBase64Encoded: true,
+	}
+	req.RequestContext.DomainName = "example.lambda-url.us-east-1.on.aws"
+	req.RequestContext.HTTP.Method = http.MethodPost
+
+	resp, err := Serve(context.Background(), h, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &events.LambdaFunctionURLResponse{
+		StatusCode: http.StatusCreated,
+		Headers:    map[string]string{"Content-Type": "text/plain"},
+		Body:       "POST https://example.lambda-url.us-east-1.on.aws/slack/actions?a=1 secret payload",
+	}
+	if diff := cmp.Diff(want, resp); diff != "" {
+		t.Error(diff)
+	}
+
+	req.Body = "not base64"
+	resp, err = Serve(context.Background(), h, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status %d", resp.StatusCode)
+	}
+}
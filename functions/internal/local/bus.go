@@ -0,0 +1,100 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/segmentio/ksuid"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/evb"
+)
+
+var _ evb.API = (*Bus)(nil)
+
+// Bus is an in-process event bus. Events are delivered to the subscribers of
+// their type right away, in the same shape EventBridge would deliver them.
+// Like with EventBridge, failing subscribers don't affect the sender; their
+// errors are collected instead.
+type Bus struct {
+	source string
+
+	mu          sync.Mutex
+	subscribers map[string][]func(context.Context, events.CloudWatchEvent) error
+	sent        []events.CloudWatchEvent
+	errs        []error
+}
+
+func NewBus(source string) *Bus {
+	return &Bus{
+		source:      source,
+		subscribers: map[string][]func(context.Context, events.CloudWatchEvent) error{},
+	}
+}
+
+// Subscribe registers a function to be called for every event of a type.
+func (b *Bus) Subscribe(eventType string, fn func(context.Context, events.CloudWatchEvent) error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subscribers[eventType] = append(b.subscribers[eventType], fn)
+}
+
+func (b *Bus) Send(ctx context.Context, eventType string, events ...interface{}) error {
+	for _, e := range events {
+		if err := b.send(ctx, eventType, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bus) send(ctx context.Context, eventType string, event interface{}) error {
+	detail, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	e := events.CloudWatchEvent{
+		Version:    "0",
+		ID:         ksuid.New().String(),
+		DetailType: eventType,
+		Source:     b.source,
+		Time:       time.Now().UTC(),
+		Detail:     detail,
+	}
+
+	b.mu.Lock()
+	b.sent = append(b.sent, e)
+	subscribers := b.subscribers[eventType]
+	b.mu.Unlock()
+
+	for _, fn := range subscribers {
+		if err := fn(ctx, e); err != nil {
+			b.mu.Lock()
+			b.errs = append(b.errs, fmt.Errorf("%s: %w", eventType, err))
+			b.mu.Unlock()
+		}
+	}
+
+	return nil
+}
+
+// Sent returns all events sent so far.
+func (b *Bus) Sent() []events.CloudWatchEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]events.CloudWatchEvent(nil), b.sent...)
+}
+
+// Errors returns the errors of all failed deliveries so far.
+func (b *Bus) Errors() []error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]error(nil), b.errs...)
+}
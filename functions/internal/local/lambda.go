@@ -0,0 +1,163 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	lambdasvc "github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+)
+
+// Function is a handler invoked with a JSON payload.
+type Function func(ctx context.Context, payload []byte) ([]byte, error)
+
+// Lambda invokes registered functions in-process. Asynchronous invocations
+// run to completion before InvokeWithContext returns, unless workers were
+// started with Serve, and their errors are collected instead of being
+// returned to the caller.
+type Lambda struct {
+	lambdaiface.LambdaAPI
+
+	mu        sync.Mutex
+	functions map[string]Function
+	errs      []error
+	pending   int
+	idle      *sync.Cond
+
+	queueMu sync.RWMutex
+	queue   chan invocation
+}
+
+type invocation struct {
+	name    string
+	fn      Function
+	payload []byte
+}
+
+// queueSize bounds the number of pending asynchronous invocations. Callers
+// block once the queue is full.
+const queueSize = 100
+
+func NewLambda() *Lambda {
+	l := &Lambda{functions: map[string]Function{}}
+	l.idle = sync.NewCond(&l.mu)
+	return l
+}
+
+// Register makes a function available under the given name.
+func (l *Lambda) Register(name string, fn Function) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.functions[name] = fn
+}
+
+func (l *Lambda) InvokeWithContext(ctx aws.Context, in *lambdasvc.InvokeInput, _ ...request.Option) (*lambdasvc.InvokeOutput, error) {
+	name := aws.StringValue(in.FunctionName)
+
+	l.mu.Lock()
+	fn, ok := l.functions[name]
+	l.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("function %s not found", name)
+	}
+
+	if aws.StringValue(in.InvocationType) == lambdasvc.InvocationTypeEvent {
+		inv := invocation{name: name, fn: fn, payload: in.Payload}
+
+		l.mu.Lock()
+		l.pending++
+		l.mu.Unlock()
+
+		l.queueMu.RLock()
+		queued := l.queue != nil
+		if queued {
+			l.queue <- inv
+		}
+		l.queueMu.RUnlock()
+
+		if !queued {
+			l.runAsync(ctx, inv)
+		}
+		return &lambdasvc.InvokeOutput{StatusCode: aws.Int64(202)}, nil //nolint:gomnd
+	}
+
+	payload, err := fn(ctx, in.Payload)
+	if err != nil {
+		return &lambdasvc.InvokeOutput{
+			StatusCode:    aws.Int64(200), //nolint:gomnd
+			FunctionError: aws.String("Unhandled"),
+			Payload:       []byte(fmt.Sprintf("%q", err.Error())),
+		}, nil
+	}
+
+	return &lambdasvc.InvokeOutput{StatusCode: aws.Int64(200), Payload: payload}, nil //nolint:gomnd
+}
+
+func (l *Lambda) runAsync(ctx context.Context, inv invocation) {
+	_, err := inv.fn(ctx, inv.payload)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: %w", inv.name, err))
+	}
+	l.pending--
+	if l.pending == 0 {
+		l.idle.Broadcast()
+	}
+}
+
+// Serve runs asynchronous invocations on a fixed number of workers until ctx
+// is done. Queued invocations are finished before Serve returns.
+func (l *Lambda) Serve(ctx context.Context, workers int) {
+	queue := make(chan invocation, queueSize)
+
+	l.queueMu.Lock()
+	l.queue = queue
+	l.queueMu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for inv := range queue {
+				// Like on AWS, invocations outlive their caller
+				l.runAsync(context.Background(), inv)
+			}
+		}()
+	}
+
+	<-ctx.Done()
+
+	l.queueMu.Lock()
+	l.queue = nil
+	close(queue)
+	l.queueMu.Unlock()
+
+	wg.Wait()
+}
+
+// Wait blocks until all asynchronous invocations have finished.
+func (l *Lambda) Wait() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.pending > 0 {
+		l.idle.Wait()
+	}
+}
+
+// Errors returns the errors of all failed asynchronous invocations so far.
+func (l *Lambda) Errors() []error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]error(nil), l.errs...)
+}
@@ -0,0 +1,122 @@
+package local
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// Mail is a message received by SMTPServer.
+type Mail struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+// SMTPServer is a minimal SMTP server that accepts all mail and keeps it in
+// memory. It speaks just enough of the protocol for net/smtp.
+type SMTPServer struct {
+	ln net.Listener
+
+	mu   sync.Mutex
+	mail []Mail
+}
+
+// NewSMTPServer listens on a random local port.
+func NewSMTPServer() (*SMTPServer, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SMTPServer{ln: ln}
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the address to send mail to.
+func (s *SMTPServer) Addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *SMTPServer) Close() error {
+	return s.ln.Close()
+}
+
+// Mail returns all messages received so far.
+func (s *SMTPServer) Mail() []Mail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Mail(nil), s.mail...)
+}
+
+func (s *SMTPServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *SMTPServer) handle(conn net.Conn) {
+	c := textproto.NewConn(conn)
+	defer c.Close()
+
+	reply := func(format string, args ...interface{}) bool {
+		return c.PrintfLine(format, args...) == nil
+	}
+
+	if !reply("220 localhost SMTP ready") {
+		return
+	}
+
+	var m Mail
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			m = Mail{From: address(line[len("MAIL FROM:"):])}
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			m.To = append(m.To, address(line[len("RCPT TO:"):]))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			data, err := c.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			m.Data = data
+			s.mu.Lock()
+			s.mail = append(s.mail, m)
+			s.mu.Unlock()
+			reply("250 OK")
+		case cmd == "RSET", cmd == "NOOP":
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func address(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		s = s[:i] // drop parameters like BODY=8BITMIME
+	}
+	return strings.Trim(s, "<>")
+}
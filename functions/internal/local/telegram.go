@@ -0,0 +1,83 @@
+package local
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// TelegramCall is a Bot API method called on TelegramAPI.
+type TelegramCall struct {
+	Token  string
+	Method string
+	Params map[string]interface{}
+}
+
+// TelegramAPI is a fake of the Telegram Bot API that records all calls and
+// reports success, except for chats listed in Blocked.
+type TelegramAPI struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	calls   []TelegramCall
+	blocked map[float64]bool
+}
+
+func NewTelegramAPI() *TelegramAPI {
+	api := &TelegramAPI{blocked: map[float64]bool{}}
+	api.Server = httptest.NewServer(http.HandlerFunc(api.handle))
+	return api
+}
+
+// Block makes calls for the chat fail as if the user blocked the bot.
+func (api *TelegramAPI) Block(chatID int64) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	api.blocked[float64(chatID)] = true
+}
+
+// Calls returns all calls made so far.
+func (api *TelegramAPI) Calls() []TelegramCall {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	return append([]TelegramCall(nil), api.calls...)
+}
+
+func (api *TelegramAPI) handle(w http.ResponseWriter, r *http.Request) {
+	// Requests go to /bot<token>/<method>
+	path := strings.TrimPrefix(r.URL.Path, "/bot")
+	i := strings.LastIndexByte(path, '/')
+	if i < 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	call := TelegramCall{Token: path[:i], Method: path[i+1:]}
+	if err := json.NewDecoder(r.Body).Decode(&call.Params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	chatID, _ := call.Params["chat_id"].(float64)
+
+	api.mu.Lock()
+	api.calls = append(api.calls, call)
+	blocked := api.blocked[chatID]
+	api.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if blocked {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
+			"ok":          false,
+			"error_code":  http.StatusForbidden,
+			"description": "Forbidden: bot was blocked by the user",
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": map[string]interface{}{}}) //nolint:errcheck
+}
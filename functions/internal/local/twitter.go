@@ -0,0 +1,235 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+var _ twitter.API = (*Twitter)(nil)
+
+// Account is a Twitter user that signed up for Listkeeper.
+type Account struct {
+	User         twitter.User `json:"user"`
+	AccessToken  string       `json:"accessToken"`
+	AccessSecret string       `json:"accessSecret"`
+	Followers    []int64      `json:"followers"`
+	Blocked      []int64      `json:"blocked,omitempty"`
+}
+
+// Fixture describes the state of the fake Twitter API, e.g. in a JSON file.
+type Fixture struct {
+	Accounts  []Account       `json:"accounts"`
+	Users     []*twitter.User `json:"users"`
+	Suspended []int64         `json:"suspended,omitempty"`
+}
+
+// Twitter is a fake Twitter API serving accounts and users from memory.
+// Accounts are identified by their access token, like with the real API.
+type Twitter struct {
+	mu        sync.Mutex
+	accounts  map[string]*Account
+	users     map[int64]*twitter.User
+	suspended map[int64]bool
+}
+
+func NewTwitter(fixture *Fixture) *Twitter {
+	t := &Twitter{
+		accounts:  map[string]*Account{},
+		users:     map[int64]*twitter.User{},
+		suspended: map[int64]bool{},
+	}
+
+	for i := range fixture.Accounts {
+		t.AddAccount(fixture.Accounts[i])
+	}
+	for _, u := range fixture.Users {
+		t.AddUser(u)
+	}
+	for _, id := range fixture.Suspended {
+		t.suspended[id] = true
+	}
+
+	return t
+}
+
+// LoadTwitter reads a fixture from a JSON file.
+func LoadTwitter(path string) (*Twitter, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fixture Fixture
+	if err := json.Unmarshal(buf, &fixture); err != nil {
+		return nil, err
+	}
+
+	return NewTwitter(&fixture), nil
+}
+
+// Accounts returns all accounts that signed up.
+func (t *Twitter) Accounts() []Account {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	accounts := make([]Account, 0, len(t.accounts))
+	for _, a := range t.accounts {
+		accounts = append(accounts, *a)
+	}
+	return accounts
+}
+
+func (t *Twitter) AddAccount(a Account) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.accounts[a.AccessToken] = &a
+	if id, err := strconv.ParseInt(a.User.ID, 10, 64); err == nil { //nolint:gomnd
+		t.users[id] = &a.User
+	}
+}
+
+func (t *Twitter) AddUser(u *twitter.User) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if id, err := strconv.ParseInt(u.ID, 10, 64); err == nil { //nolint:gomnd
+		t.users[id] = u
+	}
+}
+
+// SetFollowers replaces the followers of the account with the access token.
+func (t *Twitter) SetFollowers(accessToken string, ids []int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if a, ok := t.accounts[accessToken]; ok {
+		a.Followers = ids
+	}
+}
+
+// RevokeToken makes all further requests with the access token fail.
+func (t *Twitter) RevokeToken(accessToken string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.accounts, accessToken)
+}
+
+// Suspend makes the user appear as suspended.
+func (t *Twitter) Suspend(id int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.suspended[id] = true
+}
+
+func (t *Twitter) FollowerIDs(ctx context.Context, accessToken, accessSecret string) (*twitter.FollowerIDs, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a, ok := t.accounts[accessToken]
+	if !ok || a.AccessSecret != accessSecret {
+		return nil, twitter.ErrInvalidToken
+	}
+	return &twitter.FollowerIDs{IDs: append([]int64{}, a.Followers...), Pages: 1}, nil
+}
+
+func (t *Twitter) CurrentUser(ctx context.Context, accessToken, accessSecret string) (*twitter.User, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a, ok := t.accounts[accessToken]
+	if !ok || a.AccessSecret != accessSecret {
+		return nil, twitter.ErrInvalidToken
+	}
+	u := a.User
+	u.TotalFollowers = len(a.Followers)
+	return &u, nil
+}
+
+func (t *Twitter) UserByID(ctx context.Context, accessToken, accessSecret string, userID int64) (*twitter.User, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.accounts[accessToken]; !ok {
+		return nil, twitter.ErrInvalidToken
+	}
+	if t.suspended[userID] {
+		return nil, twitter.ErrUserSuspended
+	}
+	u, ok := t.users[userID]
+	if !ok {
+		return nil, twitter.ErrUserNotFound
+	}
+	c := *u
+	return &c, nil
+}
+
+func (t *Twitter) Relationship(ctx context.Context, accessToken, accessSecret string, userID int64) (*twitter.Relationship, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a, ok := t.accounts[accessToken]
+	if !ok || a.AccessSecret != accessSecret {
+		return nil, twitter.ErrInvalidToken
+	}
+	if _, ok := t.users[userID]; !ok {
+		return nil, twitter.ErrUserNotFound
+	}
+	return &twitter.Relationship{
+		FollowedBy: contains(a.Followers, userID),
+		Blocking:   contains(a.Blocked, userID),
+	}, nil
+}
+
+func contains(ids []int64, id int64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Twitter) RemoveFollower(ctx context.Context, accessToken, accessSecret string, userID int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a, ok := t.accounts[accessToken]
+	if !ok || a.AccessSecret != accessSecret {
+		return twitter.ErrInvalidToken
+	}
+	if _, ok := t.users[userID]; !ok {
+		return twitter.ErrUserNotFound
+	}
+	followers := a.Followers[:0:0]
+	for _, id := range a.Followers {
+		if id != userID {
+			followers = append(followers, id)
+		}
+	}
+	a.Followers = followers
+	return nil
+}
+
+// BlockUser behaves like RemoveFollower, as blocked users cannot follow, but
+// also remembers the block.
+func (t *Twitter) BlockUser(ctx context.Context, accessToken, accessSecret string, userID int64) error {
+	if err := t.RemoveFollower(ctx, accessToken, accessSecret, userID); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if a, ok := t.accounts[accessToken]; ok && !contains(a.Blocked, userID) {
+		a.Blocked = append(a.Blocked, userID)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package notify
+
+import (
+	"github.com/kelseyhightower/envconfig"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/secret"
+)
+
+// Config configures the channels of a registry. Optional channels are
+// disabled if left empty.
+type Config struct {
+	SlackUsername string `envconfig:"SLACK_USERNAME" required:"true"`
+	SlackIconURL  string `envconfig:"SLACK_ICON_URL" required:"true"`
+	SlackTokenKey string `envconfig:"SLACK_TOKEN_KEY"` // the Slack app is disabled if empty
+	AppURL        string `envconfig:"APP_URL" default:"https://listkeeper.io"`
+	SMTP          struct {
+		Addr     string `envconfig:"SMTP_ADDR"` // email is disabled if empty
+		Username string `envconfig:"SMTP_USERNAME"`
+		Password string `envconfig:"SMTP_PASSWORD"`
+	}
+	EmailFrom        string `envconfig:"EMAIL_FROM" default:"Listkeeper <notifications@listkeeper.io>"`
+	TelegramBotToken string `envconfig:"TELEGRAM_BOT_TOKEN"` // Telegram is disabled if empty
+	VAPIDPrivateKey  string `envconfig:"VAPID_PRIVATE_KEY"`  // Web Push is disabled if empty
+}
+
+// NewRegistryFromEnv returns a registry with the channels configured by
+// environment variables, as set on all functions that notify users.
+func NewRegistryFromEnv(table data.TableAPI) (*Registry, error) {
+	var cfg Config
+	if err := envconfig.Process("", &cfg); err != nil {
+		return nil, err
+	}
+	return NewRegistryFromConfig(&cfg, table)
+}
+
+// NewRegistryFromConfig returns a registry with all channels enabled by cfg.
+func NewRegistryFromConfig(cfg *Config, table data.TableAPI) (*Registry, error) {
+	slack := &Slack{
+		Username: cfg.SlackUsername,
+		IconURL:  cfg.SlackIconURL,
+		Table:    table,
+	}
+	if cfg.SlackTokenKey != "" {
+		box, err := secret.NewBox(cfg.SlackTokenKey)
+		if err != nil {
+			return nil, err
+		}
+		slack.Tokens = box
+	}
+
+	r := NewRegistry()
+	r.Register(ChannelSlack, slack)
+	r.Register(ChannelDiscord, &Discord{
+		Username:  cfg.SlackUsername,
+		AvatarURL: cfg.SlackIconURL,
+	})
+
+	if cfg.SMTP.Addr != "" {
+		email, err := NewEmail(cfg.SMTP.Addr, cfg.SMTP.Username, cfg.SMTP.Password, cfg.EmailFrom)
+		if err != nil {
+			return nil, err
+		}
+		r.Register(ChannelEmail, email)
+	}
+
+	if cfg.TelegramBotToken != "" {
+		r.Register(ChannelTelegram, &Telegram{Token: cfg.TelegramBotToken})
+	}
+
+	if cfg.VAPIDPrivateKey != "" {
+		key, err := ParseVAPIDKey(cfg.VAPIDPrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		r.Register(ChannelPush, &Push{
+			Key:     key,
+			Subject: cfg.AppURL,
+			URL:     cfg.AppURL,
+			Table:   table,
+		})
+	}
+
+	return r, nil
+}
@@ -0,0 +1,156 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+)
+
+const defaultDeliveryLease = 5 * time.Minute
+
+// Deliveries records sending follower events via the channels of a Registry,
+// see data.NotificationDelivery. Channels that already sent an event are
+// skipped, which makes notifications idempotent even though the event bus
+// delivers events at least once. A failed attempt is returned as an error so
+// that the invocation is retried; after data.MaxNotificationAttempts, the
+// delivery is given up and ends up on the dead-letter list, unless it is
+// about an alert, see Message.AlertID.
+type Deliveries struct {
+	Table data.TableAPI
+
+	// Lease is how long an attempt in progress keeps others from sending
+	// the same event via the same channel. Defaults to 5 minutes.
+	Lease time.Duration
+
+	// Now defaults to time.Now.
+	Now func() time.Time
+}
+
+func (ds *Deliveries) send(ctx context.Context, user *data.User, channel string, n Notifier, msg *Message) error {
+	var (
+		id  = msg.deliveryID()
+		now = ds.now()
+	)
+
+	d, err := ds.Table.GetNotificationDelivery(ctx, user.ID, id, channel)
+	switch {
+	case errors.Is(err, data.ErrNotificationNotFound):
+		if event := msg.deliveryEvent(); event != nil {
+			d = data.NewNotificationDelivery(event, channel, now)
+		} else {
+			d = data.NewAlertDelivery(user.ID, id, channel, now)
+		}
+	case err != nil:
+		return err
+	}
+
+	switch {
+	case d.Status == data.DeliveryStatusSent:
+		log.Printf("event %s already sent via %s", id, channel)
+		return nil
+	case d.Status == data.DeliveryStatusFailed:
+		log.Printf("event %s failed for good via %s, skipping", id, channel)
+		return nil
+	case d.Attempts > 0 && d.LastError == "" && now.Sub(d.UpdatedAt) < ds.lease():
+		log.Printf("event %s is being sent via %s, skipping", id, channel)
+		return nil
+	}
+
+	// Claim the delivery so that concurrent invocations don't send it too
+	d.Attempts++
+	d.LastError = ""
+	d.UpdatedAt = now
+	if err := ds.Table.SaveNotificationDelivery(ctx, d); err != nil {
+		if errors.Is(err, data.ErrNotificationConflict) {
+			log.Printf("event %s is being sent via %s, skipping", id, channel)
+			return nil
+		}
+		return err
+	}
+
+	rctx, resp := WithResponse(ctx)
+	sendErr := n.Notify(rctx, user, msg)
+
+	d.UpdatedAt = ds.now()
+	d.Response = resp.String()
+	switch {
+	case sendErr == nil:
+		d.Status = data.DeliveryStatusSent
+	case d.Attempts >= data.MaxNotificationAttempts:
+		d.Status = data.DeliveryStatusFailed
+		d.LastError = sendErr.Error()
+	default:
+		d.LastError = sendErr.Error()
+	}
+
+	if err := ds.Table.SaveNotificationDelivery(ctx, d); err != nil {
+		return err
+	}
+
+	if d.Status == data.DeliveryStatusFailed {
+		// Retrying the invocation would not change anything
+		log.Printf("giving up on event %s via %s after %d attempts: %s", id, channel, d.Attempts, sendErr)
+		return nil
+	}
+	return sendErr
+}
+
+func (ds *Deliveries) lease() time.Duration {
+	if ds.Lease != 0 {
+		return ds.Lease
+	}
+	return defaultDeliveryLease
+}
+
+func (ds *Deliveries) now() time.Time {
+	if ds.Now != nil {
+		return ds.Now()
+	}
+	return time.Now()
+}
+
+// Response is what the provider responded to a message.
+type Response struct {
+	StatusCode int    // of the HTTP response, if any
+	Text       string // e.g. the ID of the message
+}
+
+func (r *Response) String() string {
+	var parts []string
+	if r.StatusCode != 0 {
+		parts = append(parts, fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)))
+	}
+	if r.Text != "" {
+		parts = append(parts, r.Text)
+	}
+	return strings.Join(parts, ", ")
+}
+
+type responseKey struct{}
+
+// WithResponse returns a context in which notifiers record what the provider
+// responded to a message.
+func WithResponse(ctx context.Context) (context.Context, *Response) {
+	var resp Response
+	return context.WithValue(ctx, responseKey{}, &resp), &resp
+}
+
+// setResponse records the provider's response to a message, such as its ID.
+func setResponse(ctx context.Context, format string, args ...interface{}) {
+	if resp, ok := ctx.Value(responseKey{}).(*Response); ok {
+		resp.Text = fmt.Sprintf(format, args...)
+	}
+}
+
+// setStatusCode records the status of the provider's HTTP response.
+func setStatusCode(ctx context.Context, code int) {
+	if resp, ok := ctx.Value(responseKey{}).(*Response); ok {
+		resp.StatusCode = code
+	}
+}
@@ -0,0 +1,166 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+type respondingNotifier struct {
+	fakeNotifier
+}
+
+func (n *respondingNotifier) Notify(ctx context.Context, user *data.User, msg *Message) error {
+	if err := n.fakeNotifier.Notify(ctx, user, msg); err != nil {
+		return err
+	}
+	setResponse(ctx, "id=%d", len(n.sent))
+	return nil
+}
+
+func newTrackedRegistry(table data.TableAPI, now time.Time) (*Registry, *respondingNotifier, *fakeNotifier) {
+	var (
+		slack   = &respondingNotifier{fakeNotifier{enabled: true}}
+		discord = &fakeNotifier{enabled: true, err: errors.New("boom")}
+	)
+
+	r := NewRegistry()
+	r.TrackDeliveries(&Deliveries{Table: table, Now: func() time.Time { return now }})
+	r.Register(ChannelSlack, slack)
+	r.Register(ChannelDiscord, discord)
+	return r, slack, discord
+}
+
+func TestRegistry_NotifyTracked(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		now   = time.Date(2020, 11, 7, 9, 30, 0, 0, time.UTC)
+		table = data.NewMemoryTable()
+		user  = &data.User{ID: "111"}
+		event = &data.FollowerEvent{
+			ID:                  "some-event-id",
+			UserID:              "111",
+			FollowerState:       data.FollowerStateNew,
+			FollowerStateReason: data.FollowerStateReasonFollowed,
+			Follower:            &twitter.User{ID: "123"},
+		}
+		msg = &Message{Header: "New follower", Event: event}
+	)
+
+	r, slack, discord := newTrackedRegistry(table, now)
+
+	// The event bus delivers the event again after each failed invocation
+	for i := 1; i <= data.MaxNotificationAttempts; i++ {
+		err := r.Notify(ctx, user, msg)
+		if i < data.MaxNotificationAttempts && err == nil {
+			t.Errorf("attempt %d: expected error to retry invocation", i)
+		}
+		if i == data.MaxNotificationAttempts && err != nil {
+			t.Errorf("attempt %d: unexpected error after giving up: %s", i, err)
+		}
+	}
+	// Duplicate delivery by the event bus
+	if err := r.Notify(ctx, user, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(slack.sent) != 1 {
+		t.Errorf("expected slack to be notified once, got %d", len(slack.sent))
+	}
+	if len(discord.sent) != data.MaxNotificationAttempts {
+		t.Errorf("expected discord to be tried %d times, got %d", data.MaxNotificationAttempts, len(discord.sent))
+	}
+
+	sent, err := table.GetNotificationDelivery(ctx, user.ID, event.ID, ChannelSlack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent.Status != data.DeliveryStatusSent || sent.Attempts != 1 || sent.Response != "id=1" {
+		t.Errorf("unexpected slack delivery: %+v", sent)
+	}
+
+	dead, err := table.GetDeadLetters(ctx, user.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 {
+		t.Fatalf("expected one dead letter, got %d", len(dead))
+	}
+	want := data.NotificationDelivery{
+		UserID:    "111",
+		EventID:   "some-event-id",
+		Channel:   ChannelDiscord,
+		Status:    data.DeliveryStatusFailed,
+		Attempts:  data.MaxNotificationAttempts,
+		LastError: "boom",
+		Event:     event,
+		CreatedAt: now,
+		UpdatedAt: now,
+		ExpiresAt: now.Add(data.NotificationDeliveryTTL),
+		Version:   2 * data.MaxNotificationAttempts,
+	}
+	if diff := cmp.Diff(want, *dead[0]); diff != "" {
+		t.Error(diff)
+	}
+
+	// Replay after the channel was fixed
+	if err := dead[0].Requeue(now); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.SaveNotificationDelivery(ctx, dead[0]); err != nil {
+		t.Fatal(err)
+	}
+	discord.err = nil
+	if err := r.NotifyChannel(ctx, user, ChannelDiscord, msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.NotifyChannel(ctx, user, ChannelDiscord, msg); err != nil {
+		t.Fatal(err)
+	}
+	if len(discord.sent) != data.MaxNotificationAttempts+1 {
+		t.Errorf("expected replay to be sent once, got %d messages", len(discord.sent))
+	}
+	if dead, _ := table.GetDeadLetters(ctx, user.ID, 0); len(dead) != 0 {
+		t.Errorf("expected no dead letters after replay, got %d", len(dead))
+	}
+}
+
+func TestRegistry_NotifyInProgress(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		now   = time.Date(2020, 11, 7, 9, 30, 0, 0, time.UTC)
+		table = data.NewMemoryTable()
+		user  = &data.User{ID: "111"}
+		event = &data.FollowerEvent{ID: "some-event-id", UserID: "111", Follower: &twitter.User{ID: "123"}}
+	)
+
+	// Another invocation claimed the delivery, but didn't finish yet
+	d := data.NewNotificationDelivery(event, ChannelSlack, now)
+	d.Attempts = 1
+	if err := table.SaveNotificationDelivery(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+
+	r, slack, _ := newTrackedRegistry(table, now.Add(time.Minute))
+	if err := r.NotifyChannel(ctx, user, ChannelSlack, &Message{Event: event}); err != nil {
+		t.Fatal(err)
+	}
+	if len(slack.sent) != 0 {
+		t.Error("sent event while another attempt was in progress")
+	}
+
+	// The other invocation died, so the lease expired
+	r, slack, _ = newTrackedRegistry(table, now.Add(time.Hour))
+	if err := r.NotifyChannel(ctx, user, ChannelSlack, &Message{Event: event}); err != nil {
+		t.Fatal(err)
+	}
+	if len(slack.sent) != 1 {
+		t.Error("expected event to be sent after lease expired")
+	}
+}
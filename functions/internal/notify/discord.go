@@ -0,0 +1,147 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+)
+
+var _ Notifier = (*Discord)(nil)
+
+const defaultDiscordRetries = 3
+
+// Discord posts messages as embeds to webhooks.
+type Discord struct {
+	Username  string
+	AvatarURL string
+
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+
+	// MaxRetries limits how often a rate-limited request is retried.
+	MaxRetries int
+}
+
+type discordMessage struct {
+	Username  string         `json:"username,omitempty"`
+	AvatarURL string         `json:"avatar_url,omitempty"`
+	Embeds    []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	Thumbnail   *discordThumbnail `json:"thumbnail,omitempty"`
+	Footer      *discordFooter    `json:"footer,omitempty"`
+}
+
+type discordThumbnail struct {
+	URL string `json:"url"`
+}
+
+type discordFooter struct {
+	Text string `json:"text"`
+}
+
+func (d *Discord) Enabled(user *data.User) bool {
+	return user.Discord.Enabled
+}
+
+func (d *Discord) Notify(ctx context.Context, user *data.User, msg *Message) error {
+	embed := discordEmbed{
+		Title:       msg.Header,
+		Description: markdown(msg.Text),
+	}
+	if msg.ImageURL != "" {
+		embed.Thumbnail = &discordThumbnail{URL: msg.ImageURL}
+	}
+	if msg.Footer != "" {
+		embed.Footer = &discordFooter{Text: PlainText(msg.Footer)} // footers don't support markdown
+	}
+
+	body, err := json.Marshal(discordMessage{
+		Username:  d.Username,
+		AvatarURL: d.AvatarURL,
+		Embeds:    []discordEmbed{embed},
+	})
+	if err != nil {
+		return err
+	}
+
+	maxRetries := d.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = defaultDiscordRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := d.post(ctx, user.Discord.WebhookURL, body)
+		if err != nil || retryAfter == 0 {
+			return err
+		}
+		if attempt == maxRetries {
+			return fmt.Errorf("discord: still rate limited after %d retries", maxRetries)
+		}
+
+		log.Printf("discord: rate limited, retrying in %s", retryAfter)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryAfter):
+		}
+	}
+}
+
+// post sends the message once. If Discord rate limits the request, it returns
+// how long to wait before trying again.
+func (d *Discord) post(ctx context.Context, url string, body []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	setStatusCode(ctx, resp.StatusCode)
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		var rateLimit struct {
+			RetryAfter float64 `json:"retry_after"` // seconds
+		}
+		if err := json.Unmarshal(respBody, &rateLimit); err != nil {
+			return 0, fmt.Errorf("discord: invalid rate limit response: %w", err)
+		}
+		// Never busy-loop, even if Discord tells us to retry immediately
+		retryAfter := time.Duration(rateLimit.RetryAfter * float64(time.Second))
+		if retryAfter < 100*time.Millisecond {
+			retryAfter = 100 * time.Millisecond
+		}
+		return retryAfter, nil
+	case resp.StatusCode >= 300:
+		return 0, fmt.Errorf("discord: %s: %s", resp.Status, bytes.TrimSpace(respBody))
+	default:
+		return 0, nil
+	}
+}
@@ -0,0 +1,94 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+)
+
+func TestDiscord_Notify(t *testing.T) {
+	var (
+		requests int
+		got      discordMessage
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"message": "You are being rate limited.", "retry_after": 0.01, "global": false}`)) //nolint:errcheck
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	d := &Discord{Username: "Listkeeper", Client: srv.Client()}
+	user := &data.User{Discord: data.DiscordConfig{Enabled: true, WebhookURL: srv.URL}}
+
+	ctx, resp := WithResponse(context.Background())
+	err := d.Notify(ctx, user, &Message{
+		Header:   "New follower",
+		Text:     "Bob (<https://twitter.com/bob|@bob>) followed you :tada:\n\n*Bio:* Hi",
+		Footer:   "You (@alice) now have 42 Twitter followers",
+		ImageURL: "https://pbs.twimg.com/profile_images/2/bob_400x400.jpg",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if requests != 2 {
+		t.Errorf("expected 2 requests, got %d", requests)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+
+	want := discordMessage{
+		Username: "Listkeeper",
+		Embeds: []discordEmbed{{
+			Title:       "New follower",
+			Description: "Bob ([@bob](https://twitter.com/bob)) followed you 🎉\n\n**Bio:** Hi",
+			Thumbnail:   &discordThumbnail{URL: "https://pbs.twimg.com/profile_images/2/bob_400x400.jpg"},
+			Footer:      &discordFooter{Text: "You (@alice) now have 42 Twitter followers"},
+		}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestDiscord_NotifyRateLimited(t *testing.T) {
+	var requests int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"retry_after": 0}`)) //nolint:errcheck
+	}))
+	defer srv.Close()
+
+	d := &Discord{Client: srv.Client(), MaxRetries: 2}
+	user := &data.User{Discord: data.DiscordConfig{Enabled: true, WebhookURL: srv.URL}}
+
+	ctx, resp := WithResponse(context.Background())
+	err := d.Notify(ctx, user, &Message{Header: "New follower"})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if requests != 3 {
+		t.Errorf("expected 3 requests, got %d", requests)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected status %d, got %d", http.StatusTooManyRequests, resp.StatusCode)
+	}
+}
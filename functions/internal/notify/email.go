@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/segmentio/ksuid"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+)
+
+var _ Notifier = (*Email)(nil)
+
+// Email sends messages via SMTP, as HTML with a plain-text alternative.
+type Email struct {
+	addr string
+	auth smtp.Auth
+	from *mail.Address
+}
+
+// NewEmail returns a notifier sending mail through the SMTP server at addr
+// (host:port). Username and password are optional.
+func NewEmail(addr, username, password, from string) (*Email, error) {
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address: %w", err)
+	}
+
+	e := &Email{addr: addr, from: fromAddr}
+	if username != "" {
+		host := addr
+		if i := strings.LastIndex(addr, ":"); i >= 0 {
+			host = addr[:i]
+		}
+		e.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return e, nil
+}
+
+func (e *Email) Enabled(user *data.User) bool {
+	return user.Email.Enabled && user.Email.Address != ""
+}
+
+func (e *Email) Notify(ctx context.Context, user *data.User, msg *Message) error {
+	to := &mail.Address{Name: user.Name, Address: user.Email.Address}
+
+	body, err := e.render(to, msg)
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(e.addr, e.auth, e.from.Address, []string{to.Address}, body)
+}
+
+var textTemplate = texttemplate.Must(texttemplate.New("text").Parse(`{{.Header}}
+
+{{.Text}}
+
+--
+{{.Footer}}
+`))
+
+var htmlTemplate = template.Must(template.New("html").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Header}}</title></head>
+<body style="font-family: sans-serif; max-width: 600px;">
+<h2>{{.Header}}</h2>
+{{if .ImageURL}}<img src="{{.ImageURL}}" alt="profile image" width="96" height="96" style="float: right; margin-left: 16px;">
+{{end}}{{.Text}}
+<p style="clear: both; color: #666; font-size: small;">{{.Footer}}</p>
+</body>
+</html>
+`))
+
+func (e *Email) render(to *mail.Address, msg *Message) ([]byte, error) {
+	var text bytes.Buffer
+	err := textTemplate.Execute(&text, map[string]string{
+		"Header": msg.Header,
+		"Text":   strings.TrimSpace(PlainText(msg.Text)),
+		"Footer": PlainText(msg.Footer),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var html bytes.Buffer
+	err = htmlTemplate.Execute(&html, map[string]interface{}{
+		"Header":   msg.Header,
+		"Text":     HTMLText(msg.Text),
+		"Footer":   HTMLInline(msg.Footer),
+		"ImageURL": msg.ImageURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		body bytes.Buffer
+		mw   = multipart.NewWriter(&body)
+	)
+
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qw := quotedprintable.NewWriter(w)
+		if _, err := qw.Write(part.content); err != nil {
+			return nil, err
+		}
+		if err := qw.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	header := []string{
+		"From: " + e.from.String(),
+		"To: " + to.String(),
+		"Subject: " + mime.QEncoding.Encode("utf-8", msg.Header),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: <" + ksuid.New().String() + "@" + domain(e.from.Address) + ">",
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + mw.Boundary(),
+	}
+
+	return append([]byte(strings.Join(header, "\r\n")+"\r\n\r\n"), body.Bytes()...), nil
+}
+
+func domain(address string) string {
+	return address[strings.LastIndex(address, "@")+1:]
+}
@@ -0,0 +1,143 @@
+package notify
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/local"
+)
+
+func TestEmail_Notify(t *testing.T) {
+	srv, err := local.NewSMTPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	e, err := NewEmail(srv.Addr(), "", "", "Listkeeper <notifications@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &data.User{
+		Name:  "Alice",
+		Email: data.EmailConfig{Enabled: true, Address: "alice@example.com"},
+	}
+	msg := &Message{
+		Header: "New follower :tada:",
+		Text:   "<https://twitter.com/bob|*Bob*> is now following you.\n\nBio: Tom & Jerry",
+		Footer: "<https://listkeeper.io|Listkeeper>",
+	}
+
+	if !e.Enabled(user) {
+		t.Fatal("expected email to be enabled")
+	}
+	if err := e.Notify(context.Background(), user, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	received := srv.Mail()
+	if len(received) != 1 {
+		t.Fatalf("expected 1 mail, got %d", len(received))
+	}
+	if diff := cmp.Diff("notifications@example.com", received[0].From); diff != "" {
+		t.Error(diff)
+	}
+	if diff := cmp.Diff([]string{"alice@example.com"}, received[0].To); diff != "" {
+		t.Error(diff)
+	}
+
+	m, err := mail.ReadMessage(strings.NewReader(string(received[0].Data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(msg.Header, subject); diff != "" {
+		t.Error(diff)
+	}
+	if diff := cmp.Diff(`"Alice" <alice@example.com>`, m.Header.Get("To")); diff != "" {
+		t.Error(diff)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("multipart/alternative", mediaType); diff != "" {
+		t.Error(diff)
+	}
+
+	parts := map[string]string{}
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(p) // decodes quoted-printable
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[p.Header.Get("Content-Type")] = string(b)
+	}
+
+	text := parts["text/plain; charset=utf-8"]
+	for _, want := range []string{
+		"New follower :tada:",
+		"Bob (https://twitter.com/bob) is now following you.",
+		"Bio: Tom & Jerry",
+		"Listkeeper (https://listkeeper.io)",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text part %q does not contain %q", text, want)
+		}
+	}
+
+	html := parts["text/html; charset=utf-8"]
+	for _, want := range []string{
+		`<a href="https://twitter.com/bob"><strong>Bob</strong></a> is now following you.`,
+		"<p>Bio: Tom &amp; Jerry</p>",
+		`<a href="https://listkeeper.io">Listkeeper</a>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("html part %q does not contain %q", html, want)
+		}
+	}
+}
+
+func TestEmail_Enabled(t *testing.T) {
+	e, err := NewEmail("localhost:25", "", "", "notifications@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		config  data.EmailConfig
+		enabled bool
+	}{
+		{config: data.EmailConfig{}},
+		{config: data.EmailConfig{Address: "alice@example.com"}},
+		{config: data.EmailConfig{Enabled: true}},
+		{config: data.EmailConfig{Enabled: true, Address: "alice@example.com"}, enabled: true},
+	}
+
+	for _, test := range tests {
+		if diff := cmp.Diff(test.enabled, e.Enabled(&data.User{Email: test.config})); diff != "" {
+			t.Errorf("%+v: %s", test.config, diff)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package notify
+
+import (
+	"html"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	mrkdwnLink = regexp.MustCompile(`<([^|>\s]+)(?:\|([^>]+))?>`)
+	mrkdwnBold = regexp.MustCompile(`\*([^*\n]+)\*`)
+
+	emojis = strings.NewReplacer(
+		":tada:", "🎉",
+		":wave:", "👋",
+		":warning:", "⚠️",
+	)
+
+	// Slack requires these characters to be escaped in text, see
+	// https://api.slack.com/reference/surfaces/formatting#escaping
+	mrkdwnEscaper   = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	mrkdwnUnescaper = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&")
+)
+
+// EscapeMrkdwn escapes text that isn't ours, like a follower's bio, so that it
+// can't add links to a message.
+func EscapeMrkdwn(s string) string {
+	return mrkdwnEscaper.Replace(s)
+}
+
+// PlainText converts mrkdwn to plain text, e.g. for emails and feeds. Links
+// keep their URL so that they remain usable.
+func PlainText(s string) string {
+	s = mrkdwnLink.ReplaceAllStringFunc(s, func(m string) string {
+		parts := mrkdwnLink.FindStringSubmatch(m)
+		if parts[2] == "" {
+			return parts[1]
+		}
+		return parts[2] + " (" + parts[1] + ")"
+	})
+	s = mrkdwnBold.ReplaceAllString(s, "$1")
+	return emojis.Replace(mrkdwnUnescaper.Replace(s))
+}
+
+// HTMLText converts mrkdwn to HTML, one paragraph per block of text.
+func HTMLText(s string) template.HTML {
+	var paragraphs []string
+	for _, p := range strings.Split(strings.TrimSpace(s), "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			paragraphs = append(paragraphs, "<p>"+string(HTMLInline(p))+"</p>")
+		}
+	}
+	return template.HTML(strings.Join(paragraphs, "\n")) //nolint:gosec // escaped by HTMLInline
+}
+
+// HTMLInline converts mrkdwn to HTML without wrapping it in a paragraph. Only
+// links to web pages are kept; others are reduced to their label.
+func HTMLInline(s string) template.HTML {
+	var (
+		b    strings.Builder
+		last int
+	)
+
+	for _, m := range mrkdwnLink.FindAllStringSubmatchIndex(s, -1) {
+		b.WriteString(htmlEscape(s[last:m[0]]))
+
+		href := mrkdwnUnescaper.Replace(s[m[2]:m[3]])
+		label := s[m[2]:m[3]]
+		if m[4] >= 0 {
+			label = s[m[4]:m[5]]
+		}
+		if webURL(href) {
+			b.WriteString(`<a href="` + html.EscapeString(href) + `">` + htmlEscape(label) + `</a>`)
+		} else {
+			b.WriteString(htmlEscape(label))
+		}
+
+		last = m[1]
+	}
+	b.WriteString(htmlEscape(s[last:]))
+
+	return template.HTML(strings.ReplaceAll(b.String(), "\n", "<br>\n")) //nolint:gosec // escaped above
+}
+
+func htmlEscape(s string) string {
+	s = html.EscapeString(mrkdwnUnescaper.Replace(s))
+	s = mrkdwnBold.ReplaceAllString(s, "<strong>$1</strong>")
+	return emojis.Replace(s)
+}
+
+// webURL reports whether a URL is safe to link to from HTML, as opposed to
+// schemes like javascript: that run code when clicked.
+func webURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	return (scheme == "https" || scheme == "http") && u.Host != ""
+}
+
+// markdown converts mrkdwn to the Markdown dialect understood by Discord.
+func markdown(s string) string {
+	s = mrkdwnBold.ReplaceAllString(s, "**$1**")
+	s = mrkdwnLink.ReplaceAllStringFunc(s, func(m string) string {
+		parts := mrkdwnLink.FindStringSubmatch(m)
+		if parts[2] == "" {
+			return parts[1]
+		}
+		return "[" + parts[2] + "](" + parts[1] + ")"
+	})
+	return emojis.Replace(mrkdwnUnescaper.Replace(s))
+}
+
+// telegramHTML converts mrkdwn to the subset of HTML supported by Telegram,
+// which has no tags for line breaks.
+func telegramHTML(s string) string {
+	s = string(HTMLInline(s))
+	s = strings.ReplaceAll(s, "<br>\n", "\n")
+	return strings.NewReplacer("<strong>", "<b>", "</strong>", "</b>").Replace(s)
+}
+
+// pushText converts mrkdwn to plain text for push notifications. Their
+// links can't be clicked, so only the labels are kept.
+func pushText(s string) string {
+	s = mrkdwnLink.ReplaceAllStringFunc(s, func(m string) string {
+		parts := mrkdwnLink.FindStringSubmatch(m)
+		if parts[2] == "" {
+			return parts[1]
+		}
+		return parts[2]
+	})
+	s = mrkdwnBold.ReplaceAllString(s, "$1")
+	return emojis.Replace(mrkdwnUnescaper.Replace(s))
+}
@@ -0,0 +1,31 @@
+package notify
+
+import "testing"
+
+func TestHTMLInline(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"*Bob* (<https://twitter.com/bob|@bob>)", `<strong>Bob</strong> (<a href="https://twitter.com/bob">@bob</a>)`},
+		{"<http://example.com>", `<a href="http://example.com">http://example.com</a>`},
+		{"<javascript:alert(1)|click>", "click"},
+		{"<JavaScript://example.com/%0Aalert(1)|click>", "click"},
+		{"<data:text/html,x|click>", "click"},
+		{"<https://example.com?a=1&amp;b=2|x>", `<a href="https://example.com?a=1&amp;b=2">x</a>`},
+		{EscapeMrkdwn("<javascript:alert(1)|click> & <b>"), "&lt;javascript:alert(1)|click&gt; &amp; &lt;b&gt;"},
+	}
+
+	for _, test := range tests {
+		if got := string(HTMLInline(test.in)); got != test.want {
+			t.Errorf("%s: want %q, got %q", test.in, test.want, got)
+		}
+	}
+}
+
+func TestPlainText_Escaped(t *testing.T) {
+	in := EscapeMrkdwn("<javascript:alert(1)|click> & more")
+	if got, want := PlainText(in), "<javascript:alert(1)|click> & more"; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,191 @@
+// Package notify delivers notifications to users via the channels they set
+// up, such as Slack, Discord, Telegram, email, or browser push.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+)
+
+// Message is a notification for a user. Text may use Slack's mrkdwn markup,
+// which channels convert to their own format.
+type Message struct {
+	Header   string
+	Text     string
+	Footer   string
+	ImageURL string
+
+	// Event is matched against the filter rules of each channel, if set.
+	Event *data.FollowerEvent
+
+	// DeliveryEvent tracks the delivery of a message about several events,
+	// which leaves Event empty, under one of them, see Deliveries.
+	DeliveryEvent *data.FollowerEvent
+
+	// AlertID tracks the delivery of an alert, which is about no follower
+	// event, under the ID of the event that triggered it.
+	AlertID string
+
+	// Test messages are sent on request to check a channel, about a sample
+	// event that cannot be acted on.
+	Test bool
+}
+
+// Notifier delivers messages via a single channel.
+type Notifier interface {
+	// Enabled reports whether the user has set up the channel.
+	Enabled(user *data.User) bool
+	Notify(ctx context.Context, user *data.User, msg *Message) error
+}
+
+// Channel names
+const (
+	ChannelSlack    = "slack"
+	ChannelEmail    = "email"
+	ChannelDiscord  = "discord"
+	ChannelTelegram = "telegram"
+	ChannelPush     = "push"
+)
+
+// Registry holds all available channels by name.
+type Registry struct {
+	names      []string
+	notifiers  map[string]Notifier
+	deliveries *Deliveries
+}
+
+func NewRegistry() *Registry {
+	return &Registry{notifiers: map[string]Notifier{}}
+}
+
+// Register adds a channel. Channels are notified in the order they were
+// registered.
+func (r *Registry) Register(name string, n Notifier) {
+	if _, ok := r.notifiers[name]; !ok {
+		r.names = append(r.names, name)
+	}
+	r.notifiers[name] = n
+}
+
+// TrackDeliveries records every message about a follower event that is sent
+// via a channel, see Deliveries.
+func (r *Registry) TrackDeliveries(d *Deliveries) {
+	r.deliveries = d
+}
+
+// Get returns the channel with the given name.
+func (r *Registry) Get(name string) (Notifier, bool) {
+	n, ok := r.notifiers[name]
+	return n, ok
+}
+
+// Channels returns the names of all registered channels.
+func (r *Registry) Channels() []string {
+	return append([]string(nil), r.names...)
+}
+
+// Notify sends the message via all channels the user has enabled. A failing
+// channel doesn't keep the message from being sent via the others.
+func (r *Registry) Notify(ctx context.Context, user *data.User, msg *Message) error {
+	return r.NotifyFunc(ctx, user, func(channel string) *Message {
+		if msg.Event != nil && !Filter(user, channel).Match(msg.Event) {
+			log.Printf("event filtered out for %s", channel)
+			return nil
+		}
+		return msg
+	})
+}
+
+// NotifyFunc is like Notify, but builds the message for each channel, e.g.
+// to only include events that pass its filter. Channels are skipped if the
+// message is nil.
+func (r *Registry) NotifyFunc(ctx context.Context, user *data.User, build func(channel string) *Message) error {
+	var (
+		failed   []string
+		firstErr error
+	)
+
+	for _, name := range r.names {
+		n := r.notifiers[name]
+		if !n.Enabled(user) {
+			continue
+		}
+		msg := build(name)
+		if msg == nil {
+			continue
+		}
+
+		if err := r.send(ctx, user, name, n, msg); err != nil {
+			log.Printf("failed to notify via %s: %s", name, err)
+			failed = append(failed, name)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if firstErr != nil {
+		return fmt.Errorf("failed to notify via %s: %w", strings.Join(failed, ", "), firstErr)
+	}
+	return nil
+}
+
+// NotifyChannel sends the message via a single channel, regardless of its
+// filter rules, e.g. to replay a failed delivery.
+func (r *Registry) NotifyChannel(ctx context.Context, user *data.User, channel string, msg *Message) error {
+	n, ok := r.notifiers[channel]
+	if !ok {
+		return fmt.Errorf("unknown channel %q", channel)
+	}
+	if !n.Enabled(user) {
+		return fmt.Errorf("channel %s is disabled", channel)
+	}
+	return r.send(ctx, user, channel, n, msg)
+}
+
+func (r *Registry) send(ctx context.Context, user *data.User, channel string, n Notifier, msg *Message) error {
+	if r.deliveries == nil || msg.deliveryID() == "" || msg.Test {
+		return n.Notify(ctx, user, msg)
+	}
+	return r.deliveries.send(ctx, user, channel, n, msg)
+}
+
+// deliveryEvent returns the event under which the delivery of the message is
+// tracked, if any.
+func (m *Message) deliveryEvent() *data.FollowerEvent {
+	if m.Event != nil {
+		return m.Event
+	}
+	return m.DeliveryEvent
+}
+
+// deliveryID returns the ID under which the delivery of the message is
+// tracked, if any.
+func (m *Message) deliveryID() string {
+	if event := m.deliveryEvent(); event != nil {
+		return event.ID
+	}
+	return m.AlertID
+}
+
+// Filter returns the filter rules the user set for a channel, or nil.
+func Filter(user *data.User, channel string) *data.NotificationFilter {
+	switch channel {
+	case ChannelSlack:
+		return user.Slack.Filter
+	case ChannelEmail:
+		return user.Email.Filter
+	case ChannelDiscord:
+		return user.Discord.Filter
+	case ChannelTelegram:
+		return user.Telegram.Filter
+	case ChannelPush:
+		return user.Push.Filter
+	default:
+		return nil
+	}
+}
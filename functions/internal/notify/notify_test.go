@@ -0,0 +1,93 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+type fakeNotifier struct {
+	enabled bool
+	err     error
+	sent    []*Message
+}
+
+func (n *fakeNotifier) Enabled(*data.User) bool { return n.enabled }
+
+func (n *fakeNotifier) Notify(_ context.Context, _ *data.User, msg *Message) error {
+	n.sent = append(n.sent, msg)
+	return n.err
+}
+
+func TestRegistry_Notify(t *testing.T) {
+	var (
+		failing  = &fakeNotifier{enabled: true, err: errors.New("boom")}
+		disabled = &fakeNotifier{}
+		working  = &fakeNotifier{enabled: true}
+	)
+
+	r := NewRegistry()
+	r.Register("failing", failing)
+	r.Register("disabled", disabled)
+	r.Register("working", working)
+
+	if diff := cmp.Diff([]string{"failing", "disabled", "working"}, r.Channels()); diff != "" {
+		t.Error(diff)
+	}
+
+	msg := &Message{Header: "Hello"}
+	err := r.Notify(context.Background(), &data.User{}, msg)
+	if err == nil || err.Error() != "failed to notify via failing: boom" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if len(failing.sent) != 1 || len(working.sent) != 1 {
+		t.Error("expected enabled channels to be notified despite failure")
+	}
+	if len(disabled.sent) != 0 {
+		t.Error("expected disabled channel to be skipped")
+	}
+}
+
+func TestRegistry_NotifyFiltered(t *testing.T) {
+	var (
+		slack   = &fakeNotifier{enabled: true}
+		discord = &fakeNotifier{enabled: true}
+	)
+
+	r := NewRegistry()
+	r.Register(ChannelSlack, slack)
+	r.Register(ChannelDiscord, discord)
+
+	user := &data.User{
+		Slack: data.SlackConfig{Filter: &data.NotificationFilter{States: []string{data.FollowerStateLost}}},
+	}
+	event := &data.FollowerEvent{
+		FollowerState:       data.FollowerStateNew,
+		FollowerStateReason: data.FollowerStateReasonFollowed,
+		Follower:            &twitter.User{ID: "123"},
+	}
+
+	if err := r.Notify(context.Background(), user, &Message{Header: "New follower", Event: event}); err != nil {
+		t.Fatal(err)
+	}
+	if len(slack.sent) != 0 {
+		t.Error("expected event to be filtered out for slack")
+	}
+	if len(discord.sent) != 1 {
+		t.Error("expected discord to be notified without filter")
+	}
+
+	// Messages not about an event, like digests, aren't filtered
+	if err := r.Notify(context.Background(), user, &Message{Header: "Digest"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(slack.sent) != 1 {
+		t.Error("expected message without event to be sent via slack")
+	}
+}
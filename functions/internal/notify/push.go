@@ -0,0 +1,237 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+)
+
+var _ Notifier = (*Push)(nil)
+
+const (
+	// Push services must accept bodies of 4096 bytes, which leaves this
+	// much for the message after the header, padding delimiter, and tag.
+	pushRecordSize = 4096
+	pushMaxPayload = pushRecordSize - 86 - 1 - 16
+
+	// pushTTL is how long push services keep messages for offline devices.
+	pushTTL = 24 * time.Hour
+)
+
+// Push sends Web Push notifications to the browsers the user subscribed,
+// see https://datatracker.ietf.org/doc/html/rfc8030. Subscriptions the push
+// service reports as gone are removed.
+type Push struct {
+	Key     *VAPIDKey
+	Subject string // how push services can contact us, e.g. mailto:
+	URL     string // opened when clicking the notification
+	Table   data.TableAPI
+
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// pushPayload is what the app's service worker receives.
+type pushPayload struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	Icon  string `json:"icon,omitempty"`
+	Tag   string `json:"tag,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+func (p *Push) Enabled(user *data.User) bool {
+	return user.Push.Enabled && len(user.Push.Subscriptions) > 0
+}
+
+// Notify sends the message to all subscribed browsers. It only fails if no
+// browser got the message, so that retries won't notify the others twice.
+func (p *Push) Notify(ctx context.Context, user *data.User, msg *Message) error {
+	payload, err := p.payload(msg)
+	if err != nil {
+		return err
+	}
+
+	var (
+		subs = append([]*data.PushSubscription(nil), user.Push.Subscriptions...)
+		sent int
+		errs []string
+	)
+	for _, s := range subs {
+		status, err := p.send(ctx, s, payload)
+		switch {
+		case status == http.StatusNotFound || status == http.StatusGone:
+			log.Printf("push subscription %s has expired, removing it", s.ID)
+			if err := p.Table.RemoveUserPushSubscription(ctx, user, s.ID); err != nil && !errors.Is(err, data.ErrPushSubscriptionNotFound) {
+				return err
+			}
+			user.RemovePushSubscription(s.ID) //nolint:errcheck
+		case err != nil:
+			errs = append(errs, err.Error())
+		default:
+			sent++
+		}
+	}
+
+	setResponse(ctx, "sent to %d of %d devices", sent, len(subs))
+	if sent == 0 && len(errs) > 0 {
+		return fmt.Errorf("push: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (p *Push) payload(msg *Message) ([]byte, error) {
+	pp := pushPayload{
+		Title: pushText(msg.Header),
+		Body:  strings.TrimSpace(pushText(msg.Text) + "\n\n" + pushText(msg.Footer)),
+		Icon:  msg.ImageURL,
+		URL:   p.URL,
+	}
+	if msg.Event != nil {
+		pp.Tag = msg.Event.ID
+	}
+
+	for {
+		b, err := json.Marshal(pp)
+		if err != nil || len(b) <= pushMaxPayload {
+			return b, err
+		}
+		// Cut the body until the message fits
+		cut := len(pp.Body) - (len(b) - pushMaxPayload) - len("…")
+		if cut <= 0 {
+			return nil, errors.New("push: message too long")
+		}
+		for cut > 0 && !utf8.RuneStart(pp.Body[cut]) {
+			cut--
+		}
+		pp.Body = pp.Body[:cut] + "…"
+	}
+}
+
+// send delivers an encrypted message to the push service, returning its
+// status code.
+func (p *Push) send(ctx context.Context, s *data.PushSubscription, payload []byte) (int, error) {
+	body, err := encryptPush(s, payload)
+	if err != nil {
+		return 0, err
+	}
+	auth, err := p.Key.authorization(s.Endpoint, p.Subject, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(pushTTL.Seconds())))
+	req.Header.Set("Urgency", "normal")
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	setStatusCode(ctx, resp.StatusCode)
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp.StatusCode, nil
+}
+
+// encryptPush encrypts a message for the browser as specified in RFC 8291,
+// using the aes128gcm content encoding of RFC 8188 with a single record.
+func encryptPush(s *data.PushSubscription, plaintext []byte) ([]byte, error) {
+	uaPublic, err := decodeBase64URL(s.P256dh)
+	if err != nil {
+		return nil, fmt.Errorf("push: invalid p256dh key: %w", err)
+	}
+	uaX, uaY, err := unmarshalP256(uaPublic)
+	if err != nil {
+		return nil, fmt.Errorf("push: %w", err)
+	}
+	authSecret, err := decodeBase64URL(s.Auth)
+	if err != nil || len(authSecret) != 16 {
+		return nil, errors.New("push: invalid auth secret")
+	}
+
+	// A new key pair for each message, whose public key goes in the header
+	curve := elliptic.P256()
+	asPrivate, asX, asY, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := marshalP256(asX, asY)
+	sx, _ := curve.ScalarMult(uaX, uaY, asPrivate)
+	ecdhSecret := sx.FillBytes(make([]byte, 32))
+
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaPublic...), asPublic...)
+	ikm := hkdf(authSecret, ecdhSecret, keyInfo, 32)
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, 21+len(asPublic)+len(plaintext)+1+gcm.Overhead())
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, pushRecordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+
+	// The last (and only) record ends with 0x02, without further padding
+	record := append(append([]byte(nil), plaintext...), 2)
+	return gcm.Seal(header, nonce, record, nil), nil
+}
+
+// hkdf derives a key of up to 32 bytes as specified in RFC 5869, which only
+// takes a single round of HKDF-Expand.
+func hkdf(salt, ikm, info []byte, length int) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ikm)
+	prk := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, prk)
+	mac.Write(info)
+	mac.Write([]byte{1})
+	return mac.Sum(nil)[:length]
+}
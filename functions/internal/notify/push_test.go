@@ -0,0 +1,322 @@
+package notify
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+)
+
+// browser is the receiving end of push messages.
+type browser struct {
+	private []byte
+	public  []byte
+	auth    []byte
+}
+
+func newBrowser(t *testing.T) *browser {
+	t.Helper()
+
+	private, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	if _, err := rand.Read(auth); err != nil {
+		t.Fatal(err)
+	}
+	return &browser{private: private, public: marshalP256(x, y), auth: auth}
+}
+
+func (b *browser) subscribe(t *testing.T, u *data.User, endpoint string) *data.PushSubscription {
+	t.Helper()
+
+	s, err := u.AddPushSubscription(endpoint,
+		base64.RawURLEncoding.EncodeToString(b.public),
+		base64.RawURLEncoding.EncodeToString(b.auth),
+		"Firefox", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// decrypt reverses encryptPush as a browser would.
+func (b *browser) decrypt(t *testing.T, body []byte) []byte {
+	t.Helper()
+
+	var (
+		salt     = body[:16]
+		rs       = binary.BigEndian.Uint32(body[16:20])
+		idlen    = int(body[20])
+		asPublic = body[21 : 21+idlen]
+		sealed   = body[21+idlen:]
+	)
+	if rs != pushRecordSize || len(sealed) > int(rs) {
+		t.Fatalf("unexpected record size %d for %d bytes", rs, len(sealed))
+	}
+
+	asX, asY, err := unmarshalP256(asPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sx, _ := elliptic.P256().ScalarMult(asX, asY, b.private)
+
+	keyInfo := append(append([]byte("WebPush: info\x00"), b.public...), asPublic...)
+	ikm := hkdf(b.auth, sx.FillBytes(make([]byte, 32)), keyInfo, 32)
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record[len(record)-1] != 2 {
+		t.Fatalf("record does not end with delimiter: %x", record)
+	}
+	return record[:len(record)-1]
+}
+
+// verifyVAPID checks the JWT in the Authorization header like a push service.
+func verifyVAPID(t *testing.T, r *http.Request, key *VAPIDKey) {
+	t.Helper()
+
+	var token, k string
+	for _, part := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "vapid "), ", ") {
+		switch {
+		case strings.HasPrefix(part, "t="):
+			token = part[2:]
+		case strings.HasPrefix(part, "k="):
+			k = part[2:]
+		}
+	}
+	if k != key.PublicKey() {
+		t.Errorf("unexpected public key %q", k)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("invalid token %q", token)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		t.Fatalf("invalid signature %q", parts[2])
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !ecdsa.Verify(&key.key.PublicKey, hash[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		t.Error("invalid signature")
+	}
+
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c struct {
+		Aud string `json:"aud"`
+		Sub string `json:"sub"`
+		Exp int64  `json:"exp"`
+	}
+	if err := json.Unmarshal(claims, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Aud != "https://"+r.Host || c.Sub != "mailto:push@example.com" || c.Exp <= time.Now().Unix() {
+		t.Errorf("unexpected claims %+v", c)
+	}
+}
+
+func newPushUser() *data.User {
+	u := data.NewUser("111")
+	u.Handle = "alice"
+	u.Name = "Alice"
+	u.ProfileImageURL = "https://example.com/alice.png"
+	u.AccessToken = "token"
+	u.AccessSecret = "secret"
+	u.LastLogin = u.CreatedAt
+	u.LastIP = "127.0.0.1"
+	u.LoginsCount = 1
+	return u
+}
+
+func TestPush_Notify(t *testing.T) {
+	key, err := GenerateVAPIDKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		laptop = newBrowser(t)
+		phone  = newBrowser(t)
+		got    pushPayload
+	)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+
+		verifyVAPID(t, r, key)
+		if r.Header.Get("Content-Encoding") != "aes128gcm" || r.Header.Get("TTL") == "" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(laptop.decrypt(t, body), &got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	var (
+		ctx   = context.Background()
+		table = data.NewMemoryTable()
+		user  = newPushUser()
+	)
+	laptop.subscribe(t, user, srv.URL+"/laptop")
+	gone := phone.subscribe(t, user, srv.URL+"/gone")
+	if err := table.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Push{
+		Key:     key,
+		Subject: "mailto:push@example.com",
+		URL:     "https://listkeeper.io",
+		Table:   table,
+		Client:  srv.Client(),
+	}
+	if !p.Enabled(user) {
+		t.Fatal("expected push to be enabled")
+	}
+
+	rctx, resp := WithResponse(ctx)
+	err = p.Notify(rctx, user, &Message{
+		Header:   "New follower",
+		Text:     "Bob (<https://twitter.com/bob|@bob>) followed you :tada:",
+		Footer:   "You (@alice) now have 42 Twitter followers",
+		ImageURL: "https://pbs.twimg.com/profile_images/2/bob_400x400.jpg",
+		Event:    &data.FollowerEvent{ID: "event-1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := pushPayload{
+		Title: "New follower",
+		Body:  "Bob (@bob) followed you 🎉\n\nYou (@alice) now have 42 Twitter followers",
+		Icon:  "https://pbs.twimg.com/profile_images/2/bob_400x400.jpg",
+		Tag:   "event-1",
+		URL:   "https://listkeeper.io",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+	if want := "sent to 1 of 2 devices"; resp.Text != want {
+		t.Errorf("expected response %q, got %q", want, resp.Text)
+	}
+
+	// The expired subscription was removed
+	stored, err := table.GetUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []*data.User{user, stored} {
+		if len(u.Push.Subscriptions) != 1 || u.Push.Subscriptions[0].ID == gone.ID {
+			t.Errorf("expected expired subscription to be removed, got %+v", u.Push.Subscriptions)
+		}
+	}
+}
+
+func TestPush_NotifyFailed(t *testing.T) {
+	key, err := GenerateVAPIDKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	user := &data.User{ID: "111"}
+	newBrowser(t).subscribe(t, user, srv.URL+"/laptop")
+
+	p := &Push{Key: key, Subject: "mailto:push@example.com", Client: srv.Client()}
+	err = p.Notify(context.Background(), user, &Message{Header: "New follower"})
+	if err == nil || !strings.Contains(err.Error(), "429") {
+		t.Errorf("expected rate limit error, got %v", err)
+	}
+	if len(user.Push.Subscriptions) != 1 {
+		t.Error("subscription must be kept after temporary errors")
+	}
+}
+
+func TestPush_PayloadTooLong(t *testing.T) {
+	p := &Push{}
+	b, err := p.payload(&Message{Header: "Digest", Text: strings.Repeat("ö", 5000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) > pushMaxPayload {
+		t.Errorf("payload of %d bytes exceeds %d", len(b), pushMaxPayload)
+	}
+	var pp pushPayload
+	if err := json.Unmarshal(b, &pp); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(pp.Body, "ö…") {
+		t.Errorf("body not cut properly: %q", pp.Body[len(pp.Body)-10:])
+	}
+}
+
+func TestParseVAPIDKey(t *testing.T) {
+	key, err := GenerateVAPIDKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseVAPIDKey(key.PrivateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.PublicKey() != key.PublicKey() {
+		t.Errorf("public keys differ: %s != %s", parsed.PublicKey(), key.PublicKey())
+	}
+	if n := len(key.PublicKey()); n != 87 {
+		t.Errorf("expected public key of 87 characters, got %d", n)
+	}
+
+	for _, invalid := range []string{"", "short", strings.Repeat("A", 43)} {
+		if _, err := ParseVAPIDKey(invalid); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/slack-go/slack"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/i18n"
+	"github.com/mlafeldt/listkeeper/functions/internal/secret"
+)
+
+var _ Notifier = (*Slack)(nil)
+
+// Action IDs of the buttons below follower notifications, which are handled
+// by the Slack app.
+const (
+	SlackActionIgnore = "ignore_follower"
+	SlackActionRemove = "remove_follower"
+	SlackActionBlock  = "block_follower"
+)
+
+// SlackActionsBlockID identifies the block with the buttons, which is
+// replaced by the outcome once an action was taken.
+const SlackActionsBlockID = "follower_actions"
+
+// SlackActionValue identifies the user and the follower an action is about.
+// The user is needed as several users may share a workspace.
+func SlackActionValue(userID, followerID, handle string) string {
+	return strings.Join([]string{userID, followerID, handle}, ":")
+}
+
+// ParseSlackActionValue is the inverse of SlackActionValue.
+func ParseSlackActionValue(v string) (userID, followerID, handle string, ok bool) {
+	parts := strings.Split(v, ":")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" { //nolint:gomnd
+		return "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], true
+}
+
+// Slack posts messages via the installed Slack app, or else to incoming
+// webhooks. Messages about events of the same run are grouped in a thread,
+// which webhooks can't do.
+type Slack struct {
+	Username string
+	IconURL  string
+
+	// Tokens decrypts the bot tokens of installed apps. Only webhooks are
+	// used without it.
+	Tokens *secret.Box
+
+	// Table records the thread of the latest run, see data.SlackThread.
+	Table data.TableAPI
+
+	// APIURL defaults to slack.APIURL.
+	APIURL string
+}
+
+func (s *Slack) Enabled(user *data.User) bool {
+	return user.Slack.Enabled
+}
+
+func (s *Slack) Notify(ctx context.Context, user *data.User, msg *Message) error {
+	log.Printf("slack = {Channel:%s TeamID:%s Installed:%t}", user.Slack.Channel, user.Slack.TeamID, user.Slack.Installed())
+
+	var accessory *slack.Accessory
+	if msg.ImageURL != "" {
+		accessory = slack.NewAccessory(slack.NewImageBlockElement(msg.ImageURL, "profile image"))
+	}
+
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(
+			slack.NewTextBlockObject("plain_text", msg.Header, false, false),
+		),
+		slack.NewSectionBlock(
+			slack.NewTextBlockObject("mrkdwn", msg.Text, false, false),
+			nil,
+			accessory,
+		),
+		slack.NewContextBlock(
+			"",
+			slack.NewTextBlockObject("mrkdwn", msg.Footer, false, false),
+		),
+	}
+	if actions := followerActions(user, msg.Event); actions != nil && !msg.Test {
+		blocks = append(blocks, actions)
+	}
+
+	if s.Tokens != nil && user.Slack.Installed() {
+		err := s.post(ctx, user, msg, blocks)
+		var slackErr slack.SlackErrorResponse
+		if !errors.As(err, &slackErr) || slackErr.Err != "not_in_channel" || user.Slack.WebhookURL == "" {
+			return slackStatus(ctx, err)
+		}
+		// The app can only post to private channels it was invited to
+		log.Printf("slack app not in channel %s, falling back to webhook", user.Slack.Channel)
+	}
+
+	webhookMsg := slack.WebhookMessage{
+		Username: s.Username,
+		IconURL:  s.IconURL,
+		Channel:  user.Slack.Channel,
+		Blocks:   &slack.Blocks{BlockSet: blocks},
+	}
+
+	return slackStatus(ctx, slack.PostWebhookContext(ctx, user.Slack.WebhookURL, &webhookMsg))
+}
+
+// slackStatus records the HTTP status of a request to Slack, which the
+// client only exposes as an error.
+func slackStatus(ctx context.Context, err error) error {
+	var statusErr slack.StatusCodeError
+	switch {
+	case errors.As(err, &statusErr):
+		setStatusCode(ctx, statusErr.Code)
+	case err == nil:
+		setStatusCode(ctx, http.StatusOK)
+	}
+	return err
+}
+
+// post sends the message with the bot token. The first message of a run
+// starts a thread, later ones are replies. If events delivered concurrently
+// both start a thread, the one recorded first wins and the other message is
+// moved there.
+func (s *Slack) post(ctx context.Context, user *data.User, msg *Message, blocks []slack.Block) error {
+	token, err := s.Tokens.Open(user.Slack.BotToken)
+	if err != nil {
+		return err
+	}
+
+	var opts []slack.Option
+	if s.APIURL != "" {
+		opts = append(opts, slack.OptionAPIURL(s.APIURL))
+	}
+	client := slack.New(token, opts...)
+
+	var runID string
+	if msg.Event != nil {
+		runID = msg.Event.RunID
+	}
+
+	msgOpts := []slack.MsgOption{
+		slack.MsgOptionText(msg.Header, false), // shown in notifications
+		slack.MsgOptionBlocks(blocks...),
+	}
+	thread := user.Slack.Thread
+	if runID != "" && thread != nil && thread.RunID == runID {
+		return s.reply(ctx, client, user, thread, msgOpts)
+	}
+
+	_, ts, err := client.PostMessageContext(ctx, user.Slack.ChannelID, msgOpts...)
+	if err != nil {
+		return err
+	}
+	setResponse(ctx, "ts=%s", ts)
+
+	if runID == "" || s.Table == nil {
+		return nil
+	}
+	user.Slack.Thread = &data.SlackThread{RunID: runID, TS: ts}
+	err = s.Table.UpdateUserSlackThread(ctx, user)
+	if !errors.Is(err, data.ErrSlackThreadExists) {
+		return err
+	}
+
+	stored, err := s.Table.GetUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	log.Printf("slack thread of run %s started concurrently, moving message %s there", runID, ts)
+	if _, _, err := client.DeleteMessageContext(ctx, user.Slack.ChannelID, ts); err != nil {
+		return err
+	}
+	user.Slack.Thread = stored.Slack.Thread
+	return s.reply(ctx, client, user, stored.Slack.Thread, msgOpts)
+}
+
+func (s *Slack) reply(ctx context.Context, client *slack.Client, user *data.User, thread *data.SlackThread, msgOpts []slack.MsgOption) error {
+	msgOpts = append(msgOpts, slack.MsgOptionTS(thread.TS))
+	_, ts, err := client.PostMessageContext(ctx, user.Slack.ChannelID, msgOpts...)
+	if err != nil {
+		return err
+	}
+	setResponse(ctx, "ts=%s", ts)
+	return nil
+}
+
+// followerActions returns buttons to act on the follower. Only messages of
+// the installed app are interactive, which includes its own webhook.
+func followerActions(user *data.User, event *data.FollowerEvent) slack.Block {
+	if !user.Slack.Installed() || event == nil || event.Follower == nil || event.Follower.ID == "" {
+		return nil
+	}
+
+	var (
+		p       = i18n.NewPrinter(user.Language())
+		value   = SlackActionValue(user.ID, event.Follower.ID, event.Follower.Handle)
+		buttons []slack.BlockElement
+	)
+	text := func(s string) *slack.TextBlockObject {
+		return slack.NewTextBlockObject("plain_text", s, false, false)
+	}
+	button := func(actionID, s string) *slack.ButtonBlockElement {
+		return slack.NewButtonBlockElement(actionID, value, text(s))
+	}
+
+	buttons = append(buttons, button(SlackActionIgnore, p.Sprintf("Ignore this account")))
+	if event.FollowerState == data.FollowerStateNew {
+		buttons = append(buttons, button(SlackActionRemove, p.Sprintf("Remove follower")))
+	}
+	block := button(SlackActionBlock, p.Sprintf("Block")).WithStyle(slack.StyleDanger)
+	block.Confirm = slack.NewConfirmationBlockObject(
+		text(p.Sprintf("Block this account?")),
+		text(p.Sprintf("They won't be able to follow you or see your tweets.")),
+		text(p.Sprintf("Block")),
+		text(p.Sprintf("Cancel")),
+	)
+	buttons = append(buttons, block)
+
+	return slack.NewActionBlock(SlackActionsBlockID, buttons...)
+}
@@ -0,0 +1,266 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/slack-go/slack"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/secret"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+const testTokenKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+type slackPost struct {
+	Token    string
+	Channel  string
+	ThreadTS string
+}
+
+func newSlackUser(t *testing.T, table data.TableAPI, box *secret.Box, webhookURL string) *data.User {
+	t.Helper()
+
+	token, err := box.Seal("xoxb-123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := data.NewUser("111")
+	u.Handle = "alice"
+	u.Name = "Alice"
+	u.ProfileImageURL = "https://example.com/alice.png"
+	u.AccessToken = "token"
+	u.AccessSecret = "secret"
+	u.LastLogin = u.CreatedAt
+	u.LastIP = "127.0.0.1"
+	u.LoginsCount = 1
+	u.Slack = data.SlackConfig{
+		Enabled:    true,
+		WebhookURL: webhookURL,
+		Channel:    "#followers",
+		TeamID:     "T1",
+		ChannelID:  "C1",
+		BotToken:   token,
+	}
+	if err := table.CreateUser(context.Background(), u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestSlack_NotifyThreads(t *testing.T) {
+	var posts []slackPost
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat.postMessage" {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		r.ParseForm() //nolint:errcheck
+		posts = append(posts, slackPost{
+			Token:    r.Form.Get("token"),
+			Channel:  r.Form.Get("channel"),
+			ThreadTS: r.Form.Get("thread_ts"),
+		})
+		fmt.Fprintf(w, `{"ok": true, "channel": "C1", "ts": "100.%d"}`, len(posts))
+	}))
+	defer srv.Close()
+
+	var (
+		ctx      = context.Background()
+		table    = data.NewMemoryTable()
+		box, _   = secret.NewBox(testTokenKey)
+		user     = newSlackUser(t, table, box, "")
+		s        = &Slack{Tokens: box, Table: table, APIURL: srv.URL + "/"}
+		runEvent = func(runID string) *Message {
+			return &Message{Header: "New follower", Event: &data.FollowerEvent{RunID: runID}}
+		}
+	)
+
+	for _, msg := range []*Message{
+		runEvent("run1"),
+		runEvent("run1"),
+		{Header: "Please reconnect your account"}, // not part of a run
+		runEvent("run1"),
+		runEvent("run2"),
+		runEvent("run2"),
+	} {
+		if err := s.Notify(ctx, user, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []slackPost{
+		{"xoxb-123", "C1", ""},
+		{"xoxb-123", "C1", "100.1"},
+		{"xoxb-123", "C1", ""},
+		{"xoxb-123", "C1", "100.1"},
+		{"xoxb-123", "C1", ""},
+		{"xoxb-123", "C1", "100.5"},
+	}
+	if diff := cmp.Diff(want, posts); diff != "" {
+		t.Error(diff)
+	}
+
+	stored, err := table.GetUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&data.SlackThread{RunID: "run2", TS: "100.5"}, stored.Slack.Thread); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestSlack_NotifyConcurrentThread(t *testing.T) {
+	var (
+		posts   []slackPost
+		deleted []string
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm() //nolint:errcheck
+		switch r.URL.Path {
+		case "/chat.postMessage":
+			posts = append(posts, slackPost{
+				Token:    r.Form.Get("token"),
+				Channel:  r.Form.Get("channel"),
+				ThreadTS: r.Form.Get("thread_ts"),
+			})
+			fmt.Fprintf(w, `{"ok": true, "channel": "C1", "ts": "200.%d"}`, len(posts))
+		case "/chat.delete":
+			deleted = append(deleted, r.Form.Get("ts"))
+			fmt.Fprint(w, `{"ok": true}`)
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	var (
+		ctx    = context.Background()
+		table  = data.NewMemoryTable()
+		box, _ = secret.NewBox(testTokenKey)
+		user   = newSlackUser(t, table, box, "")
+		s      = &Slack{Tokens: box, Table: table, APIURL: srv.URL + "/"}
+	)
+
+	// Another event of the run started the thread in the meantime
+	other := *user
+	other.Slack.Thread = &data.SlackThread{RunID: "run1", TS: "100.1"}
+	if err := table.UpdateUserSlackThread(ctx, &other); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &Message{Header: "New follower", Event: &data.FollowerEvent{RunID: "run1"}}
+	if err := s.Notify(ctx, user, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []slackPost{
+		{"xoxb-123", "C1", ""},
+		{"xoxb-123", "C1", "100.1"},
+	}
+	if diff := cmp.Diff(want, posts); diff != "" {
+		t.Error(diff)
+	}
+	if diff := cmp.Diff([]string{"200.1"}, deleted); diff != "" {
+		t.Error(diff)
+	}
+
+	stored, err := table.GetUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(other.Slack.Thread, stored.Slack.Thread); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestSlack_NotifyNotInChannel(t *testing.T) {
+	var webhooks int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/chat.postMessage":
+			fmt.Fprint(w, `{"ok": false, "error": "not_in_channel"}`)
+		case "/webhook":
+			webhooks++
+		}
+	}))
+	defer srv.Close()
+
+	var (
+		box, _ = secret.NewBox(testTokenKey)
+		user   = newSlackUser(t, data.NewMemoryTable(), box, srv.URL+"/webhook")
+		s      = &Slack{Tokens: box, APIURL: srv.URL + "/"}
+	)
+
+	if err := s.Notify(context.Background(), user, &Message{Header: "New follower"}); err != nil {
+		t.Fatal(err)
+	}
+	if webhooks != 1 {
+		t.Errorf("expected fallback to webhook, got %d requests", webhooks)
+	}
+}
+
+func TestSlack_FollowerActions(t *testing.T) {
+	var (
+		box, _   = secret.NewBox(testTokenKey)
+		user     = newSlackUser(t, data.NewMemoryTable(), box, "")
+		follower = &twitter.User{ID: "123", Handle: "bob"}
+	)
+
+	actionIDs := func(b slack.Block) []string {
+		if b == nil {
+			return nil
+		}
+		var ids []string
+		for _, e := range b.(*slack.ActionBlock).Elements.ElementSet {
+			button := e.(*slack.ButtonBlockElement)
+			if button.Value != "111:123:bob" {
+				t.Errorf("unexpected value %q", button.Value)
+			}
+			ids = append(ids, button.ActionID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		event *data.FollowerEvent
+		want  []string
+	}{
+		{&data.FollowerEvent{Follower: follower, FollowerState: "NEW"}, []string{SlackActionIgnore, SlackActionRemove, SlackActionBlock}},
+		{&data.FollowerEvent{Follower: follower, FollowerState: "LOST"}, []string{SlackActionIgnore, SlackActionBlock}},
+		{&data.FollowerEvent{Follower: &twitter.User{}, FollowerState: "LOST"}, nil},
+		{nil, nil},
+	}
+
+	for _, test := range tests {
+		if diff := cmp.Diff(test.want, actionIDs(followerActions(user, test.event))); diff != "" {
+			t.Error(diff)
+		}
+	}
+
+	user.Slack = data.SlackConfig{Enabled: true, WebhookURL: "https://hooks.slack.com/services/T1/B1/xyz"}
+	if b := followerActions(user, tests[0].event); b != nil {
+		t.Errorf("added actions to message sent via legacy webhook")
+	}
+}
+
+func TestParseSlackActionValue(t *testing.T) {
+	userID, followerID, handle, ok := ParseSlackActionValue(SlackActionValue("111", "123", "bob"))
+	if !ok || userID != "111" || followerID != "123" || handle != "bob" {
+		t.Errorf("unexpected result %q %q %q %t", userID, followerID, handle, ok)
+	}
+
+	for _, v := range []string{"", "111", "111::bob", ":123:bob", "1:2:3:4"} {
+		if _, _, _, ok := ParseSlackActionValue(v); ok {
+			t.Errorf("accepted invalid value %q", v)
+		}
+	}
+}
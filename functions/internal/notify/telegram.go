@@ -0,0 +1,127 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+)
+
+var _ Notifier = (*Telegram)(nil)
+
+const (
+	defaultTelegramURL = "https://api.telegram.org"
+
+	// Captions of photos are limited to 1024 characters
+	telegramMaxCaption = 1024
+)
+
+// Telegram sends messages to linked chats via the Bot API.
+type Telegram struct {
+	Token string
+
+	// BaseURL defaults to https://api.telegram.org.
+	BaseURL string
+
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+func (t *Telegram) Enabled(user *data.User) bool {
+	return user.Telegram.Enabled && user.Telegram.ChatID != 0
+}
+
+func (t *Telegram) Notify(ctx context.Context, user *data.User, msg *Message) error {
+	parts := []string{"<b>" + html.EscapeString(msg.Header) + "</b>"}
+	if text := strings.TrimSpace(msg.Text); text != "" {
+		parts = append(parts, telegramHTML(text))
+	}
+	if msg.Footer != "" {
+		parts = append(parts, "<i>"+telegramHTML(msg.Footer)+"</i>")
+	}
+	text := strings.Join(parts, "\n\n")
+
+	if msg.ImageURL != "" && utf8.RuneCountInString(text) <= telegramMaxCaption {
+		return t.call(ctx, "sendPhoto", map[string]interface{}{
+			"chat_id":    user.Telegram.ChatID,
+			"photo":      msg.ImageURL,
+			"caption":    text,
+			"parse_mode": "HTML",
+		})
+	}
+
+	return t.SendMessage(ctx, user.Telegram.ChatID, text)
+}
+
+// SendMessage sends a text formatted with Telegram's HTML subset.
+func (t *Telegram) SendMessage(ctx context.Context, chatID int64, text string) error {
+	return t.call(ctx, "sendMessage", map[string]interface{}{
+		"chat_id":                  chatID,
+		"text":                     text,
+		"parse_mode":               "HTML",
+		"disable_web_page_preview": true,
+	})
+}
+
+// call invokes a method of the Bot API, see https://core.telegram.org/bots/api#making-requests
+func (t *Telegram) call(ctx context.Context, method string, params interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	baseURL := t.BaseURL
+	if baseURL == "" {
+		baseURL = defaultTelegramURL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/bot"+t.Token+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// Don't leak the token, which is part of the URL
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("telegram: %s failed: %w", method, err)
+	}
+	defer resp.Body.Close()
+	setStatusCode(ctx, resp.StatusCode)
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		ErrorCode   int    `json:"error_code"`
+		Description string `json:"description"`
+		Result      struct {
+			MessageID int64 `json:"message_id"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("telegram: %s failed: %s", method, resp.Status)
+	}
+	if !result.OK {
+		return fmt.Errorf("telegram: %s failed: %d %s", method, result.ErrorCode, result.Description)
+	}
+	if result.Result.MessageID != 0 {
+		setResponse(ctx, "message_id=%d", result.Result.MessageID)
+	}
+	return nil
+}
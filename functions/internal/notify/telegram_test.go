@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/local"
+)
+
+func TestTelegram_Notify(t *testing.T) {
+	api := local.NewTelegramAPI()
+	defer api.Close()
+
+	tg := &Telegram{Token: "123:abc", BaseURL: api.URL}
+	user := &data.User{Telegram: data.TelegramConfig{Enabled: true, ChatID: 42}}
+
+	msg := &Message{
+		Header: "New follower",
+		Text:   "Bob (<https://twitter.com/bob|@bob>) followed you :tada:\n\n*Bio:* Tom & Jerry",
+		Footer: "You (@alice) now have 42 Twitter followers",
+	}
+	if err := tg.Notify(context.Background(), user, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	msg.ImageURL = "https://pbs.twimg.com/profile_images/2/bob_400x400.jpg"
+	if err := tg.Notify(context.Background(), user, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	text := "<b>New follower</b>\n\n" +
+		`Bob (<a href="https://twitter.com/bob">@bob</a>) followed you 🎉` + "\n\n" +
+		"<b>Bio:</b> Tom &amp; Jerry\n\n" +
+		"<i>You (@alice) now have 42 Twitter followers</i>"
+
+	want := []local.TelegramCall{
+		{
+			Token:  "123:abc",
+			Method: "sendMessage",
+			Params: map[string]interface{}{
+				"chat_id":                  float64(42),
+				"text":                     text,
+				"parse_mode":               "HTML",
+				"disable_web_page_preview": true,
+			},
+		},
+		{
+			Token:  "123:abc",
+			Method: "sendPhoto",
+			Params: map[string]interface{}{
+				"chat_id":    float64(42),
+				"photo":      "https://pbs.twimg.com/profile_images/2/bob_400x400.jpg",
+				"caption":    text,
+				"parse_mode": "HTML",
+			},
+		},
+	}
+	if diff := cmp.Diff(want, api.Calls()); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestTelegram_NotifyBlocked(t *testing.T) {
+	api := local.NewTelegramAPI()
+	defer api.Close()
+	api.Block(42)
+
+	tg := &Telegram{Token: "123:abc", BaseURL: api.URL}
+	user := &data.User{Telegram: data.TelegramConfig{Enabled: true, ChatID: 42}}
+
+	err := tg.Notify(context.Background(), user, &Message{Header: "New follower"})
+	if err == nil || err.Error() != "telegram: sendMessage failed: 403 Forbidden: bot was blocked by the user" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,264 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/i18n"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+// maxTemplateOutput limits the size of a rendered template.
+const maxTemplateOutput = 4000
+
+// TemplateData is the data model of notification templates. For example, the
+// template
+//
+//	{{.Follower.Name}} ({{link (print "https://twitter.com/" .Follower.Handle) .Follower.Handle}}) {{if eq .Event.State "NEW"}}followed{{else}}unfollowed{{end}} you
+//
+// renders as "Alice (<https://twitter.com/alice|alice>) followed you". Like
+// the default notifications, templates may use Slack's mrkdwn markup.
+type TemplateData struct {
+	// User is the Listkeeper user who is notified.
+	User TemplateUser
+
+	// Follower is the follower who was gained or lost. Deactivated, deleted,
+	// and suspended followers only come with an ID. Their name, bio, and
+	// location are escaped like mrkdwn text.
+	Follower twitter.User
+
+	// Event is the follower change.
+	Event TemplateEvent
+
+	// TotalFollowers is the number of followers of the user after the change.
+	TotalFollowers int
+
+	lang language.Tag // formats numbers like the user's other notifications
+}
+
+type TemplateUser struct {
+	Handle string
+	Name   string
+}
+
+type TemplateEvent struct {
+	State     string // NEW or LOST
+	Reason    string // FOLLOWED, REFOLLOWED, UNFOLLOWED, DEACTIVATED, DELETED, SUSPENDED, PROTECTED, BLOCKED, or REMOVED
+	Refollows int    // how often a REFOLLOWED follower came back recently
+	CreatedAt time.Time
+}
+
+// NewTemplateData returns the data to render templates for an event with.
+func NewTemplateData(user *data.User, event *data.FollowerEvent) *TemplateData {
+	d := &TemplateData{
+		User: TemplateUser{Handle: user.Handle, Name: user.Name},
+		Event: TemplateEvent{
+			State:     event.FollowerState,
+			Reason:    event.FollowerStateReason,
+			Refollows: event.Refollows,
+			CreatedAt: event.CreatedAt,
+		},
+		TotalFollowers: event.TotalFollowers,
+		lang:           user.Language(),
+	}
+	if event.Follower != nil {
+		d.Follower = *event.Follower
+		d.Follower.Name = EscapeMrkdwn(d.Follower.Name)
+		d.Follower.Bio = EscapeMrkdwn(d.Follower.Bio)
+		d.Follower.Location = EscapeMrkdwn(d.Follower.Location)
+	}
+	return d
+}
+
+// SampleFollowerEvent returns an event to preview templates with.
+func SampleFollowerEvent(user *data.User, reason string) *data.FollowerEvent {
+	e := &data.FollowerEvent{
+		ID:                  "sample",
+		UserID:              user.ID,
+		TotalFollowers:      1234,
+		FollowerState:       data.FollowerStateNew,
+		FollowerStateReason: data.FollowerStateReasonFollowed,
+		CreatedAt:           time.Now().UTC().Truncate(time.Second),
+		Follower: &twitter.User{
+			ID:              "783214",
+			Handle:          "Twitter",
+			Name:            "Twitter",
+			Location:        "everywhere",
+			Bio:             "What's happening?!",
+			ProfileImageURL: "https://pbs.twimg.com/profile_images/1488548719062654976/u6qfBBkF_normal.jpg",
+			Verified:        true,
+			TotalFollowers:  65000000,
+		},
+	}
+
+	switch reason {
+	case data.FollowerStateReasonRefollowed:
+		e.FollowerStateReason, e.Refollows = reason, data.SerialRefollows
+	case data.FollowerStateReasonUnfollowed, data.FollowerStateReasonProtected,
+		data.FollowerStateReasonBlocked, data.FollowerStateReasonRemoved:
+		e.FollowerState, e.FollowerStateReason = data.FollowerStateLost, reason
+	case data.FollowerStateReasonDeactivated, data.FollowerStateReasonDeleted, data.FollowerStateReasonSuspended:
+		e.FollowerState, e.FollowerStateReason = data.FollowerStateLost, reason
+		e.Follower = &twitter.User{ID: e.Follower.ID}
+	}
+
+	return e
+}
+
+// templateFuncs returns all functions available to templates besides the
+// builtins, formatting numbers with the given printer. None of them has side
+// effects.
+func templateFuncs(p *message.Printer) template.FuncMap {
+	return template.FuncMap{
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+		"trim":  strings.TrimSpace,
+		"replace": func(s, old, new string) string {
+			return strings.ReplaceAll(s, old, new)
+		},
+		"truncate": func(n int, s string) string {
+			if utf8.RuneCountInString(s) <= n {
+				return s
+			}
+			return string([]rune(s)[:n]) + "…"
+		},
+		"default": func(def, s string) string {
+			if s == "" {
+				return def
+			}
+			return s
+		},
+		"plural": func(n int, one, many string) string {
+			if n == 1 {
+				return one
+			}
+			return many
+		},
+		"number": func(n int) string {
+			return p.Sprintf("%d", n)
+		},
+		"link": func(url, text string) string {
+			return "<" + url + "|" + text + ">"
+		},
+	}
+}
+
+// ParseTemplate parses a notification template. Only actions that can't run
+// away are allowed: no loops and no calls of other templates.
+func ParseTemplate(name, text string) (*template.Template, error) {
+	funcs := templateFuncs(i18n.NewPrinter(language.English))
+	t, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkNodes(t.Tree, t.Tree.Root); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func checkNodes(tree *parse.Tree, node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkNodes(tree, child); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return checkBranch(tree, &n.BranchNode)
+	case *parse.WithNode:
+		return checkBranch(tree, &n.BranchNode)
+	case *parse.RangeNode:
+		location, _ := tree.ErrorContext(n)
+		return fmt.Errorf("template: %s: range is not allowed", location)
+	case *parse.TemplateNode:
+		location, _ := tree.ErrorContext(n)
+		return fmt.Errorf("template: %s: template is not allowed", location)
+	}
+	return nil
+}
+
+func checkBranch(tree *parse.Tree, n *parse.BranchNode) error {
+	if err := checkNodes(tree, n.List); err != nil {
+		return err
+	}
+	return checkNodes(tree, n.ElseList)
+}
+
+// RenderTemplate parses and executes a notification template in the user's
+// language.
+func RenderTemplate(name, text string, d *TemplateData) (string, error) {
+	t, err := ParseTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+
+	t.Funcs(templateFuncs(i18n.NewPrinter(d.lang)))
+
+	var buf bytes.Buffer
+	if err := t.Execute(&limitedWriter{&buf, maxTemplateOutput}, d); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// ValidateTemplates renders all templates against sample events to report
+// errors along with their position, e.g. "template: text:1:12: ...".
+func ValidateTemplates(user *data.User, templates data.NotificationTemplates) error {
+	parts := []struct{ name, text string }{
+		{"header", templates.Header},
+		{"text", templates.Text},
+		{"footer", templates.Footer},
+	}
+
+	for _, reason := range []string{
+		data.FollowerStateReasonFollowed,
+		data.FollowerStateReasonUnfollowed,
+		data.FollowerStateReasonDeleted,
+		data.FollowerStateReasonSuspended,
+		data.FollowerStateReasonRefollowed,
+		data.FollowerStateReasonDeactivated,
+		data.FollowerStateReasonProtected,
+		data.FollowerStateReasonBlocked,
+		data.FollowerStateReasonRemoved,
+	} {
+		d := NewTemplateData(user, SampleFollowerEvent(user, reason))
+		for _, part := range parts {
+			if part.text == "" {
+				continue
+			}
+			if _, err := RenderTemplate(part.name, part.text, d); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+var errTemplateOutputTooLong = fmt.Errorf("output exceeds %d bytes", maxTemplateOutput)
+
+type limitedWriter struct {
+	buf *bytes.Buffer
+	n   int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > w.n {
+		return 0, errTemplateOutputTooLong
+	}
+	return w.buf.Write(p)
+}
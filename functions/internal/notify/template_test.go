@@ -0,0 +1,107 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	d := NewTemplateData(&data.User{Handle: "alice", Name: "Alice"}, &data.FollowerEvent{
+		TotalFollowers:      1000,
+		Follower:            &twitter.User{ID: "1", Handle: "bob", Name: "Bob", Bio: "  Gopher  "},
+		FollowerState:       data.FollowerStateNew,
+		FollowerStateReason: data.FollowerStateReasonFollowed,
+		CreatedAt:           time.Date(2020, 11, 7, 9, 30, 0, 0, time.UTC),
+	})
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{
+			`{{.Follower.Name}} ({{link (print "https://twitter.com/" .Follower.Handle) .Follower.Handle}}) {{if eq .Event.State "NEW"}}followed{{else}}unfollowed{{end}} you`,
+			"Bob (<https://twitter.com/bob|bob>) followed you",
+		},
+		{`{{number .TotalFollowers}} {{plural .TotalFollowers "follower" "followers"}}`, "1,000 followers"},
+		{`{{plural 1 "follower" "followers"}}`, "follower"},
+		{`{{upper .User.Handle}} {{lower .Event.Reason}}`, "ALICE followed"},
+		{`[{{trim .Follower.Bio}}]`, "[Gopher]"},
+		{`{{replace .User.Name "A" "a"}}`, "alice"},
+		{`{{truncate 2 .User.Name}} {{truncate 10 .User.Name}}`, "Al… Alice"},
+		{`{{default "n/a" .Follower.Location}}`, "n/a"},
+		{`{{.Event.CreatedAt.Format "2006-01-02"}}`, "2020-11-07"},
+		{`{{with .Follower.Bio}}has bio{{end}}`, "has bio"},
+	}
+
+	for _, test := range tests {
+		got, err := RenderTemplate("text", test.template, d)
+		if err != nil {
+			t.Errorf("%s: %s", test.template, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: want %q, got %q", test.template, test.want, got)
+		}
+	}
+}
+
+func TestRenderTemplate_German(t *testing.T) {
+	d := NewTemplateData(&data.User{Locale: data.LocaleGerman}, &data.FollowerEvent{TotalFollowers: 1234567})
+
+	got, err := RenderTemplate("text", `{{number .TotalFollowers}}`, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1.234.567"; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestRenderTemplate_Errors(t *testing.T) {
+	d := NewTemplateData(&data.User{Handle: "alice"}, SampleFollowerEvent(&data.User{}, data.FollowerStateReasonFollowed))
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{`{{.Follower.Nmae}}`, `template: text:1:11: executing "text" at <.Follower.Nmae>: can't evaluate field Nmae`},
+		{`{{.User.Name`, `template: text:1: unclosed action`},
+		{`{{shell "rm"}}`, `template: text:1: function "shell" not defined`},
+		{`ok {{range .User.Name}}x{{end}}`, `template: text:1:11: range is not allowed`},
+		{`{{if true}}{{template "x"}}{{end}}`, `template: text:1:22: template is not allowed`},
+		{strings.Repeat("x", maxTemplateOutput+1), `output exceeds 4000 bytes`},
+		{`{{truncate 10000 (printf "%5000d" 1)}}`, `output exceeds 4000 bytes`},
+	}
+
+	for _, test := range tests {
+		_, err := RenderTemplate("text", test.template, d)
+		if err == nil {
+			t.Errorf("%.40s: expected error", test.template)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%.40s: want error containing %q, got %q", test.template, test.want, err)
+		}
+	}
+}
+
+func TestValidateTemplates(t *testing.T) {
+	user := &data.User{ID: "111", Handle: "alice"}
+
+	if err := ValidateTemplates(user, data.NotificationTemplates{
+		Header: `{{if eq .Event.State "NEW"}}Hello{{else}}Bye{{end}} {{.Follower.Handle}}`,
+		Footer: `{{number .TotalFollowers}} followers`,
+	}); err != nil {
+		t.Error(err)
+	}
+
+	// Fine for followers, but not for deleted users
+	err := ValidateTemplates(user, data.NotificationTemplates{Text: `{{index .Follower.Name 0}}`})
+	if err == nil || !strings.Contains(err.Error(), "template: text:1:") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
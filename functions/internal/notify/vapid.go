@@ -0,0 +1,131 @@
+package notify
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"time"
+)
+
+// vapidTokenTTL is how long the JWT identifying us to push services is
+// valid, at most 24 hours according to RFC 8292.
+const vapidTokenTTL = 12 * time.Hour
+
+// VAPIDKey identifies the application server to push services, see
+// https://datatracker.ietf.org/doc/html/rfc8292. Browsers subscribe with
+// its public key, and push services only accept messages signed with the
+// private key.
+type VAPIDKey struct {
+	key *ecdsa.PrivateKey
+}
+
+// GenerateVAPIDKey creates a new key pair, e.g. to set up Web Push.
+func GenerateVAPIDKey() (*VAPIDKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &VAPIDKey{key}, nil
+}
+
+// ParseVAPIDKey parses a private key in the format used by most Web Push
+// libraries: the base64url-encoded 32-byte scalar.
+func ParseVAPIDKey(privateKey string) (*VAPIDKey, error) {
+	d, err := decodeBase64URL(privateKey)
+	if err != nil || len(d) != 32 {
+		return nil, errors.New("vapid: private key must be 32 bytes encoded as base64url")
+	}
+
+	curve := elliptic.P256()
+	key := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	if key.D.Sign() == 0 || key.D.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("vapid: invalid private key")
+	}
+	key.Curve = curve
+	key.X, key.Y = curve.ScalarBaseMult(d)
+	return &VAPIDKey{key}, nil
+}
+
+// PrivateKey returns the base64url-encoded private key.
+func (k *VAPIDKey) PrivateKey() string {
+	return base64.RawURLEncoding.EncodeToString(k.key.D.FillBytes(make([]byte, 32)))
+}
+
+// PublicKey returns the base64url-encoded uncompressed public key, which
+// browsers expect as applicationServerKey when subscribing.
+func (k *VAPIDKey) PublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(marshalP256(k.key.X, k.key.Y))
+}
+
+// authorization returns the value of the Authorization header for requests
+// to the push service at endpoint. The subject tells the push service whom
+// to contact, e.g. a mailto: or https: URL.
+func (k *VAPIDKey) authorization(endpoint, subject string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(map[string]string{"typ": "JWT", "alg": "ES256"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(vapidTokenTTL).Unix(),
+		"sub": subject,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, k.key, hash[:])
+	if err != nil {
+		return "", err
+	}
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+
+	token := unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)
+	return fmt.Sprintf("vapid t=%s, k=%s", token, k.PublicKey()), nil
+}
+
+// marshalP256 encodes a point in uncompressed form. crypto/ecdh would do,
+// but requires Go 1.20.
+func marshalP256(x, y *big.Int) []byte {
+	b := make([]byte, 65)
+	b[0] = 4
+	x.FillBytes(b[1:33])
+	y.FillBytes(b[33:])
+	return b
+}
+
+// unmarshalP256 decodes a point in uncompressed form, making sure that it
+// is on the curve.
+func unmarshalP256(b []byte) (x, y *big.Int, err error) {
+	if len(b) != 65 || b[0] != 4 {
+		return nil, nil, errors.New("invalid P-256 public key")
+	}
+	x, y = new(big.Int).SetBytes(b[1:33]), new(big.Int).SetBytes(b[33:])
+	if !elliptic.P256().IsOnCurve(x, y) {
+		return nil, nil, errors.New("invalid P-256 public key")
+	}
+	return x, y, nil
+}
+
+// decodeBase64URL accepts base64url with or without padding, as browsers
+// and libraries differ.
+func decodeBase64URL(s string) ([]byte, error) {
+	if n := len(s) % 4; n != 0 {
+		s += "===="[n:]
+	}
+	return base64.URLEncoding.DecodeString(s)
+}
@@ -0,0 +1,452 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+)
+
+// Headers sent with every webhook request
+const (
+	WebhookSignatureHeader = "Listkeeper-Signature"
+	WebhookTimestampHeader = "Listkeeper-Timestamp"
+	WebhookDeliveryHeader  = "Listkeeper-Delivery"
+)
+
+const (
+	defaultWebhookMaxAttempts  = 5
+	defaultWebhookBackoff      = 5 * time.Minute
+	defaultWebhookDisableAfter = 5
+	defaultWebhookLogTTL       = 30 * 24 * time.Hour
+	webhookRequestTimeout      = 10 * time.Second
+)
+
+// webhookChannelPrefix is prepended to the ID of a webhook to track its
+// deliveries like those of a notification channel.
+const webhookChannelPrefix = "webhook:"
+
+// Webhooks sends follower events as signed JSON to the webhook endpoints of a
+// user. Unlike the channels of a Registry, endpoints receive the raw events
+// rather than a rendered message.
+//
+// Each delivery makes a single request. If it fails, the next attempt is
+// scheduled with exponential backoff and made by Retry. Once all attempts
+// failed, the delivery ends up on the user's dead-letter list under
+// WebhookChannel, from where it can be replayed via DeliverChannel.
+type Webhooks struct {
+	Table data.TableAPI
+
+	// Client defaults to a client that refuses to connect to private,
+	// loopback, and link-local addresses, see webhookClient.
+	Client *http.Client
+
+	// MaxAttempts is how often a delivery is tried before it is given up.
+	// Defaults to 5.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry, which doubles with every
+	// further attempt. Defaults to 5 minutes.
+	Backoff time.Duration
+
+	// DisableAfter is the number of consecutive failed deliveries after
+	// which an endpoint is disabled.
+	DisableAfter int
+
+	// LogTTL is how long deliveries are kept in the table.
+	LogTTL time.Duration
+
+	// Now defaults to time.Now.
+	Now func() time.Time
+}
+
+// WebhookChannel returns the name under which deliveries to a webhook are
+// tracked, e.g. on the dead-letter list.
+func WebhookChannel(webhookID string) string {
+	return webhookChannelPrefix + webhookID
+}
+
+// IsWebhookChannel reports whether deliveries via the channel go to a
+// webhook and returns its ID.
+func IsWebhookChannel(channel string) (string, bool) {
+	if !strings.HasPrefix(channel, webhookChannelPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(channel, webhookChannelPrefix), true
+}
+
+// SignWebhook returns the signature of a webhook request, which is the
+// hex-encoded HMAC-SHA256 of the timestamp and the body joined by a dot.
+// Receivers should reject requests with an old timestamp to prevent replays.
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10))) //nolint:errcheck
+	mac.Write([]byte("."))                                     //nolint:errcheck
+	mac.Write(body)                                            //nolint:errcheck
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Deliver sends the event to all enabled webhooks of the user and records
+// each delivery. A failing endpoint doesn't keep the event from being sent to
+// the others. Events the endpoint already received, or whose delivery is
+// waiting for a retry, are skipped, as the event bus delivers at least once.
+func (wh *Webhooks) Deliver(ctx context.Context, user *data.User, event *data.FollowerEvent) error {
+	var (
+		failed   []string
+		firstErr error
+	)
+
+	for _, w := range user.Webhooks {
+		if !w.Enabled {
+			continue
+		}
+		if err := wh.deliver(ctx, user, w, event); err != nil {
+			log.Printf("webhook %s: delivery failed: %s", w.ID, err)
+			failed = append(failed, w.ID)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if firstErr != nil {
+		return fmt.Errorf("failed to deliver to webhooks %s: %w", strings.Join(failed, ", "), firstErr)
+	}
+	return nil
+}
+
+// DeliverChannel sends the event to a single webhook again, e.g. to replay a
+// failed delivery. It starts a new round of attempts.
+func (wh *Webhooks) DeliverChannel(ctx context.Context, user *data.User, channel string, event *data.FollowerEvent) error {
+	id, ok := IsWebhookChannel(channel)
+	if !ok {
+		return fmt.Errorf("unknown channel %q", channel)
+	}
+	w := user.Webhook(id)
+	if w == nil {
+		return data.ErrWebhookNotFound
+	}
+	if !w.Enabled {
+		return fmt.Errorf("webhook %s is disabled", id)
+	}
+
+	d, err := wh.Table.GetWebhookDelivery(ctx, user.ID, w.ID, data.NewWebhookDeliveryID(event.ID, w.ID))
+	switch {
+	case errors.Is(err, data.ErrWebhookDeliveryNotFound):
+		d = wh.newDelivery(user, w, event)
+	case err != nil:
+		return err
+	}
+	d.Attempts = 0
+	d.Event = event
+	return wh.attempt(ctx, user, w, d, true)
+}
+
+// Retry makes the next attempt of all deliveries that are due. It stops
+// early if the context is about to expire, leaving the rest to the next run.
+func (wh *Webhooks) Retry(ctx context.Context) error {
+	due, err := wh.Table.GetDueWebhookDeliveries(ctx, wh.now())
+	if err != nil {
+		return err
+	}
+
+	var (
+		users    = map[string]*data.User{}
+		retried  int
+		failed   []string
+		firstErr error
+	)
+	for _, d := range due {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < webhookRequestTimeout {
+			log.Printf("running out of time, leaving %d webhook deliveries to the next run", len(due)-retried)
+			break
+		}
+		retried++
+
+		if err := wh.retry(ctx, users, d); err != nil {
+			log.Printf("webhook %s: retry of delivery %s failed: %s", d.WebhookID, d.ID, err)
+			failed = append(failed, d.ID)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	log.Printf("retried %d webhook deliveries", retried)
+
+	if firstErr != nil {
+		return fmt.Errorf("failed to retry webhook deliveries %s: %w", strings.Join(failed, ", "), firstErr)
+	}
+	return nil
+}
+
+func (wh *Webhooks) retry(ctx context.Context, users map[string]*data.User, d *data.WebhookDelivery) error {
+	user, ok := users[d.UserID]
+	if !ok {
+		var err error
+		user, err = wh.Table.GetUser(ctx, d.UserID)
+		switch {
+		case errors.Is(err, data.ErrUserNotFound):
+			user = nil
+		case err != nil:
+			return err
+		}
+		users[d.UserID] = user
+	}
+
+	var w *data.Webhook
+	if user != nil {
+		w = user.Webhook(d.WebhookID)
+	}
+	if w == nil || !w.Enabled {
+		// Nobody is waiting for the event anymore
+		log.Printf("webhook %s: removed or disabled, giving up on delivery %s", d.WebhookID, d.ID)
+		d.NextAttemptAt = time.Time{}
+		d.Event = nil
+		return wh.Table.SaveWebhookDelivery(ctx, d)
+	}
+
+	return wh.attempt(ctx, user, w, d, false)
+}
+
+func (wh *Webhooks) deliver(ctx context.Context, user *data.User, w *data.Webhook, event *data.FollowerEvent) error {
+	id := data.NewWebhookDeliveryID(event.ID, w.ID)
+	_, err := wh.Table.GetWebhookDelivery(ctx, user.ID, w.ID, id)
+	switch {
+	case err == nil:
+		log.Printf("webhook %s: event %s already delivered, skipping", w.ID, event.ID)
+		return nil
+	case !errors.Is(err, data.ErrWebhookDeliveryNotFound):
+		return err
+	}
+
+	return wh.attempt(ctx, user, w, wh.newDelivery(user, w, event), false)
+}
+
+func (wh *Webhooks) newDelivery(user *data.User, w *data.Webhook, event *data.FollowerEvent) *data.WebhookDelivery {
+	now := wh.now()
+	return &data.WebhookDelivery{
+		ID:        data.NewWebhookDeliveryID(event.ID, w.ID),
+		UserID:    user.ID,
+		WebhookID: w.ID,
+		EventID:   event.ID,
+		Event:     event,
+		CreatedAt: now,
+		ExpiresAt: now.Add(wh.logTTL()),
+	}
+}
+
+// attempt makes a single request to the webhook and either schedules the next
+// attempt or settles the delivery. A failed request is not returned as an
+// error, as the retry is up to the schedule.
+func (wh *Webhooks) attempt(ctx context.Context, user *data.User, w *data.Webhook, d *data.WebhookDelivery, replay bool) error {
+	event := d.Event
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	d.Attempts++
+	status, postErr := wh.post(ctx, w, d.ID, body)
+	d.StatusCode = status
+	d.Succeeded = postErr == nil
+	d.Error = ""
+	d.NextAttemptAt = time.Time{}
+	d.Event = nil
+
+	giveUp := postErr != nil && d.Attempts >= wh.maxAttempts()
+	if postErr != nil {
+		d.Error = postErr.Error()
+		if !giveUp {
+			d.NextAttemptAt = wh.now().Add(wh.backoff() << (d.Attempts - 1))
+			d.Event = event
+			log.Printf("webhook %s: attempt %d failed, retrying at %s: %s",
+				w.ID, d.Attempts, d.NextAttemptAt.Format(time.RFC3339), postErr)
+		} else {
+			log.Printf("webhook %s: giving up on event %s after %d attempts: %s", w.ID, event.ID, d.Attempts, postErr)
+		}
+	}
+
+	if err := wh.Table.SaveWebhookDelivery(ctx, d); err != nil {
+		return err
+	}
+
+	// Only deliveries that won't be tried again count as failed
+	updated := *w
+	switch {
+	case d.Succeeded:
+		updated.Failures = 0
+	case giveUp:
+		updated.Failures++
+		if updated.Failures >= wh.disableAfter() {
+			log.Printf("webhook %s: disabled after %d failed deliveries", w.ID, updated.Failures)
+			updated.Enabled = false
+		}
+	}
+	if updated != *w {
+		if err := wh.Table.UpdateUserWebhook(ctx, user, &updated); err != nil {
+			return err
+		}
+		*w = updated
+	}
+
+	switch {
+	case giveUp:
+		return wh.settle(ctx, user, w, d, event, data.DeliveryStatusFailed, true)
+	case d.Succeeded && (replay || d.Attempts > 1):
+		// The delivery may have been replayed from the dead-letter list
+		return wh.settle(ctx, user, w, d, event, data.DeliveryStatusSent, false)
+	}
+	return nil
+}
+
+// settle records the outcome of a delivery like that of a notification
+// channel, which puts failed deliveries on the dead-letter list. Unless
+// create is set, only an existing record is updated.
+func (wh *Webhooks) settle(ctx context.Context, user *data.User, w *data.Webhook, d *data.WebhookDelivery, event *data.FollowerEvent, status string, create bool) error {
+	now := wh.now()
+	channel := WebhookChannel(w.ID)
+
+	n, err := wh.Table.GetNotificationDelivery(ctx, user.ID, event.ID, channel)
+	switch {
+	case errors.Is(err, data.ErrNotificationNotFound):
+		if !create {
+			return nil
+		}
+		n = data.NewNotificationDelivery(event, channel, now)
+	case err != nil:
+		return err
+	}
+
+	n.Status = status
+	n.Attempts = d.Attempts
+	n.LastError = d.Error
+	n.Response = ""
+	if d.StatusCode != 0 {
+		n.Response = fmt.Sprintf("%d %s", d.StatusCode, http.StatusText(d.StatusCode))
+	}
+	n.UpdatedAt = now
+	return wh.Table.SaveNotificationDelivery(ctx, n)
+}
+
+// post sends a single request and returns the status code of the response.
+func (wh *Webhooks) post(ctx context.Context, w *data.Webhook, deliveryID string, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Listkeeper-Webhooks")
+	req.Header.Set(WebhookDeliveryHeader, deliveryID)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(w.Secret, now, body))
+
+	client := wh.Client
+	if client == nil {
+		client = webhookClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16)) //nolint:errcheck
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func (wh *Webhooks) maxAttempts() int {
+	if wh.MaxAttempts != 0 {
+		return wh.MaxAttempts
+	}
+	return defaultWebhookMaxAttempts
+}
+
+func (wh *Webhooks) backoff() time.Duration {
+	if wh.Backoff != 0 {
+		return wh.Backoff
+	}
+	return defaultWebhookBackoff
+}
+
+func (wh *Webhooks) disableAfter() int {
+	if wh.DisableAfter != 0 {
+		return wh.DisableAfter
+	}
+	return defaultWebhookDisableAfter
+}
+
+func (wh *Webhooks) logTTL() time.Duration {
+	if wh.LogTTL != 0 {
+		return wh.LogTTL
+	}
+	return defaultWebhookLogTTL
+}
+
+func (wh *Webhooks) now() time.Time {
+	if wh.Now != nil {
+		return wh.Now()
+	}
+	return time.Now()
+}
+
+// errWebhookAddress is returned when a webhook resolves to an address that
+// is not on the public internet.
+var errWebhookAddress = errors.New("webhook address not allowed")
+
+// webhookClient only connects to public addresses. The check happens when
+// dialing, after DNS resolution, so that neither redirects nor DNS records
+// pointing to internal addresses get past it. Proxies are ignored for the
+// same reason.
+var webhookClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: webhookRequestTimeout,
+			Control: checkWebhookAddress,
+		}).DialContext,
+		TLSHandshakeTimeout: webhookRequestTimeout,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+func checkWebhookAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: %s", errWebhookAddress, address)
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return fmt.Errorf("%w: %s", errWebhookAddress, address)
+	}
+	return nil
+}
@@ -0,0 +1,325 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+func newWebhookUser(t *testing.T, table data.TableAPI, url string) *data.User {
+	t.Helper()
+
+	u := data.NewUser("111")
+	u.Handle = "alice"
+	u.Name = "Alice"
+	u.ProfileImageURL = "https://example.com/alice.png"
+	u.AccessToken = "token"
+	u.AccessSecret = "secret"
+	u.LastLogin = u.CreatedAt
+	u.LastIP = "127.0.0.1"
+	u.LoginsCount = 1
+	if err := u.SetWebhooks([]data.WebhookInput{{URL: url, Enabled: true}}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.CreateUser(context.Background(), u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func newFollowerEvent() *data.FollowerEvent {
+	now := time.Now()
+	return &data.FollowerEvent{
+		ID:                  "some-event-id",
+		UserID:              "111",
+		TotalFollowers:      42,
+		Follower:            &twitter.User{ID: "2", Handle: "bob", Name: "Bob"},
+		FollowerState:       data.FollowerStateNew,
+		FollowerStateReason: data.FollowerStateReasonFollowed,
+		CreatedAt:           now,
+		ExpiresAt:           now.Add(24 * time.Hour),
+	}
+}
+
+func TestWebhooks_Deliver(t *testing.T) {
+	var (
+		ctx         = context.Background()
+		table       = data.NewMemoryTable()
+		now         = time.Now()
+		requests    int
+		deliveryIDs []string
+		user        *data.User
+	)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		deliveryIDs = append(deliveryIDs, r.Header.Get(WebhookDeliveryHeader))
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		ts, err := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+		if err != nil {
+			t.Error(err)
+		}
+		if got, want := r.Header.Get(WebhookSignatureHeader), SignWebhook(user.Webhooks[0].Secret, time.Unix(ts, 0), body); got != want {
+			t.Errorf("signature = %q, want %q", got, want)
+		}
+
+		var e data.FollowerEvent
+		if err := json.Unmarshal(body, &e); err != nil {
+			t.Error(err)
+		}
+		if e.ID != "some-event-id" || e.Follower.Handle != "bob" {
+			t.Errorf("unexpected event: %+v", e)
+		}
+	}))
+	defer srv.Close()
+
+	user = newWebhookUser(t, table, srv.URL)
+	wh := &Webhooks{Table: table, Client: srv.Client(), Now: func() time.Time { return now }}
+
+	// The failed request is left to the schedule
+	if err := wh.Deliver(ctx, user, newFollowerEvent()); err != nil {
+		t.Fatal(err)
+	}
+	// The event bus may deliver the event again
+	if err := wh.Deliver(ctx, user, newFollowerEvent()); err != nil {
+		t.Fatal(err)
+	}
+	// Not due yet
+	if err := wh.Retry(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 {
+		t.Fatalf("expected 1 request, got %d", requests)
+	}
+
+	now = now.Add(defaultWebhookBackoff)
+	if err := wh.Retry(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing left to retry
+	if err := wh.Retry(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	id := "some-event-id-" + user.Webhooks[0].ID
+	if diff := cmp.Diff([]string{id, id}, deliveryIDs); diff != "" {
+		t.Error(diff)
+	}
+
+	deliveries, err := table.GetLatestWebhookDeliveries(ctx, user.ID, user.Webhooks[0].ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("expected 1 delivery, got %d", len(deliveries))
+	}
+	d := deliveries[0]
+	if d.ID != id || !d.Succeeded || d.Attempts != 2 || d.StatusCode != http.StatusOK || d.Error != "" ||
+		!d.NextAttemptAt.IsZero() || d.Event != nil {
+		t.Errorf("unexpected delivery: %+v", d)
+	}
+}
+
+func TestWebhooks_RetryBacksOff(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		table    = data.NewMemoryTable()
+		now      = time.Now()
+		requests []time.Time
+	)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, now)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	user := newWebhookUser(t, table, srv.URL)
+	wh := &Webhooks{Table: table, Client: srv.Client(), MaxAttempts: 4, Backoff: time.Minute, Now: func() time.Time { return now }}
+
+	start := now
+	if err := wh.Deliver(ctx, user, newFollowerEvent()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		now = now.Add(time.Minute)
+		if err := wh.Retry(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []time.Time{start, start.Add(1 * time.Minute), start.Add(3 * time.Minute), start.Add(7 * time.Minute)}
+	if diff := cmp.Diff(want, requests); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestWebhooks_DeliverDisablesFailingEndpoint(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		table    = data.NewMemoryTable()
+		now      = time.Now()
+		requests int
+	)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	user := newWebhookUser(t, table, srv.URL)
+	wh := &Webhooks{Table: table, Client: srv.Client(), MaxAttempts: 3, DisableAfter: 2, Now: func() time.Time { return now }}
+
+	// Each event is tried until all attempts failed
+	for i := 0; i < 3; i++ {
+		event := newFollowerEvent()
+		event.ID = fmt.Sprintf("event-%d", i)
+		user, err := table.GetUser(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := wh.Deliver(ctx, user, event); err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < 3; j++ {
+			now = now.Add(time.Hour)
+			if err := wh.Retry(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Disabled after two failed deliveries
+	if want := 2 * 3; requests != want {
+		t.Errorf("expected %d requests, got %d", want, requests)
+	}
+
+	stored, err := table.GetUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := stored.Webhooks[0]; w.Enabled || w.Failures != 2 {
+		t.Errorf("expected webhook to be disabled: %+v", w)
+	}
+
+	deliveries, err := table.GetLatestWebhookDeliveries(ctx, user.ID, user.Webhooks[0].ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 2 {
+		t.Fatalf("expected 2 deliveries, got %d", len(deliveries))
+	}
+	if d := deliveries[0]; d.Succeeded || d.Attempts != 3 || d.StatusCode != http.StatusInternalServerError || d.Error == "" || !d.NextAttemptAt.IsZero() {
+		t.Errorf("unexpected delivery: %+v", d)
+	}
+
+	dead, err := table.GetDeadLetters(ctx, user.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 2 {
+		t.Errorf("expected 2 dead letters, got %d", len(dead))
+	}
+}
+
+func TestWebhooks_DeliverChannel(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		table    = data.NewMemoryTable()
+		requests int
+	)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	user := newWebhookUser(t, table, srv.URL)
+	wh := &Webhooks{Table: table, Client: srv.Client(), MaxAttempts: 1}
+	channel := WebhookChannel(user.Webhooks[0].ID)
+
+	if err := wh.Deliver(ctx, user, newFollowerEvent()); err != nil {
+		t.Fatal(err)
+	}
+	n, err := table.GetNotificationDelivery(ctx, user.ID, "some-event-id", channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Status != data.DeliveryStatusFailed || n.Attempts != 1 || n.LastError == "" {
+		t.Errorf("unexpected dead letter: %+v", n)
+	}
+
+	// Replayed from the dead-letter list
+	if err := n.Requeue(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.SaveNotificationDelivery(ctx, n); err != nil {
+		t.Fatal(err)
+	}
+	if err := wh.DeliverChannel(ctx, user, channel, n.Event); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err = table.GetNotificationDelivery(ctx, user.ID, "some-event-id", channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Status != data.DeliveryStatusSent || n.Attempts != 1 || n.LastError != "" {
+		t.Errorf("unexpected notification delivery: %+v", n)
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 requests, got %d", requests)
+	}
+}
+
+func TestWebhooks_RefusesInternalAddresses(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		table    = data.NewMemoryTable()
+		requests int
+	)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer srv.Close()
+
+	user := newWebhookUser(t, table, srv.URL)
+	wh := &Webhooks{Table: table, MaxAttempts: 1}
+
+	if err := wh.Deliver(ctx, user, newFollowerEvent()); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 0 {
+		t.Errorf("expected no requests, got %d", requests)
+	}
+
+	deliveries, err := table.GetLatestWebhookDeliveries(ctx, user.ID, user.Webhooks[0].ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || !strings.Contains(deliveries[0].Error, errWebhookAddress.Error()) {
+		t.Errorf("unexpected deliveries: %+v", deliveries)
+	}
+}
@@ -0,0 +1,632 @@
+package notifyuser
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/pkg/errors"
+	"golang.org/x/text/message"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/i18n"
+	"github.com/mlafeldt/listkeeper/functions/internal/notify"
+)
+
+type Output struct {
+	Header string
+	Text   string
+	Footer string
+}
+
+type Handler struct {
+	Table     data.TableAPI
+	Notifiers *notify.Registry
+	Webhooks  *notify.Webhooks // optional
+	AppURL    string
+
+	// Now defaults to time.Now.
+	Now func() time.Time
+}
+
+func (h *Handler) Handle(ctx context.Context, event events.CloudWatchEvent) (*Output, error) {
+	switch event.DetailType {
+	case "Twitter Follower Change":
+		var e data.FollowerEvent
+		if err := json.Unmarshal(event.Detail, &e); err != nil {
+			return nil, err
+		}
+		return h.notifyFollowerChange(ctx, &e)
+	case "Tracking Status Change":
+		var e data.TrackingStatusEvent
+		if err := json.Unmarshal(event.Detail, &e); err != nil {
+			return nil, err
+		}
+		return h.notifyTrackingStatusChange(ctx, event.ID, &e)
+	case "Follower Milestone":
+		var e data.MilestoneEvent
+		if err := json.Unmarshal(event.Detail, &e); err != nil {
+			return nil, err
+		}
+		return h.notifyMilestone(ctx, event.ID, &e)
+	case "Follower Anomaly":
+		var e data.AnomalyEvent
+		if err := json.Unmarshal(event.Detail, &e); err != nil {
+			return nil, err
+		}
+		return h.notifyAnomaly(ctx, event.ID, &e)
+	case "Notification Replay":
+		var e data.NotificationReplayEvent
+		if err := json.Unmarshal(event.Detail, &e); err != nil {
+			return nil, err
+		}
+		return h.replayNotification(ctx, &e)
+	case "Scheduled Event":
+		return h.runSchedule(ctx)
+	default:
+		return nil, fmt.Errorf("unable to handle event of type %q", event.DetailType)
+	}
+}
+
+func (h *Handler) notifyFollowerChange(ctx context.Context, event *data.FollowerEvent) (*Output, error) {
+	log.SetPrefix(event.UserID + " ")
+	log.Printf("event = %+v", event)
+
+	user, err := h.Table.GetUser(ctx, event.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	out, imageURL := FollowerChangeOutput(user, event)
+
+	switch {
+	case user.Digest.Enabled():
+		// Events are stored by diff-followers anyway and summarized by send-digests
+		log.Printf("digest mode %s, skipping notification", user.Digest.Mode)
+	case user.InQuietHours(h.now()):
+		// The event may have been deferred before the webhooks failed
+		d := data.NewDeferredEvent(event, h.now())
+		if err := h.Table.CreateDeferredEvent(ctx, d); err != nil && !errors.Is(err, data.ErrDeferredEventExists) {
+			return nil, err
+		}
+		log.Printf("quiet hours, deferred notification until %02d:00", user.QuietHours.End)
+	case user.Burst.Enabled:
+		dueAt, err := h.buffer(ctx, user, event)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("buffered notification until %s", dueAt.Format(time.RFC3339))
+	default:
+		if err := h.notify(ctx, user, out, imageURL, event); err != nil {
+			return nil, err
+		}
+	}
+
+	// Webhooks are meant for machines, which don't sleep
+	if h.Webhooks != nil {
+		if err := h.Webhooks.Deliver(ctx, user, event); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("output = %s", out)
+
+	return out, nil
+}
+
+// FollowerChangeOutput formats the notification about a follower change. The
+// user's templates replace the defaults, unless they fail to render. It also
+// returns the URL of the follower's profile image, if any.
+func FollowerChangeOutput(user *data.User, event *data.FollowerEvent) (*Output, string) {
+	var (
+		follower = event.Follower
+		p        = i18n.NewPrinter(user.Language())
+	)
+
+	header := map[string]string{
+		"NEW":  p.Sprintf("New follower"),
+		"LOST": p.Sprintf("Lost follower"),
+	}[event.FollowerState]
+	if event.FollowerStateReason == data.FollowerStateReasonRefollowed {
+		header = p.Sprintf("Follower returned")
+	}
+
+	text := summary(p, event)
+
+	const sep = "\n\n"
+	text += sep
+	if event.SerialRefollower() {
+		text += p.Sprintf(":warning: Came back %d times recently, they keep unfollowing and refollowing you%s", event.Refollows, sep)
+	}
+	if follower.Bio != "" {
+		text += p.Sprintf("*Bio:* %s%s", notify.EscapeMrkdwn(follower.Bio), sep)
+	}
+	if follower.Location != "" {
+		text += p.Sprintf("*Location:* %s%s", notify.EscapeMrkdwn(follower.Location), sep)
+	}
+	if follower.Name != "" {
+		text += p.Sprintf("*Followers:* %d%s", follower.TotalFollowers, sep)
+	}
+
+	footer := p.Sprintf("You (@%s) now have %d Twitter followers", user.Handle, event.TotalFollowers)
+
+	var imageURL string
+	if follower.ProfileImageURL != "" {
+		imageURL = strings.Replace(follower.ProfileImageURL, "_normal.", "_400x400.", 1)
+	}
+
+	out := Output{
+		Header: header,
+		Text:   text,
+		Footer: footer,
+	}
+
+	d := notify.NewTemplateData(user, event)
+	for _, part := range []struct {
+		name, template string
+		output         *string
+	}{
+		{"header", user.Templates.Header, &out.Header},
+		{"text", user.Templates.Text, &out.Text},
+		{"footer", user.Templates.Footer, &out.Footer},
+	} {
+		if part.template == "" {
+			continue
+		}
+		rendered, err := notify.RenderTemplate(part.name, part.template, d)
+		if err != nil {
+			log.Printf("failed to render %s template, using default: %s", part.name, err)
+			continue
+		}
+		*part.output = rendered
+	}
+
+	return &out, imageURL
+}
+
+// buffer defers the event until the user's burst window is over. Events join
+// the burst in progress, which also keeps events of the same diff run
+// together, so the window starts with the first event.
+func (h *Handler) buffer(ctx context.Context, user *data.User, event *data.FollowerEvent) (time.Time, error) {
+	deferred, err := h.Table.GetDeferredEvents(ctx, user.ID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	dueAt := h.now().Add(user.BurstWindow())
+	for _, d := range deferred {
+		if !d.DueAt.IsZero() && d.DueAt.Before(dueAt) {
+			dueAt = d.DueAt
+		}
+	}
+
+	d := data.NewDeferredEvent(event, h.now())
+	d.DueAt = dueAt
+	if err := h.Table.CreateDeferredEvent(ctx, d); err != nil && !errors.Is(err, data.ErrDeferredEventExists) {
+		return time.Time{}, err
+	}
+	return dueAt, nil
+}
+
+// flushDeferredEvents delivers the events held back during quiet hours or
+// buffered as bursts of all users whose quiet hours are over and whose bursts
+// are due. It runs on a schedule and only looks at users with deferred
+// events.
+// runSchedule flushes deferred events and retries failed webhook deliveries
+// that are due.
+func (h *Handler) runSchedule(ctx context.Context) (*Output, error) {
+	out, err := h.flushDeferredEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if h.Webhooks != nil {
+		if err := h.Webhooks.Retry(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (h *Handler) flushDeferredEvents(ctx context.Context) (*Output, error) {
+	log.SetPrefix("")
+
+	all, err := h.Table.GetAllDeferredEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		userIDs  []string
+		deferred = map[string][]*data.DeferredEvent{}
+		flushed  int
+	)
+	for _, d := range all {
+		if _, ok := deferred[d.UserID]; !ok {
+			userIDs = append(userIDs, d.UserID)
+		}
+		deferred[d.UserID] = append(deferred[d.UserID], d)
+	}
+
+	for _, id := range userIDs {
+		if !due(deferred[id], h.now()) {
+			continue
+		}
+
+		user, err := h.Table.GetUser(ctx, id)
+		if errors.Is(err, data.ErrUserNotFound) {
+			// Deferred events of deleted users expire
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if user.InQuietHours(h.now()) {
+			continue
+		}
+
+		if err := h.flush(ctx, user, deferred[id]); err != nil {
+			return nil, errors.Wrapf(err, "failed to flush deferred events of user with ID %s", user.ID)
+		}
+		flushed += len(deferred[id])
+	}
+
+	out := Output{Header: fmt.Sprintf("Flushed %d deferred events", flushed)}
+
+	log.Printf("output = %s", out)
+
+	return &out, nil
+}
+
+// due reports whether all buffered events are due. Events deferred during
+// quiet hours are due right away, as quiet hours are checked separately.
+func due(deferred []*data.DeferredEvent, now time.Time) bool {
+	for _, d := range deferred {
+		if d.DueAt.After(now) {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Handler) flush(ctx context.Context, user *data.User, deferred []*data.DeferredEvent) error {
+	// Events of the same run may be deferred within the same second
+	sort.SliceStable(deferred, func(i, j int) bool {
+		return deferred[i].Event.CreatedAt.Before(deferred[j].Event.CreatedAt)
+	})
+
+	var quiet, burst bool
+	for _, d := range deferred {
+		if d.DueAt.IsZero() {
+			quiet = true
+		} else {
+			burst = true
+		}
+	}
+
+	coalesce := (burst || user.QuietHours.Coalesce) && len(deferred) > 1
+	if coalesce {
+		events := make([]*data.FollowerEvent, len(deferred))
+		for i, d := range deferred {
+			events[i] = d.Event
+		}
+		if err := h.notifyCoalesced(ctx, user, events, quiet); err != nil {
+			return err
+		}
+	}
+
+	for _, d := range deferred {
+		if !coalesce {
+			out, imageURL := FollowerChangeOutput(user, d.Event)
+			if err := h.notify(ctx, user, out, imageURL, d.Event); err != nil {
+				return err
+			}
+		}
+		if err := h.Table.DeleteDeferredEvent(ctx, d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// maxCoalescedLines is how many events a coalesced message lists before
+// pointing to the dashboard for the rest.
+const maxCoalescedLines = 10
+
+// notifyCoalesced sends one message that lists all events, new followers
+// first, skipping those filtered out per channel.
+func (h *Handler) notifyCoalesced(ctx context.Context, user *data.User, events []*data.FollowerEvent, quiet bool) error {
+	p := i18n.NewPrinter(user.Language())
+
+	return h.Notifiers.NotifyFunc(ctx, user, func(channel string) *notify.Message {
+		var (
+			filter    = notify.Filter(user, channel)
+			newEvents []*data.FollowerEvent
+			lost      []*data.FollowerEvent
+			first     *data.FollowerEvent
+			latest    *data.FollowerEvent
+		)
+		for _, e := range events {
+			if !filter.Match(e) {
+				continue
+			}
+			if first == nil {
+				first = e
+			}
+			if e.FollowerState == data.FollowerStateNew {
+				newEvents = append(newEvents, e)
+			} else {
+				lost = append(lost, e)
+			}
+			latest = e
+		}
+		if latest == nil {
+			return nil
+		}
+
+		var (
+			total = len(newEvents) + len(lost)
+			lines []string
+			shown int
+		)
+		for _, section := range []struct {
+			title  string
+			events []*data.FollowerEvent
+		}{
+			{p.Sprintf("*New followers:*"), newEvents},
+			{p.Sprintf("*Lost followers:*"), lost},
+		} {
+			if len(section.events) == 0 || shown == maxCoalescedLines {
+				continue
+			}
+			if len(lines) > 0 {
+				lines = append(lines, "")
+			}
+			lines = append(lines, section.title)
+			for _, e := range section.events {
+				if shown == maxCoalescedLines {
+					break
+				}
+				lines = append(lines, "• "+summary(p, e))
+				shown++
+			}
+		}
+		if total > shown {
+			lines = append(lines, p.Sprintf("…and %d more", total-shown))
+		}
+		if h.AppURL != "" {
+			lines = append(lines, "", p.Sprintf("See all changes on your <%s|dashboard>", h.AppURL))
+		}
+
+		header := p.Sprintf("%d follower changes", total)
+		if quiet {
+			header = p.Sprintf("%d follower changes during quiet hours", total)
+		}
+
+		return &notify.Message{
+			Header: header,
+			Text:   strings.Join(lines, "\n"),
+			Footer: p.Sprintf("You (@%s) now have %d Twitter followers", user.Handle, latest.TotalFollowers),
+			// Events deferred later only add to the end, which keeps the
+			// key of a retried message
+			DeliveryEvent: first,
+		}
+	})
+}
+
+// summary describes the follower change in one line. The follower's name is
+// escaped, as anyone can put markup into it.
+func summary(p *message.Printer, event *data.FollowerEvent) string {
+	follower := *event.Follower
+	if follower.Handle == "" {
+		return summaryByID(p, event)
+	}
+	follower.Name = notify.EscapeMrkdwn(follower.Name)
+	return map[string]string{
+		data.FollowerStateReasonFollowed:   p.Sprintf("%s (<https://twitter.com/%s|@%s>) followed you :tada:", follower.Name, follower.Handle, follower.Handle),
+		data.FollowerStateReasonUnfollowed: p.Sprintf("%s (<https://twitter.com/%s|@%s>) unfollowed you", follower.Name, follower.Handle, follower.Handle),
+		data.FollowerStateReasonRefollowed: p.Sprintf("%s (<https://twitter.com/%s|@%s>) followed you again", follower.Name, follower.Handle, follower.Handle),
+		data.FollowerStateReasonProtected:  p.Sprintf("%s (<https://twitter.com/%s|@%s>) went protected and is no longer listed as your follower", follower.Name, follower.Handle, follower.Handle),
+		data.FollowerStateReasonBlocked:    p.Sprintf("%s (<https://twitter.com/%s|@%s>) blocked you", follower.Name, follower.Handle, follower.Handle),
+		data.FollowerStateReasonRemoved:    p.Sprintf("You removed %s (<https://twitter.com/%s|@%s>) from your followers", follower.Name, follower.Handle, follower.Handle),
+	}[event.FollowerStateReason]
+}
+
+// summaryByID describes followers without a profile, which are gone, blocked
+// the user, or weren't looked up as the rate budget was used up.
+func summaryByID(p *message.Printer, event *data.FollowerEvent) string {
+	id := event.Follower.ID
+	return map[string]string{
+		data.FollowerStateReasonFollowed:    p.Sprintf("User with ID %s followed you :tada:", id),
+		data.FollowerStateReasonUnfollowed:  p.Sprintf("User with ID %s unfollowed you", id),
+		data.FollowerStateReasonRefollowed:  p.Sprintf("User with ID %s followed you again", id),
+		data.FollowerStateReasonDeleted:     p.Sprintf("User with ID %s was deleted", id),
+		data.FollowerStateReasonSuspended:   p.Sprintf("User with ID %s was suspended", id),
+		data.FollowerStateReasonDeactivated: p.Sprintf("User with ID %s deactivated their account", id),
+		data.FollowerStateReasonProtected:   p.Sprintf("User with ID %s went protected and is no longer listed as your follower", id),
+		data.FollowerStateReasonBlocked:     p.Sprintf("User with ID %s blocked you", id),
+		data.FollowerStateReasonRemoved:     p.Sprintf("You removed the user with ID %s from your followers", id),
+	}[event.FollowerStateReason]
+}
+
+func (h *Handler) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+	return time.Now()
+}
+
+func (h *Handler) notifyTrackingStatusChange(ctx context.Context, alertID string, event *data.TrackingStatusEvent) (*Output, error) {
+	log.SetPrefix(event.UserID + " ")
+	log.Printf("event = %+v", event)
+
+	if event.TrackingStatus != data.TrackingStatusNeedsReauth {
+		log.Print("nothing to notify")
+		return &Output{}, nil
+	}
+
+	user, err := h.Table.GetUser(ctx, event.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	p := i18n.NewPrinter(user.Language())
+
+	out := Output{
+		Header: p.Sprintf("Please reconnect your account"),
+		Text:   p.Sprintf("Listkeeper can no longer access your Twitter account @%s, most likely because access was revoked. Please <%s|log in again> to resume tracking your followers.", user.Handle, h.AppURL),
+		Footer: p.Sprintf("Tracking of your followers is paused until you log in again"),
+	}
+
+	if err := h.alert(ctx, user, &out, "", alertID); err != nil {
+		return nil, err
+	}
+
+	log.Printf("output = %s", out)
+
+	return &out, nil
+}
+
+// notifyMilestone congratulates the user on reaching a milestone. Like all
+// alerts, it is sent right away, regardless of quiet hours and digests.
+func (h *Handler) notifyMilestone(ctx context.Context, alertID string, event *data.MilestoneEvent) (*Output, error) {
+	log.SetPrefix(event.UserID + " ")
+	log.Printf("event = %+v", event)
+
+	user, err := h.Table.GetUser(ctx, event.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	p := i18n.NewPrinter(user.Language())
+
+	out := Output{
+		Header: p.Sprintf("You reached %d followers", event.Milestone),
+		Text:   p.Sprintf("Congratulations! Your Twitter account @%s has %d followers now :trophy:", user.Handle, event.TotalFollowers),
+		Footer: p.Sprintf("Manage your alerts at <%s|Listkeeper>", h.AppURL),
+	}
+
+	if err := h.alert(ctx, user, &out, user.ProfileImageURL, alertID); err != nil {
+		return nil, err
+	}
+
+	log.Printf("output = %s", out)
+
+	return &out, nil
+}
+
+// notifyAnomaly warns the user about a run that changed their followers
+// unusually, e.g. a mass unfollow.
+func (h *Handler) notifyAnomaly(ctx context.Context, alertID string, event *data.AnomalyEvent) (*Output, error) {
+	log.SetPrefix(event.UserID + " ")
+	log.Printf("event = %+v", event)
+
+	user, err := h.Table.GetUser(ctx, event.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	p := i18n.NewPrinter(user.Language())
+
+	var out Output
+	switch event.Kind {
+	case data.AnomalyKindLoss:
+		var percent float64
+		if event.PreviousFollowers > 0 {
+			percent = float64(event.Lost) * 100 / float64(event.PreviousFollowers)
+		}
+		out.Header = p.Sprintf(":rotating_light: Lost %d followers at once", event.Lost)
+		out.Text = p.Sprintf("%d of your %d followers (%.1f%%) are gone since the last check.", event.Lost, event.PreviousFollowers, percent)
+	case data.AnomalyKindSpike:
+		out.Header = p.Sprintf(":chart_with_upwards_trend: Unusual follower activity")
+		out.Text = p.Sprintf("%d new and %d lost followers since the last check, compared with %.1f changes per check on average.", event.Gained, event.Lost, event.AvgChanges)
+	default:
+		return nil, fmt.Errorf("unknown anomaly %q", event.Kind)
+	}
+	out.Footer = p.Sprintf("You (@%s) now have %d Twitter followers", user.Handle, event.TotalFollowers)
+
+	if err := h.alert(ctx, user, &out, "", alertID); err != nil {
+		return nil, err
+	}
+
+	log.Printf("output = %s", out)
+
+	return &out, nil
+}
+
+// replayNotification sends a dead letter again after it was requeued via
+// GraphQL. Filter rules, quiet hours, and digests don't apply, since the user
+// explicitly asked for it.
+func (h *Handler) replayNotification(ctx context.Context, event *data.NotificationReplayEvent) (*Output, error) {
+	log.SetPrefix(event.UserID + " ")
+	log.Printf("event = %+v", event)
+
+	user, err := h.Table.GetUser(ctx, event.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := h.Table.GetNotificationDelivery(ctx, event.UserID, event.EventID, event.Channel)
+	if err != nil {
+		return nil, err
+	}
+	if d.Alert {
+		return nil, fmt.Errorf("alert %s cannot be replayed", d.EventID)
+	}
+
+	out, imageURL := FollowerChangeOutput(user, d.Event)
+
+	if _, ok := notify.IsWebhookChannel(event.Channel); ok {
+		if h.Webhooks == nil {
+			return nil, fmt.Errorf("unknown channel %q", event.Channel)
+		}
+		if err := h.Webhooks.DeliverChannel(ctx, user, event.Channel, d.Event); err != nil {
+			return nil, err
+		}
+		log.Printf("output = %s", out)
+		return out, nil
+	}
+
+	err = h.Notifiers.NotifyChannel(ctx, user, event.Channel, &notify.Message{
+		Header:   out.Header,
+		Text:     out.Text,
+		Footer:   out.Footer,
+		ImageURL: imageURL,
+		Event:    d.Event,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("output = %s", out)
+
+	return out, nil
+}
+
+func (h *Handler) notify(ctx context.Context, user *data.User, out *Output, imageURL string, event *data.FollowerEvent) error {
+	return h.Notifiers.Notify(ctx, user, &notify.Message{
+		Header:   out.Header,
+		Text:     out.Text,
+		Footer:   out.Footer,
+		ImageURL: imageURL,
+		Event:    event,
+	})
+}
+
+// alert notifies the user about something other than a follower change. Its
+// delivery is tracked under the ID of the triggering event so that retries
+// of the invocation skip the channels that already sent it.
+func (h *Handler) alert(ctx context.Context, user *data.User, out *Output, imageURL, alertID string) error {
+	return h.Notifiers.Notify(ctx, user, &notify.Message{
+		Header:   out.Header,
+		Text:     out.Text,
+		Footer:   out.Footer,
+		ImageURL: imageURL,
+		AlertID:  alertID,
+	})
+}
@@ -0,0 +1,600 @@
+package notifyuser
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/notify"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+type fakeNotifier struct {
+	sent []*notify.Message
+}
+
+func (n *fakeNotifier) Enabled(*data.User) bool { return true }
+
+func (n *fakeNotifier) Notify(_ context.Context, _ *data.User, msg *notify.Message) error {
+	n.sent = append(n.sent, msg)
+	return nil
+}
+
+func newUser(t *testing.T, table data.TableAPI, quiet data.QuietHours) *data.User {
+	t.Helper()
+
+	u := data.NewUser("111")
+	u.Handle = "alice"
+	u.Name = "Alice"
+	u.ProfileImageURL = "https://example.com/alice.png"
+	u.AccessToken = "token"
+	u.AccessSecret = "secret"
+	u.LastLogin = u.CreatedAt
+	u.LastIP = "127.0.0.1"
+	u.LoginsCount = 1
+	u.Timezone = "Europe/Berlin"
+	u.QuietHours = quiet
+	if err := table.CreateUser(context.Background(), u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func followerChange(t *testing.T, handle string, total int, createdAt time.Time) events.CloudWatchEvent {
+	t.Helper()
+
+	return followerEvent(t, &data.FollowerEvent{
+		ID:                  handle,
+		UserID:              "111",
+		TotalFollowers:      total,
+		Follower:            &twitter.User{ID: handle, Handle: handle, Name: handle},
+		FollowerState:       data.FollowerStateNew,
+		FollowerStateReason: data.FollowerStateReasonFollowed,
+		CreatedAt:           createdAt,
+	})
+}
+
+func followerEvent(t *testing.T, e *data.FollowerEvent) events.CloudWatchEvent {
+	t.Helper()
+
+	detail, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events.CloudWatchEvent{DetailType: "Twitter Follower Change", Detail: detail}
+}
+
+func TestHandler_QuietHours(t *testing.T) {
+	tests := []struct {
+		coalesce bool
+		want     []string
+	}{
+		{coalesce: false, want: []string{"New follower", "New follower"}},
+		{coalesce: true, want: []string{"2 follower changes during quiet hours"}},
+	}
+
+	for _, test := range tests {
+		var (
+			ctx      = context.Background()
+			table    = data.NewMemoryTable()
+			_        = newUser(t, table, data.QuietHours{Enabled: true, Start: 22, End: 7, Coalesce: test.coalesce})
+			notifier = &fakeNotifier{}
+			now, _   = time.Parse(time.RFC3339, "2020-11-07T03:00:00+01:00")
+		)
+
+		notifiers := notify.NewRegistry()
+		notifiers.Register("fake", notifier)
+
+		h := &Handler{
+			Table:     table,
+			Notifiers: notifiers,
+			Now:       func() time.Time { return now },
+		}
+
+		for i, handle := range []string{"bob", "carol"} {
+			if _, err := h.Handle(ctx, followerChange(t, handle, 100+i, now.Add(time.Duration(i)*time.Second))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Retried invocations must not defer the same event twice
+		if _, err := h.Handle(ctx, followerChange(t, "bob", 100, now)); err != nil {
+			t.Fatal(err)
+		}
+		if len(notifier.sent) != 0 {
+			t.Fatalf("notified during quiet hours: %+v", notifier.sent)
+		}
+
+		// Still quiet
+		if _, err := h.Handle(ctx, events.CloudWatchEvent{DetailType: "Scheduled Event"}); err != nil {
+			t.Fatal(err)
+		}
+		if len(notifier.sent) != 0 {
+			t.Fatalf("flushed during quiet hours: %+v", notifier.sent)
+		}
+
+		now = now.Add(4 * time.Hour)
+		if _, err := h.Handle(ctx, events.CloudWatchEvent{DetailType: "Scheduled Event"}); err != nil {
+			t.Fatal(err)
+		}
+
+		var headers []string
+		for _, msg := range notifier.sent {
+			headers = append(headers, msg.Header)
+		}
+		if diff := cmp.Diff(test.want, headers); diff != "" {
+			t.Error(diff)
+		}
+		if last := notifier.sent[len(notifier.sent)-1]; last.Footer != "You (@alice) now have 101 Twitter followers" {
+			t.Errorf("unexpected footer: %q", last.Footer)
+		}
+
+		deferred, err := table.GetDeferredEvents(ctx, "111")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deferred) != 0 {
+			t.Errorf("%d deferred events left", len(deferred))
+		}
+	}
+}
+
+type flakyNotifier struct {
+	fakeNotifier
+	failures int
+}
+
+func (n *flakyNotifier) Notify(ctx context.Context, user *data.User, msg *notify.Message) error {
+	if n.failures > 0 {
+		n.failures--
+		return errors.New("channel unavailable")
+	}
+	return n.fakeNotifier.Notify(ctx, user, msg)
+}
+
+func TestHandler_CoalescedPartialFailure(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		table  = data.NewMemoryTable()
+		_      = newUser(t, table, data.QuietHours{Enabled: true, Start: 22, End: 7, Coalesce: true})
+		ok     = &fakeNotifier{}
+		flaky  = &flakyNotifier{failures: 1}
+		now, _ = time.Parse(time.RFC3339, "2020-11-07T03:00:00+01:00")
+	)
+
+	notifiers := notify.NewRegistry()
+	notifiers.TrackDeliveries(&notify.Deliveries{Table: table, Now: func() time.Time { return now }})
+	notifiers.Register("ok", ok)
+	notifiers.Register("flaky", flaky)
+
+	h := &Handler{
+		Table:     table,
+		Notifiers: notifiers,
+		Now:       func() time.Time { return now },
+	}
+
+	for i, handle := range []string{"bob", "carol"} {
+		if _, err := h.Handle(ctx, followerChange(t, handle, 100+i, now.Add(time.Duration(i)*time.Second))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now = now.Add(4 * time.Hour)
+	if _, err := h.Handle(ctx, events.CloudWatchEvent{DetailType: "Scheduled Event"}); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := h.Handle(ctx, events.CloudWatchEvent{DetailType: "Scheduled Event"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The channel that worked the first time isn't notified again
+	if len(ok.sent) != 1 || len(flaky.sent) != 1 {
+		t.Errorf("expected one message per channel, got %d and %d", len(ok.sent), len(flaky.sent))
+	}
+
+	deferred, err := table.GetDeferredEvents(ctx, "111")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deferred) != 0 {
+		t.Errorf("%d deferred events left", len(deferred))
+	}
+}
+
+func TestHandler_Burst(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		table    = data.NewMemoryTable()
+		user     = newUser(t, table, data.QuietHours{})
+		notifier = &fakeNotifier{}
+		now      = time.Date(2020, 11, 7, 12, 0, 0, 0, time.UTC)
+	)
+	user.Burst = data.BurstConfig{Enabled: true, Window: 10}
+	if err := table.UpdateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	notifiers := notify.NewRegistry()
+	notifiers.Register("fake", notifier)
+
+	h := &Handler{
+		Table:     table,
+		Notifiers: notifiers,
+		AppURL:    "https://listkeeper.io",
+		Now:       func() time.Time { return now },
+	}
+	flush := func() {
+		t.Helper()
+		if _, err := h.Handle(ctx, events.CloudWatchEvent{DetailType: "Scheduled Event"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A single event is delivered as usual once the window is over, even if
+	// buffered twice by a retry
+	for i := 0; i < 2; i++ {
+		if _, err := h.Handle(ctx, followerChange(t, "bob", 100, now)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flush()
+	if len(notifier.sent) != 0 {
+		t.Fatalf("notified before the window was over: %+v", notifier.sent)
+	}
+	now = now.Add(10 * time.Minute)
+	flush()
+	if len(notifier.sent) != 1 || notifier.sent[0].Header != "New follower" {
+		t.Fatalf("unexpected messages: %+v", notifier.sent)
+	}
+	notifier.sent = nil
+
+	// A run with many changes, some arriving after the window started
+	for i := 0; i < 12; i++ {
+		e := &data.FollowerEvent{
+			ID:                  fmt.Sprintf("event-%02d", i),
+			UserID:              "111",
+			RunID:               "run-1",
+			TotalFollowers:      110,
+			Follower:            &twitter.User{ID: fmt.Sprint(i), Handle: fmt.Sprintf("user%d", i), Name: fmt.Sprintf("User %d", i)},
+			FollowerState:       data.FollowerStateNew,
+			FollowerStateReason: data.FollowerStateReasonFollowed,
+			CreatedAt:           now.Add(time.Duration(i) * time.Second),
+		}
+		if i%3 == 0 {
+			e.FollowerState = data.FollowerStateLost
+			e.FollowerStateReason = data.FollowerStateReasonUnfollowed
+		}
+		if _, err := h.Handle(ctx, followerEvent(t, e)); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Minute)
+	}
+
+	// The window started with the first event and is over
+	flush()
+	if len(notifier.sent) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(notifier.sent))
+	}
+
+	want := &notify.Message{
+		Header: "12 follower changes",
+		Text: "*New followers:*\n" +
+			"• User 1 (<https://twitter.com/user1|@user1>) followed you :tada:\n" +
+			"• User 2 (<https://twitter.com/user2|@user2>) followed you :tada:\n" +
+			"• User 4 (<https://twitter.com/user4|@user4>) followed you :tada:\n" +
+			"• User 5 (<https://twitter.com/user5|@user5>) followed you :tada:\n" +
+			"• User 7 (<https://twitter.com/user7|@user7>) followed you :tada:\n" +
+			"• User 8 (<https://twitter.com/user8|@user8>) followed you :tada:\n" +
+			"• User 10 (<https://twitter.com/user10|@user10>) followed you :tada:\n" +
+			"• User 11 (<https://twitter.com/user11|@user11>) followed you :tada:\n" +
+			"\n" +
+			"*Lost followers:*\n" +
+			"• User 0 (<https://twitter.com/user0|@user0>) unfollowed you\n" +
+			"• User 3 (<https://twitter.com/user3|@user3>) unfollowed you\n" +
+			"…and 2 more\n" +
+			"\n" +
+			"See all changes on your <https://listkeeper.io|dashboard>",
+		Footer: "You (@alice) now have 110 Twitter followers",
+	}
+	if diff := cmp.Diff(want, notifier.sent[0], cmpopts.IgnoreFields(notify.Message{}, "DeliveryEvent")); diff != "" {
+		t.Error(diff)
+	}
+	if e := notifier.sent[0].DeliveryEvent; e == nil || e.ID != "event-00" {
+		t.Errorf("expected delivery to be tracked under the first event, got %+v", e)
+	}
+
+	deferred, err := table.GetDeferredEvents(ctx, "111")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deferred) != 0 {
+		t.Errorf("%d deferred events left", len(deferred))
+	}
+}
+
+func TestHandler_Alerts(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		table    = data.NewMemoryTable()
+		user     = newUser(t, table, data.QuietHours{Enabled: true, Start: 0, End: 23})
+		notifier = &fakeNotifier{}
+		now      = time.Date(2020, 11, 7, 12, 0, 0, 0, time.UTC)
+	)
+
+	notifiers := notify.NewRegistry()
+	notifiers.Register("fake", notifier)
+
+	h := &Handler{
+		Table:     table,
+		Notifiers: notifiers,
+		AppURL:    "https://listkeeper.io",
+		Now:       func() time.Time { return now },
+	}
+
+	tests := []struct {
+		detailType string
+		detail     interface{}
+		want       *notify.Message
+	}{
+		{
+			detailType: "Follower Milestone",
+			detail:     &data.MilestoneEvent{UserID: user.ID, Milestone: 1000, TotalFollowers: 1002},
+			want: &notify.Message{
+				Header:   "You reached 1,000 followers",
+				Text:     "Congratulations! Your Twitter account @alice has 1,002 followers now :trophy:",
+				Footer:   "Manage your alerts at <https://listkeeper.io|Listkeeper>",
+				ImageURL: "https://example.com/alice.png",
+			},
+		},
+		{
+			detailType: "Follower Anomaly",
+			detail: &data.AnomalyEvent{
+				UserID: user.ID, Kind: data.AnomalyKindLoss,
+				PreviousFollowers: 2000, TotalFollowers: 1871, Gained: 1, Lost: 130, AvgChanges: 2.5,
+			},
+			want: &notify.Message{
+				Header: ":rotating_light: Lost 130 followers at once",
+				Text:   "130 of your 2,000 followers (6.5%) are gone since the last check.",
+				Footer: "You (@alice) now have 1,871 Twitter followers",
+			},
+		},
+		{
+			detailType: "Follower Anomaly",
+			detail: &data.AnomalyEvent{
+				UserID: user.ID, Kind: data.AnomalyKindSpike,
+				PreviousFollowers: 2000, TotalFollowers: 2030, Gained: 35, Lost: 5, AvgChanges: 2.5,
+			},
+			want: &notify.Message{
+				Header: ":chart_with_upwards_trend: Unusual follower activity",
+				Text:   "35 new and 5 lost followers since the last check, compared with 2.5 changes per check on average.",
+				Footer: "You (@alice) now have 2,030 Twitter followers",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		notifier.sent = nil
+
+		detail, err := json.Marshal(test.detail)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Alerts are sent right away, even during quiet hours
+		if _, err := h.Handle(ctx, events.CloudWatchEvent{DetailType: test.detailType, Detail: detail}); err != nil {
+			t.Fatal(err)
+		}
+		if len(notifier.sent) != 1 {
+			t.Fatalf("%s: expected 1 message, got %d", test.detailType, len(notifier.sent))
+		}
+		if diff := cmp.Diff(test.want, notifier.sent[0]); diff != "" {
+			t.Errorf("%s: %s", test.detailType, diff)
+		}
+	}
+}
+
+func TestFollowerChangeOutput(t *testing.T) {
+	event := &data.FollowerEvent{
+		TotalFollowers:      1,
+		Follower:            &twitter.User{ID: "1", Handle: "bob", Name: "Bob", Location: "Berlin", TotalFollowers: 2500},
+		FollowerState:       data.FollowerStateLost,
+		FollowerStateReason: data.FollowerStateReasonUnfollowed,
+	}
+
+	tests := []struct {
+		locale string
+		want   *Output
+	}{
+		{
+			locale: data.LocaleEnglish,
+			want: &Output{
+				Header: "Lost follower",
+				Text:   "Bob (<https://twitter.com/bob|@bob>) unfollowed you\n\n*Location:* Berlin\n\n*Followers:* 2,500\n\n",
+				Footer: "You (@alice) now have 1 Twitter follower",
+			},
+		},
+		{
+			locale: data.LocaleGerman,
+			want: &Output{
+				Header: "Follower verloren",
+				Text:   "Bob (<https://twitter.com/bob|@bob>) folgt dir nicht mehr\n\n*Ort:* Berlin\n\n*Follower:* 2.500\n\n",
+				Footer: "Du (@alice) hast jetzt 1 Twitter-Follower",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		out, _ := FollowerChangeOutput(&data.User{Handle: "alice", Locale: test.locale}, event)
+		if diff := cmp.Diff(test.want, out); diff != "" {
+			t.Errorf("%s: %s", test.locale, diff)
+		}
+	}
+}
+
+func TestFollowerChangeOutput_SerialRefollower(t *testing.T) {
+	event := &data.FollowerEvent{
+		TotalFollowers:      2,
+		Follower:            &twitter.User{ID: "1", Handle: "bob", Name: "Bob"},
+		FollowerState:       data.FollowerStateNew,
+		FollowerStateReason: data.FollowerStateReasonRefollowed,
+		Refollows:           data.SerialRefollows,
+	}
+
+	want := &Output{
+		Header: "Follower returned",
+		Text: "Bob (<https://twitter.com/bob|@bob>) followed you again\n\n" +
+			":warning: Came back 3 times recently, they keep unfollowing and refollowing you\n\n" +
+			"*Followers:* 0\n\n",
+		Footer: "You (@alice) now have 2 Twitter followers",
+	}
+
+	out, _ := FollowerChangeOutput(&data.User{Handle: "alice"}, event)
+	if diff := cmp.Diff(want, out); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestFollowerChangeOutput_EscapesFollower(t *testing.T) {
+	event := &data.FollowerEvent{
+		TotalFollowers: 2,
+		Follower: &twitter.User{
+			ID:       "1",
+			Handle:   "bob",
+			Name:     "<https://evil.example|Bob>",
+			Bio:      "<javascript:alert(1)|click> & more",
+			Location: "<!here>",
+		},
+		FollowerState:       data.FollowerStateNew,
+		FollowerStateReason: data.FollowerStateReasonFollowed,
+	}
+
+	want := &Output{
+		Header: "New follower",
+		Text: "&lt;https://evil.example|Bob&gt; (<https://twitter.com/bob|@bob>) followed you :tada:\n\n" +
+			"*Bio:* &lt;javascript:alert(1)|click&gt; &amp; more\n\n" +
+			"*Location:* &lt;!here&gt;\n\n" +
+			"*Followers:* 0\n\n",
+		Footer: "You (@alice) now have 2 Twitter followers",
+	}
+
+	out, _ := FollowerChangeOutput(&data.User{Handle: "alice"}, event)
+	if diff := cmp.Diff(want, out); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestFollowerChangeOutput_WithoutProfile(t *testing.T) {
+	tests := []struct {
+		follower *twitter.User
+		reason   string
+		want     string
+	}{
+		{&twitter.User{ID: "1", Handle: "bob", Name: "Bob"}, data.FollowerStateReasonBlocked, "Bob (<https://twitter.com/bob|@bob>) blocked you"},
+		{&twitter.User{ID: "1"}, data.FollowerStateReasonBlocked, "User with ID 1 blocked you"},
+		{&twitter.User{ID: "1"}, data.FollowerStateReasonFollowed, "User with ID 1 followed you :tada:"},
+		{&twitter.User{ID: "1"}, data.FollowerStateReasonUnfollowed, "User with ID 1 unfollowed you"},
+	}
+
+	for _, test := range tests {
+		event := &data.FollowerEvent{
+			Follower:            test.follower,
+			FollowerState:       data.FollowerStateLost,
+			FollowerStateReason: test.reason,
+		}
+		out, _ := FollowerChangeOutput(&data.User{Handle: "alice"}, event)
+		if !strings.HasPrefix(out.Text, test.want) {
+			t.Errorf("%s %+v: unexpected text %q", test.reason, test.follower, out.Text)
+		}
+	}
+}
+
+func TestHandler_AlertPartialFailure(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		table = data.NewMemoryTable()
+		user  = newUser(t, table, data.QuietHours{})
+		ok    = &fakeNotifier{}
+		flaky = &flakyNotifier{failures: 1}
+	)
+
+	notifiers := notify.NewRegistry()
+	notifiers.TrackDeliveries(&notify.Deliveries{Table: table})
+	notifiers.Register("ok", ok)
+	notifiers.Register("flaky", flaky)
+
+	h := &Handler{Table: table, Notifiers: notifiers}
+
+	detail, _ := json.Marshal(&data.MilestoneEvent{UserID: user.ID, Milestone: 1000, TotalFollowers: 1002})
+	event := events.CloudWatchEvent{ID: "some-alert-id", DetailType: "Follower Milestone", Detail: detail}
+
+	// Lambda retries the invocation
+	if _, err := h.Handle(ctx, event); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := h.Handle(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+
+	// The channel that worked the first time isn't notified again
+	if len(ok.sent) != 1 || len(flaky.sent) != 1 {
+		t.Errorf("expected one message per channel, got %d and %d", len(ok.sent), len(flaky.sent))
+	}
+	d, err := table.GetNotificationDelivery(ctx, user.ID, "some-alert-id", "flaky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Alert || d.Status != data.DeliveryStatusSent || d.Attempts != 2 {
+		t.Errorf("unexpected delivery: %+v", d)
+	}
+}
+
+func TestHandler_ReplayNotification(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		table    = data.NewMemoryTable()
+		_        = newUser(t, table, data.QuietHours{})
+		notifier = &fakeNotifier{}
+		now      = time.Date(2020, 11, 7, 12, 0, 0, 0, time.UTC)
+	)
+
+	notifiers := notify.NewRegistry()
+	notifiers.TrackDeliveries(&notify.Deliveries{Table: table})
+	notifiers.Register(notify.ChannelSlack, notifier)
+
+	var e data.FollowerEvent
+	if err := json.Unmarshal(followerChange(t, "bob", 100, now).Detail, &e); err != nil {
+		t.Fatal(err)
+	}
+	d := data.NewNotificationDelivery(&e, notify.ChannelSlack, now)
+	d.Status = data.DeliveryStatusFailed
+	if err := d.Requeue(now); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.SaveNotificationDelivery(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+
+	h := Handler{Table: table, Notifiers: notifiers}
+	detail, _ := json.Marshal(data.NotificationReplayEvent{UserID: "111", EventID: "bob", Channel: notify.ChannelSlack})
+	if _, err := h.Handle(ctx, events.CloudWatchEvent{DetailType: "Notification Replay", Detail: detail}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(notifier.sent) != 1 || notifier.sent[0].Header != "New follower" {
+		t.Fatalf("unexpected messages: %+v", notifier.sent)
+	}
+	d, err := table.GetNotificationDelivery(ctx, "111", "bob", notify.ChannelSlack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Status != data.DeliveryStatusSent {
+		t.Errorf("unexpected status %s", d.Status)
+	}
+}
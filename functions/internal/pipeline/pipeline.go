@@ -0,0 +1,333 @@
+// Package pipeline runs all functions of the follower pipeline in-process,
+// wired together the same way the CDK stacks wire them together on AWS.
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	lambdasvc "github.com/aws/aws-sdk-go/service/lambda"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/budget"
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/difffollowers"
+	"github.com/mlafeldt/listkeeper/functions/internal/enqueueusers"
+	"github.com/mlafeldt/listkeeper/functions/internal/getfollowers"
+	"github.com/mlafeldt/listkeeper/functions/internal/local"
+	"github.com/mlafeldt/listkeeper/functions/internal/notify"
+	"github.com/mlafeldt/listkeeper/functions/internal/notifyuser"
+	"github.com/mlafeldt/listkeeper/functions/internal/senddigests"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+const (
+	eventSource         = "listkeeper"
+	bucketName          = "listkeeper-local"
+	getFollowersName    = "get-followers"
+	defaultTableTTL     = 7 * 24 * time.Hour
+	defaultEventTTL     = 90 * 24 * time.Hour
+	defaultAppURL       = "https://listkeeper.io"
+	defaultSlackName    = "Listkeeper"
+	defaultSlackIconURL = "https://listkeeper.io/slack-icon.png"
+)
+
+// Config holds the stand-ins for the AWS services and the Twitter API.
+// Optional settings default to those of the Lambda functions.
+type Config struct {
+	Table   data.TableAPI
+	Bucket  *local.Bucket
+	Twitter twitter.API
+	Budget  *budget.Planner
+
+	Notifiers *notify.Registry
+	AppURL    string
+	TableTTL  time.Duration
+	EventTTL  time.Duration
+}
+
+// Pipeline connects enqueue-users, get-followers, diff-followers, and
+// notify-user. Like on AWS, enqueue-users invokes get-followers, which hands
+// its follower list to diff-followers on success; follower changes are
+// published to the event bus and picked up by notify-user.
+type Pipeline struct {
+	Table  data.TableAPI
+	Bus    *local.Bus
+	Lambda *local.Lambda
+	Budget *budget.Planner
+
+	enqueue *enqueueusers.Handler
+	digests *senddigests.Handler
+	notify  *notifyuser.Handler
+
+	mu            sync.Mutex
+	notifications []*Notification
+}
+
+// Notification is a message notify-user produced for an event.
+type Notification struct {
+	Event  events.CloudWatchEvent
+	Output *notifyuser.Output
+}
+
+// Result summarizes a single run of the pipeline.
+type Result struct {
+	Enqueued      *enqueueusers.Output
+	Events        []events.CloudWatchEvent
+	Notifications []*Notification
+	Errors        []error
+}
+
+func New(cfg Config) *Pipeline {
+	if cfg.Budget == nil {
+		cfg.Budget = budget.NewPlanner(cfg.Table)
+	}
+	if cfg.AppURL == "" {
+		cfg.AppURL = defaultAppURL
+	}
+	if cfg.Notifiers == nil {
+		cfg.Notifiers = notify.NewRegistry()
+		cfg.Notifiers.TrackDeliveries(&notify.Deliveries{Table: cfg.Table})
+		cfg.Notifiers.Register(notify.ChannelSlack, &notify.Slack{
+			Username: defaultSlackName,
+			IconURL:  defaultSlackIconURL,
+		})
+		cfg.Notifiers.Register(notify.ChannelDiscord, &notify.Discord{
+			Username:  defaultSlackName,
+			AvatarURL: defaultSlackIconURL,
+		})
+	}
+	if cfg.TableTTL == 0 {
+		cfg.TableTTL = defaultTableTTL
+	}
+	if cfg.EventTTL == 0 {
+		cfg.EventTTL = defaultEventTTL
+	}
+
+	p := &Pipeline{
+		Table:  cfg.Table,
+		Bus:    local.NewBus(eventSource),
+		Lambda: local.NewLambda(),
+		Budget: cfg.Budget,
+	}
+
+	getFollowers := &getfollowers.Handler{
+		Table:      cfg.Table,
+		TableTTL:   cfg.TableTTL,
+		S3Uploader: cfg.Bucket,
+		BucketName: bucketName,
+		EVB:        p.Bus,
+		Twitter:    cfg.Twitter,
+	}
+
+	diffFollowers := &difffollowers.Handler{
+		Table:        cfg.Table,
+		EventTTL:     cfg.EventTTL,
+		EVB:          p.Bus,
+		S3Downloader: cfg.Bucket,
+		Twitter:      cfg.Twitter,
+	}
+
+	p.notify = &notifyuser.Handler{
+		Table:     cfg.Table,
+		Notifiers: cfg.Notifiers,
+		Webhooks:  &notify.Webhooks{Table: cfg.Table},
+		AppURL:    cfg.AppURL,
+	}
+
+	p.digests = &senddigests.Handler{
+		Table:     cfg.Table,
+		Notifiers: cfg.Notifiers,
+		AppURL:    cfg.AppURL,
+	}
+
+	p.enqueue = &enqueueusers.Handler{
+		Table:        cfg.Table,
+		Budget:       p.Budget,
+		Lambda:       p.Lambda,
+		FunctionName: getFollowersName,
+	}
+
+	// get-followers passes its result to diff-followers on success
+	p.Lambda.Register(getFollowersName, func(ctx context.Context, payload []byte) ([]byte, error) {
+		var in getfollowers.Input
+		if err := json.Unmarshal(payload, &in); err != nil {
+			return nil, err
+		}
+
+		list, err := getFollowers.Handle(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := diffFollowers.Handle(ctx, difffollowers.Input{UserID: list.UserID}); err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(list)
+	})
+
+	p.Bus.Subscribe("New User Signup", func(ctx context.Context, event events.CloudWatchEvent) error {
+		return p.invokeGetFollowers(ctx, event.Detail)
+	})
+
+	notify := func(ctx context.Context, event events.CloudWatchEvent) error {
+		out, err := p.notify.Handle(ctx, event)
+		if err != nil {
+			return err
+		}
+
+		p.mu.Lock()
+		p.notifications = append(p.notifications, &Notification{Event: event, Output: out})
+		p.mu.Unlock()
+		return nil
+	}
+	p.Bus.Subscribe("Twitter Follower Change", notify)
+	p.Bus.Subscribe("Tracking Status Change", notify)
+	p.Bus.Subscribe("Follower Milestone", notify)
+	p.Bus.Subscribe("Follower Anomaly", notify)
+	p.Bus.Subscribe("Notification Replay", notify)
+
+	return p
+}
+
+// RunCycle runs a scheduled tick: all users that are due get their followers
+// fetched and compared, and changes are sent to notify-user.
+func (p *Pipeline) RunCycle(ctx context.Context) (*Result, error) {
+	return p.run(ctx, func(ctx context.Context) (*enqueueusers.Output, error) {
+		return p.enqueue.Handle(ctx, events.CloudWatchEvent{
+			DetailType: "Scheduled Event",
+			Source:     "aws.events",
+			Time:       time.Now().UTC(),
+		})
+	})
+}
+
+// SendDigests sends the digests that are due, like the hourly rule that
+// triggers send-digests.
+func (p *Pipeline) SendDigests(ctx context.Context) (*senddigests.Output, error) {
+	return p.digests.Handle(ctx, events.CloudWatchEvent{
+		DetailType: "Scheduled Event",
+		Source:     "aws.events",
+		Time:       time.Now().UTC(),
+	})
+}
+
+// FlushDeferredEvents delivers the notifications held back during quiet
+// hours or buffered as bursts and retries failed webhook deliveries, like the
+// rule that triggers notify-user every five minutes.
+func (p *Pipeline) FlushDeferredEvents(ctx context.Context) (*notifyuser.Output, error) {
+	return p.notify.Handle(ctx, events.CloudWatchEvent{
+		DetailType: "Scheduled Event",
+		Source:     "aws.events",
+		Time:       time.Now().UTC(),
+	})
+}
+
+// CheckUser runs the pipeline for a single user regardless of their schedule.
+func (p *Pipeline) CheckUser(ctx context.Context, userID string) (*Result, error) {
+	return p.run(ctx, func(ctx context.Context) (*enqueueusers.Output, error) {
+		payload, err := json.Marshal(getfollowers.Input{UserID: userID})
+		if err != nil {
+			return nil, err
+		}
+		if err := p.invokeGetFollowers(ctx, payload); err != nil {
+			return nil, err
+		}
+		return &enqueueusers.Output{UserIDs: []string{userID}, TotalUsers: 1}, nil
+	})
+}
+
+// SignUp registers a user and, if they are new, triggers the first fetch of
+// their followers just like resolve-graphql does.
+func (p *Pipeline) SignUp(ctx context.Context, user *data.User) (*Result, error) {
+	return p.run(ctx, func(ctx context.Context) (*enqueueusers.Output, error) {
+		// Followers of new users are fetched right away, no need to check
+		// them again in the same cycle.
+		if user.LoginsCount <= 1 {
+			user.DeferNextCheck(time.Now())
+		}
+		if err := p.Table.RegisterUser(ctx, user); err != nil {
+			return nil, err
+		}
+		if user.LoginsCount > 1 {
+			return &enqueueusers.Output{}, nil
+		}
+		if err := p.Bus.Send(ctx, "New User Signup", data.UserSignupEvent{UserID: user.ID}); err != nil {
+			return nil, err
+		}
+		return &enqueueusers.Output{UserIDs: []string{user.ID}, TotalUsers: 1}, nil
+	})
+}
+
+// invokeGetFollowers starts get-followers asynchronously, like enqueue-users
+// and the signup rule do.
+func (p *Pipeline) invokeGetFollowers(ctx context.Context, payload []byte) error {
+	_, err := p.Lambda.InvokeWithContext(ctx, &lambdasvc.InvokeInput{
+		FunctionName:   aws.String(getFollowersName),
+		Payload:        payload,
+		InvocationType: aws.String(lambdasvc.InvocationTypeEvent),
+	})
+	return err
+}
+
+func (p *Pipeline) run(ctx context.Context, start func(context.Context) (*enqueueusers.Output, error)) (*Result, error) {
+	var (
+		sentBefore   = len(p.Bus.Sent())
+		busErrs      = len(p.Bus.Errors())
+		lambdaErrs   = len(p.Lambda.Errors())
+		notifyBefore = p.notificationCount()
+	)
+
+	enqueued, err := start(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("pipeline: %w", err)
+	}
+
+	// Invocations may run on workers, see Serve
+	p.Lambda.Wait()
+
+	p.mu.Lock()
+	notifications := append([]*Notification(nil), p.notifications[notifyBefore:]...)
+	p.mu.Unlock()
+
+	res := Result{
+		Enqueued:      enqueued,
+		Events:        p.Bus.Sent()[sentBefore:],
+		Notifications: notifications,
+	}
+	res.Errors = append(res.Errors, p.Lambda.Errors()[lambdaErrs:]...)
+	res.Errors = append(res.Errors, p.Bus.Errors()[busErrs:]...)
+
+	return &res, nil
+}
+
+func (p *Pipeline) notificationCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.notifications)
+}
+
+// NewUser returns the user that signs up with a fake Twitter account.
+func NewUser(a *local.Account) *data.User {
+	now := time.Now()
+
+	u := data.NewUser(a.User.ID)
+	u.Handle = a.User.Handle
+	u.Name = a.User.Name
+	u.Location = a.User.Location
+	u.Bio = a.User.Bio
+	u.ProfileImageURL = a.User.ProfileImageURL
+	u.AccessToken = a.AccessToken
+	u.AccessSecret = a.AccessSecret
+	u.LastLogin = now
+	u.LastIP = "127.0.0.1"
+	u.LoginsCount = 1
+	u.IDP = "twitter"
+	return u
+}
@@ -0,0 +1,248 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/local"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+func newFixture() *local.Fixture {
+	return &local.Fixture{
+		Accounts: []local.Account{
+			{
+				User: twitter.User{
+					ID:              "111",
+					Handle:          "alice",
+					Name:            "Alice",
+					ProfileImageURL: "https://pbs.twimg.com/profile_images/111/alice_normal.jpg",
+				},
+				AccessToken:  "token-111",
+				AccessSecret: "secret-111",
+				Followers:    []int64{1, 2, 3},
+			},
+		},
+		Users: []*twitter.User{
+			{ID: "1", Handle: "one", Name: "One", TotalFollowers: 10},
+			{ID: "2", Handle: "two", Name: "Two", TotalFollowers: 20},
+			{ID: "3", Handle: "three", Name: "Three", TotalFollowers: 30},
+			{ID: "4", Handle: "four", Name: "Four", TotalFollowers: 40},
+		},
+	}
+}
+
+func newPipeline(t *testing.T) (*Pipeline, *local.Twitter) {
+	t.Helper()
+
+	tw := local.NewTwitter(newFixture())
+	p := New(Config{
+		Table:   data.NewMemoryTable(),
+		Bucket:  local.NewBucket(t.TempDir()),
+		Twitter: tw,
+	})
+
+	account := tw.Accounts()[0]
+	res, err := p.SignUp(context.Background(), NewUser(&account))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+
+	// Follower lists are keyed by second
+	time.Sleep(time.Second)
+
+	return p, tw
+}
+
+func makeDue(t *testing.T, p *Pipeline, userID string) {
+	t.Helper()
+
+	u, err := p.Table.GetUser(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.ScheduleCheckAt(time.Now())
+	if err := p.Table.UpdateUserSchedule(context.Background(), u); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type change struct {
+	FollowerID string
+	State      string
+	Reason     string
+}
+
+func followerChanges(t *testing.T, res *Result) []change {
+	t.Helper()
+
+	var changes []change
+	for _, e := range res.Events {
+		if e.DetailType != "Twitter Follower Change" {
+			continue
+		}
+		var fe data.FollowerEvent
+		if err := json.Unmarshal(e.Detail, &fe); err != nil {
+			t.Fatal(err)
+		}
+		changes = append(changes, change{fe.Follower.ID, fe.FollowerState, fe.FollowerStateReason})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].FollowerID < changes[j].FollowerID })
+
+	return changes
+}
+
+func TestSignUp(t *testing.T) {
+	p, _ := newPipeline(t)
+
+	lists, err := p.Table.GetLatestFollowerLists(context.Background(), "111", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(1, len(lists)); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff(3, lists[0].TotalFollowers); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestFollowerChanges(t *testing.T) {
+	p, tw := newPipeline(t)
+	tw.SetFollowers("token-111", []int64{2, 4, 5})
+	tw.Suspend(5)
+	tw.Suspend(1)
+	makeDue(t, p, "111")
+
+	res, err := p.RunCycle(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+
+	if diff := cmp.Diff([]string{"111"}, res.Enqueued.UserIDs); diff != "" {
+		t.Error(diff)
+	}
+
+	want := []change{
+		{"1", data.FollowerStateLost, data.FollowerStateReasonSuspended},
+		{"3", data.FollowerStateLost, data.FollowerStateReasonUnfollowed},
+		{"4", data.FollowerStateNew, data.FollowerStateReasonFollowed},
+	}
+	if diff := cmp.Diff(want, followerChanges(t, res)); diff != "" {
+		t.Error(diff)
+	}
+
+	var headers []string
+	for _, n := range res.Notifications {
+		headers = append(headers, n.Output.Header)
+	}
+	sort.Strings(headers)
+	if diff := cmp.Diff([]string{"Lost follower", "Lost follower", "New follower"}, headers); diff != "" {
+		t.Error(diff)
+	}
+
+	events, err := p.Table.GetLatestFollowerEvents(context.Background(), "111", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(3, len(events)); diff != "" {
+		t.Error(diff)
+	}
+
+	// Nothing is due anymore
+	res, err = p.RunCycle(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(0, res.Enqueued.TotalUsers); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestNoChanges(t *testing.T) {
+	p, _ := newPipeline(t)
+
+	res, err := p.CheckUser(context.Background(), "111")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+
+	if diff := cmp.Diff(0, len(res.Events)); diff != "" {
+		t.Error(diff)
+	}
+
+	u, err := p.Table.GetUser(context.Background(), "111")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(90*time.Minute, u.CheckInterval); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestRevokedToken(t *testing.T) {
+	p, tw := newPipeline(t)
+	tw.RevokeToken("token-111")
+	makeDue(t, p, "111")
+
+	res, err := p.RunCycle(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(1, len(res.Errors)); diff != "" {
+		t.Error(diff)
+	}
+	if diff := cmp.Diff(1, len(res.Notifications)); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff("Please reconnect your account", res.Notifications[0].Output.Header); diff != "" {
+		t.Error(diff)
+	}
+
+	u, err := p.Table.GetUser(context.Background(), "111")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(data.TrackingStatusNeedsReauth, u.TrackingStatus); diff != "" {
+		t.Error(diff)
+	}
+
+	// Logging in again resumes tracking
+	account := local.Account{
+		User:         twitter.User{ID: "111", Handle: "alice", Name: "Alice", ProfileImageURL: "https://example.com/alice.jpg"},
+		AccessToken:  "token-222",
+		AccessSecret: "secret-222",
+		Followers:    []int64{1, 2, 3},
+	}
+	tw.AddAccount(account)
+
+	login := NewUser(&account)
+	login.LoginsCount = 2
+	if _, err := p.SignUp(context.Background(), login); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err = p.Table.GetUser(context.Background(), "111")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(data.TrackingStatusActive, u.TrackingStatus); diff != "" {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,58 @@
+package resolvegraphql
+
+import (
+	"context"
+	"fmt"
+
+	"gopkg.in/auth0.v5/management"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+)
+
+var _ Directory = (*Auth0Directory)(nil)
+
+// Auth0Directory reads users and their Twitter tokens from Auth0.
+type Auth0Directory struct {
+	mgmt *management.Management
+}
+
+func NewAuth0Directory(domain, clientID, clientSecret string) (*Auth0Directory, error) {
+	mgmt, err := management.New(domain, management.WithClientCredentials(clientID, clientSecret))
+	if err != nil {
+		return nil, fmt.Errorf("auth0: %w", err)
+	}
+	return &Auth0Directory{mgmt: mgmt}, nil
+}
+
+func (d *Auth0Directory) User(ctx context.Context, userID string) (*data.User, error) {
+	u0, err := d.mgmt.User.Read(auth0ProviderPrefix + userID)
+	if err != nil {
+		return nil, fmt.Errorf("auth0: %w", err)
+	}
+
+	user := data.NewUser(userID)
+	user.Handle = u0.GetScreenName()
+	user.Name = u0.GetName()
+	user.Location = u0.GetLocation()
+	user.Bio = u0.GetDescription()
+	user.ProfileImageURL = u0.GetPicture()
+
+	if len(u0.Identities) > 0 {
+		identity := u0.Identities[0]
+		user.AccessToken = identity.GetAccessToken()
+		user.AccessSecret = identity.GetAccessTokenSecret()
+	}
+
+	user.LastLogin = u0.GetLastLogin()
+	user.LastIP = u0.GetLastIP()
+	user.LoginsCount = u0.GetLoginsCount()
+
+	return user, nil
+}
+
+func (d *Auth0Directory) DeleteUser(ctx context.Context, userID string) error {
+	if err := d.mgmt.User.Delete(auth0ProviderPrefix + userID); err != nil {
+		return fmt.Errorf("auth0: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,631 @@
+package resolvegraphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/mitchellh/mapstructure"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/budget"
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/evb"
+	"github.com/mlafeldt/listkeeper/functions/internal/i18n"
+	"github.com/mlafeldt/listkeeper/functions/internal/notify"
+	"github.com/mlafeldt/listkeeper/functions/internal/notifyuser"
+)
+
+type Info struct {
+	FieldName        string
+	ParentTypeName   string
+	Variables        map[string]interface{}
+	SelectionSetList []string
+}
+
+type Identity struct {
+	Sub    string
+	Issuer string
+	Claims map[string]interface{}
+}
+
+// https://docs.aws.amazon.com/appsync/latest/devguide/resolver-context-reference.html
+type Event struct {
+	Info      Info
+	Arguments map[string]interface{}
+	Identity  Identity
+}
+
+const (
+	auth0ProviderPrefix     = "twitter|"
+	latestFollowerEventsMax = 100
+	webhookDeliveriesMax    = 100
+	deadLettersMax          = 100
+)
+
+// testNotificationLimit keeps users from spamming their channels, or others
+// via channels they don't own.
+var testNotificationLimit = budget.Limit{Capacity: 5, Window: time.Hour}
+
+func (event Event) userID(argName string) (string, error) {
+	argID, _ := event.Arguments[argName].(string)
+
+	// With OIDC authorization, subject must match user ID
+	if sub := event.Identity.Sub; sub != "" && sub != argID {
+		return "", errors.New("unauthorized: user ID must match subject claim")
+	}
+
+	// Remove IDP prefix from Auth0 user ID if present
+	if id := strings.TrimPrefix(argID, auth0ProviderPrefix); id != "" {
+		return id, nil
+	}
+
+	return "", errors.New("unauthorized: user ID must not be empty")
+}
+
+// Directory knows about the users who logged in with their Twitter account.
+type Directory interface {
+	// User returns the profile, tokens, and login details of a user.
+	User(ctx context.Context, userID string) (*data.User, error)
+	DeleteUser(ctx context.Context, userID string) error
+}
+
+type Handler struct {
+	Table     data.TableAPI
+	Budget    *budget.Planner
+	EVB       evb.API
+	Directory Directory
+	Notifiers *notify.Registry // sends test notifications
+	VAPIDKey  string           // public key browsers subscribe to push with, if enabled
+}
+
+func (h *Handler) Handle(ctx context.Context, event Event) (interface{}, error) {
+	spew.Printf("event = %+v\n", event)
+
+	switch event.Info.FieldName {
+	case "getUser":
+		return h.getUser(ctx, event)
+	case "getLatestFollowerEvents":
+		return h.getLatestFollowerEvents(ctx, event)
+	case "getWebhookDeliveries":
+		return h.getWebhookDeliveries(ctx, event)
+	case "getDeadLetters":
+		return h.getDeadLetters(ctx, event)
+	case "getVapidPublicKey":
+		return h.VAPIDKey, nil
+	case "ping":
+		return "pong", nil
+	case "registerUser":
+		return h.registerUser(ctx, event)
+	case "updateUser":
+		return h.updateUser(ctx, event)
+	case "deleteUser":
+		return h.deleteUser(ctx, event)
+	case "createTelegramLinkCode":
+		return h.createTelegramLinkCode(ctx, event)
+	case "createSlackInstallState":
+		return h.createSlackInstallState(ctx, event)
+	case "rotateFeedToken":
+		return h.rotateFeedToken(ctx, event)
+	case "subscribePush":
+		return h.subscribePush(ctx, event)
+	case "unsubscribePush":
+		return h.unsubscribePush(ctx, event)
+	case "previewTemplates":
+		return h.previewTemplates(ctx, event)
+	case "replayDeadLetter":
+		return h.replayDeadLetter(ctx, event)
+	case "sendTestNotification":
+		return h.sendTestNotification(ctx, event)
+	case "getRateBudgets":
+		return h.getRateBudgets(ctx, event)
+	default:
+		return nil, fmt.Errorf("unable to resolve field %q", event.Info.FieldName)
+	}
+}
+
+// getUser mirrors the AppSync resolver reading from DynamoDB directly.
+func (h *Handler) getUser(ctx context.Context, event Event) (*data.User, error) {
+	userID, err := event.userID("id")
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := h.Table.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TrackingStatus == "" {
+		user.TrackingStatus = data.TrackingStatusActive
+	}
+
+	return user, nil
+}
+
+// getLatestFollowerEvents mirrors the AppSync resolver reading from DynamoDB
+// directly.
+func (h *Handler) getLatestFollowerEvents(ctx context.Context, event Event) ([]*data.FollowerEvent, error) {
+	userID, err := event.userID("userId")
+	if err != nil {
+		return nil, err
+	}
+
+	return h.Table.GetLatestFollowerEvents(ctx, userID, latestFollowerEventsMax)
+}
+
+func (h *Handler) getWebhookDeliveries(ctx context.Context, event Event) ([]*data.WebhookDelivery, error) {
+	userID, err := event.userID("userId")
+	if err != nil {
+		return nil, err
+	}
+
+	webhookID, _ := event.Arguments["webhookId"].(string)
+	return h.Table.GetLatestWebhookDeliveries(ctx, userID, webhookID, webhookDeliveriesMax)
+}
+
+func (h *Handler) getDeadLetters(ctx context.Context, event Event) ([]*data.NotificationDelivery, error) {
+	userID, err := event.userID("userId")
+	if err != nil {
+		return nil, err
+	}
+
+	return h.Table.GetDeadLetters(ctx, userID, deadLettersMax)
+}
+
+func (h *Handler) registerUser(ctx context.Context, event Event) (*data.User, error) {
+	userID, err := event.userID("id")
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := h.Directory.User(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	user.IDP = event.Identity.Issuer
+
+	if err := h.Table.RegisterUser(ctx, user); err != nil {
+		return nil, err
+	}
+
+	if user.LoginsCount == 1 {
+		if err := h.EVB.Send(ctx, "New User Signup", data.UserSignupEvent{UserID: userID}); err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+func (h *Handler) updateUser(ctx context.Context, event Event) (*data.User, error) {
+	userID, err := event.userID("id")
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := h.Table.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var args struct {
+		Input struct {
+			Slack *struct {
+				Enabled    bool                     `json:"enabled"`
+				WebhookURL string                   `json:"webhookUrl"`
+				Channel    string                   `json:"channel"`
+				Filter     *data.NotificationFilter `json:"filter"`
+			} `json:"slack"`
+			Email    *data.EmailConfig   `json:"email"`
+			Discord  *data.DiscordConfig `json:"discord"`
+			Telegram *struct {
+				Enabled bool                     `json:"enabled"`
+				Filter  *data.NotificationFilter `json:"filter"`
+			} `json:"telegram"`
+			Push *struct {
+				Enabled bool                     `json:"enabled"`
+				Filter  *data.NotificationFilter `json:"filter"`
+			} `json:"push"`
+			Digest *struct {
+				Mode string `json:"mode"`
+				Hour *int   `json:"hour"`
+			} `json:"digest"`
+			QuietHours      *data.QuietHours            `json:"quietHours"`
+			Burst           *data.BurstConfig           `json:"burst"`
+			Refollow        *data.RefollowConfig        `json:"refollow"`
+			Alerts          *data.AlertConfig           `json:"alerts"`
+			Templates       *data.NotificationTemplates `json:"templates"`
+			Timezone        *string                     `json:"timezone"`
+			Locale          *string                     `json:"locale"`
+			IgnoreFollowers []string                    `json:"ignoreFollowers"`
+			TrackingStatus  *string                     `json:"trackingStatus"`
+			Webhooks        []data.WebhookInput         `json:"webhooks"`
+		} `json:"input"`
+	}
+
+	if err := mapstructure.Decode(event.Arguments, &args); err != nil {
+		return nil, err
+	}
+	if v := args.Input.Slack; v != nil {
+		// The app can only be installed via OAuth
+		user.Slack.Enabled = v.Enabled
+		user.Slack.WebhookURL = v.WebhookURL
+		user.Slack.Channel = v.Channel
+		user.Slack.Filter = v.Filter
+	}
+	if v := args.Input.Email; v != nil {
+		user.Email = *v
+	}
+	if v := args.Input.Discord; v != nil {
+		user.Discord = *v
+	}
+	if v := args.Input.Telegram; v != nil {
+		// The chat can only be linked via the bot
+		user.Telegram.Enabled = v.Enabled
+		user.Telegram.Filter = v.Filter
+	}
+	if v := args.Input.Push; v != nil {
+		// Browsers are subscribed via subscribePush
+		user.Push.Enabled = v.Enabled
+		user.Push.Filter = v.Filter
+	}
+	if v := args.Input.Digest; v != nil {
+		// LastSentAt is kept so that switching modes won't resend a digest
+		user.Digest.Mode = v.Mode
+		user.Digest.Hour = v.Hour
+	}
+	if v := args.Input.QuietHours; v != nil {
+		user.QuietHours = *v
+	}
+	if v := args.Input.Burst; v != nil {
+		user.Burst = *v
+	}
+	if v := args.Input.Refollow; v != nil {
+		user.Refollow = *v
+	}
+	if v := args.Input.Alerts; v != nil {
+		user.Alerts = *v
+	}
+	if v := args.Input.Templates; v != nil {
+		if err := notify.ValidateTemplates(user, *v); err != nil {
+			return nil, err
+		}
+		user.Templates = *v
+	}
+	if v := args.Input.Timezone; v != nil {
+		user.Timezone = *v
+	}
+	if v := args.Input.Locale; v != nil {
+		user.Locale = *v
+	}
+	if v := args.Input.IgnoreFollowers; v != nil {
+		user.IgnoreFollowers = v
+	}
+	if v := args.Input.TrackingStatus; v != nil {
+		if err := user.ChangeTrackingStatus(*v); err != nil {
+			return nil, err
+		}
+	}
+	if v := args.Input.Webhooks; v != nil {
+		if err := user.SetWebhooks(v, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := h.Table.UpdateUser(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+type TemplatePreview struct {
+	Header string `json:"header"`
+	Text   string `json:"text"`
+	Footer string `json:"footer"`
+}
+
+// previewTemplates renders templates against a sample event without saving
+// them. Invalid templates are reported rather than replaced by the defaults.
+func (h *Handler) previewTemplates(ctx context.Context, event Event) (*TemplatePreview, error) {
+	userID, err := event.userID("id")
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := h.Table.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var args struct {
+		Input               data.NotificationTemplates `json:"input"`
+		FollowerStateReason string                     `json:"followerStateReason"`
+	}
+	if err := mapstructure.Decode(event.Arguments, &args); err != nil {
+		return nil, err
+	}
+	if err := notify.ValidateTemplates(user, args.Input); err != nil {
+		return nil, err
+	}
+
+	user.Templates = args.Input
+	out, _ := notifyuser.FollowerChangeOutput(user, notify.SampleFollowerEvent(user, args.FollowerStateReason))
+
+	return &TemplatePreview{Header: out.Header, Text: out.Text, Footer: out.Footer}, nil
+}
+
+type TestNotificationResult struct {
+	Channel    string `json:"channel"`
+	Success    bool   `json:"success"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Response   string `json:"response,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// sendTestNotification sends a sample follower change via one of the user's
+// channels, formatted like notify-user would, and reports how it went.
+func (h *Handler) sendTestNotification(ctx context.Context, event Event) (*TestNotificationResult, error) {
+	userID, err := event.userID("id")
+	if err != nil {
+		return nil, err
+	}
+
+	channel, _ := event.Arguments["channel"].(string)
+	n, ok := h.Notifiers.Get(channel)
+	if !ok {
+		return nil, fmt.Errorf("unknown channel %q", channel)
+	}
+
+	user, err := h.Table.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !n.Enabled(user) {
+		return &TestNotificationResult{Channel: channel, Error: "channel is not enabled"}, nil
+	}
+
+	if err := h.Budget.TakeUser(ctx, "test-notification", userID, testNotificationLimit); err != nil {
+		if errors.Is(err, budget.ErrExhausted) {
+			return nil, errors.New("too many test notifications, please try again later")
+		}
+		return nil, err
+	}
+
+	var (
+		sample        = notify.SampleFollowerEvent(user, data.FollowerStateReasonFollowed)
+		out, imageURL = notifyuser.FollowerChangeOutput(user, sample)
+		rctx, resp    = notify.WithResponse(ctx)
+	)
+	err = h.Notifiers.NotifyChannel(rctx, user, channel, &notify.Message{
+		Header:   i18n.NewPrinter(user.Language()).Sprintf("[Test] %s", out.Header),
+		Text:     out.Text,
+		Footer:   out.Footer,
+		ImageURL: imageURL,
+		Event:    sample,
+		Test:     true,
+	})
+
+	result := TestNotificationResult{
+		Channel:    channel,
+		Success:    err == nil,
+		StatusCode: resp.StatusCode,
+		Response:   resp.Text,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return &result, nil
+}
+
+type TelegramLinkCode struct {
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (h *Handler) createTelegramLinkCode(ctx context.Context, event Event) (*TelegramLinkCode, error) {
+	userID, err := event.userID("id")
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := h.Table.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := user.NewTelegramLinkCode(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.Table.UpdateUser(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return &TelegramLinkCode{Code: code, ExpiresAt: user.Telegram.LinkCodeExpiresAt}, nil
+}
+
+// rotateFeedToken creates a new secret token for the feed of follower
+// events, which revokes the previous one.
+func (h *Handler) rotateFeedToken(ctx context.Context, event Event) (*data.User, error) {
+	userID, err := event.userID("id")
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := h.Table.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := user.NewFeedToken(); err != nil {
+		return nil, err
+	}
+
+	if err := h.Table.UpdateUser(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// subscribePush registers a browser for push notifications with the
+// subscription returned by its PushManager.
+func (h *Handler) subscribePush(ctx context.Context, event Event) (*data.User, error) {
+	userID, err := event.userID("id")
+	if err != nil {
+		return nil, err
+	}
+
+	if h.VAPIDKey == "" {
+		return nil, errors.New("push notifications are not available")
+	}
+
+	var args struct {
+		Input struct {
+			Endpoint string `json:"endpoint"`
+			P256dh   string `json:"p256dh"`
+			Auth     string `json:"auth"`
+			Device   string `json:"device"`
+		} `json:"input"`
+	}
+	if err := mapstructure.Decode(event.Arguments, &args); err != nil {
+		return nil, err
+	}
+
+	user, err := h.Table.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	in := args.Input
+	if _, err := user.AddPushSubscription(in.Endpoint, in.P256dh, in.Auth, in.Device, time.Now()); err != nil {
+		return nil, err
+	}
+
+	if err := h.Table.UpdateUser(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (h *Handler) unsubscribePush(ctx context.Context, event Event) (*data.User, error) {
+	userID, err := event.userID("id")
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptionID, _ := event.Arguments["subscriptionId"].(string)
+
+	user, err := h.Table.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := user.RemovePushSubscription(subscriptionID); err != nil {
+		return nil, err
+	}
+
+	if err := h.Table.UpdateUser(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+type SlackInstallState struct {
+	State     string    `json:"state"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (h *Handler) createSlackInstallState(ctx context.Context, event Event) (*SlackInstallState, error) {
+	userID, err := event.userID("id")
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := h.Table.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := user.NewSlackInstallState(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.Table.UpdateUser(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return &SlackInstallState{State: state, ExpiresAt: user.Slack.InstallStateExpiresAt}, nil
+}
+
+// replayDeadLetter requeues a failed delivery and has notify-user send it
+// again.
+func (h *Handler) replayDeadLetter(ctx context.Context, event Event) (*data.NotificationDelivery, error) {
+	userID, err := event.userID("id")
+	if err != nil {
+		return nil, err
+	}
+
+	var args struct {
+		EventID string `json:"eventId"`
+		Channel string `json:"channel"`
+	}
+	if err := mapstructure.Decode(event.Arguments, &args); err != nil {
+		return nil, err
+	}
+
+	d, err := h.Table.GetNotificationDelivery(ctx, userID, args.EventID, args.Channel)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Requeue(time.Now()); err != nil {
+		return nil, err
+	}
+	if err := h.Table.SaveNotificationDelivery(ctx, d); err != nil {
+		return nil, err
+	}
+
+	err = h.EVB.Send(ctx, "Notification Replay", data.NotificationReplayEvent{
+		UserID:  userID,
+		EventID: d.EventID,
+		Channel: d.Channel,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (h *Handler) deleteUser(ctx context.Context, event Event) (string, error) {
+	userID, err := event.userID("id")
+	if err != nil {
+		return "", err
+	}
+
+	if err := h.Table.DeleteUser(ctx, userID); err != nil {
+		return "", err
+	}
+
+	if err := h.Directory.DeleteUser(ctx, userID); err != nil {
+		return "", err
+	}
+
+	return userID, nil
+}
+
+func (h *Handler) getRateBudgets(ctx context.Context, event Event) ([]*data.RateBudget, error) {
+	// Only admins using the API key come without an OIDC identity
+	if event.Identity.Sub != "" {
+		return nil, errors.New("unauthorized: admin access required")
+	}
+
+	return h.Budget.Usage(ctx)
+}
@@ -1,4 +1,4 @@
-package main
+package resolvegraphql
 
 import (
 	"errors"
@@ -16,38 +16,38 @@ var compareErrors = cmp.Comparer(func(x, y error) bool {
 
 func TestUserID(t *testing.T) {
 	tests := []struct {
-		event   appSyncEvent
+		event   Event
 		argName string
 		userID  string
 		err     error
 	}{
 		{
-			event: appSyncEvent{},
+			event: Event{},
 			err:   errors.New("unauthorized: user ID must not be empty"),
 		},
 		{
-			event: appSyncEvent{
+			event: Event{
 				Arguments: map[string]interface{}{"id": "1234"},
 			},
 			argName: "id",
 			userID:  "1234",
 		},
 		{
-			event: appSyncEvent{
+			event: Event{
 				Arguments: map[string]interface{}{"userId": "1234"},
 			},
 			argName: "userId",
 			userID:  "1234",
 		},
 		{
-			event: appSyncEvent{
+			event: Event{
 				Arguments: map[string]interface{}{"id": "twitter|1234"},
 			},
 			argName: "id",
 			userID:  "1234",
 		},
 		{
-			event: appSyncEvent{
+			event: Event{
 				Arguments: map[string]interface{}{"id": "twitter|1234"},
 				Identity:  Identity{Sub: "twitter|1234"},
 			},
@@ -55,7 +55,7 @@ func TestUserID(t *testing.T) {
 			userID:  "1234",
 		},
 		{
-			event: appSyncEvent{
+			event: Event{
 				Arguments: map[string]interface{}{"id": "twitter|1234"},
 				Identity:  Identity{Sub: "twitter|5678"},
 			},
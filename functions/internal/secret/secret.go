@@ -0,0 +1,61 @@
+// Package secret encrypts credentials that are stored in the table, such as
+// the bot tokens of installed Slack apps.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+)
+
+var ErrMalformed = errors.New("malformed secret")
+
+// Box encrypts and decrypts secrets with AES-256-GCM.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// NewBox returns a box for the given key, which is 32 bytes encoded as hex,
+// e.g. generated with "openssl rand -hex 32".
+func NewBox(key string) (*Box, error) {
+	k, err := hex.DecodeString(key)
+	if err != nil || len(k) != 32 { //nolint:gomnd
+		return nil, errors.New("key must be 32 bytes encoded as hex")
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// Seal encrypts the plaintext with a random nonce.
+func (b *Box) Seal(plaintext string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a secret returned by Seal.
+func (b *Box) Open(secret string) (string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(secret)
+	if err != nil || len(sealed) < b.aead.NonceSize() {
+		return "", ErrMalformed
+	}
+	n := b.aead.NonceSize()
+	plaintext, err := b.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return "", ErrMalformed
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,55 @@
+package secret
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const key = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func TestBox(t *testing.T) {
+	box, err := NewBox(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := box.Seal("xoxb-123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, "xoxb") {
+		t.Errorf("secret not encrypted: %s", sealed)
+	}
+
+	again, _ := box.Seal("xoxb-123")
+	if again == sealed {
+		t.Error("nonce reused")
+	}
+
+	opened, err := box.Open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened != "xoxb-123" {
+		t.Errorf("want %q, got %q", "xoxb-123", opened)
+	}
+
+	other, _ := NewBox(strings.Repeat("ff", 32))
+	for _, s := range []string{"", "not base64!", sealed[:10], sealed[:len(sealed)-1] + "A"} {
+		if _, err := box.Open(s); !errors.Is(err, ErrMalformed) {
+			t.Errorf("%q: want ErrMalformed, got %v", s, err)
+		}
+	}
+	if _, err := other.Open(sealed); !errors.Is(err, ErrMalformed) {
+		t.Errorf("opened with wrong key: %v", err)
+	}
+}
+
+func TestNewBox(t *testing.T) {
+	for _, k := range []string{"", "abc", key[:62], "zz" + key[2:]} {
+		if _, err := NewBox(k); err == nil {
+			t.Errorf("%q: expected error", k)
+		}
+	}
+}
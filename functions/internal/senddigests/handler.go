@@ -0,0 +1,232 @@
+// Package senddigests summarizes the follower events of users in digest mode
+// and sends one notification per day or week instead of one per event.
+package senddigests
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/pkg/errors"
+	"golang.org/x/text/message"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/i18n"
+	"github.com/mlafeldt/listkeeper/functions/internal/notify"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+// maxNotable is the number of followers listed by follower count
+const maxNotable = 5
+
+type Output struct {
+	UserIDs []string
+}
+
+type Handler struct {
+	Table     data.TableAPI
+	Notifiers *notify.Registry
+	AppURL    string
+
+	// Now defaults to time.Now.
+	Now func() time.Time
+}
+
+// Summary aggregates the follower events of a digest period.
+type Summary struct {
+	Gained         int
+	Lost           int
+	Deactivated    int
+	Suspended      int
+	TotalFollowers int
+	NewFollowers   []*twitter.User // sorted by follower count
+	LostFollowers  []*twitter.User // sorted by follower count
+}
+
+// NetChange is the difference in followers over the period.
+func (s *Summary) NetChange() int {
+	return s.Gained - s.Lost
+}
+
+// Summarize aggregates the events created within [from, to). Events are
+// expected to be ordered from latest to oldest.
+func Summarize(events []*data.FollowerEvent, from, to time.Time) *Summary {
+	var s Summary
+
+	for _, e := range events {
+		if e.CreatedAt.Before(from) || !e.CreatedAt.Before(to) {
+			continue
+		}
+		if s.Gained+s.Lost == 0 {
+			s.TotalFollowers = e.TotalFollowers
+		}
+
+		switch e.FollowerState {
+		case data.FollowerStateNew:
+			s.Gained++
+			s.NewFollowers = append(s.NewFollowers, e.Follower)
+		case data.FollowerStateLost:
+			s.Lost++
+			switch e.FollowerStateReason {
+			case data.FollowerStateReasonDeactivated, data.FollowerStateReasonDeleted:
+				// Deleted followers deactivated their account first
+				s.Deactivated++
+			case data.FollowerStateReasonSuspended:
+				s.Suspended++
+			default:
+				s.LostFollowers = append(s.LostFollowers, e.Follower)
+			}
+		}
+	}
+
+	s.NewFollowers = notable(s.NewFollowers)
+	s.LostFollowers = notable(s.LostFollowers)
+
+	return &s
+}
+
+// notable returns the users with the most followers. Those without a profile
+// are left out.
+func notable(users []*twitter.User) []*twitter.User {
+	known := users[:0]
+	for _, u := range users {
+		if u.Handle != "" {
+			known = append(known, u)
+		}
+	}
+	users = known
+
+	sort.SliceStable(users, func(i, j int) bool {
+		return users[i].TotalFollowers > users[j].TotalFollowers
+	})
+	if len(users) > maxNotable {
+		users = users[:maxNotable]
+	}
+	return users
+}
+
+func (h *Handler) Handle(ctx context.Context, event events.CloudWatchEvent) (*Output, error) {
+	now := time.Now()
+	if h.Now != nil {
+		now = h.Now()
+	}
+
+	var (
+		out      Output
+		iter     = h.Table.NewUserIter()
+		failed   []string
+		firstErr error
+	)
+
+	for {
+		user := iter.Next(ctx)
+		if user == nil {
+			break
+		}
+
+		from, to, due := user.DigestPeriod(now)
+		if !due {
+			continue
+		}
+
+		// A failing user doesn't keep the others from getting their digest.
+		// Theirs is still due and sent by the next run.
+		if err := h.sendDigest(ctx, user, from, to); err != nil {
+			log.Printf("failed to send digest to user with ID %s: %s", user.ID, err)
+			failed = append(failed, user.ID)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		out.UserIDs = append(out.UserIDs, user.ID)
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("sent %d digests", len(out.UserIDs))
+
+	if firstErr != nil {
+		return &out, errors.Wrapf(firstErr, "failed to send digests to users with IDs %s", strings.Join(failed, ", "))
+	}
+
+	return &out, nil
+}
+
+func (h *Handler) sendDigest(ctx context.Context, user *data.User, from, to time.Time) error {
+	events, err := h.Table.GetFollowerEventsBetween(ctx, user.ID, from, to)
+	if err != nil {
+		return err
+	}
+
+	s := Summarize(events, from, to)
+	if err := h.Notifiers.Notify(ctx, user, h.format(user, s, from, to)); err != nil {
+		return err
+	}
+
+	user.Digest.LastSentAt = to
+	return h.Table.UpdateUserDigest(ctx, user)
+}
+
+func (h *Handler) format(user *data.User, s *Summary, from, to time.Time) *notify.Message {
+	var (
+		lang = user.Language()
+		p    = i18n.NewPrinter(lang)
+	)
+
+	var header, period string
+	if user.Digest.Mode == data.DigestModeWeekly {
+		header = p.Sprintf("Your weekly follower digest")
+		period = i18n.DateRange(lang, from, to.AddDate(0, 0, -1))
+	} else {
+		header = p.Sprintf("Your daily follower digest")
+		period = i18n.Date(lang, from)
+	}
+
+	text := fmt.Sprintf("*%s*\n\n", period)
+
+	if s.Gained+s.Lost == 0 {
+		text += p.Sprintf("No follower changes, all quiet :zzz:")
+	} else {
+		text += p.Sprintf("*Net change:* %+d\n", s.NetChange())
+		text += p.Sprintf("*Gained:* %d\n", s.Gained)
+		text += p.Sprintf("*Lost:* %d", s.Lost)
+		if s.Deactivated+s.Suspended > 0 {
+			text += p.Sprintf(" (%d deactivated, %d suspended)", s.Deactivated, s.Suspended)
+		}
+		text += "\n"
+
+		if len(s.NewFollowers) > 0 {
+			text += p.Sprintf("\n*Notable new followers:*\n") + formatFollowers(p, s.NewFollowers)
+		}
+		if len(s.LostFollowers) > 0 {
+			text += p.Sprintf("\n*Notable lost followers:*\n") + formatFollowers(p, s.LostFollowers)
+		}
+	}
+
+	footer := p.Sprintf("You (@%s) have %d Twitter followers", user.Handle, s.TotalFollowers)
+	if s.Gained+s.Lost == 0 {
+		footer = p.Sprintf("Manage your digest at <%s|Listkeeper>", h.AppURL)
+	}
+
+	return &notify.Message{
+		Header: header,
+		Text:   text,
+		Footer: footer,
+	}
+}
+
+func formatFollowers(p *message.Printer, users []*twitter.User) string {
+	var text string
+	for _, u := range users {
+		text += p.Sprintf("• %s (<https://twitter.com/%s|@%s>), %d followers\n", notify.EscapeMrkdwn(u.Name), u.Handle, u.Handle, u.TotalFollowers)
+	}
+	return text
+}
@@ -0,0 +1,213 @@
+package senddigests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/go-cmp/cmp"
+	"github.com/segmentio/ksuid"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/notify"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+// Saturday, 9:30 in Berlin
+var now, _ = time.Parse(time.RFC3339, "2020-11-07T09:30:00+01:00")
+
+type fakeNotifier struct {
+	sent    []*notify.Message
+	failFor string // user ID
+}
+
+func (n *fakeNotifier) Enabled(*data.User) bool { return true }
+
+func (n *fakeNotifier) Notify(_ context.Context, user *data.User, msg *notify.Message) error {
+	if user.ID == n.failFor {
+		return errors.New("notification failed")
+	}
+	n.sent = append(n.sent, msg)
+	return nil
+}
+
+func newUser(t *testing.T, table data.TableAPI, id string, digest data.DigestConfig) *data.User {
+	t.Helper()
+
+	u := data.NewUser(id)
+	u.Handle = "alice"
+	u.Name = "Alice"
+	u.ProfileImageURL = "https://example.com/alice.png"
+	u.AccessToken = "token"
+	u.AccessSecret = "secret"
+	u.LastLogin = u.CreatedAt
+	u.LastIP = "127.0.0.1"
+	u.LoginsCount = 1
+	u.Timezone = "Europe/Berlin"
+	u.Digest = digest
+	if err := table.CreateUser(context.Background(), u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func newEvent(t *testing.T, table data.TableAPI, userID string, ago time.Duration, state, reason string, follower *twitter.User, total int) {
+	t.Helper()
+
+	createdAt := now.Add(-ago)
+	id, err := ksuid.NewRandomWithTime(createdAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &data.FollowerEvent{
+		ID:                  id.String(),
+		UserID:              userID,
+		TotalFollowers:      total,
+		Follower:            follower,
+		FollowerState:       state,
+		FollowerStateReason: reason,
+		CreatedAt:           createdAt,
+		ExpiresAt:           createdAt.Add(24 * time.Hour),
+	}
+	if err := table.CreateFollowerEvent(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandler_Handle(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		table = data.NewMemoryTable()
+		daily = newUser(t, table, "111", data.DigestConfig{Mode: data.DigestModeDaily})
+		_     = newUser(t, table, "222", data.DigestConfig{Mode: data.DigestModeOff})
+
+		bob   = &twitter.User{ID: "1", Handle: "bob", Name: "Bob", TotalFollowers: 10}
+		carol = &twitter.User{ID: "2", Handle: "carol", Name: "Carol", TotalFollowers: 5000}
+		dave  = &twitter.User{ID: "3", Handle: "dave", Name: "Dave", TotalFollowers: 300}
+	)
+
+	newEvent(t, table, daily.ID, 25*time.Hour, data.FollowerStateNew, data.FollowerStateReasonFollowed, dave, 100) // too old
+	newEvent(t, table, daily.ID, 20*time.Hour, data.FollowerStateNew, data.FollowerStateReasonFollowed, bob, 101)
+	newEvent(t, table, daily.ID, 10*time.Hour, data.FollowerStateNew, data.FollowerStateReasonFollowed, carol, 102)
+	newEvent(t, table, daily.ID, 5*time.Hour, data.FollowerStateLost, data.FollowerStateReasonUnfollowed, dave, 101)
+	newEvent(t, table, daily.ID, 4*time.Hour, data.FollowerStateLost, data.FollowerStateReasonSuspended, &twitter.User{ID: "4"}, 100)
+	newEvent(t, table, daily.ID, 10*time.Minute, data.FollowerStateNew, data.FollowerStateReasonFollowed, bob, 101) // next digest
+
+	notifier := &fakeNotifier{}
+	notifiers := notify.NewRegistry()
+	notifiers.Register("fake", notifier)
+
+	h := &Handler{
+		Table:     table,
+		Notifiers: notifiers,
+		AppURL:    "https://listkeeper.io",
+		Now:       func() time.Time { return now },
+	}
+
+	out, err := h.Handle(ctx, events.CloudWatchEvent{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{daily.ID}, out.UserIDs); diff != "" {
+		t.Error(diff)
+	}
+
+	want := []*notify.Message{{
+		Header: "Your daily follower digest",
+		Text: "*Friday, Nov 6, 2020*\n\n" +
+			"*Net change:* +0\n" +
+			"*Gained:* 2\n" +
+			"*Lost:* 2 (0 deactivated, 1 suspended)\n" +
+			"\n*Notable new followers:*\n" +
+			"• Carol (<https://twitter.com/carol|@carol>), 5,000 followers\n" +
+			"• Bob (<https://twitter.com/bob|@bob>), 10 followers\n" +
+			"\n*Notable lost followers:*\n" +
+			"• Dave (<https://twitter.com/dave|@dave>), 300 followers\n",
+		Footer: "You (@alice) have 100 Twitter followers",
+	}}
+	if diff := cmp.Diff(want, notifier.sent); diff != "" {
+		t.Error(diff)
+	}
+
+	// The digest is only sent once
+	out, err = h.Handle(ctx, events.CloudWatchEvent{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.UserIDs) != 0 || len(notifier.sent) != 1 {
+		t.Errorf("digest sent again to %v", out.UserIDs)
+	}
+}
+
+func TestHandler_HandleContinuesAfterFailure(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		table  = data.NewMemoryTable()
+		first  = newUser(t, table, "111", data.DigestConfig{Mode: data.DigestModeDaily})
+		second = newUser(t, table, "222", data.DigestConfig{Mode: data.DigestModeDaily})
+	)
+
+	notifier := &fakeNotifier{failFor: first.ID}
+	notifiers := notify.NewRegistry()
+	notifiers.Register("fake", notifier)
+
+	h := &Handler{
+		Table:     table,
+		Notifiers: notifiers,
+		AppURL:    "https://listkeeper.io",
+		Now:       func() time.Time { return now },
+	}
+
+	out, err := h.Handle(ctx, events.CloudWatchEvent{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if diff := cmp.Diff([]string{second.ID}, out.UserIDs); diff != "" {
+		t.Error(diff)
+	}
+
+	// The failed digest is still due
+	notifier.failFor = ""
+	out, err = h.Handle(ctx, events.CloudWatchEvent{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{first.ID}, out.UserIDs); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestHandler_FormatGerman(t *testing.T) {
+	user := &data.User{
+		Handle: "alice",
+		Locale: data.LocaleGerman,
+		Digest: data.DigestConfig{Mode: data.DigestModeWeekly},
+	}
+	s := &Summary{
+		Gained:         1,
+		Lost:           3,
+		Deactivated:    1,
+		TotalFollowers: 1234,
+		NewFollowers:   []*twitter.User{{Handle: "carol", Name: "Carol", TotalFollowers: 5000}},
+	}
+	from := time.Date(2020, 10, 26, 0, 0, 0, 0, time.UTC)
+
+	h := &Handler{AppURL: "https://listkeeper.io"}
+
+	want := &notify.Message{
+		Header: "Deine wöchentliche Follower-Zusammenfassung",
+		Text: "*26. Okt. – 1. Nov. 2020*\n\n" +
+			"*Veränderung:* -2\n" +
+			"*Gewonnen:* 1\n" +
+			"*Verloren:* 3 (1 deaktiviert, 0 gesperrt)\n" +
+			"\n*Bemerkenswerte neue Follower:*\n" +
+			"• Carol (<https://twitter.com/carol|@carol>), 5.000 Follower\n",
+		Footer: "Du (@alice) hast 1.234 Twitter-Follower",
+	}
+	if diff := cmp.Diff(want, h.format(user, s, from, from.AddDate(0, 0, 7))); diff != "" {
+		t.Error(diff)
+	}
+}
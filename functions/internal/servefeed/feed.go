@@ -0,0 +1,136 @@
+package servefeed
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// feed is rendered as either Atom or RSS 2.0.
+type feed struct {
+	ID      string
+	Title   string
+	Link    string
+	Updated time.Time
+	Entries []*entry
+}
+
+// entry has a stable ID derived from the event's KSUID, so that feed
+// readers don't show events twice.
+type entry struct {
+	ID      string
+	Title   string
+	Link    string
+	Content string // HTML
+	Updated time.Time
+}
+
+// See https://datatracker.ietf.org/doc/html/rfc4287
+type atomFeed struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string       `xml:"id"`
+	Title   string       `xml:"title"`
+	Updated string       `xml:"updated"`
+	Link    atomLink     `xml:"link"`
+	Author  atomAuthor   `xml:"author"`
+	Entries []*atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Content atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+func (f *feed) atom() ([]byte, error) {
+	af := atomFeed{
+		ID:      f.ID,
+		Title:   f.Title,
+		Updated: f.Updated.Format(time.RFC3339),
+		Link:    atomLink{Href: f.Link, Rel: "alternate"},
+		Author:  atomAuthor{Name: "Listkeeper"},
+	}
+	for _, e := range f.Entries {
+		af.Entries = append(af.Entries, &atomEntry{
+			ID:      e.ID,
+			Title:   e.Title,
+			Updated: e.Updated.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: e.Link, Rel: "alternate"},
+			Content: atomContent{Type: "html", Body: e.Content},
+		})
+	}
+	return marshal(af)
+}
+
+// See https://www.rssboard.org/rss-specification
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link"`
+	Description   string     `xml:"description"`
+	LastBuildDate string     `xml:"lastBuildDate"`
+	Items         []*rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func (f *feed) rss() ([]byte, error) {
+	rf := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.Link,
+			Description:   f.Title,
+			LastBuildDate: f.Updated.Format(time.RFC1123Z),
+		},
+	}
+	for _, e := range f.Entries {
+		rf.Channel.Items = append(rf.Channel.Items, &rssItem{
+			Title:       e.Title,
+			Link:        e.Link,
+			Description: e.Content,
+			GUID:        rssGUID{Value: e.ID},
+			PubDate:     e.Updated.UTC().Format(time.RFC1123Z),
+		})
+	}
+	return marshal(rf)
+}
+
+func marshal(v interface{}) ([]byte, error) {
+	b, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
@@ -0,0 +1,165 @@
+// Package servefeed serves a private feed of follower events for those who
+// prefer a feed reader to notifications. The feed is available as Atom at
+// /<token>.atom and as RSS 2.0 at /<token>.rss, with the secret token
+// created via GraphQL.
+package servefeed
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"html"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/i18n"
+	"github.com/mlafeldt/listkeeper/functions/internal/lambdaurl"
+	"github.com/mlafeldt/listkeeper/functions/internal/notify"
+	"github.com/mlafeldt/listkeeper/functions/internal/notifyuser"
+)
+
+// MaxEntries limits the number of follower events in a feed.
+const MaxEntries = 50
+
+const (
+	formatAtom = ".atom"
+	formatRSS  = ".rss"
+)
+
+type Handler struct {
+	Table  data.TableAPI
+	AppURL string // the feed links here
+}
+
+// Handle receives requests via a Lambda function URL.
+func (h *Handler) Handle(ctx context.Context, req events.LambdaFunctionURLRequest) (*events.LambdaFunctionURLResponse, error) {
+	return lambdaurl.Serve(ctx, h, req)
+}
+
+// ServeHTTP serves the feed named by the last path element, so that the
+// handler can be mounted anywhere. Unknown and revoked tokens are treated
+// the same as missing feeds.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var (
+		name   = path.Base(r.URL.Path)
+		format = path.Ext(name)
+		token  = strings.TrimSuffix(name, format)
+	)
+	if format != formatAtom && format != formatRSS {
+		http.NotFound(w, r)
+		return
+	}
+	userID, ok := data.FeedTokenUserID(token)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	user, err := h.Table.GetUser(r.Context(), userID)
+	if errors.Is(err, data.ErrUserNotFound) || (err == nil && !user.ValidFeedToken(token)) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to get user %s: %s", userID, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	events, err := h.Table.GetLatestFollowerEvents(r.Context(), userID, MaxEntries)
+	if err != nil {
+		log.Printf("failed to get events of user %s: %s", userID, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	f := h.newFeed(user, events)
+
+	var (
+		body        []byte
+		contentType string
+	)
+	switch format {
+	case formatAtom:
+		body, err = f.atom()
+		contentType = "application/atom+xml; charset=utf-8"
+	case formatRSS:
+		body, err = f.rss()
+		contentType = "application/rss+xml; charset=utf-8"
+	}
+	if err != nil {
+		log.Printf("failed to render feed of user %s: %s", userID, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// ServeContent answers If-None-Match and If-Modified-Since
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private")
+	w.Header().Set("ETag", etag(body))
+	http.ServeContent(w, r, name, f.Updated, bytes.NewReader(body))
+}
+
+// newFeed formats events with the same wording as notifications. The feed
+// was last updated by the latest event, or by changes to the user's
+// templates or locale, whichever came last.
+func (h *Handler) newFeed(user *data.User, events []*data.FollowerEvent) *feed {
+	p := i18n.NewPrinter(user.Language())
+
+	f := feed{
+		ID:      "urn:listkeeper:feed:" + user.ID,
+		Title:   p.Sprintf("Followers of @%s", user.Handle),
+		Link:    h.AppURL,
+		Updated: user.UpdatedAt,
+	}
+
+	for _, e := range events {
+		out, imageURL := notifyuser.FollowerChangeOutput(user, e)
+
+		var content strings.Builder
+		if imageURL != "" {
+			content.WriteString(`<p><img src="` + html.EscapeString(imageURL) + `" alt="" width="96" height="96"></p>` + "\n")
+		}
+		content.WriteString(string(notify.HTMLText(out.Text)) + "\n")
+		content.WriteString("<p>" + string(notify.HTMLInline(out.Footer)) + "</p>")
+
+		title := notify.PlainText(out.Header)
+		link := h.AppURL
+		if e.Follower != nil && e.Follower.Handle != "" {
+			title += ": @" + e.Follower.Handle
+			link = "https://twitter.com/" + e.Follower.Handle
+		}
+
+		f.Entries = append(f.Entries, &entry{
+			ID:      "urn:listkeeper:event:" + e.ID,
+			Title:   title,
+			Link:    link,
+			Content: content.String(),
+			Updated: e.CreatedAt,
+		})
+		if e.CreatedAt.After(f.Updated) {
+			f.Updated = e.CreatedAt
+		}
+	}
+
+	f.Updated = f.Updated.UTC().Truncate(time.Second)
+	return &f
+}
+
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
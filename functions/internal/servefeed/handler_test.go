@@ -0,0 +1,209 @@
+package servefeed
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+var created = time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func newUser(t *testing.T, table data.TableAPI) (*data.User, string) {
+	t.Helper()
+
+	u := data.NewUser("111")
+	u.Handle = "alice"
+	u.Name = "Alice"
+	u.ProfileImageURL = "https://example.com/alice.png"
+	u.AccessToken = "token"
+	u.AccessSecret = "secret"
+	u.LastLogin = u.CreatedAt
+	u.LastIP = "127.0.0.1"
+	u.LoginsCount = 1
+	token, err := u.NewFeedToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := table.CreateUser(context.Background(), u); err != nil {
+		t.Fatal(err)
+	}
+	return u, token
+}
+
+func newEvent(t *testing.T, table data.TableAPI, id, handle string, createdAt time.Time) {
+	t.Helper()
+
+	err := table.CreateFollowerEvent(context.Background(), &data.FollowerEvent{
+		ID:                  id,
+		UserID:              "111",
+		TotalFollowers:      42,
+		Follower:            &twitter.User{ID: "2", Handle: handle, Name: "Bob", TotalFollowers: 7},
+		FollowerState:       data.FollowerStateNew,
+		FollowerStateReason: data.FollowerStateReasonFollowed,
+		CreatedAt:           createdAt,
+		ExpiresAt:           createdAt.Add(24 * time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandler_Atom(t *testing.T) {
+	var (
+		table    = data.NewMemoryTable()
+		_, token = newUser(t, table)
+		h        = &Handler{Table: table, AppURL: "https://listkeeper.io"}
+	)
+	newEvent(t, table, "1", "bob", created)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/feed/"+token+".atom", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/atom+xml") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	var got atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(got.Entries))
+	}
+
+	want := atomEntry{
+		ID:      "urn:listkeeper:event:1",
+		Title:   "New follower: @bob",
+		Updated: "2022-05-01T12:00:00Z",
+		Link:    atomLink{Href: "https://twitter.com/bob", Rel: "alternate"},
+		Content: atomContent{
+			Type: "html",
+			Body: `<p>Bob (<a href="https://twitter.com/bob">@bob</a>) followed you 🎉</p>` + "\n" +
+				`<p><strong>Followers:</strong> 7</p>` + "\n" +
+				`<p>You (@alice) now have 42 Twitter followers</p>`,
+		},
+	}
+	if diff := cmp.Diff(want, *got.Entries[0]); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestHandler_RSS(t *testing.T) {
+	var (
+		table    = data.NewMemoryTable()
+		_, token = newUser(t, table)
+		h        = &Handler{Table: table, AppURL: "https://listkeeper.io"}
+	)
+	newEvent(t, table, "1", "bob", created)
+	newEvent(t, table, "2", "carol", created.Add(time.Hour))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+token+".rss", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	var got rssFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	var guids []string
+	for _, item := range got.Channel.Items {
+		guids = append(guids, item.GUID.Value)
+	}
+	if diff := cmp.Diff([]string{"urn:listkeeper:event:2", "urn:listkeeper:event:1"}, guids); diff != "" {
+		t.Error(diff)
+	}
+	if want := "Followers of @alice"; got.Channel.Title != want {
+		t.Errorf("expected title %q, got %q", want, got.Channel.Title)
+	}
+}
+
+func TestHandler_Conditional(t *testing.T) {
+	var (
+		table    = data.NewMemoryTable()
+		_, token = newUser(t, table)
+		h        = &Handler{Table: table}
+		path     = "/feed/" + token + ".atom"
+	)
+	newEvent(t, table, "1", "bob", time.Now().Add(time.Minute))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var (
+		etag         = w.Header().Get("ETag")
+		lastModified = w.Header().Get("Last-Modified")
+	)
+	if etag == "" || lastModified == "" {
+		t.Fatalf("expected ETag and Last-Modified, got %v", w.Header())
+	}
+
+	for _, header := range []http.Header{
+		{"If-None-Match": {etag}},
+		{"If-Modified-Since": {lastModified}},
+	} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header = header
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusNotModified {
+			t.Errorf("%v: expected status 304, got %d", header, w.Code)
+		}
+	}
+
+	// A new event changes the feed
+	newEvent(t, table, "2", "carol", time.Now().Add(time.Hour))
+
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+}
+
+func TestHandler_NotFound(t *testing.T) {
+	var (
+		table       = data.NewMemoryTable()
+		user, token = newUser(t, table)
+		h           = &Handler{Table: table}
+	)
+
+	old := token
+	if _, err := user.NewFeedToken(); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.UpdateUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{
+		"/feed/" + old + ".atom", // rotated
+		"/feed/111-invalid.rss",
+		"/feed/222-invalid.rss",
+		"/feed/" + user.FeedToken + ".json",
+		"/feed/invalid",
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected status 404, got %d", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,538 @@
+// Package slackapp installs the Slack app via OAuth and handles events sent
+// by Slack, such as the app being uninstalled.
+//
+// The app is installed by sending the user to /install with a state created
+// via GraphQL. Slack redirects back to /callback, where the bot token is
+// stored on the user. Events are sent to /events, clicks on the buttons
+// below follower notifications to /actions.
+package slackapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	lambdasvc "github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/i18n"
+	"github.com/mlafeldt/listkeeper/functions/internal/lambdaurl"
+	"github.com/mlafeldt/listkeeper/functions/internal/notify"
+	"github.com/mlafeldt/listkeeper/functions/internal/secret"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+// AuthorizeURL is where users approve the install.
+const AuthorizeURL = "https://slack.com/oauth/v2/authorize"
+
+// Scopes are requested on install. The incoming webhook lets users choose a
+// channel, which the bot can post to if public.
+var Scopes = []string{"chat:write", "chat:write.public", "incoming-webhook"}
+
+// maxBodySize limits the size of event and action requests.
+const maxBodySize = 1 << 20
+
+type Handler struct {
+	Table         data.TableAPI
+	Tokens        *secret.Box
+	ClientID      string
+	ClientSecret  string
+	SigningSecret string
+	AppURL        string // users are sent back here after the install
+
+	// Twitter removes and blocks followers on behalf of users.
+	Twitter twitter.API
+
+	// RedirectURL defaults to /callback next to /install.
+	RedirectURL string
+
+	// Client is used to exchange the OAuth code and to update messages,
+	// defaults to http.DefaultClient.
+	Client *http.Client
+
+	// Lambda, if set, takes actions in an asynchronous invocation of
+	// FunctionName, usually this function, see Invoke. Slack only waits 3
+	// seconds for a response, which calls to Twitter may exceed.
+	Lambda       lambdaiface.LambdaAPI
+	FunctionName string
+
+	// Now defaults to time.Now.
+	Now func() time.Time
+}
+
+// Action is a click on a button below a follower notification, see
+// notify.SlackActionValue.
+type Action struct {
+	ID          string       `json:"id"`
+	UserID      string       `json:"userId"`
+	FollowerID  string       `json:"followerId"`
+	Handle      string       `json:"handle,omitempty"`
+	ResponseURL string       `json:"responseUrl"`
+	Blocks      slack.Blocks `json:"blocks"` // of the original message
+}
+
+// invocation is the payload of the asynchronous invocations taking actions.
+type invocation struct {
+	Action *Action `json:"slackAction"`
+}
+
+// Invoke handles both requests via the Lambda function URL and actions
+// invoked asynchronously by the function itself.
+func (h *Handler) Invoke(ctx context.Context, payload json.RawMessage) (*events.LambdaFunctionURLResponse, error) {
+	var inv invocation
+	if err := json.Unmarshal(payload, &inv); err != nil {
+		return nil, err
+	}
+	if inv.Action != nil {
+		return nil, h.takeAction(ctx, inv.Action)
+	}
+
+	var req events.LambdaFunctionURLRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, err
+	}
+	return h.Handle(ctx, req)
+}
+
+// Handle receives requests via a Lambda function URL.
+func (h *Handler) Handle(ctx context.Context, req events.LambdaFunctionURLRequest) (*events.LambdaFunctionURLResponse, error) {
+	return lambdaurl.Serve(ctx, h, req)
+}
+
+// ServeHTTP dispatches on the last path element, so that the handler can be
+// mounted anywhere.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch path := r.URL.Path; {
+	case strings.HasSuffix(path, "/install") && r.Method == http.MethodGet:
+		h.install(w, r)
+	case strings.HasSuffix(path, "/callback") && r.Method == http.MethodGet:
+		h.callback(w, r)
+	case strings.HasSuffix(path, "/events") && r.Method == http.MethodPost:
+		h.events(w, r)
+	case strings.HasSuffix(path, "/actions") && r.Method == http.MethodPost:
+		h.actions(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// install redirects to Slack to approve the install.
+func (h *Handler) install(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	if _, ok := data.SlackInstallStateUserID(state); !ok {
+		http.Error(w, "missing or invalid state", http.StatusBadRequest)
+		return
+	}
+
+	q := url.Values{
+		"client_id":    {h.ClientID},
+		"scope":        {strings.Join(Scopes, ",")},
+		"redirect_uri": {h.redirectURL(r)},
+		"state":        {state},
+	}
+	http.Redirect(w, r, AuthorizeURL+"?"+q.Encode(), http.StatusFound)
+}
+
+// callback exchanges the code for a bot token after the install was
+// approved.
+func (h *Handler) callback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if reason := q.Get("error"); reason != "" {
+		log.Printf("slack install failed: %s", reason)
+		h.redirectToApp(w, r, "error")
+		return
+	}
+
+	user, err := h.installApp(r.Context(), q.Get("state"), q.Get("code"), h.redirectURL(r))
+	if errors.Is(err, data.ErrSlackInstallStateInvalid) {
+		http.Error(w, "Sorry, this link is invalid or has expired. Please try again from your Listkeeper dashboard.", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("slack install failed: %s", err)
+		h.redirectToApp(w, r, "error")
+		return
+	}
+
+	log.Printf("installed slack app in team %s for user %s", user.Slack.TeamID, user.ID)
+
+	h.redirectToApp(w, r, "installed")
+}
+
+func (h *Handler) installApp(ctx context.Context, state, code, redirectURL string) (*data.User, error) {
+	userID, ok := data.SlackInstallStateUserID(state)
+	if !ok || code == "" {
+		return nil, data.ErrSlackInstallStateInvalid
+	}
+
+	user, err := h.Table.GetUser(ctx, userID)
+	if errors.Is(err, data.ErrUserNotFound) {
+		return nil, data.ErrSlackInstallStateInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Check before the code is used up
+	if !user.ValidSlackInstallState(state, h.now()) {
+		return nil, data.ErrSlackInstallStateInvalid
+	}
+
+	resp, err := slack.GetOAuthV2ResponseContext(ctx, h.client(), h.ClientID, h.ClientSecret, code, redirectURL)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := h.Tokens.Seal(resp.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := user.InstallSlack(state, &data.SlackInstall{
+		TeamID:     resp.Team.ID,
+		TeamName:   resp.Team.Name,
+		ChannelID:  resp.IncomingWebhook.ChannelID,
+		Channel:    resp.IncomingWebhook.Channel,
+		WebhookURL: resp.IncomingWebhook.URL,
+		BotToken:   token,
+	}, h.now()); err != nil {
+		return nil, err
+	}
+	if err := h.Table.UpdateUser(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// events handles the Events API, see https://api.slack.com/apis/connections/events-api
+func (h *Handler) events(w http.ResponseWriter, r *http.Request) {
+	body, ok := h.readVerified(w, r)
+	if !ok {
+		return
+	}
+
+	event, err := slackevents.ParseEvent(body, slackevents.OptionNoVerifyToken())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch event.Type {
+	case slackevents.URLVerification:
+		var v slackevents.EventsAPIURLVerificationEvent
+		if err := json.Unmarshal(body, &v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, v.Challenge) //nolint:errcheck
+	case slackevents.CallbackEvent:
+		switch slackevents.EventsAPIType(event.InnerEvent.Type) {
+		case slackevents.AppUninstalled, slackevents.TokensRevoked:
+			// Slack retries the event unless we respond with success
+			if err := h.uninstall(r.Context(), event.TeamID); err != nil {
+				log.Printf("failed to uninstall slack app from team %s: %s", event.TeamID, err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// uninstall disables Slack notifications of all users in the team. Users
+// are scanned since uninstalls are rare.
+func (h *Handler) uninstall(ctx context.Context, teamID string) error {
+	iter := h.Table.NewUserIter()
+	for {
+		user := iter.Next(ctx)
+		if user == nil {
+			break
+		}
+		if !user.Slack.Installed() || user.Slack.TeamID != teamID {
+			continue
+		}
+
+		user.UninstallSlack()
+		if err := h.Table.UpdateUser(ctx, user); err != nil {
+			return err
+		}
+		log.Printf("uninstalled slack app in team %s for user %s", teamID, user.ID)
+	}
+	return iter.Err()
+}
+
+// actions handles clicks on the buttons below follower notifications, see
+// https://api.slack.com/interactivity/handling. The click is acknowledged
+// right away; the original message is updated via its response URL once the
+// action was taken.
+func (h *Handler) actions(w http.ResponseWriter, r *http.Request) {
+	body, ok := h.readVerified(w, r)
+	if !ok {
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var cb slack.InteractionCallback
+	if err := json.Unmarshal([]byte(form.Get("payload")), &cb); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cb.Type != slack.InteractionTypeBlockActions || len(cb.ActionCallback.BlockActions) == 0 {
+		return
+	}
+
+	action := cb.ActionCallback.BlockActions[0]
+	userID, followerID, handle, ok := notify.ParseSlackActionValue(action.Value)
+	if !ok {
+		http.Error(w, "invalid action value", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	user, err := h.Table.GetUser(ctx, userID)
+	if errors.Is(err, data.ErrUserNotFound) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to get user %s: %s", userID, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	// Slack signs all requests with the same secret, so make sure the
+	// message was sent to the user's workspace
+	if !user.Slack.Installed() || user.Slack.TeamID != cb.Team.ID {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	a := &Action{
+		ID:          action.ActionID,
+		UserID:      userID,
+		FollowerID:  followerID,
+		Handle:      handle,
+		ResponseURL: cb.ResponseURL,
+		Blocks:      cb.Message.Blocks,
+	}
+
+	if h.Lambda == nil {
+		h.respond(ctx, user, a)
+		return
+	}
+
+	payload, err := json.Marshal(invocation{Action: a})
+	if err == nil {
+		_, err = h.Lambda.InvokeWithContext(ctx, &lambdasvc.InvokeInput{
+			FunctionName:   aws.String(h.FunctionName),
+			InvocationType: aws.String(lambdasvc.InvocationTypeEvent),
+			Payload:        payload,
+		})
+	}
+	if err != nil {
+		log.Printf("failed to invoke %s for user %s: %s", action.ActionID, user.ID, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// takeAction is invoked asynchronously by actions.
+func (h *Handler) takeAction(ctx context.Context, a *Action) error {
+	user, err := h.Table.GetUser(ctx, a.UserID)
+	if err != nil {
+		return err
+	}
+	h.respond(ctx, user, a)
+	return nil
+}
+
+// respond takes the action and updates the original message to show the
+// outcome. Failures are reported to the user rather than retried, since
+// Slack only accepts a few responses per message.
+func (h *Handler) respond(ctx context.Context, user *data.User, a *Action) {
+	var (
+		p       = i18n.NewPrinter(user.Language())
+		account = "@" + a.Handle
+	)
+	if a.Handle == "" {
+		account = a.FollowerID
+	}
+
+	outcome, err := h.act(ctx, user, a.ID, a.FollowerID, account)
+	var msg slack.WebhookMessage
+	if err != nil {
+		log.Printf("failed to %s %s for user %s: %s", a.ID, a.FollowerID, user.ID, err)
+		msg = slack.WebhookMessage{
+			ResponseType: slack.ResponseTypeEphemeral,
+			Text:         p.Sprintf("Sorry, that didn't work. Please try again later."),
+		}
+	} else {
+		log.Printf("%s %s for user %s", a.ID, a.FollowerID, user.ID)
+		msg = slack.WebhookMessage{
+			ReplaceOriginal: true,
+			Text:            outcome,
+			Blocks:          &slack.Blocks{BlockSet: replaceActions(a.Blocks.BlockSet, outcome)},
+		}
+	}
+
+	if err := slack.PostWebhookCustomHTTPContext(ctx, a.ResponseURL, h.client(), &msg); err != nil {
+		log.Printf("failed to update slack message: %s", err)
+	}
+}
+
+// act performs the action and returns a description of the outcome in the
+// user's language.
+func (h *Handler) act(ctx context.Context, user *data.User, actionID, followerID, account string) (string, error) {
+	p := i18n.NewPrinter(user.Language())
+
+	switch actionID {
+	case notify.SlackActionIgnore:
+		if !user.IgnoresFollower(followerID, "") {
+			user.IgnoreFollowers = append(user.IgnoreFollowers, followerID)
+			if err := h.Table.UpdateUser(ctx, user); err != nil {
+				return "", err
+			}
+		}
+		return p.Sprintf(":mute: %s is ignored from now on", account), nil
+	case notify.SlackActionRemove, notify.SlackActionBlock:
+		id, err := strconv.ParseInt(followerID, 10, 64)
+		if err != nil {
+			return "", err
+		}
+		if actionID == notify.SlackActionRemove {
+			err := h.removeFollower(ctx, user, id)
+			if errors.Is(err, twitter.ErrStillBlocked) {
+				// The follower is gone either way, but needs to be
+				// unblocked to be able to follow again
+				log.Printf("failed to unblock %s for user %s: %s", followerID, user.ID, err)
+				h.recordRemoval(ctx, user, followerID)
+				return p.Sprintf(":warning: %s was removed from your followers, but is still blocked. Unblock them on Twitter to let them follow you again.", account), nil
+			}
+			if err != nil {
+				return "", err
+			}
+			h.recordRemoval(ctx, user, followerID)
+			return p.Sprintf(":wave: %s was removed from your followers", account), nil
+		}
+		if err := h.Twitter.BlockUser(ctx, user.AccessToken, user.AccessSecret, id); err != nil {
+			return "", err
+		}
+		h.recordRemoval(ctx, user, followerID)
+		return p.Sprintf(":no_entry_sign: %s was blocked", account), nil
+	default:
+		return "", fmt.Errorf("unknown action %q", actionID)
+	}
+}
+
+// maxRemoveAttempts is how often removing a follower is tried if they stay
+// blocked. Blocking again does no harm.
+const maxRemoveAttempts = 3
+
+func (h *Handler) removeFollower(ctx context.Context, user *data.User, id int64) error {
+	var err error
+	for i := 0; i < maxRemoveAttempts; i++ {
+		err = h.Twitter.RemoveFollower(ctx, user.AccessToken, user.AccessSecret, id)
+		if !errors.Is(err, twitter.ErrStillBlocked) {
+			return err
+		}
+	}
+	return err
+}
+
+// recordRemoval remembers that the user removed or blocked the follower, so
+// that diff-followers won't report them as unfollowing. The action was taken
+// either way, so failing to record it is only logged.
+func (h *Handler) recordRemoval(ctx context.Context, user *data.User, followerID string) {
+	c, err := h.Table.GetFollowerChurnByID(ctx, user.ID, followerID)
+	if errors.Is(err, data.ErrFollowerChurnNotFound) {
+		c, err = &data.FollowerChurn{UserID: user.ID, FollowerID: followerID}, nil
+	}
+	if err == nil {
+		c.Removed(h.now())
+		err = h.Table.SaveFollowerChurn(ctx, c)
+	}
+	if err != nil {
+		log.Printf("failed to record removal of %s for user %s: %s", followerID, user.ID, err)
+	}
+}
+
+// replaceActions replaces the buttons with the outcome, so that the action
+// cannot be taken twice.
+func replaceActions(blocks []slack.Block, outcome string) []slack.Block {
+	var replaced []slack.Block
+	for _, b := range blocks {
+		if a, ok := b.(*slack.ActionBlock); ok && a.BlockID == notify.SlackActionsBlockID {
+			b = slack.NewContextBlock("", slack.NewTextBlockObject("mrkdwn", outcome, false, false))
+		}
+		replaced = append(replaced, b)
+	}
+	return replaced
+}
+
+// readVerified reads the request body and verifies that it was signed by
+// Slack, see https://api.slack.com/authentication/verifying-requests-from-slack
+func (h *Handler) readVerified(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	sv, err := slack.NewSecretsVerifier(r.Header, h.SigningSecret)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return nil, false
+	}
+	sv.Write(body) //nolint:errcheck
+	if err := sv.Ensure(); err != nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return body, true
+}
+
+func (h *Handler) redirectURL(r *http.Request) string {
+	if h.RedirectURL != "" {
+		return h.RedirectURL
+	}
+	scheme := "https"
+	if r.TLS == nil && r.Header.Get("X-Forwarded-Proto") == "http" {
+		scheme = "http"
+	}
+	path := strings.TrimSuffix(r.URL.Path, "/install") + "/callback"
+	return scheme + "://" + r.Host + path
+}
+
+func (h *Handler) redirectToApp(w http.ResponseWriter, r *http.Request, result string) {
+	http.Redirect(w, r, h.AppURL+"?slack="+result, http.StatusFound)
+}
+
+func (h *Handler) client() *http.Client {
+	if h.Client != nil {
+		return h.Client
+	}
+	return http.DefaultClient
+}
+
+func (h *Handler) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,414 @@
+package slackapp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	lambdasvc "github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/secret"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+const (
+	tokenKey      = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	signingSecret = "shhh"
+)
+
+var now, _ = time.Parse(time.RFC3339, "2020-11-07T09:30:00Z")
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func newUser(t *testing.T, table data.TableAPI) *data.User {
+	t.Helper()
+
+	u := data.NewUser("111")
+	u.Handle = "alice"
+	u.Name = "Alice"
+	u.ProfileImageURL = "https://example.com/alice.png"
+	u.AccessToken = "token"
+	u.AccessSecret = "secret"
+	u.LastLogin = u.CreatedAt
+	u.LastIP = "127.0.0.1"
+	u.LoginsCount = 1
+	if err := table.CreateUser(context.Background(), u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func newHandler(t *testing.T, table data.TableAPI) *Handler {
+	t.Helper()
+
+	box, err := secret.NewBox(tokenKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stands in for https://slack.com/api/oauth.v2.access
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		r.ParseForm() //nolint:errcheck
+		body := `{"ok": false, "error": "invalid_code"}`
+		if r.URL.Path == "/api/oauth.v2.access" && r.Form.Get("code") == "good" && r.Form.Get("client_secret") == "client-secret" {
+			body = `{
+				"ok": true,
+				"access_token": "xoxb-123",
+				"team": {"id": "T1", "name": "Acme"},
+				"incoming_webhook": {"channel": "#followers", "channel_id": "C1", "url": "https://hooks.slack.com/services/T1/B1/xyz"}
+			}`
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: http.Header{}}, nil
+	})}
+
+	return &Handler{
+		Table:         table,
+		Tokens:        box,
+		ClientID:      "client-id",
+		ClientSecret:  "client-secret",
+		SigningSecret: signingSecret,
+		AppURL:        "https://listkeeper.io",
+		Client:        client,
+		Now:           func() time.Time { return now },
+	}
+}
+
+func TestHandler_Install(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		table = data.NewMemoryTable()
+		user  = newUser(t, table)
+		h     = newHandler(t, table)
+	)
+
+	state, err := user.NewSlackInstallState(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := table.UpdateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://slack.listkeeper.io/install?state="+state, nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+	loc, _ := url.Parse(w.Header().Get("Location"))
+	if q := loc.Query(); q.Get("state") != state || q.Get("redirect_uri") != "https://slack.listkeeper.io/callback" || q.Get("client_id") != "client-id" {
+		t.Errorf("unexpected redirect to %s", loc)
+	}
+
+	tests := []struct {
+		query    string
+		status   int
+		location string
+	}{
+		{"state=" + state + "&code=bad", http.StatusFound, "https://listkeeper.io?slack=error"},
+		{"error=access_denied&state=" + state, http.StatusFound, "https://listkeeper.io?slack=error"},
+		{"state=111-0000000000000000&code=good", http.StatusBadRequest, ""},
+		{"state=" + state + "&code=good", http.StatusFound, "https://listkeeper.io?slack=installed"},
+		{"state=" + state + "&code=good", http.StatusBadRequest, ""}, // used up
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://slack.listkeeper.io/callback?"+test.query, nil))
+		if w.Code != test.status || w.Header().Get("Location") != test.location {
+			t.Errorf("%s: got %d %q, want %d %q", test.query, w.Code, w.Header().Get("Location"), test.status, test.location)
+		}
+	}
+
+	stored, err := table.GetUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := h.Tokens.Open(stored.Slack.BotToken)
+	if err != nil || token != "xoxb-123" {
+		t.Errorf("unexpected bot token %q: %v", token, err)
+	}
+	stored.Slack.BotToken = ""
+	want := data.SlackConfig{
+		Enabled:    true,
+		WebhookURL: "https://hooks.slack.com/services/T1/B1/xyz",
+		Channel:    "#followers",
+		TeamID:     "T1",
+		TeamName:   "Acme",
+		ChannelID:  "C1",
+	}
+	if diff := cmp.Diff(want, stored.Slack); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func signedEvent(t *testing.T, body string, ts time.Time) *http.Request {
+	t.Helper()
+
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+
+	r := httptest.NewRequest(http.MethodPost, "/slack/events", strings.NewReader(body))
+	r.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func TestHandler_Events(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		table = data.NewMemoryTable()
+		user  = newUser(t, table)
+		h     = newHandler(t, table)
+	)
+
+	user.Slack = data.SlackConfig{Enabled: true, TeamID: "T1", ChannelID: "C1", BotToken: "sealed", WebhookURL: "https://hooks.slack.com/services/T1/B1/xyz"}
+	if err := table.UpdateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, signedEvent(t, `{"type": "url_verification", "token": "x", "challenge": "abc"}`, time.Now()))
+	if w.Code != http.StatusOK || w.Body.String() != "abc" {
+		t.Errorf("unexpected challenge response: %d %q", w.Code, w.Body)
+	}
+
+	uninstalled := `{"type": "event_callback", "team_id": "T1", "event": {"type": "app_uninstalled"}}`
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, signedEvent(t, uninstalled, time.Now().Add(-time.Hour)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("accepted stale event: %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r := signedEvent(t, uninstalled, time.Now())
+	r.Header.Set("X-Slack-Signature", "v0=00")
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("accepted bad signature: %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, signedEvent(t, uninstalled, time.Now()))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body)
+	}
+
+	stored, err := table.GetUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(data.SlackConfig{}, stored.Slack); diff != "" {
+		t.Error(diff)
+	}
+}
+
+type twitterStub struct {
+	twitter.API
+
+	removed []int64
+	blocked []int64
+}
+
+func (t *twitterStub) RemoveFollower(ctx context.Context, accessToken, accessSecret string, userID int64) error {
+	if userID == 555 {
+		return fmt.Errorf("%w: over capacity", twitter.ErrStillBlocked)
+	}
+	t.removed = append(t.removed, userID)
+	return nil
+}
+
+func (t *twitterStub) BlockUser(ctx context.Context, accessToken, accessSecret string, userID int64) error {
+	if userID == 666 {
+		return twitter.ErrUserSuspended
+	}
+	t.blocked = append(t.blocked, userID)
+	return nil
+}
+
+func signedAction(t *testing.T, teamID, actionID, value, responseURL string) *http.Request {
+	t.Helper()
+
+	payload := fmt.Sprintf(`{
+		"type": "block_actions",
+		"team": {"id": %q},
+		"response_url": %q,
+		"actions": [{"action_id": %q, "block_id": "follower_actions", "value": %q}],
+		"message": {"blocks": [
+			{"type": "header", "text": {"type": "plain_text", "text": "New follower"}},
+			{"type": "actions", "block_id": "follower_actions", "elements": []}
+		]}
+	}`, teamID, responseURL, actionID, value)
+
+	r := signedEvent(t, url.Values{"payload": {payload}}.Encode(), time.Now())
+	r.URL.Path = "/slack/actions"
+	return r
+}
+
+func TestHandler_Actions(t *testing.T) {
+	var responses []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		responses = append(responses, string(body))
+	}))
+	defer srv.Close()
+
+	var (
+		ctx   = context.Background()
+		table = data.NewMemoryTable()
+		user  = newUser(t, table)
+		tw    = &twitterStub{}
+		h     = newHandler(t, table)
+	)
+	h.Twitter = tw
+	h.Client = srv.Client()
+
+	user.Slack = data.SlackConfig{Enabled: true, TeamID: "T1", ChannelID: "C1", BotToken: "sealed"}
+	if err := table.UpdateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		teamID   string
+		actionID string
+		value    string
+		status   int
+		response string
+	}{
+		{"T1", "ignore_follower", "111:123:bob", http.StatusOK, `{"text":":mute: @bob is ignored from now on","blocks":[{"type":"header","text":{"type":"plain_text","text":"New follower"}},{"type":"context","elements":[{"type":"mrkdwn","text":":mute: @bob is ignored from now on"}]}],"replace_original":true,"delete_original":false}`},
+		{"T1", "ignore_follower", "111:123:bob", http.StatusOK, `{"text":":mute: @bob is ignored from now on","blocks":[{"type":"header","text":{"type":"plain_text","text":"New follower"}},{"type":"context","elements":[{"type":"mrkdwn","text":":mute: @bob is ignored from now on"}]}],"replace_original":true,"delete_original":false}`},
+		{"T1", "remove_follower", "111:456:", http.StatusOK, `{"text":":wave: 456 was removed from your followers","blocks":[{"type":"header","text":{"type":"plain_text","text":"New follower"}},{"type":"context","elements":[{"type":"mrkdwn","text":":wave: 456 was removed from your followers"}]}],"replace_original":true,"delete_original":false}`},
+		{"T1", "block_follower", "111:789:carol", http.StatusOK, `{"text":":no_entry_sign: @carol was blocked","blocks":[{"type":"header","text":{"type":"plain_text","text":"New follower"}},{"type":"context","elements":[{"type":"mrkdwn","text":":no_entry_sign: @carol was blocked"}]}],"replace_original":true,"delete_original":false}`},
+		{"T1", "remove_follower", "111:555:dan", http.StatusOK, `{"text":":warning: @dan was removed from your followers, but is still blocked. Unblock them on Twitter to let them follow you again.","blocks":[{"type":"header","text":{"type":"plain_text","text":"New follower"}},{"type":"context","elements":[{"type":"mrkdwn","text":":warning: @dan was removed from your followers, but is still blocked. Unblock them on Twitter to let them follow you again."}]}],"replace_original":true,"delete_original":false}`},
+		{"T1", "block_follower", "111:666:mallory", http.StatusOK, `{"text":"Sorry, that didn't work. Please try again later.","response_type":"ephemeral","replace_original":false,"delete_original":false}`},
+		{"T2", "block_follower", "111:789:carol", http.StatusForbidden, ""},
+		{"T1", "block_follower", "222:789:carol", http.StatusForbidden, ""},
+		{"T1", "block_follower", "garbage", http.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+		responses = nil
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, signedAction(t, test.teamID, test.actionID, test.value, srv.URL))
+		if w.Code != test.status {
+			t.Errorf("%s %s: unexpected status %d: %s", test.actionID, test.value, w.Code, w.Body)
+		}
+		var response string
+		if len(responses) > 0 {
+			response = strings.TrimSpace(responses[0])
+		}
+		if diff := cmp.Diff(test.response, response); diff != "" {
+			t.Errorf("%s %s: %s", test.actionID, test.value, diff)
+		}
+	}
+
+	stored, err := table.GetUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"123"}, stored.IgnoreFollowers); diff != "" {
+		t.Error(diff)
+	}
+	if diff := cmp.Diff([]int64{456}, tw.removed); diff != "" {
+		t.Error(diff)
+	}
+	if diff := cmp.Diff([]int64{789}, tw.blocked); diff != "" {
+		t.Error(diff)
+	}
+
+	for _, id := range []string{"456", "555", "789"} {
+		c, err := table.GetFollowerChurnByID(ctx, user.ID, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !c.RemovedRecently(h.now()) {
+			t.Errorf("removal of %s not recorded", id)
+		}
+	}
+}
+
+type lambdaStub struct {
+	lambdaiface.LambdaAPI
+
+	invocations []*lambdasvc.InvokeInput
+}
+
+func (l *lambdaStub) InvokeWithContext(ctx aws.Context, in *lambdasvc.InvokeInput, _ ...request.Option) (*lambdasvc.InvokeOutput, error) {
+	l.invocations = append(l.invocations, in)
+	return &lambdasvc.InvokeOutput{}, nil
+}
+
+func TestHandler_ActionsAsync(t *testing.T) {
+	var responses []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		responses = append(responses, strings.TrimSpace(string(body)))
+	}))
+	defer srv.Close()
+
+	var (
+		ctx    = context.Background()
+		table  = data.NewMemoryTable()
+		user   = newUser(t, table)
+		tw     = &twitterStub{}
+		lambda = &lambdaStub{}
+		h      = newHandler(t, table)
+	)
+	h.Twitter = tw
+	h.Client = srv.Client()
+	h.Lambda = lambda
+	h.FunctionName = "slack-app"
+
+	user.Slack = data.SlackConfig{Enabled: true, TeamID: "T1", ChannelID: "C1", BotToken: "sealed"}
+	if err := table.UpdateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	// The click is acknowledged before the action is taken
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, signedAction(t, "T1", "block_follower", "111:789:carol", srv.URL))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body)
+	}
+	if len(responses) != 0 || len(tw.blocked) != 0 {
+		t.Fatalf("action taken before acknowledging: %v", responses)
+	}
+	if len(lambda.invocations) != 1 {
+		t.Fatalf("expected 1 invocation, got %d", len(lambda.invocations))
+	}
+	in := lambda.invocations[0]
+	if aws.StringValue(in.FunctionName) != "slack-app" || aws.StringValue(in.InvocationType) != lambdasvc.InvocationTypeEvent {
+		t.Errorf("unexpected invocation: %+v", in)
+	}
+
+	if _, err := h.Invoke(ctx, in.Payload); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]int64{789}, tw.blocked); diff != "" {
+		t.Error(diff)
+	}
+	want := []string{`{"text":":no_entry_sign: @carol was blocked","blocks":[{"type":"header","text":{"type":"plain_text","text":"New follower"}},{"type":"context","elements":[{"type":"mrkdwn","text":":no_entry_sign: @carol was blocked"}]}],"replace_original":true,"delete_original":false}`}
+	if diff := cmp.Diff(want, responses); diff != "" {
+		t.Error(diff)
+	}
+}
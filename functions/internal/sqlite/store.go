@@ -0,0 +1,88 @@
+// Package sqlite provides a data.Store backed by a SQLite database, so that
+// Listkeeper can be self-hosted without DynamoDB.
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+
+	_ "github.com/mattn/go-sqlite3" // register driver
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS items (
+	collection TEXT NOT NULL,
+	key        TEXT NOT NULL,
+	value      BLOB NOT NULL,
+	PRIMARY KEY (collection, key)
+) WITHOUT ROWID;
+`
+
+var _ data.Store = (*Store)(nil)
+
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens the database at path, creating it if necessary.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite only allows a single writer anyway
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) Get(collection, key string) ([]byte, error) {
+	var value []byte
+	err := s.db.QueryRow(`SELECT value FROM items WHERE collection = ? AND key = ?`, collection, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, data.ErrItemNotFound
+	}
+	return value, err
+}
+
+func (s *Store) Put(collection, key string, value []byte) error {
+	_, err := s.db.Exec(`INSERT INTO items (collection, key, value) VALUES (?, ?, ?)
+		ON CONFLICT (collection, key) DO UPDATE SET value = excluded.value`, collection, key, value)
+	return err
+}
+
+func (s *Store) Delete(collection, key string) error {
+	_, err := s.db.Exec(`DELETE FROM items WHERE collection = ? AND key = ?`, collection, key)
+	return err
+}
+
+func (s *Store) Scan(collection, prefix string) ([]data.StoreItem, error) {
+	rows, err := s.db.Query(`SELECT key, value FROM items
+		WHERE collection = ? AND substr(key, 1, ?) = ? ORDER BY key`, collection, len(prefix), prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []data.StoreItem
+	for rows.Next() {
+		var item data.StoreItem
+		if err := rows.Scan(&item.Key, &item.Value); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
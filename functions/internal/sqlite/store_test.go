@@ -0,0 +1,99 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+)
+
+func openStore(t *testing.T) *Store {
+	t.Helper()
+
+	s, err := Open(filepath.Join(t.TempDir(), "listkeeper.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func TestStore(t *testing.T) {
+	s := openStore(t)
+
+	for _, key := range []string{"111#b", "111#a", "1111#a", "222#a"} {
+		if err := s.Put("lists", key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Put("lists", "111#a", []byte("updated")); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := s.Scan("lists", "111#")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []data.StoreItem{
+		{Key: "111#a", Value: []byte("updated")},
+		{Key: "111#b", Value: []byte("111#b")},
+	}
+	if diff := cmp.Diff(want, items); diff != "" {
+		t.Error(diff)
+	}
+
+	if err := s.Delete("lists", "111#a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("lists", "111#a"); !errors.Is(err, data.ErrItemNotFound) {
+		t.Errorf("expected item to be deleted, got %v", err)
+	}
+}
+
+func TestLocalTable(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		table = data.NewLocalTable(openStore(t))
+		now   = time.Now().UTC().Truncate(time.Second)
+	)
+
+	user := data.NewUser("111")
+	user.Handle = "alice"
+	user.Name = "Alice"
+	user.ProfileImageURL = "https://example.com/alice.jpg"
+	user.AccessToken = "token"
+	user.AccessSecret = "secret"
+	user.LastLogin = now
+	user.LastIP = "127.0.0.1"
+	user.LoginsCount = 1
+
+	if err := table.RegisterUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := table.GetUser(ctx, "111")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(user.AccessToken, got.AccessToken); diff != "" {
+		t.Error(diff)
+	}
+
+	due := table.NewDueUserIter(now.Add(time.Minute))
+	if u := due.Next(ctx); u == nil || u.ID != "111" {
+		t.Errorf("expected user to be due, got %v", u)
+	}
+
+	if err := table.DeleteUser(ctx, "111"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := table.GetUser(ctx, "111"); !errors.Is(err, data.ErrUserNotFound) {
+		t.Errorf("expected user to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+// Package telegrambot handles updates sent to the Telegram bot, which users
+// message with a one-time code to link their chat for notifications.
+package telegrambot
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/lambdaurl"
+	"github.com/mlafeldt/listkeeper/functions/internal/notify"
+)
+
+// SecretTokenHeader carries the secret token set along with the webhook.
+const SecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// Update is the part of a Telegram update we care about, see
+// https://core.telegram.org/bots/api#update
+type Update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *Message `json:"message"`
+}
+
+type Message struct {
+	Chat struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+	Text string `json:"text"`
+}
+
+type Handler struct {
+	Table       data.TableAPI
+	Bot         *notify.Telegram
+	SecretToken string
+}
+
+// Handle receives updates via a Lambda function URL.
+func (h *Handler) Handle(ctx context.Context, req events.LambdaFunctionURLRequest) (*events.LambdaFunctionURLResponse, error) {
+	return lambdaurl.Serve(ctx, h, req)
+}
+
+// ServeHTTP receives updates via Handle or, in self-hosted mode, directly.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorized(r.Header.Get(SecretTokenHeader)) {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var update Update
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.HandleUpdate(r.Context(), &update); err != nil {
+		log.Printf("failed to handle telegram update %d: %s", update.UpdateID, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) authorized(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.SecretToken)) == 1
+}
+
+// HandleUpdate links the chat to a user if the message contains a valid link
+// code, which is either typed in or passed via https://t.me/<bot>?start=<code>.
+func (h *Handler) HandleUpdate(ctx context.Context, update *Update) error {
+	msg := update.Message
+	if msg == nil || msg.Text == "" {
+		return nil
+	}
+
+	code := strings.TrimSpace(strings.TrimPrefix(msg.Text, "/start"))
+	if code == "" {
+		return h.Bot.SendMessage(ctx, msg.Chat.ID, "Hi! To get notified about your Twitter followers, please send me the code shown in your Listkeeper dashboard.")
+	}
+
+	user, err := h.link(ctx, code, msg.Chat.ID)
+	if errors.Is(err, data.ErrTelegramLinkCodeInvalid) {
+		return h.Bot.SendMessage(ctx, msg.Chat.ID, "Sorry, this code is invalid or has expired. Please get a new one from your Listkeeper dashboard.")
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("linked telegram chat %d to user %s", msg.Chat.ID, user.ID)
+
+	return h.Bot.SendMessage(ctx, msg.Chat.ID, "Done! You will be notified about the followers of @"+user.Handle+" in this chat.")
+}
+
+func (h *Handler) link(ctx context.Context, code string, chatID int64) (*data.User, error) {
+	userID, ok := data.TelegramLinkCodeUserID(code)
+	if !ok {
+		return nil, data.ErrTelegramLinkCodeInvalid
+	}
+
+	user, err := h.Table.GetUser(ctx, userID)
+	if errors.Is(err, data.ErrUserNotFound) {
+		return nil, data.ErrTelegramLinkCodeInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := user.LinkTelegram(code, chatID, time.Now()); err != nil {
+		return nil, err
+	}
+	if err := h.Table.UpdateUser(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
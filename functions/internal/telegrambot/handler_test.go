@@ -0,0 +1,149 @@
+package telegrambot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/local"
+	"github.com/mlafeldt/listkeeper/functions/internal/notify"
+)
+
+func newUser(t *testing.T, table data.TableAPI) *data.User {
+	t.Helper()
+
+	u := data.NewUser("111")
+	u.Handle = "alice"
+	u.Name = "Alice"
+	u.ProfileImageURL = "https://example.com/alice.png"
+	u.AccessToken = "token"
+	u.AccessSecret = "secret"
+	u.LastLogin = u.CreatedAt
+	u.LastIP = "127.0.0.1"
+	u.LoginsCount = 1
+	if err := table.CreateUser(context.Background(), u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func newLinkCode(t *testing.T, table data.TableAPI, userID string) string {
+	t.Helper()
+
+	ctx := context.Background()
+	u, err := table.GetUser(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := u.NewTelegramLinkCode(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := table.UpdateUser(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestHandler_HandleUpdate(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		table = data.NewMemoryTable()
+		user  = newUser(t, table)
+		code  = newLinkCode(t, table, user.ID)
+	)
+
+	api := local.NewTelegramAPI()
+	defer api.Close()
+
+	h := &Handler{Table: table, Bot: &notify.Telegram{Token: "123:abc", BaseURL: api.URL}}
+
+	updates := []string{
+		"/start",
+		"111-0000000000000000",
+		"/start " + code,
+		code, // already used
+	}
+	for _, text := range updates {
+		msg := &Message{Text: text}
+		msg.Chat.ID = 7
+		if err := h.HandleUpdate(ctx, &Update{Message: msg}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	linked, err := table.GetUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(data.TelegramConfig{Enabled: true, ChatID: 7}, linked.Telegram); diff != "" {
+		t.Error(diff)
+	}
+
+	var replies []string
+	for _, call := range api.Calls() {
+		replies = append(replies, call.Params["text"].(string))
+	}
+	want := []string{
+		"Hi! To get notified about your Twitter followers, please send me the code shown in your Listkeeper dashboard.",
+		"Sorry, this code is invalid or has expired. Please get a new one from your Listkeeper dashboard.",
+		"Done! You will be notified about the followers of @alice in this chat.",
+		"Sorry, this code is invalid or has expired. Please get a new one from your Listkeeper dashboard.",
+	}
+	if diff := cmp.Diff(want, replies); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	var (
+		table = data.NewMemoryTable()
+		user  = newUser(t, table)
+		code  = newLinkCode(t, table, user.ID)
+	)
+
+	api := local.NewTelegramAPI()
+	defer api.Close()
+
+	h := &Handler{
+		Table:       table,
+		Bot:         &notify.Telegram{Token: "123:abc", BaseURL: api.URL},
+		SecretToken: "s3cret",
+	}
+
+	body := `{"update_id": 1, "message": {"chat": {"id": 42}, "text": "` + code + `"}}`
+
+	tests := []struct {
+		token  string
+		status int
+	}{
+		{token: "", status: http.StatusUnauthorized},
+		{token: "wrong", status: http.StatusUnauthorized},
+		{token: "s3cret", status: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/telegram", strings.NewReader(body))
+		req.Header.Set(SecretTokenHeader, test.token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != test.status {
+			t.Errorf("token %q: status = %d, want %d", test.token, rec.Code, test.status)
+		}
+	}
+
+	linked, err := table.GetUser(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(data.TelegramConfig{Enabled: true, ChatID: 42}, linked.Telegram); diff != "" {
+		t.Error(diff)
+	}
+}
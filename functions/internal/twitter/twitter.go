@@ -2,7 +2,12 @@ package twitter
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
 
 	"github.com/dghubble/go-twitter/twitter"
 	"github.com/dghubble/oauth1"
@@ -10,56 +15,147 @@ import (
 )
 
 type API interface {
-	FollowerIDs(ctx context.Context, accessToken, accessSecret string) ([]int64, error)
+	FollowerIDs(ctx context.Context, accessToken, accessSecret string) (*FollowerIDs, error)
 	CurrentUser(ctx context.Context, accessToken, accessSecret string) (*User, error)
 	UserByID(ctx context.Context, accessToken, accessSecret string, userID int64) (*User, error)
+	Relationship(ctx context.Context, accessToken, accessSecret string, userID int64) (*Relationship, error)
+	RemoveFollower(ctx context.Context, accessToken, accessSecret string, userID int64) error
+	BlockUser(ctx context.Context, accessToken, accessSecret string, userID int64) error
 }
 
+// Limiter is consulted before every request to a rate-limited endpoint.
+type Limiter interface {
+	Take(ctx context.Context, endpoint string) error
+}
+
+// BatchLimiter is implemented by limiters that can draw the budget for
+// several requests at once and give back what wasn't used.
+type BatchLimiter interface {
+	Limiter
+	TakeN(ctx context.Context, endpoint string, n int) error
+	Refund(ctx context.Context, endpoint string, n int) error
+}
+
+type followerPagesKey struct{}
+
+// WithFollowerPages returns a context that makes FollowerIDs draw the budget
+// for the given number of pages up front, e.g. as many as the last time, so
+// that it doesn't run out halfway. Pages that aren't needed are refunded.
+func WithFollowerPages(ctx context.Context, pages int) context.Context {
+	return context.WithValue(ctx, followerPagesKey{}, pages)
+}
+
+func followerPages(ctx context.Context) int {
+	pages, _ := ctx.Value(followerPagesKey{}).(int)
+	switch {
+	case pages < 1:
+		return 1
+	case pages > maxFollowerRequests:
+		return maxFollowerRequests
+	}
+	return pages
+}
+
+const (
+	EndpointFollowerIDs       = "followers/ids"
+	EndpointUsersShow         = "users/show"
+	EndpointFriendshipsShow   = "friendships/show"
+	EndpointVerifyCredentials = "account/verify_credentials"
+	EndpointBlocksCreate      = "blocks/create"
+	EndpointBlocksDestroy     = "blocks/destroy"
+)
+
 var _ API = (*Client)(nil)
 
 type Client struct {
-	config *oauth1.Config
+	config  *oauth1.Config
+	limiter Limiter
 }
 
-func NewClient(consumerKey, consumerSecret string) *Client {
+// NewClient returns a new Twitter client. The limiter is optional.
+func NewClient(consumerKey, consumerSecret string, limiter Limiter) *Client {
 	return &Client{
 		config: &oauth1.Config{
 			ConsumerKey:    consumerKey,
 			ConsumerSecret: consumerSecret,
 			Endpoint:       twitterOAuth1.AuthorizeEndpoint,
 		},
+		limiter: limiter,
 	}
 }
 
+func (c *Client) take(ctx context.Context, endpoint string) error {
+	if c.limiter == nil {
+		return nil
+	}
+	return c.limiter.Take(ctx, endpoint)
+}
+
 func (c *Client) newClientWithContext(ctx context.Context, accessToken, accessSecret string) *twitter.Client {
 	token := oauth1.NewToken(accessToken, accessSecret)
 	return twitter.NewClient(c.config.Client(ctx, token))
 }
 
-// Due to Twitter's API rate limiting, this function will only return up to
-// 75,000 followers (15 requests * 5000 items, over 15 minutes).
-func (c *Client) FollowerIDs(ctx context.Context, accessToken, accessSecret string) ([]int64, error) {
-	const (
-		maxRequests  = 15
-		maxBatchSize = 5000
-	)
+const (
+	maxFollowerRequests  = 15
+	maxFollowerBatchSize = 5000
 
+	// MaxFollowerIDs is how many followers FollowerIDs returns at most.
+	MaxFollowerIDs = maxFollowerRequests * maxFollowerBatchSize
+)
+
+// FollowerIDs are the IDs of a user's followers, most recent first.
+type FollowerIDs struct {
+	IDs       []int64
+	Pages     int  // requests it took to fetch them
+	Truncated bool // the user has more followers than MaxFollowerIDs
+}
+
+// Due to Twitter's API rate limiting, this function will only return up to
+// 75,000 followers (15 requests * 5000 items, over 15 minutes), the most
+// recent ones.
+func (c *Client) FollowerIDs(ctx context.Context, accessToken, accessSecret string) (*FollowerIDs, error) {
 	var (
-		tc  = c.newClientWithContext(ctx, accessToken, accessSecret)
-		ids = []int64{}
+		tc       = c.newClientWithContext(ctx, accessToken, accessSecret)
+		result   = FollowerIDs{IDs: []int64{}}
+		cursor   = int64(-1)
+		reserved int
 	)
 
-	for req, cursor := 0, int64(-1); req < maxRequests && cursor != 0; req++ {
-		params := twitter.FollowerIDParams{Cursor: cursor, Count: maxBatchSize}
+	if bl, ok := c.limiter.(BatchLimiter); ok {
+		pages := followerPages(ctx)
+		if err := bl.TakeN(ctx, EndpointFollowerIDs, pages); err != nil {
+			return nil, err
+		}
+		reserved = pages
+		defer func() {
+			// Requests that were made count even if they failed
+			if unused := reserved - result.Pages; unused > 0 {
+				if err := bl.Refund(ctx, EndpointFollowerIDs, unused); err != nil {
+					log.Printf("failed to refund %d follower pages: %s", unused, err)
+				}
+			}
+		}()
+	}
+
+	for result.Pages < maxFollowerRequests && cursor != 0 {
+		if result.Pages >= reserved {
+			if err := c.take(ctx, EndpointFollowerIDs); err != nil {
+				return nil, err
+			}
+		}
+		params := twitter.FollowerIDParams{Cursor: cursor, Count: maxFollowerBatchSize}
 		followers, _, err := tc.Followers.IDs(&params)
+		result.Pages++
 		if err != nil {
 			return nil, makeErr(err)
 		}
-		ids = append(ids, followers.IDs...)
+		result.IDs = append(result.IDs, followers.IDs...)
 		cursor = followers.NextCursor
 	}
+	result.Truncated = cursor != 0
 
-	return ids, nil
+	return &result, nil
 }
 
 type User struct {
@@ -70,10 +166,15 @@ type User struct {
 	Bio             string `json:"bio,omitempty"`
 	ProfileImageURL string `json:"profileImageUrl,omitempty"`
 	Protected       bool   `json:"protected"`
+	Verified        bool   `json:"verified"`
 	TotalFollowers  int    `json:"totalFollowers"`
 }
 
 func (c *Client) CurrentUser(ctx context.Context, accessToken, accessSecret string) (*User, error) {
+	if err := c.take(ctx, EndpointVerifyCredentials); err != nil {
+		return nil, err
+	}
+
 	tc := c.newClientWithContext(ctx, accessToken, accessSecret)
 
 	u, _, err := tc.Accounts.VerifyCredentials(nil)
@@ -85,6 +186,10 @@ func (c *Client) CurrentUser(ctx context.Context, accessToken, accessSecret stri
 }
 
 func (c *Client) UserByID(ctx context.Context, accessToken, accessSecret string, userID int64) (*User, error) {
+	if err := c.take(ctx, EndpointUsersShow); err != nil {
+		return nil, err
+	}
+
 	tc := c.newClientWithContext(ctx, accessToken, accessSecret)
 
 	u, _, err := tc.Users.Show(&twitter.UserShowParams{UserID: userID})
@@ -95,6 +200,101 @@ func (c *Client) UserByID(ctx context.Context, accessToken, accessSecret string,
 	return makeUser(u), nil
 }
 
+// Relationship is how the authenticating user relates to another user.
+type Relationship struct {
+	Following  bool // the user follows them
+	FollowedBy bool // they follow the user
+	Blocking   bool // the user blocked them
+	BlockedBy  bool // they blocked the user
+}
+
+const friendshipsShowURL = "https://api.twitter.com/1.1/friendships/show.json"
+
+// Relationship requests friendships/show directly, as the library doesn't
+// decode whether the user was blocked.
+func (c *Client) Relationship(ctx context.Context, accessToken, accessSecret string, userID int64) (*Relationship, error) {
+	if err := c.take(ctx, EndpointFriendshipsShow); err != nil {
+		return nil, err
+	}
+
+	hc := c.config.Client(ctx, oauth1.NewToken(accessToken, accessSecret))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		friendshipsShowURL+"?target_id="+strconv.FormatInt(userID, 10), nil) //nolint:gomnd
+	if err != nil {
+		return nil, err
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr twitter.APIError
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil || apiErr.Empty() {
+			return nil, errors.New("friendships/show: " + resp.Status)
+		}
+		return nil, makeErr(apiErr)
+	}
+
+	var body struct {
+		Relationship struct {
+			Source struct {
+				Following  bool `json:"following"`
+				FollowedBy bool `json:"followed_by"`
+				Blocking   bool `json:"blocking"`
+				BlockedBy  bool `json:"blocked_by"`
+			} `json:"source"`
+		} `json:"relationship"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	src := body.Relationship.Source
+	return &Relationship{
+		Following:  src.Following,
+		FollowedBy: src.FollowedBy,
+		Blocking:   src.Blocking,
+		BlockedBy:  src.BlockedBy,
+	}, nil
+}
+
+// RemoveFollower makes the user stop following by blocking and unblocking
+// them, as API v1.1 has no endpoint for this.
+func (c *Client) RemoveFollower(ctx context.Context, accessToken, accessSecret string, userID int64) error {
+	if err := c.BlockUser(ctx, accessToken, accessSecret, userID); err != nil {
+		return err
+	}
+
+	if err := c.take(ctx, EndpointBlocksDestroy); err != nil {
+		return fmt.Errorf("%w: %s", ErrStillBlocked, err)
+	}
+
+	tc := c.newClientWithContext(ctx, accessToken, accessSecret)
+
+	if _, _, err := tc.Blocks.Destroy(&twitter.BlockDestroyParams{UserID: userID}); err != nil {
+		return fmt.Errorf("%w: %s", ErrStillBlocked, makeErr(err))
+	}
+
+	return nil
+}
+
+func (c *Client) BlockUser(ctx context.Context, accessToken, accessSecret string, userID int64) error {
+	if err := c.take(ctx, EndpointBlocksCreate); err != nil {
+		return err
+	}
+
+	tc := c.newClientWithContext(ctx, accessToken, accessSecret)
+
+	if _, _, err := tc.Blocks.Create(&twitter.BlockCreateParams{UserID: userID}); err != nil {
+		return makeErr(err)
+	}
+
+	return nil
+}
+
 func makeUser(u *twitter.User) *User {
 	return &User{
 		ID:              u.IDStr,
@@ -104,6 +304,7 @@ func makeUser(u *twitter.User) *User {
 		Bio:             u.Description,
 		ProfileImageURL: u.ProfileImageURLHttps,
 		Protected:       u.Protected,
+		Verified:        u.Verified,
 		TotalFollowers:  u.FollowersCount,
 	}
 }
@@ -115,7 +316,7 @@ func makeErr(err error) error {
 	if errors.As(err, &apiErr) {
 		if !apiErr.Empty() {
 			switch apiErr.Errors[0].Code {
-			case 50:
+			case 50: // deleted or deactivated, which only time tells apart
 				return ErrUserNotFound
 			case 63:
 				return ErrUserSuspended
@@ -123,6 +324,8 @@ func makeErr(err error) error {
 				return ErrRateLimitExceeded
 			case 89:
 				return ErrInvalidToken
+			case 136:
+				return ErrBlocked
 			}
 		}
 	}
@@ -133,6 +336,11 @@ func makeErr(err error) error {
 var (
 	ErrUserNotFound      = errors.New("user not found")
 	ErrUserSuspended     = errors.New("user suspended")
+	ErrBlocked           = errors.New("blocked by user")
 	ErrRateLimitExceeded = errors.New("rate limit exceeded")
 	ErrInvalidToken      = errors.New("invalid or expired token")
+
+	// ErrStillBlocked is returned by RemoveFollower if the follower was
+	// blocked but not unblocked again.
+	ErrStillBlocked = errors.New("removed follower is still blocked")
 )
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/kelseyhightower/envconfig"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/notify"
+	"github.com/mlafeldt/listkeeper/functions/internal/senddigests"
+)
+
+func main() {
+	var env struct {
+		TableName string `envconfig:"TABLE_NAME" required:"true"`
+		AppURL    string `envconfig:"APP_URL" default:"https://listkeeper.io"`
+	}
+	envconfig.MustProcess("", &env)
+
+	sess := session.Must(session.NewSession())
+	table := data.NewTable(sess, env.TableName)
+
+	notifiers, err := notify.NewRegistryFromEnv(table)
+	if err != nil {
+		panic(err)
+	}
+
+	h := senddigests.Handler{
+		Table:     table,
+		Notifiers: notifiers,
+		AppURL:    env.AppURL,
+	}
+
+	lambda.Start(h.Handle)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/kelseyhightower/envconfig"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/servefeed"
+)
+
+func main() {
+	var env struct {
+		TableName string `envconfig:"TABLE_NAME" required:"true"`
+		AppURL    string `envconfig:"APP_URL" default:"https://listkeeper.io"`
+	}
+	envconfig.MustProcess("", &env)
+
+	sess := session.Must(session.NewSession())
+	h := servefeed.Handler{
+		Table:  data.NewTable(sess, env.TableName),
+		AppURL: env.AppURL,
+	}
+
+	lambda.Start(h.Handle)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	lambdasvc "github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/kelseyhightower/envconfig"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/secret"
+	"github.com/mlafeldt/listkeeper/functions/internal/slackapp"
+	"github.com/mlafeldt/listkeeper/functions/internal/twitter"
+)
+
+func main() {
+	var env struct {
+		TableName string `envconfig:"TABLE_NAME" required:"true"`
+		AppURL    string `envconfig:"APP_URL" default:"https://listkeeper.io"`
+		Slack     struct {
+			ClientID      string `envconfig:"SLACK_CLIENT_ID" required:"true"`
+			ClientSecret  string `envconfig:"SLACK_CLIENT_SECRET" required:"true"`
+			SigningSecret string `envconfig:"SLACK_SIGNING_SECRET" required:"true"`
+			TokenKey      string `envconfig:"SLACK_TOKEN_KEY" required:"true"`
+		}
+		FunctionName   string `envconfig:"AWS_LAMBDA_FUNCTION_NAME" required:"true"`
+		ConsumerKey    string `envconfig:"TWITTER_CONSUMER_KEY" required:"true"`
+		ConsumerSecret string `envconfig:"TWITTER_CONSUMER_SECRET" required:"true"`
+	}
+	envconfig.MustProcess("", &env)
+
+	box, err := secret.NewBox(env.Slack.TokenKey)
+	if err != nil {
+		panic(err)
+	}
+
+	sess := session.Must(session.NewSession())
+	h := slackapp.Handler{
+		Table:         data.NewTable(sess, env.TableName),
+		Tokens:        box,
+		ClientID:      env.Slack.ClientID,
+		ClientSecret:  env.Slack.ClientSecret,
+		SigningSecret: env.Slack.SigningSecret,
+		AppURL:        env.AppURL,
+		Twitter:       twitter.NewClient(env.ConsumerKey, env.ConsumerSecret, nil),
+		Lambda:        lambdasvc.New(sess),
+		FunctionName:  env.FunctionName,
+	}
+
+	lambda.Start(h.Invoke)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/kelseyhightower/envconfig"
+
+	"github.com/mlafeldt/listkeeper/functions/internal/data"
+	"github.com/mlafeldt/listkeeper/functions/internal/notify"
+	"github.com/mlafeldt/listkeeper/functions/internal/telegrambot"
+)
+
+func main() {
+	var env struct {
+		TableName string `envconfig:"TABLE_NAME" required:"true"`
+		Telegram  struct {
+			BotToken    string `envconfig:"TELEGRAM_BOT_TOKEN" required:"true"`
+			SecretToken string `envconfig:"TELEGRAM_SECRET_TOKEN" required:"true"`
+		}
+	}
+	envconfig.MustProcess("", &env)
+
+	sess := session.Must(session.NewSession())
+	h := telegrambot.Handler{
+		Table:       data.NewTable(sess, env.TableName),
+		Bot:         &notify.Telegram{Token: env.Telegram.BotToken},
+		SecretToken: env.Telegram.SecretToken,
+	}
+
+	lambda.Start(h.Handle)
+}
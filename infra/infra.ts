@@ -38,6 +38,8 @@ class ListkeeperApp extends cdk.App {
       const apiStack = new ApiStack(this, `${appName}-api`, {
         appName,
         graphqlSchema: path.join(__dirname, '..', 'schema.graphql'),
+        slackUsername: 'Listkeeper (dev)',
+        slackIconUrl: 'https://listkeeper.io/slack-icon.png',
         table: dataStack.table,
         tags,
       })
@@ -65,7 +67,7 @@ class ListkeeperApp extends cdk.App {
 
       new CoreStack(this, `${appName}-core`, {
         appName,
-        schedule: Schedule.cron({ minute: '0' }),
+        schedule: Schedule.cron({ minute: '*/15' }),
         ttlInDays,
         slackUsername: 'Listkeeper',
         slackIconUrl: 'https://listkeeper.io/slack-icon.png',
@@ -77,6 +79,8 @@ class ListkeeperApp extends cdk.App {
       const apiStack = new ApiStack(this, `${appName}-api`, {
         appName,
         graphqlSchema: path.join(__dirname, '..', 'schema.graphql'),
+        slackUsername: 'Listkeeper',
+        slackIconUrl: 'https://listkeeper.io/slack-icon.png',
         table: dataStack.table,
         tags,
       })
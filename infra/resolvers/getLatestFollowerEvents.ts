@@ -37,10 +37,12 @@ export function response(ctx: Context): FollowerEvent[] {
       bio: item.Follower.Bio,
       profileImageUrl: item.Follower.ProfileImageURL,
       protected: item.Follower.Protected,
+      verified: item.Follower.Verified ?? false,
       totalFollowers: item.Follower.TotalFollowers,
     },
     followerState: item.FollowerState,
     followerStateReason: item.FollowerStateReason,
+    refollows: item.Refollows,
     createdAt: item.CreatedAt,
   }))
 }
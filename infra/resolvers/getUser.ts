@@ -1,7 +1,20 @@
 import { util, Context, AppSyncIdentityOIDC, DynamoDBQueryRequest } from '@aws-appsync/utils'
-import { User } from '../../app/src/gql/graphql'
+import { DigestMode, NotificationFilter, TrackingStatus, User } from '../../app/src/gql/graphql'
 import { authorize } from './shared'
 
+function toFilter(filter: any): NotificationFilter | undefined {
+  if (!filter) {
+    return undefined
+  }
+  return {
+    states: filter.States,
+    reasons: filter.Reasons,
+    minFollowers: filter.MinFollowers ?? 0,
+    protectedOnly: filter.ProtectedOnly ?? false,
+    verifiedOnly: filter.VerifiedOnly ?? false,
+  }
+}
+
 export function request(ctx: Context<{ id: string }>): DynamoDBQueryRequest {
   const userId = authorize(ctx.args.id, ctx.identity as AppSyncIdentityOIDC)
 
@@ -40,10 +53,82 @@ export function response(ctx: Context): User {
       enabled: user.Slack?.Enabled ?? false,
       webhookUrl: user.Slack?.WebhookURL,
       channel: user.Slack?.Channel,
+      filter: toFilter(user.Slack?.Filter),
+      installed: !!user.Slack?.BotToken,
+      teamName: user.Slack?.TeamName,
+    },
+    email: {
+      enabled: user.Email?.Enabled ?? false,
+      address: user.Email?.Address,
+      filter: toFilter(user.Email?.Filter),
+    },
+    discord: {
+      enabled: user.Discord?.Enabled ?? false,
+      webhookUrl: user.Discord?.WebhookURL,
+      filter: toFilter(user.Discord?.Filter),
+    },
+    telegram: {
+      enabled: user.Telegram?.Enabled ?? false,
+      linked: !!user.Telegram?.ChatID,
+      filter: toFilter(user.Telegram?.Filter),
+    },
+    push: {
+      enabled: user.Push?.Enabled ?? false,
+      subscriptions: user.Push?.Subscriptions?.map((s: any) => ({
+        id: s.ID,
+        endpoint: s.Endpoint,
+        device: s.Device,
+        createdAt: s.CreatedAt,
+      })),
+      filter: toFilter(user.Push?.Filter),
+    },
+    webhooks: user.Webhooks?.map((w: any) => ({
+      id: w.ID,
+      url: w.URL,
+      secret: w.Secret,
+      enabled: w.Enabled,
+      failures: w.Failures ?? 0,
+      createdAt: w.CreatedAt,
+    })),
+    feedToken: user.FeedToken,
+    digest: {
+      mode: user.Digest?.Mode ?? DigestMode.Off,
+      hour: user.Digest?.Hour ?? 9,
+    },
+    quietHours: {
+      enabled: user.QuietHours?.Enabled ?? false,
+      start: user.QuietHours?.Start ?? 0,
+      end: user.QuietHours?.End ?? 0,
+      coalesce: user.QuietHours?.Coalesce ?? false,
+    },
+    alerts: {
+      milestones: user.Alerts?.Milestones ?? false,
+      customMilestones: user.Alerts?.CustomMilestones,
+      anomalies: user.Alerts?.Anomalies ?? false,
+      lossPercent: user.Alerts?.LossPercent || 5,
+      spikeFactor: user.Alerts?.SpikeFactor || 5,
+    },
+    burst: {
+      enabled: user.Burst?.Enabled ?? false,
+      window: user.Burst?.Window || 10,
+    },
+    refollow: {
+      enabled: user.Refollow?.Enabled ?? false,
+      window: user.Refollow?.Window || 24,
+      suppress: user.Refollow?.Suppress ?? false,
+    },
+    templates: {
+      header: user.Templates?.Header,
+      text: user.Templates?.Text,
+      footer: user.Templates?.Footer,
     },
+    timezone: user.Timezone,
+    locale: user.Locale,
     ignoreFollowers: user.IgnoreFollowers,
+    trackingStatus: user.TrackingStatus ?? TrackingStatus.Active,
     createdAt: user.CreatedAt,
     updatedAt: user.UpdatedAt,
     lastLogin: user.LastLogin,
+    nextCheckAt: user.NextCheckAt,
   }
 }
@@ -10,6 +10,8 @@ import { JsResolver } from '../constructs/js-resolver'
 interface ApiStackProps extends cdk.StackProps {
   appName: string
   graphqlSchema: string
+  slackUsername: string
+  slackIconUrl: string
   table: ddb.ITable
 }
 
@@ -59,6 +61,12 @@ export class ApiStack extends cdk.Stack {
         AUTH0_DOMAIN: auth0.domain,
         AUTH0_CLIENT_ID: auth0.clientId,
         AUTH0_CLIENT_SECRET: auth0.clientSecret,
+        // Used to send test notifications
+        SLACK_USERNAME: props.slackUsername,
+        SLACK_ICON_URL: props.slackIconUrl,
+        TELEGRAM_BOT_TOKEN: StringParameter.valueForStringParameter(this, `/${props.appName}/telegram-bot-token`),
+        SLACK_TOKEN_KEY: StringParameter.valueForStringParameter(this, `/${props.appName}/slack-token-key`),
+        VAPID_PRIVATE_KEY: StringParameter.valueForStringParameter(this, `/${props.appName}/vapid-private-key`),
       },
     })
     props.table.grantReadWriteData(resolveGraphql.function)
@@ -73,6 +81,18 @@ export class ApiStack extends cdk.Stack {
     lambdaDS.createResolver('RegisterUserResolver', { typeName: 'Mutation', fieldName: 'registerUser' })
     lambdaDS.createResolver('UpdateUserResolver', { typeName: 'Mutation', fieldName: 'updateUser' })
     lambdaDS.createResolver('DeleteUserResolver', { typeName: 'Mutation', fieldName: 'deleteUser' })
+    lambdaDS.createResolver('CreateTelegramLinkCodeResolver', { typeName: 'Mutation', fieldName: 'createTelegramLinkCode' })
+    lambdaDS.createResolver('CreateSlackInstallStateResolver', { typeName: 'Mutation', fieldName: 'createSlackInstallState' })
+    lambdaDS.createResolver('RotateFeedTokenResolver', { typeName: 'Mutation', fieldName: 'rotateFeedToken' })
+    lambdaDS.createResolver('PreviewTemplatesResolver', { typeName: 'Mutation', fieldName: 'previewTemplates' })
+    lambdaDS.createResolver('GetRateBudgetsResolver', { typeName: 'Query', fieldName: 'getRateBudgets' })
+    lambdaDS.createResolver('GetWebhookDeliveriesResolver', { typeName: 'Query', fieldName: 'getWebhookDeliveries' })
+    lambdaDS.createResolver('GetDeadLettersResolver', { typeName: 'Query', fieldName: 'getDeadLetters' })
+    lambdaDS.createResolver('ReplayDeadLetterResolver', { typeName: 'Mutation', fieldName: 'replayDeadLetter' })
+    lambdaDS.createResolver('SendTestNotificationResolver', { typeName: 'Mutation', fieldName: 'sendTestNotification' })
+    lambdaDS.createResolver('GetVapidPublicKeyResolver', { typeName: 'Query', fieldName: 'getVapidPublicKey' })
+    lambdaDS.createResolver('SubscribePushResolver', { typeName: 'Mutation', fieldName: 'subscribePush' })
+    lambdaDS.createResolver('UnsubscribePushResolver', { typeName: 'Mutation', fieldName: 'unsubscribePush' })
 
     const tableDS = api.addDynamoDbDataSource('DynamoDatasource', props.table)
     new JsResolver(this, 'GetUserResolver', {
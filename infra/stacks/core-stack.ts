@@ -3,6 +3,7 @@ import { Construct } from 'constructs'
 import { ITable } from 'aws-cdk-lib/aws-dynamodb'
 import { Schedule, Rule, RuleTargetInput } from 'aws-cdk-lib/aws-events'
 import { LambdaFunction } from 'aws-cdk-lib/aws-events-targets'
+import { FunctionUrlAuthType } from 'aws-cdk-lib/aws-lambda'
 import { LambdaDestination } from 'aws-cdk-lib/aws-lambda-destinations'
 import { PolicyStatement } from 'aws-cdk-lib/aws-iam'
 import { IBucket } from 'aws-cdk-lib/aws-s3'
@@ -53,12 +54,20 @@ export class CoreStack extends cdk.Stack {
         TABLE_NAME: props.table.tableName,
         TABLE_TTL: `${props.ttlInDays * 24}h`,
         BUCKET_NAME: props.bucket.bucketName,
+        EVENT_BUS_NAME: 'default',
+        EVENT_SOURCE_NAME: props.appName,
         ...twitterVars,
       },
       onSuccess: new LambdaDestination(diffFollowers.function, { responseOnly: true }),
     })
     props.table.grantReadWriteData(getFollowers.function)
     props.bucket.grantPut(getFollowers.function)
+    getFollowers.function.addToRolePolicy(
+      new PolicyStatement({
+        actions: ['events:PutEvents'],
+        resources: ['*'],
+      })
+    )
 
     new Rule(this, 'GetFollowersOnSignup', {
       eventPattern: {
@@ -72,28 +81,143 @@ export class CoreStack extends cdk.Stack {
       ],
     })
 
+    // prettier-ignore
+    const telegramVars = {
+      TELEGRAM_BOT_TOKEN: StringParameter.valueForStringParameter(this, `/${props.appName}/telegram-bot-token`),
+      TELEGRAM_SECRET_TOKEN: StringParameter.valueForStringParameter(this, `/${props.appName}/telegram-secret-token`),
+    }
+
+    // prettier-ignore
+    const slackAppVars = {
+      SLACK_CLIENT_ID: StringParameter.valueForStringParameter(this, `/${props.appName}/slack-client-id`),
+      SLACK_CLIENT_SECRET: StringParameter.valueForStringParameter(this, `/${props.appName}/slack-client-secret`),
+      SLACK_SIGNING_SECRET: StringParameter.valueForStringParameter(this, `/${props.appName}/slack-signing-secret`),
+      SLACK_TOKEN_KEY: StringParameter.valueForStringParameter(this, `/${props.appName}/slack-token-key`),
+    }
+
+    // prettier-ignore
+    const vapidPrivateKey = StringParameter.valueForStringParameter(this, `/${props.appName}/vapid-private-key`)
+
     const notifyUser = new GoFunction(this, 'NotifyUserFunc', {
       handlerDir: 'notify-user',
+      timeout: cdk.Duration.minutes(1), // each webhook request may take up to 10 seconds
       environment: {
         TABLE_NAME: props.table.tableName,
         SLACK_USERNAME: props.slackUsername,
         SLACK_ICON_URL: props.slackIconUrl,
+        TELEGRAM_BOT_TOKEN: telegramVars.TELEGRAM_BOT_TOKEN,
+        SLACK_TOKEN_KEY: slackAppVars.SLACK_TOKEN_KEY,
+        VAPID_PRIVATE_KEY: vapidPrivateKey,
       },
     })
-    props.table.grantReadData(notifyUser.function)
+    props.table.grantReadWriteData(notifyUser.function) // delivery logs, Slack threads
 
+    // notify-user receives the whole event to dispatch on its detail type
     new Rule(this, 'NotifyUserOnFollowerChange', {
       eventPattern: {
         source: [props.appName], // default bus
         detailType: ['Twitter Follower Change'],
       },
-      targets: [
-        new LambdaFunction(notifyUser.function, {
-          event: RuleTargetInput.fromEventPath('$.detail'),
-        }),
-      ],
+      targets: [new LambdaFunction(notifyUser.function)],
+    })
+
+    new Rule(this, 'NotifyUserOnTrackingStatusChange', {
+      eventPattern: {
+        source: [props.appName], // default bus
+        detailType: ['Tracking Status Change'],
+      },
+      targets: [new LambdaFunction(notifyUser.function)],
+    })
+
+    // Sent by diff-followers along with follower changes
+    new Rule(this, 'NotifyUserOnFollowerAlert', {
+      eventPattern: {
+        source: [props.appName], // default bus
+        detailType: ['Follower Milestone', 'Follower Anomaly'],
+      },
+      targets: [new LambdaFunction(notifyUser.function)],
     })
 
+    // Sent by resolve-graphql to replay dead letters
+    new Rule(this, 'NotifyUserOnNotificationReplay', {
+      eventPattern: {
+        source: [props.appName], // default bus
+        detailType: ['Notification Replay'],
+      },
+      targets: [new LambdaFunction(notifyUser.function)],
+    })
+
+    // Flushes notifications held back during quiet hours, which end at the full
+    // hour, and buffered bursts, whose window is at least five minutes, and
+    // retries failed webhook deliveries, whose backoff starts at five minutes
+    new Rule(this, 'ScheduleNotifyUser', {
+      schedule: Schedule.cron({ minute: '0/5' }),
+      targets: [new LambdaFunction(notifyUser.function)],
+    })
+
+    // Digests are due at the full hour in the user's timezone
+    const sendDigests = new GoFunction(this, 'SendDigestsFunc', {
+      handlerDir: 'send-digests',
+      timeout: cdk.Duration.minutes(5),
+      environment: {
+        TABLE_NAME: props.table.tableName,
+        SLACK_USERNAME: props.slackUsername,
+        SLACK_ICON_URL: props.slackIconUrl,
+        TELEGRAM_BOT_TOKEN: telegramVars.TELEGRAM_BOT_TOKEN,
+        SLACK_TOKEN_KEY: slackAppVars.SLACK_TOKEN_KEY,
+        VAPID_PRIVATE_KEY: vapidPrivateKey,
+      },
+    })
+    props.table.grantReadWriteData(sendDigests.function)
+
+    new Rule(this, 'ScheduleSendDigests', {
+      schedule: Schedule.cron({ minute: '5' }),
+      targets: [new LambdaFunction(sendDigests.function)],
+    })
+
+    // Set as the bot's webhook along with the secret token
+    const telegramBot = new GoFunction(this, 'TelegramBotFunc', {
+      handlerDir: 'telegram-bot',
+      environment: {
+        TABLE_NAME: props.table.tableName,
+        ...telegramVars,
+      },
+    })
+    props.table.grantReadWriteData(telegramBot.function)
+    const telegramBotUrl = telegramBot.function.addFunctionUrl({ authType: FunctionUrlAuthType.NONE })
+    new cdk.CfnOutput(this, 'TelegramWebhookUrl', { value: telegramBotUrl.url })
+
+    // Serves /<token>.atom and /<token>.rss
+    const serveFeed = new GoFunction(this, 'ServeFeedFunc', {
+      handlerDir: 'serve-feed',
+      environment: {
+        TABLE_NAME: props.table.tableName,
+      },
+    })
+    props.table.grantReadData(serveFeed.function)
+    const serveFeedUrl = serveFeed.function.addFunctionUrl({ authType: FunctionUrlAuthType.NONE })
+    new cdk.CfnOutput(this, 'FeedUrl', { value: serveFeedUrl.url })
+
+    // Handles /install, /callback (the app's redirect URL), /events, and /actions
+    const slackApp = new GoFunction(this, 'SlackAppFunc', {
+      handlerDir: 'slack-app',
+      environment: {
+        TABLE_NAME: props.table.tableName,
+        ...slackAppVars,
+        ...twitterVars,
+      },
+    })
+    props.table.grantReadWriteData(slackApp.function)
+    // Actions are taken in an asynchronous invocation of the function itself
+    slackApp.function.addToRolePolicy(
+      new PolicyStatement({
+        actions: ['lambda:InvokeFunction'],
+        resources: ['*'],
+      })
+    )
+    const slackAppUrl = slackApp.function.addFunctionUrl({ authType: FunctionUrlAuthType.NONE })
+    new cdk.CfnOutput(this, 'SlackAppUrl', { value: slackAppUrl.url })
+
     const enqueueUsers = new GoFunction(this, 'EnqueueUsersFunc', {
       handlerDir: 'enqueue-users',
       environment: {
@@ -101,7 +225,7 @@ export class CoreStack extends cdk.Stack {
         FUNCTION_NAME: getFollowers.function.functionName,
       },
     })
-    props.table.grantReadData(enqueueUsers.function)
+    props.table.grantReadWriteData(enqueueUsers.function)
     getFollowers.function.grantInvoke(enqueueUsers.function)
 
     new Rule(this, 'ScheduleEnqueueUsers', {
@@ -37,6 +37,16 @@ export class DataStack extends cdk.Stack {
       sortKey: { name: 'UserIndex', type: ddb.AttributeType.STRING },
       projectionType: ddb.ProjectionType.ALL,
     })
+    // Sparse index only containing tracked users, sorted by when they are due for the next check,
+    // and deferred notifications and webhook retries in partitions of their own, oldest first.
+    // CloudFormation can only add one GSI per update, so further partitions are preferred over
+    // further indexes.
+    table.addGlobalSecondaryIndex({
+      indexName: 'ScheduleIndex',
+      partitionKey: { name: 'ScheduleIndex', type: ddb.AttributeType.STRING },
+      sortKey: { name: 'NextCheckAt', type: ddb.AttributeType.STRING },
+      projectionType: ddb.ProjectionType.ALL,
+    })
     this.table = table
 
     new cdk.CfnOutput(this, 'BucketName', { value: bucket.bucketName })